@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scalland/bitebuddy/internal/handlers"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var assetsCmd = &cobra.Command{
+	Use:   "assets",
+	Short: "Manage the static files of the themes",
+}
+
+var assetsCompressCmd = &cobra.Command{
+	Use:   "compress [STATIC_DIR...]",
+	Short: "Write the brotli compressed variants of the static files of the themes, and remove outdated ones",
+	Long: `Write the brotli compressed variants of the static files of the themes, and remove outdated ones.
+
+Variants are named after the fingerprint of the file they were made from, so a variant is never served for a newer
+version of its file. Run this again after changing static files. Gzip variants do not need this, they are made when
+the server starts.
+
+Without STATIC_DIR the static directories of the built-in themes and of the themes in themes_dir are compressed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dirs := args
+		if len(dirs) == 0 {
+			patterns := []string{filepath.Join("templates", "*", "static")}
+			if themesDir := viper.GetString("themes_dir"); themesDir != "" {
+				patterns = append(patterns, filepath.Join(themesDir, "*", "static"))
+			}
+			for _, pattern := range patterns {
+				matches, _ := filepath.Glob(pattern)
+				dirs = append(dirs, matches...)
+			}
+		}
+
+		for _, dir := range dirs {
+			index, indexErr := handlers.NewAssetIndex(os.DirFS(dir))
+			if indexErr != nil {
+				log.Fatalf("%s.cmd.assetsCompressCmd: %s: %s", utils.APP_NAME, dir, indexErr.Error())
+			}
+
+			current := map[string]bool{}
+			for _, asset := range index.Assets() {
+				if !asset.Compressible() {
+					continue
+				}
+				variant := filepath.Join(dir, filepath.FromSlash(asset.Fingerprinted+handlers.BrotliSuffix))
+				current[variant] = true
+				if asset.Brotli != nil {
+					continue
+				}
+				compressed, err := handlers.BrotliAsset(asset.Data)
+				if err != nil {
+					log.Fatalf("%s.cmd.assetsCompressCmd: error compressing %s: %s", utils.APP_NAME, asset.Path, err.Error())
+				}
+				if err = os.WriteFile(variant, compressed, 0o644); err != nil {
+					log.Fatalf("%s.cmd.assetsCompressCmd: error writing %s: %s", utils.APP_NAME, variant, err.Error())
+				}
+				log.Printf("wrote %s (%d of %d bytes)", variant, len(compressed), len(asset.Data))
+			}
+
+			// variants of files which changed or were removed since they were compressed
+			err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, walkErr error) error {
+				if walkErr != nil || d.IsDir() || !strings.HasSuffix(p, handlers.BrotliSuffix) || current[p] {
+					return walkErr
+				}
+				log.Printf("removing outdated %s", p)
+				return os.Remove(p)
+			})
+			if err != nil {
+				log.Fatalf("%s.cmd.assetsCompressCmd: error removing outdated variants in %s: %s", utils.APP_NAME, dir, err.Error())
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(assetsCmd)
+	assetsCmd.AddCommand(assetsCompressCmd)
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Work with the audit trail of administrative changes",
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the audit log as CSV or JSON",
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+		entity, _ := cmd.Flags().GetString("entity")
+		action, _ := cmd.Flags().GetString("action")
+		actor, _ := cmd.Flags().GetInt64("actor")
+		since, _ := cmd.Flags().GetString("since")
+		until, _ := cmd.Flags().GetString("until")
+
+		filter := db.AuditFilter{
+			Entity:      entity,
+			Action:      action,
+			ActorUserID: actor,
+			Limit:       -1,
+		}
+		var err error
+		if since != "" {
+			if filter.Since, err = time.ParseInLocation("2006-01-02", since, time.Local); err != nil {
+				log.Fatalf("%s.cmd.auditExportCmd: invalid value for --since: %s", utils.APP_NAME, err.Error())
+			}
+		}
+		if until != "" {
+			if filter.Until, err = time.ParseInLocation("2006-01-02", until, time.Local); err != nil {
+				log.Fatalf("%s.cmd.auditExportCmd: invalid value for --until: %s", utils.APP_NAME, err.Error())
+			}
+			filter.Until = filter.Until.AddDate(0, 0, 1)
+		}
+
+		conn, err := _u.ConnectDB()
+		if err != nil {
+			log.Fatalf("%s.cmd.auditExportCmd: error connecting to database: %s", utils.APP_NAME, err.Error())
+		}
+		defer conn.Close()
+
+		entries, err := db.QueryAuditLog(conn, filter)
+		if err != nil {
+			log.Fatalf("%s.cmd.auditExportCmd: error reading audit log: %s", utils.APP_NAME, err.Error())
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" && output != "-" {
+			f, fErr := os.Create(output)
+			if fErr != nil {
+				log.Fatalf("%s.cmd.auditExportCmd: error creating %s: %s", utils.APP_NAME, output, fErr.Error())
+			}
+			defer f.Close()
+			w = f
+		}
+
+		switch format {
+		case "csv":
+			err = writeAuditCSV(w, entries)
+		case "json":
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(entries)
+		default:
+			log.Fatalf("%s.cmd.auditExportCmd: unknown format %q, use csv or json", utils.APP_NAME, format)
+		}
+		if err != nil {
+			log.Fatalf("%s.cmd.auditExportCmd: error writing audit log: %s", utils.APP_NAME, err.Error())
+		}
+		log.Printf("Exported %d audit log entries", len(entries))
+	},
+}
+
+func writeAuditCSV(w io.Writer, entries []db.AuditEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"audit_log_id", "created_at", "actor_user_id", "actor", "action", "entity", "entity_id", "ip_address", "before", "after"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		before, err := json.Marshal(e.Before)
+		if err != nil {
+			return err
+		}
+		after, err := json.Marshal(e.After)
+		if err != nil {
+			return err
+		}
+		var actorUserID, entityID string
+		if e.ActorUserID.Valid {
+			actorUserID = strconv.FormatInt(e.ActorUserID.Int64, 10)
+		}
+		if e.EntityID.Valid {
+			entityID = strconv.FormatInt(e.EntityID.Int64, 10)
+		}
+		record := []string{strconv.FormatInt(e.ID, 10), e.CreatedAt.Format(time.RFC3339), actorUserID, e.Actor, e.Action, e.Entity, entityID, e.IPAddress, string(before), string(after)}
+		if err = cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditExportCmd)
+	auditExportCmd.Flags().StringP("format", "f", "csv", "Output format: csv or json")
+	auditExportCmd.Flags().StringP("output", "o", "", "File to write the export to. Defaults to stdout")
+	auditExportCmd.Flags().String("entity", "", "Only export changes to this entity, e.g. restaurants")
+	auditExportCmd.Flags().String("action", "", "Only export this action: create, update or delete")
+	auditExportCmd.Flags().Int64("actor", 0, "Only export changes made by the user with this ID")
+	auditExportCmd.Flags().String("since", "", "Only export changes made on or after this date (YYYY-MM-DD)")
+	auditExportCmd.Flags().String("until", "", "Only export changes made on or before this date (YYYY-MM-DD)")
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export records as CSV or JSON files",
+}
+
+var exportRestaurantsCmd = &cobra.Command{
+	Use:   "restaurants [FILE]",
+	Short: "Export every restaurant to FILE, or to the standard output, in a format which can be imported again",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		output := ""
+		if len(args) > 0 {
+			output = args[0]
+		}
+
+		conn, err := _u.ConnectDB()
+		if err != nil {
+			log.Fatalf("%s.cmd.exportRestaurantsCmd: error connecting to database: %s", utils.APP_NAME, err.Error())
+		}
+		defer conn.Close()
+
+		records, err := db.ListRestaurantRecords(conn)
+		if err != nil {
+			log.Fatalf("%s.cmd.exportRestaurantsCmd: error listing restaurants: %s", utils.APP_NAME, err.Error())
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" && output != "-" {
+			f, fErr := os.Create(output)
+			if fErr != nil {
+				log.Fatalf("%s.cmd.exportRestaurantsCmd: error creating %s: %s", utils.APP_NAME, output, fErr.Error())
+			}
+			defer f.Close()
+			w = f
+		}
+
+		if err = db.WriteRestaurants(w, records, db.DetectImportFormat(format, output)); err != nil {
+			log.Fatalf("%s.cmd.exportRestaurantsCmd: %s", utils.APP_NAME, err.Error())
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportRestaurantsCmd)
+	exportRestaurantsCmd.Flags().String("format", "", "Format of the export: csv or json. Detected from the extension of FILE when not set, csv otherwise")
+}
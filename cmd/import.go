@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import records from CSV or JSON files",
+}
+
+var importRestaurantsCmd = &cobra.Command{
+	Use:   "restaurants FILE",
+	Short: "Create or update restaurants from a CSV or JSON file, matching existing ones by name and address",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatalf("%s.cmd.importRestaurantsCmd: error opening %s: %s", utils.APP_NAME, args[0], err.Error())
+		}
+		defer f.Close()
+
+		rows, err := db.ParseRestaurants(f, db.DetectImportFormat(format, args[0]))
+		if err != nil {
+			log.Fatalf("%s.cmd.importRestaurantsCmd: error reading %s: %s", utils.APP_NAME, args[0], err.Error())
+		}
+
+		conn, err := _u.ConnectDB()
+		if err != nil {
+			log.Fatalf("%s.cmd.importRestaurantsCmd: error connecting to database: %s", utils.APP_NAME, err.Error())
+		}
+		defer conn.Close()
+
+		result, err := db.ImportRestaurants(conn, rows, dryRun, db.AuditEntry{Actor: "import"})
+		if err != nil {
+			log.Fatalf("%s.cmd.importRestaurantsCmd: %s", utils.APP_NAME, err.Error())
+		}
+		for _, row := range result.Rows {
+			if len(row.Errors) > 0 {
+				fmt.Printf("line %d: %s\n", row.Line, strings.Join(row.Errors, "; "))
+			}
+		}
+		if dryRun {
+			log.Printf("Dry run, nothing has been saved: %d to create, %d to update, %d with errors", result.Created, result.Updated, result.Failed)
+			return
+		}
+		log.Printf("Import successful! %d created, %d updated, %d skipped with errors", result.Created, result.Updated, result.Failed)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importRestaurantsCmd)
+	importRestaurantsCmd.Flags().String("format", "", "Format of FILE: csv or json. Detected from the file extension when not set")
+	importRestaurantsCmd.Flags().Bool("dry-run", false, "Only report what would be created, updated or rejected")
+}
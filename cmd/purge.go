@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently remove soft-deleted records older than the retention period",
+	Run: func(cmd *cobra.Command, args []string) {
+		retentionDays, _ := cmd.Flags().GetInt("older-than-days")
+		if !cmd.Flags().Changed("older-than-days") {
+			retentionDays = viper.GetInt("soft_delete_retention_days")
+			if !viper.IsSet("soft_delete_retention_days") {
+				retentionDays = db.DefaultSoftDeleteRetentionDays
+			}
+		}
+		if retentionDays < 0 {
+			log.Fatalf("%s.cmd.purgeCmd: the retention period cannot be negative, got %d days", utils.APP_NAME, retentionDays)
+		}
+
+		conn, err := _u.ConnectDB()
+		if err != nil {
+			log.Fatalf("%s.cmd.purgeCmd: error connecting to database: %s", utils.APP_NAME, err.Error())
+		}
+		defer conn.Close()
+
+		olderThan := time.Now().AddDate(0, 0, -retentionDays)
+		log.Printf("Purging records deleted before %s", olderThan.Format(time.RFC3339))
+		purged, err := db.Purge(conn, olderThan)
+		for table, count := range purged {
+			log.Printf("Purged %d %s", count, table)
+		}
+		if err != nil {
+			log.Fatalf("Purge failed: %s", err.Error())
+		}
+		log.Println("Purge successful!")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+	purgeCmd.Flags().Int("older-than-days", db.DefaultSoftDeleteRetentionDays, "Purge records deleted more than this many days ago. Defaults to soft_delete_retention_days from the configuration")
+}
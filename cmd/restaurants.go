@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var restaurantsCmd = &cobra.Command{
+	Use:   "restaurants",
+	Short: "Work with restaurants",
+}
+
+var restaurantsRankCmd = &cobra.Command{
+	Use:   "rank",
+	Short: "Compute the ranking scores of every restaurant and of its metrics",
+	Long: `Compute the ranking scores of every restaurant and of its metrics.
+
+The ranking score is the Bayesian average of the approved reviews: their mean after adding ranking_prior_weight
+reviews scoring ranking_prior_mean, so that a restaurant with a handful of perfect reviews does not outrank one with
+hundreds of very good ones. Restaurants are ranked again whenever their reviews change. With ranking_half_life_days
+set, older reviews count less as time passes, so run this periodically, e.g. daily from cron, to keep the scores
+current.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conn, err := _u.ConnectDB()
+		if err != nil {
+			log.Fatalf("%s.cmd.restaurantsRankCmd: error connecting to database: %s", utils.APP_NAME, err.Error())
+		}
+		defer conn.Close()
+
+		rk := db.LoadRanking()
+		log.Printf("Ranking restaurants with a prior of %.2f weighing %.1f reviews, half-life %s", rk.PriorMean, rk.PriorWeight, rk.HalfLife)
+		n, err := db.RankRestaurants(conn, rk, time.Now())
+		if err != nil {
+			log.Fatalf("Ranking failed: %s", err.Error())
+		}
+		log.Printf("Ranking successful! Ranked %d restaurants", n)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restaurantsCmd)
+	restaurantsCmd.AddCommand(restaurantsRankCmd)
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/moderation"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var reviewsCmd = &cobra.Command{
+	Use:   "reviews",
+	Short: "Work with reviews",
+}
+
+var reviewsScanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Score recent reviews for signs of abuse and flag the suspicious ones for moderation",
+	Long: `Score recent reviews for signs of abuse and flag the suspicious ones for moderation.
+
+Reviews are scored as they are written, which misses the first reviews of a burst. Scanning again once the burst is
+over catches them as well. The thresholds are the anomaly_* settings of the configuration.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetInt("days")
+		if days <= 0 {
+			log.Fatalf("%s.cmd.reviewsScanCmd: the number of days must be positive, got %d", utils.APP_NAME, days)
+		}
+
+		conn, err := _u.ConnectDB()
+		if err != nil {
+			log.Fatalf("%s.cmd.reviewsScanCmd: error connecting to database: %s", utils.APP_NAME, err.Error())
+		}
+		defer conn.Close()
+
+		since := time.Now().AddDate(0, 0, -days)
+		ids, err := db.ReviewIDsSince(conn, since, db.ReviewStatusPending, db.ReviewStatusApproved)
+		if err != nil {
+			log.Fatalf("Scan failed: error listing reviews: %s", err.Error())
+		}
+		log.Printf("Scanning %d reviews written since %s", len(ids), since.Format(time.RFC3339))
+
+		detector := moderation.NewDetector(moderation.LoadAnomalyConfig())
+		var flagged int
+		for _, id := range ids {
+			before, err := db.Snapshot(conn, "reviews", "review_id", id)
+			if err != nil {
+				log.Fatalf("Scan failed: %s", err.Error())
+			}
+			a, err := detector.Review(conn, id)
+			if err != nil {
+				log.Fatalf("Scan failed: %s", err.Error())
+			}
+			if !a.Flagged {
+				continue
+			}
+			flagged++
+			log.Printf("Flagged review %d, scored %.2f: %s", id, a.Score, db.FormatModerationReasons(a.Reasons))
+			after, err := db.Snapshot(conn, "reviews", "review_id", id)
+			if err != nil {
+				log.Fatalf("Scan failed: %s", err.Error())
+			}
+			err = db.WriteAuditEntry(conn, db.AuditEntry{
+				Actor:    "scan",
+				Action:   db.AuditActionUpdate,
+				Entity:   "reviews",
+				EntityID: sql.NullInt64{Int64: id, Valid: true},
+				Before:   before,
+				After:    after,
+			})
+			if err != nil {
+				log.Fatalf("Scan failed: error writing audit entry: %s", err.Error())
+			}
+		}
+		// Flagged reviews no longer count towards the ranking of their restaurant
+		if flagged > 0 {
+			if _, err := db.RankRestaurants(conn, db.LoadRanking(), time.Now()); err != nil {
+				log.Fatalf("Scan failed: %s", err.Error())
+			}
+		}
+		log.Printf("Scan successful! Flagged %d of %d reviews", flagged, len(ids))
+	},
+}
+
+var reviewsAnalyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Score the sentiment of reviews and find the aspects they mention",
+	Long: `Score the sentiment of reviews and find the aspects they mention.
+
+Reviews are analyzed as they are saved. Analyzing them again fills in reviews written before the analysis existed, or
+after the word lists changed. Reviews whose text contradicts their overall score are flagged for moderation, as set by
+the sentiment_* settings of the configuration.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetInt("days")
+		if days < 0 {
+			log.Fatalf("%s.cmd.reviewsAnalyzeCmd: the number of days cannot be negative, got %d", utils.APP_NAME, days)
+		}
+
+		conn, err := _u.ConnectDB()
+		if err != nil {
+			log.Fatalf("%s.cmd.reviewsAnalyzeCmd: error connecting to database: %s", utils.APP_NAME, err.Error())
+		}
+		defer conn.Close()
+
+		var since time.Time
+		if days > 0 {
+			since = time.Now().AddDate(0, 0, -days)
+		}
+		ids, err := db.ReviewIDsSince(conn, since)
+		if err != nil {
+			log.Fatalf("Analysis failed: error listing reviews: %s", err.Error())
+		}
+		log.Printf("Analyzing %d reviews", len(ids))
+
+		cfg := moderation.LoadSentimentConfig()
+		var flagged int
+		for _, id := range ids {
+			before, err := db.Snapshot(conn, "reviews", "review_id", id)
+			if err != nil {
+				log.Fatalf("Analysis failed: %s", err.Error())
+			}
+			res, err := moderation.AnalyzeReview(conn, id, cfg)
+			if err != nil {
+				log.Fatalf("Analysis failed: %s", err.Error())
+			}
+			if !res.Flagged {
+				continue
+			}
+			flagged++
+			log.Printf("Flagged review %d: %s", id, res.Reason)
+			after, err := db.Snapshot(conn, "reviews", "review_id", id)
+			if err != nil {
+				log.Fatalf("Analysis failed: %s", err.Error())
+			}
+			err = db.WriteAuditEntry(conn, db.AuditEntry{
+				Actor:    "analyze",
+				Action:   db.AuditActionUpdate,
+				Entity:   "reviews",
+				EntityID: sql.NullInt64{Int64: id, Valid: true},
+				Before:   before,
+				After:    after,
+			})
+			if err != nil {
+				log.Fatalf("Analysis failed: error writing audit entry: %s", err.Error())
+			}
+		}
+		// Flagged reviews no longer count towards the ranking of their restaurant
+		if flagged > 0 {
+			if _, err := db.RankRestaurants(conn, db.LoadRanking(), time.Now()); err != nil {
+				log.Fatalf("Analysis failed: %s", err.Error())
+			}
+		}
+		log.Printf("Analysis successful! Flagged %d of %d reviews", flagged, len(ids))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reviewsCmd)
+	reviewsCmd.AddCommand(reviewsScanCmd)
+	reviewsScanCmd.Flags().Int("days", 7, "Scan the reviews written within this many days")
+	reviewsCmd.AddCommand(reviewsAnalyzeCmd)
+	reviewsAnalyzeCmd.Flags().Int("days", 0, "Analyze the reviews written within this many days, 0 for all of them")
+}
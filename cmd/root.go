@@ -5,7 +5,6 @@ import (
 	"embed"
 	"fmt"
 	"github.com/scalland/bitebuddy/pkg/utils"
-	"html/template"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -19,7 +18,6 @@ var (
 	}
 	_u          *utils.Utils
 	TemplatesFS embed.FS
-	_tpl        *template.Template
 	_db         *sql.DB
 )
 
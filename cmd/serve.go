@@ -2,27 +2,42 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/fsnotify/fsnotify"
 	"github.com/scalland/bitebuddy/internal/handlers"
 	"github.com/scalland/bitebuddy/internal/routes"
+	"github.com/scalland/bitebuddy/pkg/i18n"
 	"github.com/scalland/bitebuddy/pkg/log"
+	"github.com/scalland/bitebuddy/pkg/storage"
 	"github.com/scalland/bitebuddy/pkg/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/srinathgs/mysqlstore"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
 )
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start the BiteBuddy web server",
 	Run: func(cmd *cobra.Command, args []string) {
-		loggerOpts := &slog.HandlerOptions{
-			AddSource:   false,
-			Level:       log.LevelTrace,
-			ReplaceAttr: log.CustomLogLevel,
+		l, logErr := log.NewWithConfig(log.ConfigFromViper())
+		if logErr != nil {
+			l.Fatalf("%s.cmd.serve: error configuring logger: %s", utils.APP_NAME, logErr.Error())
 		}
-		l := log.New(loggerOpts)
+		slog.SetDefault(l.Slog())
+
+		// Re-apply the log configuration whenever the configuration file changes
+		_u.OnConfigChange(func() {
+			if err := l.Configure(log.ConfigFromViper()); err != nil {
+				l.Errorf("%s.cmd.serve: error reconfiguring logger, keeping the previous configuration: %s", utils.APP_NAME, err.Error())
+				return
+			}
+			l.Infof("%s.cmd.serve: logger reconfigured", utils.APP_NAME)
+		})
+
 		var err error
 		_db, err = _u.ConnectDB()
 		if err != nil {
@@ -75,11 +90,83 @@ var serveCmd = &cobra.Command{
 
 		sessStore.Options.Partitioned = viper.GetBool("session_cookie_partitioned")
 
+		timeZone := viper.GetString("timezone")
+		if timeZone == "" {
+			timeZone = "Local"
+		}
+		_u.SetTimeZone(timeZone)
+
 		themeName := viper.GetString("theme")
 
+		// In development the templates are read from the working directory instead of the binary, so that changes to
+		// them show up without rebuilding
+		var templatesFS fs.FS = TemplatesFS
+		devMode := viper.GetBool("dev_mode")
+		if devMode {
+			templatesFS = os.DirFS(".")
+			l.Infof("%s.cmd.serve: dev mode, reading the embedded themes from %s", utils.APP_NAME, filepath.Join(".", "templates"))
+		}
+
+		localesDir := viper.GetString("locales_dir")
+		locales, localesErr := i18n.NewBundle(localesDir, viper.GetString("default_locale"))
+		if localesErr != nil {
+			l.Fatalf("%s.cmd.serve: error loading message catalogs: %s", utils.APP_NAME, localesErr.Error())
+		}
+		if devMode && localesDir != "" {
+			watcher, watchErr := _u.WatchTree(localesDir, func(event fsnotify.Event) {
+				if err := locales.Reload(); err != nil {
+					l.Errorf("%s.cmd.serve: error reloading message catalogs, keeping the previous ones: %s", utils.APP_NAME, err.Error())
+				}
+			})
+			if watchErr != nil {
+				l.Fatalf("%s.cmd.serve: error watching message catalogs: %s", utils.APP_NAME, watchErr.Error())
+			}
+			defer watcher.Close()
+		}
+		// Reload the catalogs along with the configuration, which also picks up catalogs added to locales_dir
+		_u.OnConfigChange(func() {
+			if err := locales.Reload(); err != nil {
+				l.Errorf("%s.cmd.serve: error reloading message catalogs, keeping the previous ones: %s", utils.APP_NAME, err.Error())
+			}
+		})
+
+		themesDir := viper.GetString("themes_dir")
+		themes, themesErr := handlers.NewThemeRegistry(templatesFS, themesDir, handlers.TemplateFuncs(_u, locales))
+		if themesErr != nil {
+			l.Fatalf("%s.cmd.serve: error loading themes: %s", utils.APP_NAME, themesErr.Error())
+		}
+		if devMode {
+			watchDirs := []string{"templates"}
+			if themesDir != "" {
+				watchDirs = append(watchDirs, themesDir)
+			}
+			watchers, watchErr := themes.Watch(_u, watchDirs, l)
+			if watchErr != nil {
+				l.Fatalf("%s.cmd.serve: error watching themes: %s", utils.APP_NAME, watchErr.Error())
+			}
+			for _, watcher := range watchers {
+				defer watcher.Close()
+			}
+		}
+		// Reload the themes along with the configuration, which also picks up themes added to themes_dir since startup
+		_u.OnConfigChange(func() {
+			if err := themes.Reload(); err != nil {
+				l.Errorf("%s.cmd.serve: error reloading themes, keeping the previous ones: %s", utils.APP_NAME, err.Error())
+			}
+		})
+
 		adminUserTypeID := viper.GetInt("admin_user_type_id")
 
-		wh := handlers.NewWebHandlers(_db, l, _u, &TemplatesFS, sessStore, _tpl, themeName, sessName, adminUserTypeID)
+		mediaDriver := viper.GetString("media_storage")
+		if mediaDriver == "" {
+			mediaDriver = storage.DriverLocal
+		}
+		media, mediaErr := storage.New(mediaDriver)
+		if mediaErr != nil {
+			l.Fatalf("%s.cmd.serve: error opening media storage: %s", utils.APP_NAME, mediaErr.Error())
+		}
+
+		wh := handlers.NewWebHandlers(_db, l, _u, themes, locales, sessStore, media, themeName, sessName, adminUserTypeID)
 
 		_appPort := viper.GetInt("app_port")
 
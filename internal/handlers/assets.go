@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	// assetHashLength - the number of hex digits of the content hash put into fingerprinted file names
+	assetHashLength = 12
+
+	// assetMinCompressSize - files smaller than this are served as they are, compressing them saves next to nothing
+	assetMinCompressSize = 1024
+
+	// staticURLPrefix - the route the static directory of the themes is served from
+	staticURLPrefix = "/static/"
+
+	// BrotliSuffix - the suffix of the brotli compressed variant of a fingerprinted file, see FingerprintPath. Brotli
+	// variants are written ahead of time with `bitebuddy assets compress`, as the best compression takes a while.
+	BrotliSuffix = ".br"
+	// GzipSuffix - the suffix of the gzip compressed variant of a fingerprinted file. Missing gzip variants are
+	// compressed when the assets are indexed.
+	GzipSuffix = ".gz"
+)
+
+// compressibleAssetTypes - the extensions of the static files worth compressing. Images and fonts are compressed already.
+var compressibleAssetTypes = map[string]bool{
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".map":  true,
+	".svg":  true,
+	".txt":  true,
+	".xml":  true,
+	".html": true,
+}
+
+// Asset - a file of the static directory of a theme
+type Asset struct {
+	// Path is the path of the file in the static directory, e.g. css/style.css
+	Path string
+	// Hash is the start of the hex encoded SHA-256 of the content
+	Hash string
+	// Fingerprinted is Path with Hash in the file name, e.g. css/style.0123456789ab.css
+	Fingerprinted string
+	ContentType   string
+	Data          []byte
+	// Gzip and Brotli are the compressed variants of Data, nil when there is none
+	Gzip   []byte
+	Brotli []byte
+}
+
+// AssetIndex - the files of the static directory of a theme, by path and by fingerprinted path
+type AssetIndex struct {
+	byPath        map[string]*Asset
+	byFingerprint map[string]*Asset
+}
+
+// FingerprintPath - inserts hash into the file name of p before its extension, e.g. css/style.css becomes
+// css/style.<hash>.css
+func FingerprintPath(p, hash string) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "." + hash + ext
+}
+
+// Compressible - reports whether compressing the asset is worth it
+func (a *Asset) Compressible() bool {
+	return len(a.Data) >= assetMinCompressSize && compressibleAssetTypes[strings.ToLower(path.Ext(a.Path))]
+}
+
+// isCompressedVariant - reports whether p is the gzip or brotli variant of another static file
+func isCompressedVariant(p string) bool {
+	return strings.HasSuffix(p, BrotliSuffix) || strings.HasSuffix(p, GzipSuffix)
+}
+
+// NewAssetIndex - reads every file of fsys, which is rooted at the static directory of a theme. Compressed variants
+// are picked up when they are stored next to a file under its fingerprinted name followed by BrotliSuffix or
+// GzipSuffix, so that a variant left over from an older version of the file is never served. Gzip variants of
+// compressible files are made here when they are missing.
+func NewAssetIndex(fsys fs.FS) (*AssetIndex, error) {
+	ai := &AssetIndex{byPath: map[string]*Asset{}, byFingerprint: map[string]*Asset{}}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isCompressedVariant(p) {
+			return nil
+		}
+		data, readErr := fs.ReadFile(fsys, p)
+		if readErr != nil {
+			return readErr
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:assetHashLength]
+		contentType := mime.TypeByExtension(path.Ext(p))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		asset := &Asset{Path: p, Hash: hash, Fingerprinted: FingerprintPath(p, hash), ContentType: contentType, Data: data}
+
+		if asset.Compressible() {
+			if asset.Brotli, readErr = readOptional(fsys, asset.Fingerprinted+BrotliSuffix); readErr != nil {
+				return readErr
+			}
+			if asset.Gzip, readErr = readOptional(fsys, asset.Fingerprinted+GzipSuffix); readErr != nil {
+				return readErr
+			}
+			if asset.Gzip == nil {
+				if asset.Gzip, readErr = GzipAsset(data); readErr != nil {
+					return fmt.Errorf("error compressing %s: %s", p, readErr.Error())
+				}
+			}
+		}
+
+		ai.byPath[asset.Path] = asset
+		ai.byFingerprint[asset.Fingerprinted] = asset
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error indexing static files: %s", err.Error())
+	}
+	return ai, nil
+}
+
+// readOptional - returns the content of the file p of fsys, or nil when there is no such file
+func readOptional(fsys fs.FS, p string) ([]byte, error) {
+	data, err := fs.ReadFile(fsys, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// GzipAsset - compresses data with the best gzip compression
+func GzipAsset(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BrotliAsset - compresses data with the best brotli compression
+func BrotliAsset(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := bw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Assets - returns every indexed file, in no particular order
+func (ai *AssetIndex) Assets() []*Asset {
+	assets := make([]*Asset, 0, len(ai.byPath))
+	for _, asset := range ai.byPath {
+		assets = append(assets, asset)
+	}
+	return assets
+}
+
+// URL - returns the fingerprinted URL of the static file p, e.g. /static/css/style.0123456789ab.css for
+// css/style.css, or an empty string when the theme has no such file
+func (ai *AssetIndex) URL(p string) string {
+	asset, ok := ai.byPath[strings.TrimPrefix(path.Clean("/"+p), "/")]
+	if !ok {
+		return ""
+	}
+	return staticURLPrefix + asset.Fingerprinted
+}
+
+// Lookup - returns the file requested as p, which is either its path or its fingerprinted path, and whether it was
+// requested by its current fingerprinted path. Requests for the fingerprint of an older version of a file, as sent
+// by pages cached before a deploy, get the current version.
+func (ai *AssetIndex) Lookup(p string) (asset *Asset, fingerprinted bool, ok bool) {
+	if asset, ok = ai.byFingerprint[p]; ok {
+		return asset, true, true
+	}
+	if asset, ok = ai.byPath[p]; ok {
+		return asset, false, true
+	}
+	// strip what looks like an outdated fingerprint, i.e. the last dot separated part before the extension
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	if i := strings.LastIndexByte(base, '.'); i >= 0 && len(base)-i-1 == assetHashLength {
+		if _, err := hex.DecodeString(base[i+1:]); err == nil {
+			asset, ok = ai.byPath[base[:i]+ext]
+			return asset, false, ok
+		}
+	}
+	return nil, false, false
+}
+
+// acceptsEncoding - reports whether the Accept-Encoding header of r allows the content coding
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) && strings.TrimSpace(name) != "*" {
+			continue
+		}
+		q := 1.0
+		if v, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		return q > 0
+	}
+	return false
+}
+
+// ServeThemeStatic - serves the static directory of the theme of each request. Files requested under their
+// fingerprinted name, see AssetIndex.URL, may be cached forever while the others have to be revalidated. Clients
+// accepting brotli or gzip get the compressed variant of a file when there is one.
+func (wh *WebHandlers) ServeThemeStatic() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		// Fingerprinted files are found without working out the theme of the request. Pages link the files of the
+		// theme chosen by their user fingerprinted, so the others are looked up in the theme of the site, which
+		// unlike the one of the user does not need the session.
+		asset, fingerprinted := wh.themes.LookupFingerprinted(name)
+		ok := fingerprinted
+		if !ok {
+			asset, fingerprinted, ok = wh.themeNamed(wh.siteThemeName(r)).Assets.Lookup(name)
+		}
+		if !ok {
+			wh.Error(w, r, notFound(nil, ""))
+			return
+		}
+
+		if fingerprinted {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		w.Header().Set("Content-Type", asset.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		data, etag := asset.Data, asset.Hash
+		if asset.Brotli != nil || asset.Gzip != nil {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		switch {
+		case asset.Brotli != nil && acceptsEncoding(r, "br"):
+			data, etag = asset.Brotli, asset.Hash+"-br"
+			w.Header().Set("Content-Encoding", "br")
+		case asset.Gzip != nil && acceptsEncoding(r, "gzip"):
+			data, etag = asset.Gzip, asset.Hash+"-gz"
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Header().Set("ETag", strconv.Quote(etag))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+}
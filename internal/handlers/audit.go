@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/spf13/viper"
+)
+
+// auditEntities - the tables whose changes are written to the audit log, along with their primary key columns
+var auditEntities = map[string]string{
+	"display_types":  "display_type_id",
+	"filter_types":   "filter_type_id",
+	"filters":        "filter_id",
+	"metric_reviews": "metric_review_id",
+	"metric_types":   "metric_type_id",
+	"metrics":        "metric_id",
+	"otp_requests":   "otp_request_id",
+	"restaurants":    "restaurant_id",
+	"review_photos":  "review_photo_id",
+	"reviews":        "review_id",
+	"user_types":     "user_type_id",
+	"users":          "user_id",
+}
+
+var auditActions = []string{db.AuditActionCreate, db.AuditActionUpdate, db.AuditActionDelete, db.AuditActionRestore, db.AuditActionPurge}
+
+// clientIP - returns the IP address of the client which sent r. Proxy headers are only honoured when
+// trust_proxy_headers has been enabled in the configuration as they are trivial to spoof otherwise.
+func (wh *WebHandlers) clientIP(r *http.Request) string {
+	if viper.GetBool("trust_proxy_headers") {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return strings.TrimSpace(strings.Split(xff, ",")[0])
+		}
+		if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
+			return strings.TrimSpace(xrip)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sessionUserID - returns the ID of the user logged-in in the session of r or 0 if there is none
+func (wh *WebHandlers) sessionUserID(r *http.Request) int64 {
+	session, err := wh.GetSession(r)
+	if err != nil {
+		wh.Log.Debugf("handlers.WebHandlers.sessionUserID: error getting session: %s", err.Error())
+		return 0
+	}
+	userID, _ := session.Values["user_id"].(int64)
+	return userID
+}
+
+// auditSnapshot - returns the current state of the record of entity identified by id, or nil if it cannot be read
+func (wh *WebHandlers) auditSnapshot(entity string, id int64) map[string]interface{} {
+	return wh.auditSnapshotIn(wh.db, entity, id)
+}
+
+// auditSnapshotIn - returns the same as auditSnapshot, read through conn so that it sees the changes of a transaction
+func (wh *WebHandlers) auditSnapshotIn(conn db.Queryer, entity string, id int64) map[string]interface{} {
+	pkColumn, ok := auditEntities[entity]
+	if !ok {
+		wh.Log.Errorf("handlers.WebHandlers.auditSnapshotIn: %s is not an audited entity", entity)
+		return nil
+	}
+	snapshot, err := db.Snapshot(conn, entity, pkColumn, id)
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.auditSnapshotIn: error reading %s with ID %d: %s", entity, id, err.Error())
+		return nil
+	}
+	return snapshot
+}
+
+// auditActor - returns an audit entry carrying the user logged-in in the session of r and their IP address, for
+// the caller to fill in with the details of the change
+func (wh *WebHandlers) auditActor(r *http.Request) db.AuditEntry {
+	e := db.AuditEntry{
+		IPAddress: wh.clientIP(r),
+	}
+	if actorID := wh.sessionUserID(r); actorID > 0 {
+		e.ActorUserID = sql.NullInt64{Int64: actorID, Valid: true}
+		var email sql.NullString
+		if err := wh.db.QueryRow("SELECT email FROM users WHERE user_id = ?", actorID).Scan(&email); err != nil {
+			wh.Log.Errorf("handlers.WebHandlers.auditActor: error reading email of user %d: %s", actorID, err.Error())
+		}
+		e.Actor = email.String
+	}
+	return e
+}
+
+// audit - writes an entry to the audit log on behalf of the user logged-in in the session of r. Failing to do so is
+// logged but does not fail the request as the change itself has already been made.
+func (wh *WebHandlers) audit(r *http.Request, action, entity string, entityID int64, before, after map[string]interface{}) {
+	wh.auditIn(wh.db, r, action, entity, entityID, before, after)
+}
+
+// auditIn - writes the entry like audit through conn, so that within a transaction it is only kept along with the
+// change it records
+func (wh *WebHandlers) auditIn(conn db.Queryer, r *http.Request, action, entity string, entityID int64, before, after map[string]interface{}) {
+	e := wh.auditActor(r)
+	e.Action = action
+	e.Entity = entity
+	e.EntityID = sql.NullInt64{Int64: entityID, Valid: entityID > 0}
+	e.Before = before
+	e.After = after
+	if err := db.WriteAuditEntry(conn, e); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.auditIn: error writing audit entry for %s %s %d: %s", action, entity, entityID, err.Error())
+	}
+}
+
+// auditCreate - records the creation of the record of entity which was inserted by res
+func (wh *WebHandlers) auditCreate(r *http.Request, entity string, res sql.Result) {
+	id, err := res.LastInsertId()
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.auditCreate: error fetching last insert ID of %s: %s", entity, err.Error())
+		return
+	}
+	wh.audit(r, db.AuditActionCreate, entity, id, nil, wh.auditSnapshot(entity, id))
+}
+
+// auditUpdate - records the update of the record of entity identified by id. before must have been taken with
+// auditSnapshot() before the update was made.
+func (wh *WebHandlers) auditUpdate(r *http.Request, entity string, id int64, before map[string]interface{}) {
+	wh.audit(r, db.AuditActionUpdate, entity, id, before, wh.auditSnapshot(entity, id))
+}
+
+// auditDelete - records the deletion of the record of entity identified by id. before must have been taken with
+// auditSnapshot() before the deletion was made.
+func (wh *WebHandlers) auditDelete(r *http.Request, entity string, id int64, before map[string]interface{}) {
+	wh.audit(r, db.AuditActionDelete, entity, id, before, nil)
+}
+
+type AuditLogFilterForm struct {
+	Entity   string
+	Action   string
+	Actor    string
+	EntityID string
+	Since    string
+	Until    string
+}
+
+type AuditLogHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Entries         []db.AuditEntry
+	Filter          AuditLogFilterForm
+	Entities        []string
+	Actions         []string
+	Page            int
+	PrevURL         template.URL
+	NextURL         template.URL
+}
+
+// AuditLogHandler - lists the entries of the audit log, filtered by the values in the query string
+func (wh *WebHandlers) AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	templateData := AuditLogHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Errors:          []string{},
+		Filter: AuditLogFilterForm{
+			Entity:   q.Get("entity"),
+			Action:   q.Get("action"),
+			Actor:    q.Get("actor"),
+			EntityID: q.Get("entity_id"),
+			Since:    q.Get("since"),
+			Until:    q.Get("until"),
+		},
+		Actions: auditActions,
+	}
+	for entity := range auditEntities {
+		templateData.Entities = append(templateData.Entities, entity)
+	}
+	sort.Strings(templateData.Entities)
+
+	page := wh.u.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	templateData.Page = page
+	if page > 1 {
+		templateData.PrevURL = pageURL(r, page-1)
+	}
+
+	filter := db.AuditFilter{
+		Entity:      templateData.Filter.Entity,
+		Action:      templateData.Filter.Action,
+		ActorUserID: int64(wh.u.Atoi(templateData.Filter.Actor)),
+		EntityID:    int64(wh.u.Atoi(templateData.Filter.EntityID)),
+		Limit:       db.DefaultAuditLogLimit + 1, // one extra entry tells whether there is a next page
+		Offset:      (page - 1) * db.DefaultAuditLogLimit,
+	}
+	var err error
+	if filter.Since, err = parseDateFilter(templateData.Filter.Since, false); err != nil {
+		templateData.Errors = append(templateData.Errors, fmt.Sprintf("invalid date in From: %s", err.Error()))
+	}
+	if filter.Until, err = parseDateFilter(templateData.Filter.Until, true); err != nil {
+		templateData.Errors = append(templateData.Errors, fmt.Sprintf("invalid date in To: %s", err.Error()))
+	}
+
+	entries, err := db.QueryAuditLog(wh.db, filter)
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.AuditLogHandler: error querying audit log: %s", err.Error())
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if len(entries) > db.DefaultAuditLogLimit {
+		entries = entries[:db.DefaultAuditLogLimit]
+		templateData.NextURL = pageURL(r, page+1)
+	}
+	templateData.Entries = entries
+
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "audit_log", templateData)
+	if tmplErr != nil {
+		wh.Log.Errorf("handlers.WebHandlers.AuditLogHandler: error executing template: audit_log: %s", tmplErr.Error())
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+// parseDateFilter - parses a YYYY-MM-DD date from a filter form. With endOfDay set, the returned time is the start of
+// the following day so that the date is included by a "<" comparison.
+func parseDateFilter(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// pageURL - returns the URL of r with the page query parameter replaced, for pagination links
+func pageURL(r *http.Request, page int) template.URL {
+	q := r.URL.Query()
+	q.Set("page", strconv.Itoa(page))
+	return template.URL(r.URL.Path + "?" + q.Encode())
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"fmt"
+	"github.com/scalland/bitebuddy/pkg/db"
 	"github.com/scalland/bitebuddy/pkg/utils"
 	"github.com/spf13/viper"
 	"net/http"
@@ -20,6 +21,8 @@ type LoginUserData struct {
 	ID       int64
 	Email    string
 	UserType int
+	// Locale is the locale the OTP email is written in
+	Locale string
 }
 
 type OTPValidationData struct {
@@ -72,7 +75,9 @@ func (l *LoginUserData) UserSendOTP(mode string, sessID string, length int, wh *
 			wh.Log.Errorf("handlers.LoginUserData.SendOTP: error fetching last insert ID: %s", liIDErr.Error())
 		}
 		emailer := wh.u.NewSMTPEmailWithConfig(viper.GetInt("smtp_port"), viper.GetString("smtp_server"), viper.GetString("smtp_user"), viper.GetString("smtp_pass"))
-		otpEmailSendError := emailer.Send(utils.APP_NAME+" Tech", "noreply@am.scalland.com", "LOGIN OTP for "+utils.APP_NAME, fmt.Sprintf("<p>Your OTP to login to https://%s is <b>%s</b></p>", utils.APP_NAME, otp), []string{l.Email}, []string{}, []string{}, []string{})
+		loc := wh.locales.Localizer(l.Locale)
+		otpEmailSendError := emailer.Send(loc.T("email.from_name", utils.APP_NAME), "noreply@am.scalland.com", loc.T("email.otp.subject", utils.APP_NAME), loc.T("email.otp.body", utils.APP_NAME, otp), []string{l.Email}, []string{}, []string{}, []string{})
+		wh.recordOTPDelivery(lastInsertID, otpEmailSendError)
 		if otpEmailSendError != nil {
 
 			wh.Log.Infof("handlers.LoginUserData.SendOTP: LastInsertID: %d", lastInsertID)
@@ -99,16 +104,30 @@ func (l *LoginUserData) UserSendOTP(mode string, sessID string, length int, wh *
 	return nil
 }
 
+// recordOTPDelivery - stores whether the OTP requested as otpRequestID could be sent, for the dashboard
+func (wh *WebHandlers) recordOTPDelivery(otpRequestID int64, sendErr error) {
+	if otpRequestID <= 0 {
+		return
+	}
+	status := db.OTPDeliverySent
+	if sendErr != nil {
+		status = db.OTPDeliveryFailed
+	}
+	if _, err := wh.db.Exec("UPDATE otp_requests SET delivery_status = ? WHERE otp_request_id = ?", status, otpRequestID); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.recordOTPDelivery: error updating OTP request %d: %s", otpRequestID, err.Error())
+	}
+}
+
 // LoginHandler renders the login page (GET) and processes login (POST).
 func (wh *WebHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	lp := LoginPage{
-		IsLoggedIn: wh.IsLoggedIn(r, w),
+		IsLoggedIn: isLoggedIn(r),
 	}
 
 	session, sessErr := wh.GetSession(r)
 	if sessErr != nil {
 		wh.Log.Errorf("handlers.WebHandlers.LoginHandler: error getting session: %s", sessErr.Error())
-		http.Error(w, sessErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(sessErr))
 		return
 	}
 
@@ -123,9 +142,9 @@ func (wh *WebHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		wh.Log.Debugf("handlers.LoginHandler: requested via GET. Presenting login page")
 		// Render the login template.
-		data, err := wh.ExecuteTemplate("login", lp)
+		data, err := wh.ExecuteTemplate(r, "login", lp)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 
@@ -143,23 +162,23 @@ func (wh *WebHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	err := wh.ReconnectDB()
 	if err != nil {
 		wh.Log.Errorf("handlers.LoginUserData.SendOTP: error connecting to DB: %s", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 
 	// For this example, we assume that if the email exists, the login is successful.
 	var user LoginUserData
-	err = wh.db.QueryRow("SELECT user_id, email, user_type_id FROM users WHERE email = ?", email).
-		Scan(&user.ID, &user.Email, &user.UserType)
+	err = wh.db.QueryRow("SELECT user_id, email, user_type_id, COALESCE(locale, '') FROM users WHERE email = ? AND deleted_at IS NULL", email).
+		Scan(&user.ID, &user.Email, &user.UserType, &user.Locale)
 
 	if err != nil {
 		wh.Log.Debugf("handlers.LoginHandler: user specified by email %s does not exist", email)
 		wh.Log.Errorf("handlers.LoginHandler: error finding user with email %s: ", err.Error())
-		lp.Errors = append(lp.Errors, "Invalid email")
+		lp.Errors = append(lp.Errors, wh.T(r, "login.error.invalid_email"))
 		// Render the login template.
-		data, err := wh.ExecuteTemplate("login", lp)
+		data, err := wh.ExecuteTemplate(r, "login", lp)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 
@@ -170,6 +189,11 @@ func (wh *WebHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	wh.Log.Debugf("handlers.LoginHandler: user specified by email %s does exist", email)
 
+	// users who have not chosen a locale get the OTP in the one they are looking at
+	if _, ok := wh.locales.Match(user.Locale); !ok {
+		user.Locale = wh.localizer(r).Locale()
+	}
+
 	// If execution reaches this point, it means that a valid user exists and has been found
 	lp.UserEmail = user.Email // set the login page data with the email address
 
@@ -198,16 +222,16 @@ func (wh *WebHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		otpSendErr := user.UserSendOTP("email", session.ID, configOTPLength, wh)
 		if otpSendErr != nil {
 			wh.Log.Debugf("handlers.LoginHandler: OTP sending Error: %s", otpSendErr.Error())
-			http.Error(w, otpSendErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(otpSendErr))
 			return
 		}
 
 		wh.Log.Debugf("handlers.LoginHandler: OTP sent successfully. Rendering Login Page")
 
 		// Render the login template with email address filled
-		data, err := wh.ExecuteTemplate("login", lp)
+		data, err := wh.ExecuteTemplate(r, "login", lp)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 
@@ -219,11 +243,11 @@ func (wh *WebHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	// i.e., the OTP is wrong, load the login template
 	if len(otp) < configOTPLength {
 		wh.Log.Debugf("handlers.LoginHandler: OTP provided by the user is too short")
-		lp.Errors = append(lp.Errors, "Illegal credentials")
+		lp.Errors = append(lp.Errors, wh.T(r, "login.error.illegal_credentials"))
 		// Render the login template
-		data, err := wh.ExecuteTemplate("login", lp)
+		data, err := wh.ExecuteTemplate(r, "login", lp)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 
@@ -247,11 +271,11 @@ func (wh *WebHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		wh.Log.Debugf("handlers.LoginHandler: OTP could not be validated from the DB: %s", err.Error())
-		lp.Errors = append(lp.Errors, "Invalid Credentials")
+		lp.Errors = append(lp.Errors, wh.T(r, "login.error.invalid_credentials"))
 		// Render the login template.
-		data, err := wh.ExecuteTemplate("login", lp)
+		data, err := wh.ExecuteTemplate(r, "login", lp)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 
@@ -273,7 +297,7 @@ func (wh *WebHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	wh.Log.Debugf("handlers.LoginHandler: user_id = %d, user_type_id = %d, is_logged_in = %t", session.Values["user_id"], session.Values["user_type_id"], session.Values["is_logged_in"])
 
 	if err = session.Save(r, w); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to save session: %s", err.Error()), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(fmt.Errorf("failed to save session: %s", err.Error())))
 		return
 	}
 
@@ -288,18 +312,17 @@ func (wh *WebHandlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	session, err := wh.GetSession(r)
 	if err != nil {
 		wh.Log.Debugf("handlers.LogoutHandler: error getting session: %s", err.Error())
-		http.Error(w, fmt.Sprintf("failed to get session: %s", err.Error()), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(fmt.Errorf("failed to get session: %s", err.Error())))
+		return
 	}
 	delete(session.Values, "user_id")
 	delete(session.Values, "user_type_id")
 	delete(session.Values, "is_logged_in")
-	wh.isLoggedIn = false
-	wh.isAdmin = false
 
 	err = session.Save(r, w)
 	if err != nil {
 		wh.Log.Debugf("handlers.LogoutHandler: error saving session: %s", err.Error())
-		http.Error(w, fmt.Sprintf("failed to save session: %s", err.Error()), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(fmt.Errorf("failed to save session: %s", err.Error())))
 		return
 	}
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
@@ -309,7 +332,8 @@ func (wh *WebHandlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 func (wh *WebHandlers) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if !wh.IsLoggedIn(r, w) {
+		r = wh.withLoginState(w, r)
+		if !isLoggedIn(r) {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
@@ -321,14 +345,15 @@ func (wh *WebHandlers) RequireAuth(next http.Handler) http.Handler {
 func (wh *WebHandlers) RequireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// check if user is logged-in or not. If they are not, redirect them to login page
-		if !wh.IsLoggedIn(r, w) {
+		r = wh.withLoginState(w, r)
+		if !isLoggedIn(r) {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
 		// If execution reaches here, it means that the user is logged-in. Check if their userTypeID is 1 or not
-		if !wh.IsLoggedInAdmin(r, w) {
-			http.Error(w, "Unauthorized: Admins only", http.StatusUnauthorized)
+		if !isAdmin(r) {
+			wh.Error(w, r, forbidden(nil, "error.admins_only"))
 			return
 		}
 		next.ServeHTTP(w, r)
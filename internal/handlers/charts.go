@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"math"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/i18n"
+)
+
+const (
+	// The size of the charts of the dashboard, in SVG user units. The charts are scaled to the width of their column.
+	chartWidth        = 600.0
+	chartHeight       = 200.0
+	chartPaddingLeft  = 36.0
+	chartPaddingRight = 4.0
+	chartPaddingTop   = 8.0
+	chartPaddingBelow = 20.0
+	// chartBarGap - the share of the width of a bar slot left empty between bars
+	chartBarGap = 0.2
+	// chartMaxLabels - the most labels written along the x axis, fewer than the bars of long ranges
+	chartMaxLabels = 8
+	// chartTicks - the number of gridlines above the x axis
+	chartTicks = 4
+	// chartMaxDailyBars - ranges of more days are charted by month
+	chartMaxDailyBars = 92
+)
+
+// chartPoint - a bar of a chart before it has been laid out. Highlight is the part of Value drawn in another colour,
+// e.g. the OTPs which could not be sent.
+type chartPoint struct {
+	Label     string
+	Value     int64
+	Highlight int64
+}
+
+// ChartBar - a laid out bar of a BarChart, in SVG user units
+type ChartBar struct {
+	X, Y, Width, Height float64
+	// HighlightY and HighlightHeight are the part of the bar standing for Highlight, drawn at its bottom
+	HighlightY, HighlightHeight float64
+	Label                       string
+	// ShowLabel is set for the bars whose label is written along the x axis
+	ShowLabel bool
+	Value     int64
+	Highlight int64
+}
+
+// ChartTick - a gridline of a BarChart
+type ChartTick struct {
+	Y     float64
+	Value int64
+}
+
+// BarChart - a bar chart laid out for the SVG drawn by the dashboard
+type BarChart struct {
+	Width, Height float64
+	// Left, Right and Baseline are the edges of the plot area, the bars stand on Baseline
+	Left, Right, Baseline float64
+	// TickX is where the values of the gridlines end, LabelY where the labels of the bars sit
+	TickX, LabelY float64
+	Bars          []ChartBar
+	Ticks         []ChartTick
+	Max           int64
+}
+
+// newBarChart - lays out a bar per point, scaled so that the highest bar reaches a round number
+func newBarChart(points []chartPoint) BarChart {
+	c := BarChart{
+		Width:    chartWidth,
+		Height:   chartHeight,
+		Left:     chartPaddingLeft,
+		Right:    chartWidth - chartPaddingRight,
+		Baseline: chartHeight - chartPaddingBelow,
+		TickX:    chartPaddingLeft - 4,
+		LabelY:   chartHeight - 6,
+	}
+	for _, p := range points {
+		if p.Value > c.Max {
+			c.Max = p.Value
+		}
+	}
+	top := niceCeiling(c.Max, chartTicks)
+	plotHeight := c.Baseline - chartPaddingTop
+	scale := func(v int64) float64 {
+		return float64(v) / float64(top) * plotHeight
+	}
+
+	for i := 1; i <= chartTicks; i++ {
+		v := top / chartTicks * int64(i)
+		c.Ticks = append(c.Ticks, ChartTick{Y: c.Baseline - scale(v), Value: v})
+	}
+
+	if len(points) == 0 {
+		return c
+	}
+	slot := (c.Right - c.Left) / float64(len(points))
+	labelEvery := int(math.Ceil(float64(len(points)) / chartMaxLabels))
+	for i, p := range points {
+		h := scale(p.Value)
+		hh := scale(p.Highlight)
+		c.Bars = append(c.Bars, ChartBar{
+			X:               c.Left + slot*float64(i) + slot*chartBarGap/2,
+			Y:               c.Baseline - h,
+			Width:           slot * (1 - chartBarGap),
+			Height:          h,
+			HighlightY:      c.Baseline - hh,
+			HighlightHeight: hh,
+			Label:           p.Label,
+			ShowLabel:       i%labelEvery == 0,
+			Value:           p.Value,
+			Highlight:       p.Highlight,
+		})
+	}
+	return c
+}
+
+// niceCeiling - returns the smallest multiple of ticks times 1, 2 or 5 times a power of ten which is at least max, so
+// that every gridline of a chart stands for a round number
+func niceCeiling(max int64, ticks int64) int64 {
+	if max <= 0 {
+		return ticks
+	}
+	step := int64(1)
+	for {
+		for _, m := range []int64{1, 2, 5} {
+			if step*m*ticks >= max {
+				return step * m * ticks
+			}
+		}
+		step *= 10
+	}
+}
+
+// dayPoints - returns a chart point per day, or per month for long ranges, from the first day of since to the day of
+// until. Days missing from counts are charted as zero.
+func dayPoints(counts []db.DayCount, since, until time.Time, l *i18n.Localizer) []chartPoint {
+	byDay := make(map[string]db.DayCount, len(counts))
+	for _, dc := range counts {
+		byDay[dc.Day.Format(time.DateOnly)] = dc
+	}
+
+	first := time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.UTC)
+	last := time.Date(until.Year(), until.Month(), until.Day(), 0, 0, 0, 0, time.UTC)
+	monthly := last.Sub(first) > chartMaxDailyBars*24*time.Hour
+
+	var points []chartPoint
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		dc := byDay[day.Format(time.DateOnly)]
+		if monthly && len(points) > 0 && day.Day() != 1 {
+			points[len(points)-1].Value += dc.Count
+			points[len(points)-1].Highlight += dc.Failed
+			continue
+		}
+		label := l.FormatTime(day, l.T("format.chart_day"))
+		if monthly {
+			label = l.FormatTime(day, l.T("format.chart_month"))
+		}
+		points = append(points, chartPoint{Label: label, Value: dc.Count, Highlight: dc.Failed})
+	}
+	return points
+}
@@ -1,9 +1,95 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/spf13/viper"
+)
+
+const (
+	// dashboardRangeParam - the query parameter which picks the time range of the dashboard, e.g. /?range=90d
+	dashboardRangeParam = "range"
+	// defaultDashboardRange - the time range shown when none has been picked
+	defaultDashboardRange = "30d"
+	// dashboardListLimit - the number of rows of the lists of the dashboard
+	dashboardListLimit = 5
+	// defaultDashboardMinReviews - the reviews a restaurant needs in the range to be ranked when
+	// dashboard_min_reviews has not been set
+	defaultDashboardMinReviews = 3
 )
 
+// DashboardRange - a time range the dashboard can be looked at over
+type DashboardRange struct {
+	Key  string
+	Days int
+}
+
+// dashboardRanges - the time ranges offered by the dashboard
+var dashboardRanges = []DashboardRange{
+	{Key: "7d", Days: 7},
+	{Key: "30d", Days: 30},
+	{Key: "90d", Days: 90},
+	{Key: "365d", Days: 365},
+}
+
+// dashboardRange - returns the time range picked in r, or the default one
+func dashboardRange(r *http.Request) DashboardRange {
+	key := r.URL.Query().Get(dashboardRangeParam)
+	for _, dr := range dashboardRanges {
+		if dr.Key == key {
+			return dr
+		}
+	}
+	for _, dr := range dashboardRanges {
+		if dr.Key == defaultDashboardRange {
+			return dr
+		}
+	}
+	return dashboardRanges[0]
+}
+
+// OTPStats - the OTPs requested in the range of the dashboard and how many of them could not be sent
+type OTPStats struct {
+	Requested int64
+	Failed    int64
+}
+
+// FailureRate - returns the share of the requested OTPs which could not be sent, as a percentage
+func (s OTPStats) FailureRate() float64 {
+	if s.Requested == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Requested) * 100
+}
+
+// SentRate - returns the share of the requested OTPs which were sent, as a percentage
+func (s OTPStats) SentRate() float64 {
+	if s.Requested == 0 {
+		return 0
+	}
+	return 100 - s.FailureRate()
+}
+
+// DashboardStats - the figures shown on the dashboard. Totals and the reviews of the last 7 and 30 days do not depend
+// on the range picked, everything else covers it.
+type DashboardStats struct {
+	Totals          db.Totals
+	ReviewsLast7    int64
+	ReviewsLast30   int64
+	ReviewsInRange  int64
+	TopRated        []db.RestaurantRating
+	BottomRated     []db.RestaurantRating
+	ActiveReviewers []db.Reviewer
+	OTP             OTPStats
+	ReviewsChart    BarChart
+	OTPChart        BarChart
+	// RecentActivity is only loaded for admins
+	RecentActivity []db.AuditEntry
+}
+
 // -----------------------------------------------------------------
 // Dashboard handler
 
@@ -11,15 +97,94 @@ type DashboardHandlerData struct {
 	IsLoggedIn      bool
 	IsLoggedInAdmin bool
 	Errors          []string
+	Range           DashboardRange
+	Ranges          []DashboardRange
+	Since           time.Time
+	MinReviews      int
+	Stats           DashboardStats
 }
 
 func (wh *WebHandlers) DashboardHandler(w http.ResponseWriter, r *http.Request) {
 	wh.Log.Debugf("inside dashboard handler")
-	data, err := wh.ExecuteTemplate("dashboard", DashboardHandlerData{IsLoggedIn: wh.isLoggedIn, IsLoggedInAdmin: wh.isAdmin, Errors: nil})
+	now := time.Now()
+	templateData := DashboardHandlerData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Errors:          nil,
+		Range:           dashboardRange(r),
+		Ranges:          dashboardRanges,
+		MinReviews:      viper.GetInt("dashboard_min_reviews"),
+	}
+	if templateData.MinReviews <= 0 {
+		templateData.MinReviews = defaultDashboardMinReviews
+	}
+	templateData.Since = now.AddDate(0, 0, -templateData.Range.Days)
+
+	stats, err := wh.dashboardStats(r, templateData.Since, now, templateData.MinReviews)
 	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	templateData.Stats = stats
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	data, err := wh.ExecuteTemplate(r, "dashboard", templateData)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	wh.WriteHTML(w, data, http.StatusOK)
 }
+
+// dashboardStats - gathers the figures of the dashboard for the range from since to now
+func (wh *WebHandlers) dashboardStats(r *http.Request, since, now time.Time, minReviews int) (DashboardStats, error) {
+	var (
+		stats DashboardStats
+		err   error
+	)
+	if stats.Totals, err = db.CountTotals(wh.db); err != nil {
+		return stats, fmt.Errorf("error counting rows: %s", err.Error())
+	}
+	if stats.ReviewsLast7, err = db.CountReviewsSince(wh.db, now.AddDate(0, 0, -7)); err != nil {
+		return stats, fmt.Errorf("error counting reviews: %s", err.Error())
+	}
+	if stats.ReviewsLast30, err = db.CountReviewsSince(wh.db, now.AddDate(0, 0, -30)); err != nil {
+		return stats, fmt.Errorf("error counting reviews: %s", err.Error())
+	}
+	rk := db.LoadRanking()
+	if stats.TopRated, err = db.RateRestaurants(wh.db, since, minReviews, dashboardListLimit, false, rk, now); err != nil {
+		return stats, fmt.Errorf("error ranking restaurants: %s", err.Error())
+	}
+	if stats.BottomRated, err = db.RateRestaurants(wh.db, since, minReviews, dashboardListLimit, true, rk, now); err != nil {
+		return stats, fmt.Errorf("error ranking restaurants: %s", err.Error())
+	}
+	if stats.ActiveReviewers, err = db.ActiveReviewers(wh.db, since, dashboardListLimit); err != nil {
+		return stats, fmt.Errorf("error ranking reviewers: %s", err.Error())
+	}
+
+	reviewsPerDay, err := db.ReviewsPerDay(wh.db, since)
+	if err != nil {
+		return stats, fmt.Errorf("error counting reviews per day: %s", err.Error())
+	}
+	for _, dc := range reviewsPerDay {
+		stats.ReviewsInRange += dc.Count
+	}
+	otpPerDay, err := db.OTPRequestsPerDay(wh.db, since)
+	if err != nil {
+		return stats, fmt.Errorf("error counting OTP requests per day: %s", err.Error())
+	}
+	for _, dc := range otpPerDay {
+		stats.OTP.Requested += dc.Count
+		stats.OTP.Failed += dc.Failed
+	}
+	loc := wh.localizer(r)
+	stats.ReviewsChart = newBarChart(dayPoints(reviewsPerDay, since, now, loc))
+	stats.OTPChart = newBarChart(dayPoints(otpPerDay, since, now, loc))
+
+	if isAdmin(r) {
+		stats.RecentActivity, err = db.QueryAuditLog(wh.db, db.AuditFilter{Since: since, Limit: dashboardListLimit * 2})
+		if err != nil {
+			return stats, fmt.Errorf("error querying audit log: %s", err.Error())
+		}
+	}
+	return stats, nil
+}
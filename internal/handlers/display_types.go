@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+)
+
+// The input controls metrics can be scored with, picked by the widget of their display type
+const (
+	DisplayWidgetStars      = "stars"
+	DisplayWidgetSlider     = "slider"
+	DisplayWidgetYesNo      = "yes_no"
+	DisplayWidgetPercentage = "percentage"
+	DisplayWidgetNumber     = "number"
+)
+
+// DisplayWidgets - the widgets a display type can use, in the order they are offered in
+var DisplayWidgets = []string{DisplayWidgetStars, DisplayWidgetSlider, DisplayWidgetYesNo, DisplayWidgetPercentage, DisplayWidgetNumber}
+
+// displayWidget - returns widget if it is known, else DisplayWidgetNumber
+func displayWidget(widget string) string {
+	for _, w := range DisplayWidgets {
+		if w == widget {
+			return w
+		}
+	}
+	return DisplayWidgetNumber
+}
+
+type DisplayType struct {
+	ID              int64
+	DisplayTypeName string
+	Widget          string
+	// Metrics is the number of metrics using the display type, only set when listing display types
+	Metrics int
+}
+
+type DisplayTypesHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	DisplayTypes    []DisplayType
+}
+
+type DisplayTypeFormTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	DisplayType     DisplayType
+	Widgets         []string
+}
+
+// -----------------------------------------------------------------
+// Display Types Handlers
+
+func (wh *WebHandlers) DisplayTypesHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := wh.db.Query("SELECT dt.display_type_id, dt.display_type_name, dt.widget, COUNT(m.metric_id) FROM display_types AS dt LEFT JOIN metrics AS m ON m.display_type_id=dt.display_type_id AND m.deleted_at IS NULL GROUP BY dt.display_type_id, dt.display_type_name, dt.widget ORDER BY dt.display_type_id")
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	defer rows.Close()
+	var displayTypes []DisplayType
+	for rows.Next() {
+		var dt DisplayType
+		err := rows.Scan(&dt.ID, &dt.DisplayTypeName, &dt.Widget, &dt.Metrics)
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		displayTypes = append(displayTypes, dt)
+	}
+	templateData := DisplayTypesHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		DisplayTypes:    displayTypes,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "display_types", templateData)
+	if tmplErr != nil {
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+func (wh *WebHandlers) DisplayTypeNewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		wh.renderDisplayTypeForm(w, r, DisplayType{Widget: DisplayWidgetStars})
+		return
+	}
+	displayTypeName := r.FormValue("display_type_name")
+	widget := displayWidget(r.FormValue("widget"))
+	stmt, err := wh.db.Prepare("INSERT INTO display_types (display_type_name, widget) VALUES (?, ?)")
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(displayTypeName, widget)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.auditCreate(r, "display_types", res)
+	wh.redirectWithFlash(w, r, "/display_types", FlashSuccess, "flash.created", wh.T(r, "entity.display_types"))
+}
+
+func (wh *WebHandlers) DisplayTypeEditHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Query().Get("id")
+	id, _ := strconv.ParseInt(idStr, 10, 64)
+	if r.Method == http.MethodGet {
+		var dt DisplayType
+		err := wh.db.QueryRow("SELECT display_type_id, display_type_name, widget FROM display_types WHERE display_type_id=?", id).
+			Scan(&dt.ID, &dt.DisplayTypeName, &dt.Widget)
+		if err == sql.ErrNoRows {
+			wh.Error(w, r, notFound(err, ""))
+			return
+		}
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		wh.renderDisplayTypeForm(w, r, dt)
+		return
+	}
+	displayTypeName := r.FormValue("display_type_name")
+	widget := displayWidget(r.FormValue("widget"))
+	before := wh.auditSnapshot("display_types", id)
+	stmt, err := wh.db.Prepare("UPDATE display_types SET display_type_name=?, widget=? WHERE display_type_id=?")
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(displayTypeName, widget, id)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.auditUpdate(r, "display_types", id, before)
+	wh.redirectWithFlash(w, r, "/display_types", FlashSuccess, "flash.updated", wh.T(r, "entity.display_types"))
+}
+
+// renderDisplayTypeForm - renders display_type_form.html for dt
+func (wh *WebHandlers) renderDisplayTypeForm(w http.ResponseWriter, r *http.Request, dt DisplayType) {
+	templateData := DisplayTypeFormTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		DisplayType:     dt,
+		Widgets:         DisplayWidgets,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "display_type_form", templateData)
+	if tmplErr != nil {
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+func (wh *WebHandlers) DisplayTypeDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	idStr := r.FormValue("id")
+	id, _ := strconv.ParseInt(idStr, 10, 64)
+	// Deleting a display type cascades to its metrics and their scores, deleted ones included, so it has to be unused
+	var inUse int
+	err := wh.db.QueryRow("SELECT COUNT(*) FROM metrics WHERE display_type_id=?", id).Scan(&inUse)
+	if err != nil {
+		wh.redirectWithError(w, r, "/display_types", err, "flash.delete_failed", wh.T(r, "entity.display_types"))
+		return
+	}
+	if inUse > 0 {
+		wh.redirectWithError(w, r, "/display_types", nil, "error.type_in_use", wh.T(r, "entity.display_types"), inUse)
+		return
+	}
+	before := wh.auditSnapshot("display_types", id)
+	stmt, err := wh.db.Prepare("DELETE FROM display_types WHERE display_type_id=?")
+	if err != nil {
+		wh.redirectWithError(w, r, "/display_types", err, "flash.delete_failed", wh.T(r, "entity.display_types"))
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(id)
+	if err != nil {
+		wh.redirectWithError(w, r, "/display_types", err, "flash.delete_failed", wh.T(r, "entity.display_types"))
+		return
+	}
+	wh.auditDelete(r, "display_types", id, before)
+	wh.redirectWithFlash(w, r, "/display_types", FlashSuccess, "flash.deleted", wh.T(r, "entity.display_types"))
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTPError - a failed request. Message is shown to the user while Err, which may carry SQL errors, file paths and the
+// like, is only logged.
+type HTTPError struct {
+	Status int
+	// Message is the catalog key, or the text, of what the user is told. Empty for the default message of Status.
+	Message string
+	// Args format Message
+	Args []interface{}
+	Err  error
+}
+
+func (e *HTTPError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	if len(e.Args) > 0 {
+		msg = fmt.Sprintf("%s %v", msg, e.Args)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %s", e.Status, msg, e.Err.Error())
+	}
+	return fmt.Sprintf("%d %s", e.Status, msg)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// NewHTTPError - returns an HTTPError with the given status, internal error and user message
+func NewHTTPError(status int, err error, message string, args ...interface{}) *HTTPError {
+	return &HTTPError{Status: status, Message: message, Args: args, Err: err}
+}
+
+func badRequest(err error, message string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusBadRequest, err, message, args...)
+}
+
+func forbidden(err error, message string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusForbidden, err, message, args...)
+}
+
+func notFound(err error, message string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusNotFound, err, message, args...)
+}
+
+func methodNotAllowed() *HTTPError {
+	return NewHTTPError(http.StatusMethodNotAllowed, nil, "")
+}
+
+func conflict(err error, message string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusConflict, err, message, args...)
+}
+
+// internalError - wraps err, which is never shown to the user
+func internalError(err error) *HTTPError {
+	return NewHTTPError(http.StatusInternalServerError, err, "")
+}
+
+type ErrorPageTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Status          int
+	Title           string
+	Message         string
+}
+
+// errorResponse - the body of the errors sent to API clients
+type errorResponse struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Error - answers r with err. An *HTTPError in the chain of err decides the status and the message shown, any other
+// error is a 500 whose details are only logged. Browsers get the error page of the theme, API clients a JSON body.
+func (wh *WebHandlers) Error(w http.ResponseWriter, r *http.Request, err error) {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		httpErr = internalError(err)
+	}
+	if httpErr.Status >= http.StatusInternalServerError {
+		wh.Log.Errorf("handlers.WebHandlers.Error: %s %s: %s", r.Method, r.URL.Path, httpErr.Error())
+	} else {
+		wh.Log.Debugf("handlers.WebHandlers.Error: %s %s: %s", r.Method, r.URL.Path, httpErr.Error())
+	}
+
+	loc := wh.localizer(r)
+	title, ok := loc.Lookup(fmt.Sprintf("error.%d.title", httpErr.Status))
+	if !ok {
+		title = http.StatusText(httpErr.Status)
+	}
+	message := loc.T(fmt.Sprintf("error.%d.message", httpErr.Status))
+	if httpErr.Message != "" {
+		message = loc.T(httpErr.Message, httpErr.Args...)
+	}
+
+	if wantsJSON(r) {
+		var body errorResponse
+		body.Error.Status = httpErr.Status
+		body.Error.Title = title
+		body.Error.Message = message
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(httpErr.Status)
+		if encodeErr := json.NewEncoder(w).Encode(body); encodeErr != nil {
+			wh.Log.Debugf("handlers.WebHandlers.Error: error writing response: %s", encodeErr.Error())
+		}
+		return
+	}
+
+	page, tmplErr := wh.ExecuteTemplate(r, "error", ErrorPageTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Status:          httpErr.Status,
+		Title:           title,
+		Message:         message,
+	})
+	if tmplErr != nil {
+		wh.Log.Errorf("handlers.WebHandlers.Error: error executing template: error: %s", tmplErr.Error())
+		http.Error(w, message, httpErr.Status)
+		return
+	}
+	wh.WriteHTML(w, page, httpErr.Status)
+}
+
+// wantsJSON - reports whether r comes from an API client rather than a browser
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	return strings.Contains(accept, "application/json") ||
+		strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest") ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// NotFoundHandler - answers requests for which no route exists. Routers do not run their middlewares for it, so the
+// login state the navigation of the page depends on is worked out here.
+func (wh *WebHandlers) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	wh.Error(w, wh.withLoginState(w, r), notFound(nil, ""))
+}
+
+// MethodNotAllowedHandler - answers requests whose path has a route but not for their method
+func (wh *WebHandlers) MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	wh.Error(w, wh.withLoginState(w, r), methodNotAllowed())
+}
@@ -16,7 +16,7 @@ type FilterType struct {
 func (wh *WebHandlers) FilterTypesHandler(w http.ResponseWriter, r *http.Request) {
 	rows, err := wh.db.Query("SELECT filter_type_id, filter_type_name FROM filter_types")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer rows.Close()
@@ -25,23 +25,25 @@ func (wh *WebHandlers) FilterTypesHandler(w http.ResponseWriter, r *http.Request
 		var ft FilterType
 		err := rows.Scan(&ft.ID, &ft.FilterTypeName)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 		fTypes = append(fTypes, ft)
 	}
-	tmpl, tmplErr := wh.ExecuteTemplate("filter_types", fTypes)
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "filter_types", fTypes)
 	if tmplErr != nil {
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
 
 func (wh *WebHandlers) FilterTypeNewHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl, tmplErr := wh.ExecuteTemplate("filter_type_form", nil)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "filter_type_form", nil)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
@@ -49,16 +51,17 @@ func (wh *WebHandlers) FilterTypeNewHandler(w http.ResponseWriter, r *http.Reque
 	filterTypeName := r.FormValue("filter_type_name")
 	stmt, err := wh.db.Prepare("INSERT INTO filter_types (filter_type_name) VALUES (?)")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(filterTypeName)
+	res, err := stmt.Exec(filterTypeName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/filter_types", http.StatusSeeOther)
+	wh.auditCreate(r, "filter_types", res)
+	wh.redirectWithFlash(w, r, "/filter_types", FlashSuccess, "flash.created", wh.T(r, "entity.filter_types"))
 }
 
 func (wh *WebHandlers) FilterTypeEditHandler(w http.ResponseWriter, r *http.Request) {
@@ -69,48 +72,53 @@ func (wh *WebHandlers) FilterTypeEditHandler(w http.ResponseWriter, r *http.Requ
 		err := wh.db.QueryRow("SELECT filter_type_id, filter_type_name FROM filter_types WHERE filter_type_id=?", id).
 			Scan(&ft.ID, &ft.FilterTypeName)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("filter_type_form", ft)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "filter_type_form", ft)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	filterTypeName := r.FormValue("filter_type_name")
+	before := wh.auditSnapshot("filter_types", id)
 	stmt, err := wh.db.Prepare("UPDATE filter_types SET filter_type_name=? WHERE filter_type_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(filterTypeName, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/filter_types", http.StatusSeeOther)
+	wh.auditUpdate(r, "filter_types", id, before)
+	wh.redirectWithFlash(w, r, "/filter_types", FlashSuccess, "flash.updated", wh.T(r, "entity.filter_types"))
 }
 
 func (wh *WebHandlers) FilterTypeDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	idStr := r.FormValue("id")
 	id, _ := strconv.ParseInt(idStr, 10, 64)
+	before := wh.auditSnapshot("filter_types", id)
 	stmt, err := wh.db.Prepare("DELETE FROM filter_types WHERE filter_type_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/filter_types", err, "flash.delete_failed", wh.T(r, "entity.filter_types"))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/filter_types", err, "flash.delete_failed", wh.T(r, "entity.filter_types"))
 		return
 	}
-	http.Redirect(w, r, "/filter_types", http.StatusSeeOther)
+	wh.auditDelete(r, "filter_types", id, before)
+	wh.redirectWithFlash(w, r, "/filter_types", FlashSuccess, "flash.deleted", wh.T(r, "entity.filter_types"))
 }
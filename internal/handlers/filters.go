@@ -9,40 +9,77 @@ type Filter struct {
 	ID           int64
 	FilterTypeID int64
 	FilterValue  string
+	// FilterTypeName names the filter type FilterTypeID refers to, only set when listing filters
+	FilterTypeName string
+}
+
+type FiltersHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Filters         []Filter
+}
+
+type FilterFormTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Filter          Filter
+	FilterType      LookupField
+}
+
+// filterFormData - returns the data of filter_form.html for f, with its filter type as a lookup field
+func (wh *WebHandlers) filterFormData(r *http.Request, f Filter) (FilterFormTemplateData, error) {
+	data := FilterFormTemplateData{IsLoggedIn: isLoggedIn(r), IsLoggedInAdmin: isAdmin(r), Filter: f}
+	var err error
+	data.FilterType, err = wh.lookupField("filter_type_id", "field.filter_type", "filter_types", true, f.FilterTypeID)
+	return data, err
 }
 
 // -----------------------------------------------------------------
 // Filters Handlers
 
 func (wh *WebHandlers) FiltersHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := wh.db.Query("SELECT filter_id, filter_type_id, filter_value FROM filters")
+	rows, err := wh.db.Query("SELECT f.filter_id, f.filter_type_id, COALESCE(ft.filter_type_name, ''), f.filter_value FROM filters AS f LEFT JOIN filter_types AS ft ON f.filter_type_id=ft.filter_type_id ORDER BY f.filter_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer rows.Close()
 	var filters []Filter
 	for rows.Next() {
 		var f Filter
-		err := rows.Scan(&f.ID, &f.FilterTypeID, &f.FilterValue)
+		err := rows.Scan(&f.ID, &f.FilterTypeID, &f.FilterTypeName, &f.FilterValue)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 		filters = append(filters, f)
 	}
-	tmpl, tmplErr := wh.ExecuteTemplate("filters", filters)
+	templateData := FiltersHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Filters:         filters,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "filters", templateData)
 	if tmplErr != nil {
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
 
 func (wh *WebHandlers) FilterNewHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl, tmplErr := wh.ExecuteTemplate("filter_form", nil)
+		templateData, err := wh.filterFormData(r, Filter{})
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "filter_form", templateData)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
@@ -51,16 +88,17 @@ func (wh *WebHandlers) FilterNewHandler(w http.ResponseWriter, r *http.Request)
 	filterValue := r.FormValue("filter_value")
 	stmt, err := wh.db.Prepare("INSERT INTO filters (filter_type_id, filter_value) VALUES (?, ?)")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(filterTypeID, filterValue)
+	res, err := stmt.Exec(filterTypeID, filterValue)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/filters", http.StatusSeeOther)
+	wh.auditCreate(r, "filters", res)
+	wh.redirectWithFlash(w, r, "/filters", FlashSuccess, "flash.created", wh.T(r, "entity.filters"))
 }
 
 func (wh *WebHandlers) FilterEditHandler(w http.ResponseWriter, r *http.Request) {
@@ -71,49 +109,59 @@ func (wh *WebHandlers) FilterEditHandler(w http.ResponseWriter, r *http.Request)
 		err := wh.db.QueryRow("SELECT filter_id, filter_type_id, filter_value FROM filters WHERE filter_id=?", id).
 			Scan(&f.ID, &f.FilterTypeID, &f.FilterValue)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("filter_form.html", f)
+		templateData, err := wh.filterFormData(r, f)
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "filter_form", templateData)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	filterTypeID, _ := strconv.ParseInt(r.FormValue("filter_type_id"), 10, 64)
 	filterValue := r.FormValue("filter_value")
+	before := wh.auditSnapshot("filters", id)
 	stmt, err := wh.db.Prepare("UPDATE filters SET filter_type_id=?, filter_value=? WHERE filter_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(filterTypeID, filterValue, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/filters", http.StatusSeeOther)
+	wh.auditUpdate(r, "filters", id, before)
+	wh.redirectWithFlash(w, r, "/filters", FlashSuccess, "flash.updated", wh.T(r, "entity.filters"))
 }
 
 func (wh *WebHandlers) FilterDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	idStr := r.FormValue("id")
 	id, _ := strconv.ParseInt(idStr, 10, 64)
+	before := wh.auditSnapshot("filters", id)
 	stmt, err := wh.db.Prepare("DELETE FROM filters WHERE filter_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/filters", err, "flash.delete_failed", wh.T(r, "entity.filters"))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/filters", err, "flash.delete_failed", wh.T(r, "entity.filters"))
 		return
 	}
-	http.Redirect(w, r, "/filters", http.StatusSeeOther)
+	wh.auditDelete(r, "filters", id, before)
+	wh.redirectWithFlash(w, r, "/filters", FlashSuccess, "flash.deleted", wh.T(r, "entity.filters"))
 }
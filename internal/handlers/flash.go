@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/gob"
+	"net/http"
+	"strings"
+)
+
+// The levels of flash messages
+const (
+	FlashSuccess = "success"
+	FlashInfo    = "info"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
+// flashSessionKey - the session value flash messages are queued under until a page shows them
+const flashSessionKey = "_flash"
+
+// Flash - a message shown once on the next page the user sees, typically after a redirect
+type Flash struct {
+	Level   string
+	Message string
+}
+
+func init() {
+	// session values are gob encoded
+	gob.Register(Flash{})
+}
+
+// AlertClass - returns the Bootstrap alert class matching the level of the message
+func (f Flash) AlertClass() string {
+	switch f.Level {
+	case FlashSuccess:
+		return "alert-success"
+	case FlashWarning:
+		return "alert-warning"
+	case FlashError:
+		return "alert-danger"
+	}
+	return "alert-info"
+}
+
+// flashContextKey - the key of the flash messages taken from the session for a request, see FlashMiddleware
+type flashContextKey struct{}
+
+// AddFlash - queues the message stored under key in the catalog, formatted with args, to be shown on the next page
+func (wh *WebHandlers) AddFlash(w http.ResponseWriter, r *http.Request, level, key string, args ...interface{}) {
+	session, err := wh.GetSession(r)
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.AddFlash: error getting session: %s", err.Error())
+		return
+	}
+	session.AddFlash(Flash{Level: level, Message: wh.T(r, key, args...)}, flashSessionKey)
+	if err = session.Save(r, w); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.AddFlash: error saving session: %s", err.Error())
+	}
+}
+
+// redirectWithFlash - queues a flash message, see AddFlash, and redirects to url
+func (wh *WebHandlers) redirectWithFlash(w http.ResponseWriter, r *http.Request, url, level, key string, args ...interface{}) {
+	wh.AddFlash(w, r, level, key, args...)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// redirectWithError - logs err, queues an error flash message, see AddFlash, and redirects to url
+func (wh *WebHandlers) redirectWithError(w http.ResponseWriter, r *http.Request, url string, err error, key string, args ...interface{}) {
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.redirectWithError: %s %s: %s", r.Method, r.URL.Path, err.Error())
+	}
+	wh.redirectWithFlash(w, r, url, FlashError, key, args...)
+}
+
+// FlashMiddleware - takes the queued flash messages out of the session for requests of pages, so that ExecuteTemplate
+// can show them. Requests for anything else, such as images or scripts, leave them queued.
+func (wh *WebHandlers) FlashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		session, err := wh.GetSession(r)
+		if err != nil {
+			wh.Log.Debugf("handlers.WebHandlers.FlashMiddleware: error getting session: %s", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+		queued := session.Flashes(flashSessionKey)
+		if len(queued) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err = session.Save(r, w); err != nil {
+			wh.Log.Errorf("handlers.WebHandlers.FlashMiddleware: error saving session: %s", err.Error())
+		}
+		flashes := make([]Flash, 0, len(queued))
+		for _, f := range queued {
+			if flash, ok := f.(Flash); ok {
+				flashes = append(flashes, flash)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), flashContextKey{}, flashes)))
+	})
+}
+
+// requestFlashes - returns the flash messages to be shown on the page rendered for r
+func requestFlashes(r *http.Request) []Flash {
+	flashes, _ := r.Context().Value(flashContextKey{}).([]Flash)
+	return flashes
+}
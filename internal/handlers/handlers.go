@@ -4,45 +4,39 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
-	"embed"
-	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/sessions"
+	"github.com/scalland/bitebuddy/pkg/i18n"
 	"github.com/scalland/bitebuddy/pkg/log"
+	"github.com/scalland/bitebuddy/pkg/storage"
 	"github.com/scalland/bitebuddy/pkg/utils"
 	"github.com/srinathgs/mysqlstore"
-	"html/template"
-	"io/fs"
 	"net/http"
-	"path/filepath"
-	"strings"
 )
 
 type WebHandlers struct {
 	adminUserTypeID int
 	db              *sql.DB
-	isLoggedIn      bool
-	isAdmin         bool
+	locales         *i18n.Bundle
 	Log             *log.Logger
+	media           storage.Storage
 	store           *mysqlstore.MySQLStore
 	sessionName     string
-	templatesFS     *embed.FS
-	tpl             *template.Template
+	themes          *ThemeRegistry
 	themeName       string
 	u               *utils.Utils
 }
 
-func NewWebHandlers(db *sql.DB, l *log.Logger, u *utils.Utils, tFS *embed.FS, sessionStore *mysqlstore.MySQLStore, tpl *template.Template, tName, sName string, adminUserTypeID int) *WebHandlers {
+func NewWebHandlers(db *sql.DB, l *log.Logger, u *utils.Utils, themes *ThemeRegistry, locales *i18n.Bundle, sessionStore *mysqlstore.MySQLStore, media storage.Storage, tName, sName string, adminUserTypeID int) *WebHandlers {
 	return &WebHandlers{
 		adminUserTypeID: adminUserTypeID,
 		db:              db,
-		isLoggedIn:      false,
-		isAdmin:         false,
+		locales:         locales,
 		Log:             l,
+		media:           media,
 		u:               u,
-		templatesFS:     tFS,
+		themes:          themes,
 		store:           sessionStore,
-		tpl:             tpl,
 		themeName:       tName,
 		sessionName:     sName,
 	}
@@ -54,16 +48,6 @@ func (wh *WebHandlers) GetSession(r *http.Request) (*sessions.Session, error) {
 	return wh.store.Get(r, wh.sessionName)
 }
 
-// GetIsLoggedIn - returns the value of WebHandlers.isLoggedIn
-func (wh *WebHandlers) GetIsLoggedIn() bool {
-	return wh.isLoggedIn
-}
-
-// GetIsAdmin - returns the value of WebHandlers.isAdmin
-func (wh *WebHandlers) GetIsAdmin() bool {
-	return wh.isAdmin
-}
-
 func (wh *WebHandlers) IsLoggedIn(r *http.Request, w http.ResponseWriter) bool {
 	session, err := wh.store.Get(r, wh.sessionName)
 	if err != nil {
@@ -78,31 +62,27 @@ func (wh *WebHandlers) IsLoggedIn(r *http.Request, w http.ResponseWriter) bool {
 	var dbUserID, dbUserTypeID int
 	if okIsLoggedIn && okUserType && okUserID && isLoggedIn == true {
 		// user is logged-in according to session. Let us check if they exist in the DB
-		wh.Log.Debugf("handlers.WebHandlers.IsLoggedIn: SQLEXEC: SELECT user_id, user_type_id FROM users WHERE user_id = %d AND user_type_id = %d", userID, userTypeID)
-		err = wh.db.QueryRow("SELECT user_id, user_type_id FROM users WHERE user_id = ? AND user_type_id = ?", userID, userTypeID).Scan(&dbUserID, &dbUserTypeID)
+		wh.Log.Debugf("handlers.WebHandlers.IsLoggedIn: SQLEXEC: SELECT user_id, user_type_id FROM users WHERE user_id = %d AND user_type_id = %d AND deleted_at IS NULL", userID, userTypeID)
+		err = wh.db.QueryRow("SELECT user_id, user_type_id FROM users WHERE user_id = ? AND user_type_id = ? AND deleted_at IS NULL", userID, userTypeID).Scan(&dbUserID, &dbUserTypeID)
 		if err != nil {
 			wh.Log.Debugf("handlers.WebHandlers.IsLoggedIn: error validating user in DB: %s", err.Error())
 			return false
 		}
-		wh.isLoggedIn = true
-		return wh.isLoggedIn // user validated from both session and DB
+		return true // user validated from both session and DB
 	}
 	wh.Log.Debugf("handlers.WebHandlers.IsLoggedIn: user could not be verified from session. Won't check in DB")
 	wh.Log.Debugf("handler.WebHandlers.IsLoggedIn: checking if the session values are set or not and adding accordingly")
 	if !okUserID {
 		wh.Log.Debugf("handler.WebHandlers.IsLoggedIn: value for user_id is not set. Setting it")
 		session.Values["user_id"] = 0
-		wh.isLoggedIn = false
 	}
 	if !okUserType {
 		wh.Log.Debugf("handler.WebHandlers.IsLoggedIn: value for user_type_id is not set. Setting it")
 		session.Values["user_type_id"] = 0
-		wh.isLoggedIn = false
 	}
 	if !okIsLoggedIn {
 		wh.Log.Debugf("handler.WebHandlers.IsLoggedIn: value for is_logged_in is not set. Setting it")
 		session.Values["is_logged_in"] = false
-		wh.isLoggedIn = false
 	}
 	wh.Log.Debugf("handler.WebHandlers.IsLoggedIn: saving the set values to the session")
 	err = session.Save(r, w)
@@ -110,8 +90,8 @@ func (wh *WebHandlers) IsLoggedIn(r *http.Request, w http.ResponseWriter) bool {
 		wh.Log.Debugf("handlers.WebHandlers.IsLoggedIn: error saving session: %s", err.Error())
 	}
 	wh.Log.Debugf("handler.WebHandlers.IsLoggedIn: saved empty values into the session successfully with session ID: %s", session.ID)
-	wh.Log.Debugf("handlers.WebHandlers.IsLoggedIn: returning %t", wh.isLoggedIn)
-	return wh.isLoggedIn // user could not be validated from session. DB was not checked
+	wh.Log.Debugf("handlers.WebHandlers.IsLoggedIn: returning false")
+	return false // user could not be validated from session. DB was not checked
 }
 
 func (wh *WebHandlers) IsLoggedInAdmin(r *http.Request, w http.ResponseWriter) bool {
@@ -128,15 +108,13 @@ func (wh *WebHandlers) IsLoggedInAdmin(r *http.Request, w http.ResponseWriter) b
 	var dbUserID, dbUserTypeID int
 	if okIsLoggedIn && okUserType && okUserID && isLoggedIn == true && userTypeID == wh.adminUserTypeID {
 		// user is logged-in according to session and are an admin user. Let us check if they exist in the DB
-		wh.Log.Debugf("handlers.WebHandlers.IsLoggedInAdmin: SQLEXEC: SELECT user_id, user_type_id FROM users WHERE user_id = %d AND user_type_id = %d", userID, userTypeID)
-		err = wh.db.QueryRow("SELECT user_id, user_type_id FROM users WHERE user_id = ? AND user_type_id = ?", userID, userTypeID).Scan(&dbUserID, &dbUserTypeID)
+		wh.Log.Debugf("handlers.WebHandlers.IsLoggedInAdmin: SQLEXEC: SELECT user_id, user_type_id FROM users WHERE user_id = %d AND user_type_id = %d AND deleted_at IS NULL", userID, userTypeID)
+		err = wh.db.QueryRow("SELECT user_id, user_type_id FROM users WHERE user_id = ? AND user_type_id = ? AND deleted_at IS NULL", userID, userTypeID).Scan(&dbUserID, &dbUserTypeID)
 		if err != nil {
 			wh.Log.Debugf("handlers.WebHandlers.IsLoggedInAdmin: error validating user in DB: %s", err.Error())
 			return false
 		}
-		wh.isLoggedIn = true
-		wh.isAdmin = true
-		return wh.isAdmin // user validated from both session and DB
+		return true // user validated from both session and DB
 	}
 	wh.Log.Debugf("handlers.WebHandlers.IsLoggedInAdmin: user could not be verified from session. Won't check in DB")
 	wh.Log.Debugf("handler.WebHandlers.IsLoggedInAdmin: checking if the session values are set or not and adding accordingly")
@@ -147,20 +125,18 @@ func (wh *WebHandlers) IsLoggedInAdmin(r *http.Request, w http.ResponseWriter) b
 	if !okUserType {
 		wh.Log.Debugf("handler.WebHandlers.IsLoggedInAdmin: value for user_type_id is not set. Setting it")
 		session.Values["user_type_id"] = 0
-		wh.isAdmin = false
 	}
 	if !okIsLoggedIn {
 		wh.Log.Debugf("handler.WebHandlers.IsLoggedInAdmin: value for is_logged_in is not set. Setting it")
 		session.Values["is_logged_in"] = false
-		wh.isLoggedIn = false
 	}
 	err = session.Save(r, w)
 	if err != nil {
 		wh.Log.Debugf("handlers.WebHandlers.IsLoggedInAdmin: error saving session: %s", err.Error())
 	}
 	wh.Log.Debugf("handler.WebHandlers.IsLoggedInAdmin: saved empty values into the session successfully with session ID: %s", session.ID)
-	wh.Log.Debugf("handlers.WebHandlers.IsLoggedInAdmin: returning %t", wh.isLoggedIn && wh.isAdmin)
-	return wh.isLoggedIn && wh.isAdmin // user could not be validated from session. DB was not checked
+	wh.Log.Debugf("handlers.WebHandlers.IsLoggedInAdmin: returning false")
+	return false // user could not be validated from session. DB was not checked
 }
 
 func (wh *WebHandlers) ReconnectDB() error {
@@ -185,58 +161,22 @@ func (wh *WebHandlers) ReconnectDB() error {
 	}
 }
 
-func (wh *WebHandlers) ExecuteTemplate(templateFileNameSansExtension string, data interface{}) (bytes.Buffer, error) {
-	tmpl, tmplErr := wh.generateHTML(wh.themeName, templateFileNameSansExtension, data)
+// ExecuteTemplate - renders the page templateFileNameSansExtension with data, using the theme and the locale r is to be
+// rendered with
+func (wh *WebHandlers) ExecuteTemplate(r *http.Request, templateFileNameSansExtension string, data interface{}) (bytes.Buffer, error) {
+	theme := wh.theme(r)
+	funcs := localizedTemplateFuncs(wh.u, wh.localizer(r), r)
+	funcs["asset"] = theme.Assets.URL
+	funcs["flashes"] = func() []Flash { return requestFlashes(r) }
+	tmpl, tmplErr := theme.Templates.Execute(templateFileNameSansExtension, data, funcs)
 	if tmplErr != nil {
+		wh.Log.Debugf("handlers.WebHandlers.ExecuteTemplate: %s", tmplErr.Error())
 		return bytes.Buffer{}, tmplErr
 	}
 
 	return tmpl, nil
 }
 
-// GenerateHTML generates the complete HTML for a given page name
-func (wh *WebHandlers) generateHTML(themeName, pageName string, data interface{}) (bytes.Buffer, error) {
-
-	wh.Log.Debugf("template theme: %s", themeName)
-
-	baseDir := filepath.Join("templates", themeName)
-	wh.Log.Debugf("base template dir: %s", baseDir)
-
-	// Define the paths to the partials and page files
-	layoutPath := filepath.Join(baseDir, "partials", "layout.html")
-	headerPath := filepath.Join(baseDir, "partials", "header.html")
-	footerPath := filepath.Join(baseDir, "partials", "footer.html")
-	pagePath := filepath.Join(baseDir, "pages", pageName+".html")
-
-	wh.Log.Debugf("template partial (base): %s", layoutPath)
-	wh.Log.Debugf("template partial (header): %s", headerPath)
-	wh.Log.Debugf("template partial (footer): %s", footerPath)
-	wh.Log.Debugf("template partial (page): %s", pagePath)
-
-	// Parse the templates
-	tmpl, err := template.ParseFiles(layoutPath, headerPath, footerPath, pagePath)
-	if err != nil {
-		wh.Log.Debugf("Error parsing template: %s", err.Error())
-		return bytes.Buffer{}, fmt.Errorf("error parsing templates: %s", err.Error())
-	}
-
-	// Parse templates from the embedded filesystem.
-	tmpl, err = template.ParseFS(wh.templatesFS, layoutPath, headerPath, footerPath, pagePath)
-	if err != nil {
-		wh.Log.Fatal(err)
-	}
-
-	// Create a buffer to store the generated HTML
-	var output bytes.Buffer
-	err = tmpl.Execute(&output, data)
-	if err != nil {
-		wh.Log.Debugf("Error parsing template: %s", err.Error())
-		return bytes.Buffer{}, fmt.Errorf("error executing template: %s", err.Error())
-	}
-
-	return output, nil
-}
-
 func (wh *WebHandlers) WriteHTML(w http.ResponseWriter, data bytes.Buffer, httpStatus int) {
 	switch httpStatus < 100 || httpStatus > 600 {
 	case true:
@@ -256,8 +196,14 @@ func (wh *WebHandlers) GetThemeName() string {
 	return wh.themeName
 }
 
-func (wh *WebHandlers) GetTemplateFS() *embed.FS {
-	return wh.templatesFS
+// GetLocales - returns the message catalogs of the handlers
+func (wh *WebHandlers) GetLocales() *i18n.Bundle {
+	return wh.locales
+}
+
+// GetThemes - returns the themes available to the handlers
+func (wh *WebHandlers) GetThemes() *ThemeRegistry {
+	return wh.themes
 }
 
 func (wh *WebHandlers) LoggerMiddleware(next http.Handler) http.Handler {
@@ -270,21 +216,3 @@ func (wh *WebHandlers) LoggerMiddleware(next http.Handler) http.Handler {
 	})
 
 }
-
-// ServeStaticFilesWithContentType -Custom handler to wrap http.FileServer and set correct Content-Type for CSS
-func (wh *WebHandlers) ServeStaticFilesWithContentType(fsys fs.FS) http.Handler {
-	fileServer := http.FileServer(http.FS(fsys))
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if the requested file path ends with ".css"
-		if strings.HasSuffix(r.URL.Path, ".css") {
-			w.Header().Set("Content-Type", "text/css")
-		}
-
-		if strings.HasSuffix(r.URL.Path, ".js") {
-			w.Header().Set("Content-Type", "application/javascript")
-		}
-
-		fileServer.ServeHTTP(w, r) // Call the original FileServer to handle the file serving
-	})
-}
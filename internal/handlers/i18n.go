@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/scalland/bitebuddy/pkg/i18n"
+)
+
+const (
+	// localeQueryParam - the query parameter which switches the locale, e.g. /restaurants?lang=hi
+	localeQueryParam = "lang"
+	// localeSessionKey - the session value which remembers the locale picked with localeQueryParam
+	localeSessionKey = "locale"
+)
+
+// localeContextKey - the key of the locale of a request in its context, see LocaleMiddleware
+type localeContextKey struct{}
+
+// LocaleMiddleware - works out the locale and the theme of every request once, see requestLocale and userThemeName,
+// and remembers a locale picked with the lang query parameter in the session so that the following pages are shown in
+// it too. The preferences of the logged-in user are kept in the session as well, see userPreferences.
+func (wh *WebHandlers) LocaleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Static files are the same in every locale, and found without the theme of the user, see ServeThemeStatic
+		if strings.HasPrefix(r.URL.Path, staticURLPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		wh.saveUserPreferences(w, r)
+		if picked, ok := wh.locales.Match(r.URL.Query().Get(localeQueryParam)); ok {
+			session, err := wh.GetSession(r)
+			if err != nil {
+				wh.Log.Debugf("handlers.WebHandlers.LocaleMiddleware: error getting session: %s", err.Error())
+			} else if session.Values[localeSessionKey] != picked {
+				session.Values[localeSessionKey] = picked
+				if err = session.Save(r, w); err != nil {
+					wh.Log.Errorf("handlers.WebHandlers.LocaleMiddleware: error saving session: %s", err.Error())
+				}
+			}
+		}
+		ctx := context.WithValue(r.Context(), localeContextKey{}, wh.requestLocale(r))
+		ctx = context.WithValue(ctx, themeContextKey{}, wh.userThemeName(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestLocale - returns the locale r is answered in: the one picked with the lang query parameter, else the one
+// picked earlier in the session, else the one chosen by the logged-in user, else the best match for the
+// Accept-Language header, else the default locale
+func (wh *WebHandlers) requestLocale(r *http.Request) string {
+	if locale, ok := wh.locales.Match(r.URL.Query().Get(localeQueryParam)); ok {
+		return locale
+	}
+
+	session, err := wh.GetSession(r)
+	if err != nil {
+		wh.Log.Debugf("handlers.WebHandlers.requestLocale: error getting session: %s", err.Error())
+	} else if picked, ok := session.Values[localeSessionKey].(string); ok {
+		if locale, ok := wh.locales.Match(picked); ok {
+			return locale
+		}
+	}
+
+	if prefs, _ := wh.userPreferences(r); prefs.Locale != "" {
+		if locale, ok := wh.locales.Match(prefs.Locale); ok {
+			return locale
+		}
+	}
+
+	return wh.locales.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// localizer - returns the Localizer of the locale r is answered in
+func (wh *WebHandlers) localizer(r *http.Request) *i18n.Localizer {
+	locale, ok := r.Context().Value(localeContextKey{}).(string)
+	if !ok {
+		locale = wh.requestLocale(r)
+	}
+	return wh.locales.Localizer(locale)
+}
+
+// T - returns the message stored under key in the catalog of the locale r is answered in, formatted with args
+func (wh *WebHandlers) T(r *http.Request, key string, args ...interface{}) string {
+	return wh.localizer(r).T(key, args...)
+}
+
+// formLocale - returns the locale chosen in the locale field of the form posted in r, or an empty string for unknown
+// locales and for the locale negotiated with the browser
+func (wh *WebHandlers) formLocale(r *http.Request) string {
+	locale, ok := wh.locales.Match(r.FormValue("locale"))
+	if !ok {
+		return ""
+	}
+	return locale
+}
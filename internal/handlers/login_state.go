@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// loginContextKey - the key of the LoginState of a request in its context, see LoginMiddleware
+type loginContextKey struct{}
+
+// LoginState - whether the user of a request is logged in, and whether they are an admin
+type LoginState struct {
+	LoggedIn bool
+	Admin    bool
+}
+
+// LoginMiddleware - works out once per request whether its user is logged in and is an admin, and keeps the answer
+// in the context of the request for RequireAuth, RequireAdmin and the pages the handlers render. Static files and
+// media are the same for every user and skip it.
+func (wh *WebHandlers) LoginMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, staticURLPrefix) || strings.HasPrefix(r.URL.Path, mediaURLPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, wh.withLoginState(w, r))
+	})
+}
+
+// withLoginState - returns r carrying the login state of its user in its context, see IsLoggedIn and
+// IsLoggedInAdmin. The state is only worked out if r does not carry it yet.
+func (wh *WebHandlers) withLoginState(w http.ResponseWriter, r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(loginContextKey{}).(LoginState); ok {
+		return r
+	}
+	var state LoginState
+	if wh.db != nil {
+		state.LoggedIn = wh.IsLoggedIn(r, w)
+		state.Admin = state.LoggedIn && wh.IsLoggedInAdmin(r, w)
+	}
+	return r.WithContext(context.WithValue(r.Context(), loginContextKey{}, state))
+}
+
+// loginState - returns the login state of the user of r, see LoginMiddleware. Requests it was not worked out for
+// count as logged out.
+func loginState(r *http.Request) LoginState {
+	state, _ := r.Context().Value(loginContextKey{}).(LoginState)
+	return state
+}
+
+// isLoggedIn - reports whether the user of r is logged in, see loginState
+func isLoggedIn(r *http.Request) bool {
+	return loginState(r).LoggedIn
+}
+
+// isAdmin - reports whether the user of r is a logged-in admin, see loginState
+func isAdmin(r *http.Request) bool {
+	return loginState(r).Admin
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// lookupPreloadLimit - the number of options rendered into a lookup select. Tables with more rows are searched
+	// through LookupHandler as the user types.
+	lookupPreloadLimit = 100
+	// lookupMaxLimit - the most options LookupHandler answers with
+	lookupMaxLimit = 50
+)
+
+// lookupSource - a table foreign keys point to, and how its rows are labelled for people
+type lookupSource struct {
+	// From is the FROM clause, with any joins the label needs
+	From string
+	// ID and Label are the SQL expressions of the primary key and of the label of a row
+	ID    string
+	Label string
+	// Where leaves out the rows which cannot be picked, e.g. deleted ones. May be empty.
+	Where string
+}
+
+// lookupSources - the tables which can be picked from in forms, by the name used in /lookup/{source}
+var lookupSources = map[string]lookupSource{
+	"restaurants": {
+		From:  "restaurants",
+		ID:    "restaurant_id",
+		Label: "name",
+		Where: "deleted_at IS NULL",
+	},
+	"users": {
+		From:  "users",
+		ID:    "user_id",
+		Label: "COALESCE(NULLIF(email, ''), NULLIF(mobile_number, ''), CONCAT('#', user_id))",
+		Where: "deleted_at IS NULL",
+	},
+	"reviews": {
+		From:  "reviews AS rv JOIN restaurants AS rs ON rv.restaurant_id=rs.restaurant_id JOIN users AS u ON rv.user_id=u.user_id",
+		ID:    "rv.review_id",
+		Label: "CONCAT('#', rv.review_id, ' ', rs.name, ' - ', COALESCE(NULLIF(u.email, ''), NULLIF(u.mobile_number, ''), CONCAT('#', u.user_id)))",
+		Where: "rv.deleted_at IS NULL AND rs.deleted_at IS NULL AND u.deleted_at IS NULL",
+	},
+	"metrics": {
+		From:  "metrics",
+		ID:    "metric_id",
+		Label: "metric_name",
+		Where: "deleted_at IS NULL",
+	},
+	"display_types": {
+		From:  "display_types",
+		ID:    "display_type_id",
+		Label: "display_type_name",
+	},
+	"metric_types": {
+		From:  "metric_types",
+		ID:    "metric_type_id",
+		Label: "metric_type_name",
+	},
+	"filter_types": {
+		From:  "filter_types",
+		ID:    "filter_type_id",
+		Label: "filter_type_name",
+	},
+}
+
+// LookupOption - a row of a lookup source as offered in a select
+type LookupOption struct {
+	ID    int64  `json:"id"`
+	Label string `json:"label"`
+}
+
+// LookupField - a foreign key field of a form, rendered by the lookup_select.html partial as a select which can be
+// searched. Options holds the first rows of the source, plus the selected one; when the source has more rows than
+// that, Searchable is set and the page searches the rest through LookupHandler.
+type LookupField struct {
+	// Name is the name and id of the form field
+	Name string
+	// Label is the catalog key of the label of the field
+	Label    string
+	Source   string
+	Required bool
+	Selected int64
+	Options  []LookupOption
+	// Searchable is set when Options does not hold every row of Source
+	Searchable bool
+}
+
+// IsSelected - reports whether id is the selected option
+func (f LookupField) IsSelected(id int64) bool {
+	return f.Selected > 0 && f.Selected == id
+}
+
+// query - returns the query listing the rows of s whose label contains the search term, if any, and its arguments
+func (s lookupSource) query(q string, limit int) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if s.Where != "" {
+		where = append(where, s.Where)
+	}
+	if q != "" {
+		where = append(where, fmt.Sprintf("(%s LIKE ? OR CAST(%s AS CHAR) = ?)", s.Label, s.ID))
+		args = append(args, "%"+escapeLike(q)+"%", q)
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s", s.ID, s.Label, s.From)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY 2 LIMIT %d", limit)
+	return query, args
+}
+
+// escapeLike - escapes the wildcards of LIKE patterns in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// lookupOptions - returns up to limit rows of the lookup source name whose label contains q, ordered by label
+func (wh *WebHandlers) lookupOptions(name, q string, limit int) ([]LookupOption, error) {
+	source, ok := lookupSources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown lookup source %s", name)
+	}
+	query, args := source.query(q, limit)
+	rows, err := wh.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s: %s", name, err.Error())
+	}
+	defer func(rows *sql.Rows) {
+		err = rows.Close()
+		if err != nil {
+			wh.Log.Errorf("handlers.WebHandlers.lookupOptions: error closing rows: %s", err.Error())
+		}
+	}(rows)
+
+	options := []LookupOption{}
+	for rows.Next() {
+		var o LookupOption
+		if err = rows.Scan(&o.ID, &o.Label); err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", name, err.Error())
+		}
+		options = append(options, o)
+	}
+	return options, rows.Err()
+}
+
+// lookupLabel - returns the label of the row id of the lookup source name, which may have been deleted since it was
+// picked
+func (wh *WebHandlers) lookupLabel(name string, id int64) (string, error) {
+	source, ok := lookupSources[name]
+	if !ok {
+		return "", fmt.Errorf("unknown lookup source %s", name)
+	}
+	var label string
+	err := wh.db.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", source.Label, source.From, source.ID), id).Scan(&label)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Sprintf("#%d", id), nil
+	}
+	return label, err
+}
+
+// lookupField - returns the form field name picking a row of the lookup source with selected, which is 0 when nothing
+// has been picked yet, as the current value
+func (wh *WebHandlers) lookupField(name, label, source string, required bool, selected int64) (LookupField, error) {
+	field := LookupField{Name: name, Label: label, Source: source, Required: required, Selected: selected}
+	options, err := wh.lookupOptions(source, "", lookupPreloadLimit+1)
+	if err != nil {
+		return field, err
+	}
+	if len(options) > lookupPreloadLimit {
+		options = options[:lookupPreloadLimit]
+		field.Searchable = true
+	}
+	field.Options = options
+
+	if selected > 0 {
+		for _, o := range options {
+			if o.ID == selected {
+				return field, nil
+			}
+		}
+		selectedLabel, err := wh.lookupLabel(source, selected)
+		if err != nil {
+			return field, err
+		}
+		field.Options = append([]LookupOption{{ID: selected, Label: selectedLabel}}, field.Options...)
+	}
+	return field, nil
+}
+
+// lookupResponse - the body of the answers of LookupHandler
+type lookupResponse struct {
+	Results []LookupOption `json:"results"`
+}
+
+// LookupHandler - answers /lookup/{source}?q=term&limit=n with the rows of the source whose label contains term, as
+// JSON. Used by the lookup selects of forms to search tables too large to be rendered into the page.
+func (wh *WebHandlers) LookupHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["source"]
+	if _, ok := lookupSources[name]; !ok {
+		wh.Error(w, r, notFound(nil, "error.unknown_lookup", name))
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > lookupMaxLimit {
+		limit = lookupMaxLimit
+	}
+	options, err := wh.lookupOptions(name, strings.TrimSpace(r.URL.Query().Get("q")), limit)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err = json.NewEncoder(w).Encode(lookupResponse{Results: options}); err != nil {
+		wh.Log.Debugf("handlers.WebHandlers.LookupHandler: error writing response: %s", err.Error())
+	}
+}
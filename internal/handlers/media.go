@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/images"
+	"github.com/scalland/bitebuddy/pkg/storage"
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultImageMaxUploadMB - the largest accepted image upload when image_max_upload_mb has not been set
+	defaultImageMaxUploadMB = 5
+
+	// mediaURLPrefix - the route media stored through WebHandlers.media is served from
+	mediaURLPrefix = "/media/"
+
+	// reviewPhotoMaxAge - how long clients and proxies may cache a photo of a review, which bounds how long it stays
+	// visible to them after a moderator hid it
+	reviewPhotoMaxAge = time.Hour
+)
+
+// imageMaxUploadBytes - returns the largest accepted image upload in bytes
+func imageMaxUploadBytes() int64 {
+	mb := viper.GetInt64("image_max_upload_mb")
+	if mb <= 0 {
+		mb = defaultImageMaxUploadMB
+	}
+	return mb << 20
+}
+
+// mediaURL - returns the URL key is served from
+func mediaURL(key string) string {
+	return mediaURLPrefix + key
+}
+
+// imageURL - returns the URL of the image stored under key in the named size, see images.Size
+func imageURL(key, size string) string {
+	return mediaURL(key + "/" + size + ".jpg")
+}
+
+// limitImageUpload - caps the size of the body of r so that a form carrying up to files image uploads cannot exhaust
+// memory or disk, then parses the form. It must be called before any form value of r is read. Failures are *HTTPError.
+func (wh *WebHandlers) limitImageUpload(w http.ResponseWriter, r *http.Request, files int) error {
+	maxBytes := imageMaxUploadBytes()
+	if files < 1 {
+		files = 1
+	}
+	// leave some room for the other form fields
+	r.Body = http.MaxBytesReader(w, r.Body, int64(files)*maxBytes+(1<<20))
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return badRequest(err, "error.upload_too_large", int64(files)*maxBytes>>20)
+		}
+		return badRequest(err, "error.upload_unreadable")
+	}
+	return nil
+}
+
+// storeUploadedImage - renders the image uploaded in the form field of r in each of sizes and stores the results
+// below prefix. It returns the key the sizes were stored under, or an empty key when nothing was uploaded. Keys are
+// derived from the content of the upload, so uploading the same image twice stores it once. Failures are *HTTPError.
+func (wh *WebHandlers) storeUploadedImage(r *http.Request, field, prefix string, sizes []images.Size) (string, error) {
+	_, header, err := r.FormFile(field)
+	if errors.Is(err, http.ErrMissingFile) {
+		return "", nil
+	}
+	if err != nil {
+		return "", badRequest(err, "error.upload_unreadable")
+	}
+	return wh.storeImage(header, prefix, sizes)
+}
+
+// uploadedFiles - returns the files uploaded in the form field of r, leaving out the empty parts browsers send when no
+// file was chosen
+func uploadedFiles(r *http.Request, field string) []*multipart.FileHeader {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	var headers []*multipart.FileHeader
+	for _, header := range r.MultipartForm.File[field] {
+		if header.Filename != "" || header.Size > 0 {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// storeUploadedImages - stores each of the images uploaded in the form field of r like storeUploadedImage. It returns
+// their keys in the order they were uploaded. Failures are *HTTPError, returned along with the keys of the images stored
+// before.
+func (wh *WebHandlers) storeUploadedImages(r *http.Request, field, prefix string, sizes []images.Size) ([]string, error) {
+	var keys []string
+	for _, header := range uploadedFiles(r, field) {
+		key, err := wh.storeImage(header, prefix, sizes)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// storeImage - validates the uploaded image header, renders it in each of sizes and stores the results below prefix,
+// returning the key they were stored under. Failures are *HTTPError.
+func (wh *WebHandlers) storeImage(header *multipart.FileHeader, prefix string, sizes []images.Size) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", badRequest(err, "error.upload_unreadable")
+	}
+	defer file.Close()
+
+	maxBytes := imageMaxUploadBytes()
+	if header.Size > maxBytes {
+		return "", badRequest(nil, "error.image_too_large", header.Filename, maxBytes>>20)
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		return "", badRequest(err, "error.upload_unreadable")
+	}
+	if int64(len(data)) > maxBytes {
+		return "", badRequest(nil, "error.image_too_large", header.Filename, maxBytes>>20)
+	}
+
+	// The content type sent by the browser is only a claim, the data has to actually be an image
+	contentType, err := wh.u.GetContentType(bytes.NewReader(data))
+	if err != nil {
+		return "", badRequest(err, "error.upload_unreadable")
+	}
+	if !images.SupportedContentTypes[contentType] {
+		return "", badRequest(fmt.Errorf("%s is %s", header.Filename, contentType), "error.image_unsupported", header.Filename)
+	}
+
+	rendered, err := images.Render(data, sizes)
+	if errors.Is(err, images.ErrTooLarge) {
+		return "", badRequest(err, "error.image_too_many_pixels", header.Filename)
+	}
+	if err != nil {
+		return "", badRequest(err, "error.image_unsupported", header.Filename)
+	}
+
+	sum := sha256.Sum256(data)
+	key := path.Join(prefix, hex.EncodeToString(sum[:16]))
+	for _, size := range sizes {
+		if err = wh.media.Put(key+"/"+size.Name+".jpg", bytes.NewReader(rendered[size.Name])); err != nil {
+			return "", internalError(fmt.Errorf("error storing the %s image: %s", size.Name, err.Error()))
+		}
+	}
+	wh.Log.Infof("handlers.WebHandlers.storeImage: stored %s (%s, %d bytes) as %s", header.Filename, contentType, len(data), key)
+	return key, nil
+}
+
+// MediaHandler - serves the uploaded files kept in the media storage. Stored files are never modified in place as
+// their keys are derived from their content, so clients and proxies may cache them for as long as they like. Photos of
+// reviews are the exception: they are only served while they are shown with a review, see db.IsReviewPhotoShown, and
+// cached for reviewPhotoMaxAge so that hiding them takes effect. Admins see the others to moderate them.
+func (wh *WebHandlers) MediaHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := storage.CleanKey(mux.Vars(r)["key"])
+	if err != nil {
+		wh.Error(w, r, notFound(err, ""))
+		return
+	}
+	cacheControl := "public, max-age=31536000, immutable"
+	if strings.HasPrefix(key, reviewPhotoPrefix+"/") {
+		shown, err := db.IsReviewPhotoShown(wh.db, path.Dir(key))
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		switch {
+		case shown:
+			cacheControl = fmt.Sprintf("public, max-age=%d", int(reviewPhotoMaxAge/time.Second))
+		case wh.IsLoggedInAdmin(r, w):
+			cacheControl = "private, no-store"
+		default:
+			wh.Error(w, r, notFound(fmt.Errorf("%s is not shown with any review", key), ""))
+			return
+		}
+	}
+	f, info, err := wh.media.Open(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		wh.Error(w, r, notFound(err, ""))
+		return
+	}
+	if err != nil {
+		wh.Error(w, r, internalError(fmt.Errorf("error opening %s: %s", key, err.Error())))
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", fmt.Sprintf("%q", strings.ReplaceAll(key, "/", "-")))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, path.Base(key), info.ModTime, f)
+}
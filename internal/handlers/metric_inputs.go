@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+)
+
+// metricScoreField - the prefix of the form fields review forms submit metric scores in, followed by the metric ID
+const metricScoreField = "metric_"
+
+// MetricInput - a metric scored in a form, rendered by metric_input.html with the widget of its display type
+type MetricInput struct {
+	// Name is the name of the form field of the score
+	Name     string
+	MetricID int64
+	Label    string
+	Widget   string
+	Score    sql.NullFloat64
+	Required bool
+	// Scale is the scale of the metric type of the metric, bounding the widget
+	Scale db.MetricScale
+}
+
+// StepAttr - returns the step attribute of the numeric inputs of the widget
+func (mi MetricInput) StepAttr() string {
+	if mi.Scale.Step <= 0 {
+		return "any"
+	}
+	return strconv.FormatFloat(mi.Scale.Step, 'f', -1, 64)
+}
+
+// IsScore - reports whether v is the current score
+func (mi MetricInput) IsScore(v float64) bool {
+	return mi.Score.Valid && mi.Score.Float64 == v
+}
+
+// metricInput - returns the input of the score of the metric metricID, with the widget of its display type and the
+// scale of its metric type. The error wraps sql.ErrNoRows if there is no such metric.
+func (wh *WebHandlers) metricInput(name string, metricID int64) (MetricInput, error) {
+	mi := MetricInput{Name: name, MetricID: metricID}
+	var labels string
+	err := wh.db.QueryRow("SELECT m.metric_name, dt.widget, mt.scale_min, mt.scale_max, mt.scale_step, COALESCE(mt.scale_labels, ''), mt.is_boolean FROM metrics AS m JOIN display_types AS dt ON m.display_type_id=dt.display_type_id JOIN metric_types AS mt ON m.metric_type_id=mt.metric_type_id WHERE m.metric_id=?", metricID).
+		Scan(&mi.Label, &mi.Widget, &mi.Scale.Min, &mi.Scale.Max, &mi.Scale.Step, &labels, &mi.Scale.Boolean)
+	if err != nil {
+		return mi, fmt.Errorf("error fetching metric %d: %w", metricID, err)
+	}
+	mi.Widget = displayWidget(mi.Widget)
+	mi.Scale.Labels = db.ParseScaleLabels(labels)
+	return mi, nil
+}
+
+// scoreError - returns the bad request for a score of the metric metricName which is not a value of s, as returned
+// by db.MetricScale.Validate
+func scoreError(err error, metricName string, s db.MetricScale) *HTTPError {
+	switch {
+	case errors.Is(err, db.ErrScoreNotBoolean):
+		return badRequest(err, "error.score_boolean", metricName, s.Min, s.Max)
+	case errors.Is(err, db.ErrScoreOffStep):
+		return badRequest(err, "error.score_step", metricName, s.Min, s.Step)
+	default:
+		return badRequest(err, "error.score_range", metricName, s.Min, s.Max)
+	}
+}
+
+// reviewMetricInputs - returns an input per enabled metric which has not been deleted, in their sort order, holding the score given to it by the
+// review reviewID if there is one. reviewID is 0 for new reviews.
+func (wh *WebHandlers) reviewMetricInputs(reviewID int64) ([]MetricInput, error) {
+	rows, err := wh.db.Query("SELECT m.metric_id, m.metric_name, dt.widget, mt.scale_min, mt.scale_max, mt.scale_step, COALESCE(mt.scale_labels, ''), mt.is_boolean, (SELECT mr.score FROM metric_reviews AS mr WHERE mr.review_id=? AND mr.metric_id=m.metric_id ORDER BY mr.metric_review_id LIMIT 1) FROM metrics AS m JOIN display_types AS dt ON m.display_type_id=dt.display_type_id JOIN metric_types AS mt ON m.metric_type_id=mt.metric_type_id WHERE m.deleted_at IS NULL AND m.is_enabled ORDER BY m.sort_order, m.metric_id", reviewID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying metrics: %s", err.Error())
+	}
+	defer rows.Close()
+	var inputs []MetricInput
+	for rows.Next() {
+		var (
+			mi     MetricInput
+			labels string
+		)
+		if err := rows.Scan(&mi.MetricID, &mi.Label, &mi.Widget, &mi.Scale.Min, &mi.Scale.Max, &mi.Scale.Step, &labels, &mi.Scale.Boolean, &mi.Score); err != nil {
+			return nil, fmt.Errorf("error scanning metric: %s", err.Error())
+		}
+		mi.Name = metricScoreField + strconv.FormatInt(mi.MetricID, 10)
+		mi.Widget = displayWidget(mi.Widget)
+		mi.Scale.Labels = db.ParseScaleLabels(labels)
+		inputs = append(inputs, mi)
+	}
+	return inputs, rows.Err()
+}
+
+// submittedMetricScores - returns the scores submitted in r for inputs, invalid where a score was left empty. The
+// error is a bad request naming the metric if a score is not a number or not a value of the scale of the metric.
+func submittedMetricScores(r *http.Request, inputs []MetricInput) (map[int64]sql.NullFloat64, error) {
+	scores := make(map[int64]sql.NullFloat64, len(inputs))
+	for _, mi := range inputs {
+		value := r.FormValue(mi.Name)
+		if value == "" {
+			scores[mi.MetricID] = sql.NullFloat64{}
+			continue
+		}
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, badRequest(err, "error.metric_score", mi.Label)
+		}
+		if err := mi.Scale.Validate(score); err != nil {
+			return nil, scoreError(err, mi.Label, mi.Scale)
+		}
+		scores[mi.MetricID] = sql.NullFloat64{Float64: score, Valid: true}
+	}
+	return scores, nil
+}
+
+// saveReviewMetricScores - stores scores, as returned by submittedMetricScores for inputs, as the metric scores of the
+// review reviewID through conn, auditing the changes. An invalid score removes the score the review had given to the
+// metric.
+func (wh *WebHandlers) saveReviewMetricScores(conn db.Queryer, r *http.Request, reviewID int64, inputs []MetricInput, scores map[int64]sql.NullFloat64) error {
+	for _, mi := range inputs {
+		score := scores[mi.MetricID]
+		if score == mi.Score {
+			continue
+		}
+		var metricReviewID int64
+		err := conn.QueryRow("SELECT metric_review_id FROM metric_reviews WHERE review_id=? AND metric_id=? ORDER BY metric_review_id LIMIT 1", reviewID, mi.MetricID).Scan(&metricReviewID)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("error fetching score of metric %d: %s", mi.MetricID, err.Error())
+		}
+		switch {
+		case !score.Valid:
+			before := wh.auditSnapshotIn(conn, "metric_reviews", metricReviewID)
+			if _, err := conn.Exec("DELETE FROM metric_reviews WHERE metric_review_id=?", metricReviewID); err != nil {
+				return fmt.Errorf("error deleting score of metric %d: %s", mi.MetricID, err.Error())
+			}
+			wh.auditIn(conn, r, db.AuditActionDelete, "metric_reviews", metricReviewID, before, nil)
+		case metricReviewID == 0:
+			res, err := conn.Exec("INSERT INTO metric_reviews (review_id, metric_id, score) VALUES (?, ?, ?)", reviewID, mi.MetricID, score.Float64)
+			if err != nil {
+				return fmt.Errorf("error inserting score of metric %d: %s", mi.MetricID, err.Error())
+			}
+			id, err := res.LastInsertId()
+			if err != nil {
+				return fmt.Errorf("error fetching ID of score of metric %d: %s", mi.MetricID, err.Error())
+			}
+			wh.auditIn(conn, r, db.AuditActionCreate, "metric_reviews", id, nil, wh.auditSnapshotIn(conn, "metric_reviews", id))
+		default:
+			before := wh.auditSnapshotIn(conn, "metric_reviews", metricReviewID)
+			if _, err := conn.Exec("UPDATE metric_reviews SET score=? WHERE metric_review_id=?", score.Float64, metricReviewID); err != nil {
+				return fmt.Errorf("error updating score of metric %d: %s", mi.MetricID, err.Error())
+			}
+			wh.auditIn(conn, r, db.AuditActionUpdate, "metric_reviews", metricReviewID, before, wh.auditSnapshotIn(conn, "metric_reviews", metricReviewID))
+		}
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
+
+	"github.com/scalland/bitebuddy/pkg/db"
 )
 
 type MetricReview struct {
@@ -10,59 +14,147 @@ type MetricReview struct {
 	ReviewID int64
 	MetricID int64
 	Score    float64
+	// ReviewLabel and MetricName describe the review and the metric the IDs refer to, only set when listing metric
+	// reviews
+	ReviewLabel string
+	MetricName  string
+	// Normalized is Score on the 0 to 1 range of the scale of the metric type, only set when listing metric reviews
+	Normalized sql.NullFloat64
+}
+
+type MetricReviewsHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	MetricReviews   []MetricReview
+}
+
+type MetricReviewFormTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	MetricReview    MetricReview
+	Review          LookupField
+	Metric          LookupField
+	// Score is the input of the score, with the widget of the display type of the metric once it is known
+	Score MetricInput
+}
+
+// metricReviewFormData - returns the data of metric_review_form.html for mr, with its foreign keys as lookup fields
+func (wh *WebHandlers) metricReviewFormData(r *http.Request, mr MetricReview) (MetricReviewFormTemplateData, error) {
+	data := MetricReviewFormTemplateData{IsLoggedIn: isLoggedIn(r), IsLoggedInAdmin: isAdmin(r), MetricReview: mr}
+	var err error
+	if data.Review, err = wh.lookupField("review_id", "field.review", "reviews", true, mr.ReviewID); err != nil {
+		return data, err
+	}
+	if data.Metric, err = wh.lookupField("metric_id", "field.metric", "metrics", true, mr.MetricID); err != nil {
+		return data, err
+	}
+	data.Score = MetricInput{Name: "score", Widget: DisplayWidgetNumber}
+	if mr.ID != 0 {
+		if data.Score, err = wh.metricInput("score", mr.MetricID); err != nil {
+			return data, err
+		}
+		data.Score.Score = sql.NullFloat64{Float64: mr.Score, Valid: true}
+	}
+	data.Score.Label = "Score"
+	data.Score.Required = true
+	return data, err
+}
+
+// submittedMetricReviewScore - returns the metric and the score submitted with metric_review_form.html. The error is a
+// bad request if there is no such metric or the score is not a value of the scale of its metric type.
+func (wh *WebHandlers) submittedMetricReviewScore(r *http.Request) (int64, float64, error) {
+	metricID, _ := strconv.ParseInt(r.FormValue("metric_id"), 10, 64)
+	mi, err := wh.metricInput("score", metricID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, badRequest(err, "error.unknown_metric", metricID)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	score, err := strconv.ParseFloat(r.FormValue("score"), 64)
+	if err != nil {
+		return 0, 0, badRequest(err, "error.metric_score", mi.Label)
+	}
+	if err := mi.Scale.Validate(score); err != nil {
+		return 0, 0, scoreError(err, mi.Label, mi.Scale)
+	}
+	return metricID, score, nil
 }
 
 // -----------------------------------------------------------------
 // Metric Reviews Handlers
 
 func (wh *WebHandlers) MetricReviewsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := wh.db.Query("SELECT metric_review_id, review_id, metric_id, score FROM metric_reviews")
+	rows, err := wh.db.Query("SELECT mr.metric_review_id, mr.review_id, CONCAT('#', rv.review_id, ' ', rs.name), mr.metric_id, m.metric_name, mr.score, " + db.NormalizedScoreSQL + " FROM metric_reviews AS mr JOIN reviews AS rv ON mr.review_id=rv.review_id JOIN restaurants AS rs ON rv.restaurant_id=rs.restaurant_id JOIN metrics AS m ON mr.metric_id=m.metric_id JOIN metric_types AS mt ON m.metric_type_id=mt.metric_type_id WHERE rv.deleted_at IS NULL AND m.deleted_at IS NULL ORDER BY mr.metric_review_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer rows.Close()
 	var mrReviews []MetricReview
 	for rows.Next() {
 		var mr MetricReview
-		err := rows.Scan(&mr.ID, &mr.ReviewID, &mr.MetricID, &mr.Score)
+		err := rows.Scan(&mr.ID, &mr.ReviewID, &mr.ReviewLabel, &mr.MetricID, &mr.MetricName, &mr.Score, &mr.Normalized)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 		mrReviews = append(mrReviews, mr)
 	}
-	tmpl, tmplErr := wh.ExecuteTemplate("metric_reviews", mrReviews)
+	templateData := MetricReviewsHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		MetricReviews:   mrReviews,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "metric_reviews", templateData)
 	if tmplErr != nil {
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
 
 func (wh *WebHandlers) MetricReviewNewHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl, tmplErr := wh.ExecuteTemplate("metric_review_form", nil)
+		templateData, err := wh.metricReviewFormData(r, MetricReview{})
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "metric_review_form", templateData)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	reviewID, _ := strconv.ParseInt(r.FormValue("review_id"), 10, 64)
-	metricID, _ := strconv.ParseInt(r.FormValue("metric_id"), 10, 64)
-	score, _ := strconv.ParseFloat(r.FormValue("score"), 64)
+	metricID, score, err := wh.submittedMetricReviewScore(r)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
 	stmt, err := wh.db.Prepare("INSERT INTO metric_reviews (review_id, metric_id, score) VALUES (?, ?, ?)")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(reviewID, metricID, score)
+	res, err := stmt.Exec(reviewID, metricID, score)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/metric_reviews", http.StatusSeeOther)
+	wh.auditCreate(r, "metric_reviews", res)
+	if err := wh.updateOverallScore(wh.db, r, reviewID); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.rankReviewRestaurant(reviewID)
+	wh.redirectWithFlash(w, r, "/metric_reviews", FlashSuccess, "flash.created", wh.T(r, "entity.metric_reviews"))
 }
 
 func (wh *WebHandlers) MetricReviewEditHandler(w http.ResponseWriter, r *http.Request) {
@@ -73,50 +165,98 @@ func (wh *WebHandlers) MetricReviewEditHandler(w http.ResponseWriter, r *http.Re
 		err := wh.db.QueryRow("SELECT metric_review_id, review_id, metric_id, score FROM metric_reviews WHERE metric_review_id=?", id).
 			Scan(&mr.ID, &mr.ReviewID, &mr.MetricID, &mr.Score)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		templateData, err := wh.metricReviewFormData(r, mr)
+		if err != nil {
+			wh.Error(w, r, internalError(err))
 			return
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("metric_review_form", mr)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "metric_review_form", templateData)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	reviewID, _ := strconv.ParseInt(r.FormValue("review_id"), 10, 64)
-	metricID, _ := strconv.ParseInt(r.FormValue("metric_id"), 10, 64)
-	score, _ := strconv.ParseFloat(r.FormValue("score"), 64)
+	metricID, score, err := wh.submittedMetricReviewScore(r)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	var previousReviewID int64
+	err = wh.db.QueryRow("SELECT review_id FROM metric_reviews WHERE metric_review_id=?", id).Scan(&previousReviewID)
+	if err == sql.ErrNoRows {
+		wh.Error(w, r, notFound(err, ""))
+		return
+	}
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	before := wh.auditSnapshot("metric_reviews", id)
 	stmt, err := wh.db.Prepare("UPDATE metric_reviews SET review_id=?, metric_id=?, score=? WHERE metric_review_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(reviewID, metricID, score, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/metric_reviews", http.StatusSeeOther)
+	wh.auditUpdate(r, "metric_reviews", id, before)
+	if err := wh.updateOverallScore(wh.db, r, reviewID); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	// The score may have been moved from another review
+	if previousReviewID != reviewID {
+		if err := wh.updateOverallScore(wh.db, r, previousReviewID); err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+	}
+	wh.rankReviewRestaurant(reviewID)
+	if previousReviewID != reviewID {
+		wh.rankReviewRestaurant(previousReviewID)
+	}
+	wh.redirectWithFlash(w, r, "/metric_reviews", FlashSuccess, "flash.updated", wh.T(r, "entity.metric_reviews"))
 }
 
 func (wh *WebHandlers) MetricReviewDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	idStr := r.FormValue("id")
 	id, _ := strconv.ParseInt(idStr, 10, 64)
+	var reviewID int64
+	err := wh.db.QueryRow("SELECT review_id FROM metric_reviews WHERE metric_review_id=?", id).Scan(&reviewID)
+	if err != nil && err != sql.ErrNoRows {
+		wh.redirectWithError(w, r, "/metric_reviews", err, "flash.delete_failed", wh.T(r, "entity.metric_reviews"))
+		return
+	}
+	before := wh.auditSnapshot("metric_reviews", id)
 	stmt, err := wh.db.Prepare("DELETE FROM metric_reviews WHERE metric_review_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/metric_reviews", err, "flash.delete_failed", wh.T(r, "entity.metric_reviews"))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/metric_reviews", err, "flash.delete_failed", wh.T(r, "entity.metric_reviews"))
 		return
 	}
-	http.Redirect(w, r, "/metric_reviews", http.StatusSeeOther)
+	wh.auditDelete(r, "metric_reviews", id, before)
+	if err := wh.updateOverallScore(wh.db, r, reviewID); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.MetricReviewDeleteHandler: %s", err.Error())
+	}
+	wh.rankReviewRestaurant(reviewID)
+	wh.redirectWithFlash(w, r, "/metric_reviews", FlashSuccess, "flash.deleted", wh.T(r, "entity.metric_reviews"))
 }
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+)
+
+// The bulk actions of the metric tree, see MetricBulkHandler
+const (
+	metricBulkEnable  = "enable"
+	metricBulkDisable = "disable"
+	metricBulkMove    = "move"
+)
+
+// MetricTreeNode - a metric of the tree shown on the metrics page, with its sub-metrics in their sort order
+type MetricTreeNode struct {
+	Metric
+	Children []*MetricTreeNode
+	// First and Last are set for the first and the last metric among its siblings, which cannot be moved further up
+	// or down
+	First, Last bool
+	// Editable is set when the tree is shown to an admin, who can change it
+	Editable bool
+}
+
+// metricTree - returns the top-level metrics of metrics, with the sub-metrics of each below it. metrics are expected
+// in their sort order. Metrics whose parent is not among metrics are shown as top-level metrics.
+func metricTree(metrics []Metric, editable bool) []*MetricTreeNode {
+	nodes := make(map[int64]*MetricTreeNode, len(metrics))
+	for _, m := range metrics {
+		nodes[m.ID] = &MetricTreeNode{Metric: m, Editable: editable}
+	}
+	var roots []*MetricTreeNode
+	for _, m := range metrics {
+		node := nodes[m.ID]
+		if parent, ok := nodes[m.ParentMetricID.Int64]; m.ParentMetricID.Valid && ok && !isMetricAncestor(nodes, m.ID, parent) {
+			parent.Children = append(parent.Children, node)
+			continue
+		}
+		roots = append(roots, node)
+	}
+	markEnds(roots)
+	for _, node := range nodes {
+		markEnds(node.Children)
+	}
+	return roots
+}
+
+// isMetricAncestor - reports whether the metric id is node or one of its ancestors, which would make the tree a loop.
+// The hierarchy is kept free of cycles when parents are set, this only guards against older data.
+func isMetricAncestor(nodes map[int64]*MetricTreeNode, id int64, node *MetricTreeNode) bool {
+	seen := make(map[int64]bool)
+	for node != nil && !seen[node.ID] {
+		if node.ID == id {
+			return true
+		}
+		seen[node.ID] = true
+		if !node.ParentMetricID.Valid {
+			return false
+		}
+		node = nodes[node.ParentMetricID.Int64]
+	}
+	return node != nil
+}
+
+// markEnds - marks the first and the last of siblings
+func markEnds(siblings []*MetricTreeNode) {
+	if len(siblings) == 0 {
+		return
+	}
+	siblings[0].First = true
+	siblings[len(siblings)-1].Last = true
+}
+
+// -----------------------------------------------------------------
+// Metric Tree Handlers
+
+// MetricMoveHandler - moves the metric ?id= one place up or down, as ?direction= says, among the metrics of the same
+// parent
+func (wh *WebHandlers) MetricMoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	direction := r.FormValue("direction")
+	if direction != "up" && direction != "down" {
+		wh.Error(w, r, badRequest(nil, ""))
+		return
+	}
+	var parentID sql.NullInt64
+	err := wh.db.QueryRow("SELECT parent_metric_id FROM metrics WHERE metric_id=? AND deleted_at IS NULL", id).Scan(&parentID)
+	if err == sql.ErrNoRows {
+		wh.Error(w, r, notFound(err, ""))
+		return
+	}
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+
+	siblings, err := wh.metricSiblingIDs(parentID)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	for i, siblingID := range siblings {
+		if siblingID != id {
+			continue
+		}
+		switch {
+		case direction == "up" && i > 0:
+			siblings[i-1], siblings[i] = siblings[i], siblings[i-1]
+		case direction == "down" && i < len(siblings)-1:
+			siblings[i+1], siblings[i] = siblings[i], siblings[i+1]
+		}
+		break
+	}
+	before := wh.auditSnapshot("metrics", id)
+	// The siblings are numbered afresh, which also orders metrics created before sort_order existed
+	for i, siblingID := range siblings {
+		if _, err := wh.db.Exec("UPDATE metrics SET sort_order=? WHERE metric_id=?", i+1, siblingID); err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+	}
+	wh.auditUpdate(r, "metrics", id, before)
+	http.Redirect(w, r, "/metrics", http.StatusSeeOther)
+}
+
+// metricSiblingIDs - returns the metrics of the parent parentID which have not been deleted, or the top-level metrics
+// if parentID is invalid, in their sort order
+func (wh *WebHandlers) metricSiblingIDs(parentID sql.NullInt64) ([]int64, error) {
+	query := "SELECT metric_id FROM metrics WHERE parent_metric_id IS NULL AND deleted_at IS NULL ORDER BY sort_order, metric_id"
+	var args []interface{}
+	if parentID.Valid {
+		query = "SELECT metric_id FROM metrics WHERE parent_metric_id=? AND deleted_at IS NULL ORDER BY sort_order, metric_id"
+		args = append(args, parentID.Int64)
+	}
+	rows, err := wh.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying sibling metrics: %s", err.Error())
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning sibling metric: %s", err.Error())
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// nextMetricSortOrder - returns the sort order which puts a metric after the other metrics of the parent parentID
+func (wh *WebHandlers) nextMetricSortOrder(parentID sql.NullInt64) (int, error) {
+	var next int
+	err := wh.db.QueryRow("SELECT COALESCE(MAX(sort_order), 0) + 1 FROM metrics WHERE parent_metric_id <=> ? AND deleted_at IS NULL", parentID).Scan(&next)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching the sort order of sibling metrics: %s", err.Error())
+	}
+	return next, nil
+}
+
+// MetricBulkHandler - enables, disables or moves under another parent the metrics ticked in the metric tree.
+// Disabled metrics are no longer offered in review forms, the scores they were given still count.
+func (wh *WebHandlers) MetricBulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		wh.Error(w, r, badRequest(err, ""))
+		return
+	}
+	var ids []int64
+	for _, idStr := range r.PostForm["ids"] {
+		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		wh.redirectWithError(w, r, "/metrics", nil, "error.no_metrics_selected")
+		return
+	}
+
+	action := r.FormValue("action")
+	var parentID sql.NullInt64
+	switch action {
+	case metricBulkEnable, metricBulkDisable:
+	case metricBulkMove:
+		if parentStr := r.FormValue("move_to"); parentStr != "" {
+			idVal, _ := strconv.ParseInt(parentStr, 10, 64)
+			parentID = sql.NullInt64{Int64: idVal, Valid: true}
+		}
+		for _, id := range ids {
+			if !parentID.Valid {
+				break
+			}
+			err := db.CheckMetricParent(wh.db, id, parentID.Int64)
+			if errors.Is(err, db.ErrMetricCycle) {
+				wh.redirectWithError(w, r, "/metrics", nil, "error.metric_cycle")
+				return
+			}
+			if err != nil {
+				wh.Error(w, r, internalError(err))
+				return
+			}
+		}
+	default:
+		wh.Error(w, r, badRequest(nil, ""))
+		return
+	}
+
+	for _, id := range ids {
+		before := wh.auditSnapshot("metrics", id)
+		var err error
+		switch action {
+		case metricBulkMove:
+			var sortOrder int
+			if sortOrder, err = wh.nextMetricSortOrder(parentID); err == nil {
+				_, err = wh.db.Exec("UPDATE metrics SET parent_metric_id=?, is_sub_metric=?, sort_order=? WHERE metric_id=? AND deleted_at IS NULL", parentID, parentID.Valid, sortOrder, id)
+			}
+		default:
+			_, err = wh.db.Exec("UPDATE metrics SET is_enabled=? WHERE metric_id=? AND deleted_at IS NULL", action == metricBulkEnable, id)
+		}
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		wh.auditUpdate(r, "metrics", id, before)
+	}
+	if action == metricBulkMove {
+		if err := wh.updateAllOverallScores(); err != nil {
+			wh.Log.Errorf("handlers.WebHandlers.MetricBulkHandler: %s", err.Error())
+		}
+	}
+	wh.redirectWithFlash(w, r, "/metrics", FlashSuccess, "flash.metrics_updated", len(ids))
+}
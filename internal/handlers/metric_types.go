@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+)
+
+type MetricType struct {
+	ID             int64
+	MetricTypeName string
+	Scale          db.MetricScale
+	// Metrics is the number of metrics of the type, only set when listing metric types
+	Metrics int
+}
+
+type MetricTypesHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	MetricTypes     []MetricType
+}
+
+type MetricTypeFormTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	MetricType      MetricType
+}
+
+// -----------------------------------------------------------------
+// Metric Types Handlers
+
+func (wh *WebHandlers) MetricTypesHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := wh.db.Query("SELECT mt.metric_type_id, mt.metric_type_name, mt.scale_min, mt.scale_max, mt.scale_step, COALESCE(mt.scale_labels, ''), mt.is_boolean, COUNT(m.metric_id) FROM metric_types AS mt LEFT JOIN metrics AS m ON m.metric_type_id=mt.metric_type_id AND m.deleted_at IS NULL GROUP BY mt.metric_type_id ORDER BY mt.metric_type_id")
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	defer rows.Close()
+	var metricTypes []MetricType
+	for rows.Next() {
+		var (
+			mt     MetricType
+			labels string
+		)
+		err := rows.Scan(&mt.ID, &mt.MetricTypeName, &mt.Scale.Min, &mt.Scale.Max, &mt.Scale.Step, &labels, &mt.Scale.Boolean, &mt.Metrics)
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		mt.Scale.Labels = db.ParseScaleLabels(labels)
+		metricTypes = append(metricTypes, mt)
+	}
+	templateData := MetricTypesHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		MetricTypes:     metricTypes,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "metric_types", templateData)
+	if tmplErr != nil {
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+func (wh *WebHandlers) MetricTypeNewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		wh.renderMetricTypeForm(w, r, MetricType{Scale: db.MetricScale{Min: 1, Max: 5, Step: 1}})
+		return
+	}
+	metricTypeName := r.FormValue("metric_type_name")
+	scale, err := submittedMetricScale(r)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	stmt, err := wh.db.Prepare("INSERT INTO metric_types (metric_type_name, scale_min, scale_max, scale_step, scale_labels, is_boolean) VALUES (?, ?, ?, ?, NULLIF(?, ''), ?)")
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(metricTypeName, scale.Min, scale.Max, scale.Step, scale.LabelsString(), scale.Boolean)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.auditCreate(r, "metric_types", res)
+	wh.redirectWithFlash(w, r, "/metric_types", FlashSuccess, "flash.created", wh.T(r, "entity.metric_types"))
+}
+
+func (wh *WebHandlers) MetricTypeEditHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Query().Get("id")
+	id, _ := strconv.ParseInt(idStr, 10, 64)
+	if r.Method == http.MethodGet {
+		var (
+			mt     MetricType
+			labels string
+		)
+		err := wh.db.QueryRow("SELECT metric_type_id, metric_type_name, scale_min, scale_max, scale_step, COALESCE(scale_labels, ''), is_boolean FROM metric_types WHERE metric_type_id=?", id).
+			Scan(&mt.ID, &mt.MetricTypeName, &mt.Scale.Min, &mt.Scale.Max, &mt.Scale.Step, &labels, &mt.Scale.Boolean)
+		if err == sql.ErrNoRows {
+			wh.Error(w, r, notFound(err, ""))
+			return
+		}
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		mt.Scale.Labels = db.ParseScaleLabels(labels)
+		wh.renderMetricTypeForm(w, r, mt)
+		return
+	}
+	metricTypeName := r.FormValue("metric_type_name")
+	scale, err := submittedMetricScale(r)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	before := wh.auditSnapshot("metric_types", id)
+	stmt, err := wh.db.Prepare("UPDATE metric_types SET metric_type_name=?, scale_min=?, scale_max=?, scale_step=?, scale_labels=NULLIF(?, ''), is_boolean=? WHERE metric_type_id=?")
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(metricTypeName, scale.Min, scale.Max, scale.Step, scale.LabelsString(), scale.Boolean, id)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.auditUpdate(r, "metric_types", id, before)
+	wh.redirectWithFlash(w, r, "/metric_types", FlashSuccess, "flash.updated", wh.T(r, "entity.metric_types"))
+}
+
+// submittedMetricScale - returns the scale submitted with metric_type_form.html. The labels are entered one per line.
+// Boolean scales have a single step from no to yes.
+func submittedMetricScale(r *http.Request) (db.MetricScale, error) {
+	var (
+		s   db.MetricScale
+		err error
+	)
+	if s.Min, err = strconv.ParseFloat(r.FormValue("scale_min"), 64); err != nil {
+		return s, badRequest(err, "error.scale_invalid", err.Error())
+	}
+	if s.Max, err = strconv.ParseFloat(r.FormValue("scale_max"), 64); err != nil {
+		return s, badRequest(err, "error.scale_invalid", err.Error())
+	}
+	if step := r.FormValue("scale_step"); step != "" {
+		if s.Step, err = strconv.ParseFloat(step, 64); err != nil {
+			return s, badRequest(err, "error.scale_invalid", err.Error())
+		}
+	}
+	s.Boolean = r.FormValue("is_boolean") != ""
+	if s.Boolean {
+		s.Step = s.Max - s.Min
+	}
+	for _, label := range strings.Split(r.FormValue("scale_labels"), "\n") {
+		if label = strings.TrimSpace(strings.ReplaceAll(label, db.ScaleLabelSeparator, "/")); label != "" {
+			s.Labels = append(s.Labels, label)
+		}
+	}
+	if err := s.Check(); err != nil {
+		return s, badRequest(err, "error.scale_invalid", err.Error())
+	}
+	return s, nil
+}
+
+// renderMetricTypeForm - renders metric_type_form.html for mt
+func (wh *WebHandlers) renderMetricTypeForm(w http.ResponseWriter, r *http.Request, mt MetricType) {
+	templateData := MetricTypeFormTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		MetricType:      mt,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "metric_type_form", templateData)
+	if tmplErr != nil {
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+func (wh *WebHandlers) MetricTypeDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	idStr := r.FormValue("id")
+	id, _ := strconv.ParseInt(idStr, 10, 64)
+	// Deleting a metric type cascades to its metrics and their scores, deleted ones included, so it has to be unused
+	var inUse int
+	err := wh.db.QueryRow("SELECT COUNT(*) FROM metrics WHERE metric_type_id=?", id).Scan(&inUse)
+	if err != nil {
+		wh.redirectWithError(w, r, "/metric_types", err, "flash.delete_failed", wh.T(r, "entity.metric_types"))
+		return
+	}
+	if inUse > 0 {
+		wh.redirectWithError(w, r, "/metric_types", nil, "error.type_in_use", wh.T(r, "entity.metric_types"), inUse)
+		return
+	}
+	before := wh.auditSnapshot("metric_types", id)
+	stmt, err := wh.db.Prepare("DELETE FROM metric_types WHERE metric_type_id=?")
+	if err != nil {
+		wh.redirectWithError(w, r, "/metric_types", err, "flash.delete_failed", wh.T(r, "entity.metric_types"))
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(id)
+	if err != nil {
+		wh.redirectWithError(w, r, "/metric_types", err, "flash.delete_failed", wh.T(r, "entity.metric_types"))
+		return
+	}
+	wh.auditDelete(r, "metric_types", id, before)
+	wh.redirectWithFlash(w, r, "/metric_types", FlashSuccess, "flash.deleted", wh.T(r, "entity.metric_types"))
+}
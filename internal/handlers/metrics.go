@@ -2,8 +2,13 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
 )
 
 type Metric struct {
@@ -13,66 +18,272 @@ type Metric struct {
 	IsSubMetric    bool
 	DisplayTypeID  int
 	MetricTypeID   int
+	// Weight is the share of the metric among its siblings relative to theirs, see db.MetricHierarchy
+	Weight float64
+	// SortOrder is the position of the metric among its siblings, see MetricMoveHandler
+	SortOrder int
+	// IsEnabled is unset for metrics which are no longer offered in review forms
+	IsEnabled bool
+	// ParentMetricName, DisplayTypeName and MetricTypeName are the names of the rows the IDs refer to, only set when
+	// listing metrics
+	ParentMetricName sql.NullString
+	DisplayTypeName  string
+	MetricTypeName   string
+	// Share and ShareOfParent are the shares of the metric in the overall score of a review and in the score of its
+	// parent, from 0 to 1, only set when listing metrics
+	Share         float64
+	ShareOfParent float64
+}
+
+// MetricWeight - a metric among the siblings of the metric being edited, previewing how their weights combine
+type MetricWeight struct {
+	ID     int64
+	Name   string
+	Weight float64
+	// Share is the share of the metric in the score of the parent, from 0 to 1
+	Share float64
+	// Current is set for the metric being edited
+	Current bool
+}
+
+type MetricsHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Metrics         []Metric
+	// Tree holds Metrics along the hierarchy
+	Tree []*MetricTreeNode
+}
+
+type MetricFormTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Metric          Metric
+	ParentMetric    LookupField
+	DisplayType     LookupField
+	MetricType      LookupField
+	// Siblings preview how the weight of the metric combines with the weights of the other children of its parent, or
+	// of the other top-level metrics
+	Siblings []MetricWeight
+}
+
+// metricFormData - returns the data of metric_form.html for m, with its foreign keys as lookup fields
+func (wh *WebHandlers) metricFormData(r *http.Request, m Metric) (MetricFormTemplateData, error) {
+	data := MetricFormTemplateData{IsLoggedIn: isLoggedIn(r), IsLoggedInAdmin: isAdmin(r), Metric: m}
+	var err error
+	if data.ParentMetric, err = wh.lookupField("parent_metric_id", "field.parent_metric", "metrics", false, m.ParentMetricID.Int64); err != nil {
+		return data, err
+	}
+	if data.DisplayType, err = wh.lookupField("display_type_id", "field.display_type", "display_types", true, int64(m.DisplayTypeID)); err != nil {
+		return data, err
+	}
+	if data.MetricType, err = wh.lookupField("metric_type_id", "field.metric_type", "metric_types", true, int64(m.MetricTypeID)); err != nil {
+		return data, err
+	}
+	data.Siblings, err = wh.metricSiblings(m)
+	return data, err
+}
+
+// metricSiblings - returns m along with the other metrics of its parent, with the share of each in the score of the
+// parent given the weight of m
+func (wh *WebHandlers) metricSiblings(m Metric) ([]MetricWeight, error) {
+	h, err := db.LoadMetricHierarchy(wh.db)
+	if err != nil {
+		return nil, fmt.Errorf("error loading metrics: %s", err.Error())
+	}
+	ids := h.Roots
+	if m.ParentMetricID.Valid {
+		ids = h.Children[m.ParentMetricID.Int64]
+	}
+	siblings := []MetricWeight{{ID: m.ID, Name: m.MetricName, Weight: m.Weight, Current: true}}
+	for _, id := range ids {
+		if id != m.ID {
+			siblings = append(siblings, MetricWeight{ID: id, Name: h.Metrics[id].Name, Weight: h.Metrics[id].Weight})
+		}
+	}
+	var weights float64
+	for _, s := range siblings {
+		if s.Weight > 0 {
+			weights += s.Weight
+		}
+	}
+	for i, s := range siblings {
+		if s.Weight > 0 {
+			siblings[i].Share = s.Weight / weights
+		}
+	}
+	return siblings, nil
+}
+
+// submittedMetricHierarchy - returns the parent and the weight submitted with metric_form.html for the metric id, 0
+// for new metrics. The error is a bad request if the weight is negative or the parent is a sub-metric of the metric.
+func (wh *WebHandlers) submittedMetricHierarchy(r *http.Request, id int64) (sql.NullInt64, float64, error) {
+	var parentID sql.NullInt64
+	if parentStr := r.FormValue("parent_metric_id"); parentStr != "" {
+		idVal, _ := strconv.ParseInt(parentStr, 10, 64)
+		parentID = sql.NullInt64{Int64: idVal, Valid: true}
+	}
+	weight := 1.0
+	if weightStr := r.FormValue("weight"); weightStr != "" {
+		var err error
+		if weight, err = strconv.ParseFloat(weightStr, 64); err != nil || weight < 0 {
+			return parentID, 0, badRequest(err, "error.metric_weight", weightStr)
+		}
+	}
+	if parentID.Valid {
+		err := db.CheckMetricParent(wh.db, id, parentID.Int64)
+		if errors.Is(err, db.ErrMetricCycle) {
+			return parentID, 0, badRequest(err, "error.metric_cycle")
+		}
+		if err != nil {
+			return parentID, 0, err
+		}
+	}
+	return parentID, weight, nil
+}
+
+// updateOverallScore - derives the overall score of the review reviewID from its metric scores, see
+// db.UpdateOverallScore, and audits the change, both through conn
+func (wh *WebHandlers) updateOverallScore(conn db.Queryer, r *http.Request, reviewID int64) error {
+	h, err := db.LoadMetricHierarchy(conn)
+	if err != nil {
+		return fmt.Errorf("error loading metrics: %s", err.Error())
+	}
+	before := wh.auditSnapshotIn(conn, "reviews", reviewID)
+	overall, err := db.UpdateOverallScore(conn, h, reviewID)
+	if err != nil {
+		return fmt.Errorf("error updating overall score of review %d: %s", reviewID, err.Error())
+	}
+	if overall.Valid {
+		wh.auditIn(conn, r, db.AuditActionUpdate, "reviews", reviewID, before, wh.auditSnapshotIn(conn, "reviews", reviewID))
+	}
+	return nil
+}
+
+// updateAllOverallScores - derives the overall scores of every review with metric scores again, after the weights or
+// the hierarchy of the metrics changed, and ranks the restaurants again. The changes follow from the audited change of
+// the metric and are not audited one by one.
+func (wh *WebHandlers) updateAllOverallScores() error {
+	h, err := db.LoadMetricHierarchy(wh.db)
+	if err != nil {
+		return fmt.Errorf("error loading metrics: %s", err.Error())
+	}
+	rows, err := wh.db.Query("SELECT DISTINCT mr.review_id FROM metric_reviews AS mr JOIN reviews AS rv ON mr.review_id=rv.review_id WHERE rv.deleted_at IS NULL")
+	if err != nil {
+		return fmt.Errorf("error querying reviews with metric scores: %s", err.Error())
+	}
+	var reviewIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning review: %s", err.Error())
+		}
+		reviewIDs = append(reviewIDs, id)
+	}
+	rows.Close()
+	for _, id := range reviewIDs {
+		if _, err := db.UpdateOverallScore(wh.db, h, id); err != nil {
+			return fmt.Errorf("error updating overall score of review %d: %s", id, err.Error())
+		}
+	}
+	if _, err := db.RankRestaurants(wh.db, db.LoadRanking(), time.Now()); err != nil {
+		return fmt.Errorf("error ranking restaurants: %s", err.Error())
+	}
+	return nil
 }
 
 // -----------------------------------------------------------------
 // Metrics Handlers
 
 func (wh *WebHandlers) MetricsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := wh.db.Query("SELECT metric_id, metric_name, parent_metric_id, is_sub_metric, display_type_id, metric_type_id FROM metrics")
+	rows, err := wh.db.Query("SELECT m.metric_id, m.metric_name, m.parent_metric_id, p.metric_name, m.is_sub_metric, m.display_type_id, COALESCE(dt.display_type_name, ''), m.metric_type_id, COALESCE(mt.metric_type_name, ''), m.weight, m.sort_order, m.is_enabled FROM metrics AS m LEFT JOIN metrics AS p ON m.parent_metric_id=p.metric_id AND p.deleted_at IS NULL LEFT JOIN display_types AS dt ON m.display_type_id=dt.display_type_id LEFT JOIN metric_types AS mt ON m.metric_type_id=mt.metric_type_id WHERE m.deleted_at IS NULL ORDER BY m.sort_order, m.metric_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer rows.Close()
 	var metrics []Metric
 	for rows.Next() {
 		var m Metric
-		err := rows.Scan(&m.ID, &m.MetricName, &m.ParentMetricID, &m.IsSubMetric, &m.DisplayTypeID, &m.MetricTypeID)
+		err := rows.Scan(&m.ID, &m.MetricName, &m.ParentMetricID, &m.ParentMetricName, &m.IsSubMetric, &m.DisplayTypeID, &m.DisplayTypeName, &m.MetricTypeID, &m.MetricTypeName, &m.Weight, &m.SortOrder, &m.IsEnabled)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 		metrics = append(metrics, m)
 	}
-	tmpl, tmplErr := wh.ExecuteTemplate("metrics", metrics)
+	h, err := db.LoadMetricHierarchy(wh.db)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	shares, sharesOfParent := h.Shares()
+	for i := range metrics {
+		metrics[i].Share = shares[metrics[i].ID]
+		metrics[i].ShareOfParent = sharesOfParent[metrics[i].ID]
+	}
+	templateData := MetricsHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Metrics:         metrics,
+		Tree:            metricTree(metrics, isAdmin(r)),
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "metrics", templateData)
 	if tmplErr != nil {
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
 
 func (wh *WebHandlers) MetricNewHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl, tmplErr := wh.ExecuteTemplate("metric_form.html", nil)
+		templateData, err := wh.metricFormData(r, Metric{Weight: 1, IsEnabled: true})
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "metric_form", templateData)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	metricName := r.FormValue("metric_name")
-	parentStr := r.FormValue("parent_metric_id")
-	var parentID sql.NullInt64
-	if parentStr != "" {
-		idVal, _ := strconv.ParseInt(parentStr, 10, 64)
-		parentID = sql.NullInt64{Int64: idVal, Valid: true}
+	parentID, weight, err := wh.submittedMetricHierarchy(r, 0)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
 	}
 	isSubMetric := r.FormValue("is_sub_metric") == "on"
+	isEnabled := r.FormValue("is_enabled") == "on"
 	displayTypeID, _ := strconv.Atoi(r.FormValue("display_type_id"))
 	metricTypeID, _ := strconv.Atoi(r.FormValue("metric_type_id"))
-	stmt, err := wh.db.Prepare("INSERT INTO metrics (metric_name, parent_metric_id, is_sub_metric, display_type_id, metric_type_id) VALUES (?, ?, ?, ?, ?)")
+	sortOrder, err := wh.nextMetricSortOrder(parentID)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	stmt, err := wh.db.Prepare("INSERT INTO metrics (metric_name, parent_metric_id, is_sub_metric, display_type_id, metric_type_id, weight, sort_order, is_enabled) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(metricName, parentID, isSubMetric, displayTypeID, metricTypeID)
+	res, err := stmt.Exec(metricName, parentID, isSubMetric, displayTypeID, metricTypeID, weight, sortOrder, isEnabled)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/metrics", http.StatusSeeOther)
+	wh.auditCreate(r, "metrics", res)
+	if err := wh.updateAllOverallScores(); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.MetricNewHandler: %s", err.Error())
+	}
+	wh.redirectWithFlash(w, r, "/metrics", FlashSuccess, "flash.created", wh.T(r, "entity.metrics"))
 }
 
 func (wh *WebHandlers) MetricEditHandler(w http.ResponseWriter, r *http.Request) {
@@ -80,60 +291,109 @@ func (wh *WebHandlers) MetricEditHandler(w http.ResponseWriter, r *http.Request)
 	id, _ := strconv.ParseInt(idStr, 10, 64)
 	if r.Method == http.MethodGet {
 		var m Metric
-		err := wh.db.QueryRow("SELECT metric_id, metric_name, parent_metric_id, is_sub_metric, display_type_id, metric_type_id FROM metrics WHERE metric_id=?", id).
-			Scan(&m.ID, &m.MetricName, &m.ParentMetricID, &m.IsSubMetric, &m.DisplayTypeID, &m.MetricTypeID)
+		err := wh.db.QueryRow("SELECT metric_id, metric_name, parent_metric_id, is_sub_metric, display_type_id, metric_type_id, weight, sort_order, is_enabled FROM metrics WHERE metric_id=?", id).
+			Scan(&m.ID, &m.MetricName, &m.ParentMetricID, &m.IsSubMetric, &m.DisplayTypeID, &m.MetricTypeID, &m.Weight, &m.SortOrder, &m.IsEnabled)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("metric_form", m)
+		templateData, err := wh.metricFormData(r, m)
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "metric_form", templateData)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	metricName := r.FormValue("metric_name")
-	parentStr := r.FormValue("parent_metric_id")
-	var parentID sql.NullInt64
-	if parentStr != "" {
-		idVal, _ := strconv.ParseInt(parentStr, 10, 64)
-		parentID = sql.NullInt64{Int64: idVal, Valid: true}
+	parentID, weight, err := wh.submittedMetricHierarchy(r, id)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
 	}
 	isSubMetric := r.FormValue("is_sub_metric") == "on"
+	isEnabled := r.FormValue("is_enabled") == "on"
 	displayTypeID, _ := strconv.Atoi(r.FormValue("display_type_id"))
 	metricTypeID, _ := strconv.Atoi(r.FormValue("metric_type_id"))
-	stmt, err := wh.db.Prepare("UPDATE metrics SET metric_name=?, parent_metric_id=?, is_sub_metric=?, display_type_id=?, metric_type_id=? WHERE metric_id=?")
+	// A metric moved under another parent goes after the metrics already there
+	var (
+		previousParentID sql.NullInt64
+		sortOrder        int
+	)
+	err = wh.db.QueryRow("SELECT parent_metric_id, sort_order FROM metrics WHERE metric_id=?", id).Scan(&previousParentID, &sortOrder)
+	if err == sql.ErrNoRows {
+		wh.Error(w, r, notFound(err, ""))
+		return
+	}
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if previousParentID != parentID {
+		if sortOrder, err = wh.nextMetricSortOrder(parentID); err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+	}
+	before := wh.auditSnapshot("metrics", id)
+	stmt, err := wh.db.Prepare("UPDATE metrics SET metric_name=?, parent_metric_id=?, is_sub_metric=?, display_type_id=?, metric_type_id=?, weight=?, sort_order=?, is_enabled=? WHERE metric_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(metricName, parentID, isSubMetric, displayTypeID, metricTypeID, id)
+	_, err = stmt.Exec(metricName, parentID, isSubMetric, displayTypeID, metricTypeID, weight, sortOrder, isEnabled, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/metrics", http.StatusSeeOther)
+	wh.auditUpdate(r, "metrics", id, before)
+	if err := wh.updateAllOverallScores(); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.MetricEditHandler: %s", err.Error())
+	}
+	wh.redirectWithFlash(w, r, "/metrics", FlashSuccess, "flash.updated", wh.T(r, "entity.metrics"))
 }
 
 func (wh *WebHandlers) MetricDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	idStr := r.FormValue("id")
 	id, _ := strconv.ParseInt(idStr, 10, 64)
-	stmt, err := wh.db.Prepare("DELETE FROM metrics WHERE metric_id=?")
+	// Sub-metrics of a deleted metric would lose their place in the hierarchy, and purging the metric from the trash
+	// would delete them along with their scores, so they have to be moved or deleted first
+	var subMetrics int
+	err := wh.db.QueryRow("SELECT COUNT(*) FROM metrics WHERE parent_metric_id=? AND deleted_at IS NULL", id).Scan(&subMetrics)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/metrics", err, "flash.delete_failed", wh.T(r, "entity.metrics"))
+		return
+	}
+	if subMetrics > 0 {
+		wh.redirectWithError(w, r, "/metrics", nil, "error.metric_has_sub_metrics", subMetrics)
+		return
+	}
+	before := wh.auditSnapshot("metrics", id)
+	// Metrics are only marked as deleted so that the scores given against them survive
+	stmt, err := wh.db.Prepare("UPDATE metrics SET deleted_at=CURRENT_TIMESTAMP WHERE metric_id=? AND deleted_at IS NULL")
+	if err != nil {
+		wh.redirectWithError(w, r, "/metrics", err, "flash.delete_failed", wh.T(r, "entity.metrics"))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/metrics", err, "flash.delete_failed", wh.T(r, "entity.metrics"))
 		return
 	}
-	http.Redirect(w, r, "/metrics", http.StatusSeeOther)
+	wh.auditDelete(r, "metrics", id, before)
+	if err := wh.updateAllOverallScores(); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.MetricDeleteHandler: %s", err.Error())
+	}
+	wh.redirectWithFlash(w, r, "/metrics", FlashSuccess, "flash.deleted", wh.T(r, "entity.metrics"))
 }
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/moderation"
+	"github.com/spf13/viper"
+)
+
+// The decisions of a moderator, see ReviewModerateHandler and ReviewPhotoModerateHandler
+const (
+	moderationApprove = "approve"
+	moderationReject  = "reject"
+	moderationRestore = "restore"
+)
+
+type ModerationHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	// Status is the state of the reviews listed, empty for the queue of pending and flagged reviews
+	Status   string
+	Statuses []string
+	// Counts holds the number of reviews of each state, Queued the number of pending and flagged ones
+	Counts  map[string]int64
+	Queued  int64
+	Reviews []Review
+}
+
+// moderationRules - returns the rules reviews are checked against, as configured. Invalid link patterns are logged and
+// left out.
+func (wh *WebHandlers) moderationRules() *moderation.Rules {
+	rules, err := moderation.NewRules(viper.GetStringSlice("moderation_blocklist"), viper.GetStringSlice("moderation_link_patterns"))
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.moderationRules: %s", err.Error())
+	}
+	return rules
+}
+
+// screenReview - returns the state a new review with the text reviewText starts in, along with the reasons it is held
+// for moderation, if any
+func (wh *WebHandlers) screenReview(reviewText string) (string, []db.ModerationReason) {
+	if reasons := wh.moderationRules().Check(reviewText); len(reasons) > 0 {
+		return db.ReviewStatusFlagged, reasons
+	}
+	if viper.GetBool("moderation_hold_all") {
+		return db.ReviewStatusPending, nil
+	}
+	return db.ReviewStatusApproved, nil
+}
+
+// detectAnomalies - scores the review id for signs of abuse, and flags it for moderation if it looks suspicious. Errors
+// are logged rather than returned as the review has been saved by then. It reports whether the review was flagged.
+func (wh *WebHandlers) detectAnomalies(r *http.Request, id int64) bool {
+	before := wh.auditSnapshot("reviews", id)
+	a, err := moderation.NewDetector(moderation.LoadAnomalyConfig()).Review(wh.db, id)
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.detectAnomalies: %s", err.Error())
+		return false
+	}
+	if a.Flagged {
+		wh.auditUpdate(r, "reviews", id, before)
+	}
+	return a.Flagged
+}
+
+// analyzeSentiment - scores the sentiment of the text of the review id and stores it with the aspects it mentions,
+// flagging the review for moderation if the text contradicts its overall score. Errors are logged rather than returned
+// as the review has been saved by then. It reports whether the review was flagged.
+func (wh *WebHandlers) analyzeSentiment(r *http.Request, id int64) bool {
+	before := wh.auditSnapshot("reviews", id)
+	res, err := moderation.AnalyzeReview(wh.db, id, moderation.LoadSentimentConfig())
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.analyzeSentiment: %s", err.Error())
+		return false
+	}
+	if res.Flagged {
+		wh.auditUpdate(r, "reviews", id, before)
+	}
+	return res.Flagged
+}
+
+// -----------------------------------------------------------------
+// Moderation Handlers
+
+// ModerationHandler - lists the reviews of the state ?status=, or the pending and flagged reviews waiting for a
+// moderator, oldest first
+func (wh *WebHandlers) ModerationHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !db.IsReviewStatus(status) {
+		wh.Error(w, r, badRequest(nil, ""))
+		return
+	}
+	templateData := ModerationHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Status:          status,
+		Statuses:        db.ReviewStatuses,
+		Counts:          make(map[string]int64),
+	}
+
+	rows, err := wh.db.Query("SELECT status, COUNT(*) FROM reviews WHERE deleted_at IS NULL GROUP BY status")
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			s string
+			n int64
+		)
+		if err := rows.Scan(&s, &n); err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		templateData.Counts[s] = n
+	}
+	templateData.Queued = templateData.Counts[db.ReviewStatusPending] + templateData.Counts[db.ReviewStatusFlagged]
+
+	where, args := "rv.status IN (?, ?)", []interface{}{db.ReviewStatusPending, db.ReviewStatusFlagged}
+	if status != "" {
+		where, args = "rv.status = ?", []interface{}{status}
+	}
+	templateData.Reviews, err = wh.listReviews(where+" ORDER BY rv.created_at, rv.review_id", args...)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	// Photos are moderated along with their reviews, rejected ones are shown so that they can be restored
+	ids := make([]int64, len(templateData.Reviews))
+	for i, rev := range templateData.Reviews {
+		ids[i] = rev.ID
+	}
+	photos, err := wh.reviewPhotos(ids, true)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	for i := range templateData.Reviews {
+		templateData.Reviews[i].Photos = photos[templateData.Reviews[i].ID]
+	}
+
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "moderation", templateData)
+	if tmplErr != nil {
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+// moderationBackURL - returns the URL of the moderation page listing the reviews of the state status, the queue if
+// status is not a review state
+func moderationBackURL(status string) string {
+	if db.IsReviewStatus(status) {
+		return "/moderation?status=" + url.QueryEscape(status)
+	}
+	return "/moderation"
+}
+
+// ReviewModerateHandler - approves or rejects the review id, as the form value decision says, for the reason given.
+// Rejections need a reason, which is kept with the review. Approved reviews supersede the earlier reviews their user
+// wrote for the restaurant.
+func (wh *WebHandlers) ReviewModerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	back := moderationBackURL(r.FormValue("back"))
+
+	var status, flashKey string
+	switch r.FormValue("decision") {
+	case moderationApprove:
+		status, flashKey = db.ReviewStatusApproved, "flash.review_approved"
+	case moderationReject:
+		if reason == "" {
+			wh.redirectWithError(w, r, back, nil, "error.reject_reason")
+			return
+		}
+		status, flashKey = db.ReviewStatusRejected, "flash.review_rejected"
+	default:
+		wh.Error(w, r, badRequest(nil, ""))
+		return
+	}
+
+	var exists bool
+	err := wh.db.QueryRow("SELECT TRUE FROM reviews WHERE review_id=? AND deleted_at IS NULL", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		wh.Error(w, r, notFound(err, ""))
+		return
+	}
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	before := wh.auditSnapshot("reviews", id)
+	if err := db.ModerateReview(wh.db, id, status, reason, wh.sessionUserID(r)); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	// The decision answers the reports made so far, later ones flag the review again
+	if err := db.ResolveReviewReports(wh.db, id); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.auditUpdate(r, "reviews", id, before)
+	if status == db.ReviewStatusApproved {
+		if err := wh.supersedeEarlierReviews(r, id); err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+	}
+	wh.rankReviewRestaurant(id)
+	wh.redirectWithFlash(w, r, back, FlashSuccess, flashKey, id)
+}
+
+// ReviewPhotoModerateHandler - rejects the photo id of a review, or restores it, as the form value decision says.
+// Rejected photos stay attached to their review but are not shown with it.
+func (wh *WebHandlers) ReviewPhotoModerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	back := moderationBackURL(r.FormValue("back"))
+
+	var rejected bool
+	var flashKey string
+	switch r.FormValue("decision") {
+	case moderationReject:
+		rejected, flashKey = true, "flash.photo_rejected"
+	case moderationRestore:
+		rejected, flashKey = false, "flash.photo_restored"
+	default:
+		wh.Error(w, r, badRequest(nil, ""))
+		return
+	}
+
+	var reviewID int64
+	err := wh.db.QueryRow("SELECT review_id FROM review_photos WHERE review_photo_id=? AND deleted_at IS NULL", id).Scan(&reviewID)
+	if err == sql.ErrNoRows {
+		wh.Error(w, r, notFound(err, ""))
+		return
+	}
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	before := wh.auditSnapshot("review_photos", id)
+	if err := db.RejectReviewPhoto(wh.db, id, rejected); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.auditUpdate(r, "review_photos", id, before)
+	wh.redirectWithFlash(w, r, back, FlashSuccess, flashKey, reviewID)
+}
@@ -15,42 +15,79 @@ type OTPRequest struct {
 	RequestedAt    time.Time
 	DeliveryMethod string
 	ValidTill      int64
+	// UserName names the user UserID refers to, only set when listing OTP requests
+	UserName string
+}
+
+type OtpRequestsHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	OtpRequests     []OTPRequest
+}
+
+type OtpRequestFormTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	OtpRequest      OTPRequest
+	User            LookupField
+}
+
+// otpRequestFormData - returns the data of otp_request_form.html for otpReq, with its user as a lookup field
+func (wh *WebHandlers) otpRequestFormData(r *http.Request, otpReq OTPRequest) (OtpRequestFormTemplateData, error) {
+	data := OtpRequestFormTemplateData{IsLoggedIn: isLoggedIn(r), IsLoggedInAdmin: isAdmin(r), OtpRequest: otpReq}
+	var err error
+	data.User, err = wh.lookupField("user_id", "field.user", "users", true, otpReq.UserID)
+	return data, err
 }
 
 // -----------------------------------------------------------------
 // OTP Requests Handlers
 
 func (wh *WebHandlers) OtpRequestsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := wh.db.Query("SELECT otp_request_id, user_id, otp_code, requested_at, delivery_method, valid_till FROM otp_requests")
+	rows, err := wh.db.Query("SELECT o.otp_request_id, o.user_id, COALESCE(NULLIF(u.email, ''), NULLIF(u.mobile_number, ''), CONCAT('#', o.user_id)), o.otp_code, o.requested_at, o.delivery_method, o.valid_till FROM otp_requests AS o LEFT JOIN users AS u ON o.user_id=u.user_id ORDER BY o.otp_request_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer rows.Close()
 	var otps []OTPRequest
 	for rows.Next() {
 		var otpReq OTPRequest
-		err := rows.Scan(&otpReq.ID, &otpReq.UserID, &otpReq.OTPCode, &otpReq.RequestedAt, &otpReq.DeliveryMethod, &otpReq.ValidTill)
+		err := rows.Scan(&otpReq.ID, &otpReq.UserID, &otpReq.UserName, &otpReq.OTPCode, &otpReq.RequestedAt, &otpReq.DeliveryMethod, &otpReq.ValidTill)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 		otps = append(otps, otpReq)
 	}
-	tmpl, tmplErr := wh.ExecuteTemplate("otp_requests", otps)
+	templateData := OtpRequestsHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		OtpRequests:     otps,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "otp_requests", templateData)
 	if tmplErr != nil {
 		slog.Error(fmt.Sprintf("error executing template: %s", tmplErr.Error()))
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
 
 func (wh *WebHandlers) OtpRequestNewHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl, tmplErr := wh.ExecuteTemplate("otp_request_form", nil)
+		templateData, err := wh.otpRequestFormData(r, OTPRequest{})
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "otp_request_form", templateData)
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("error executing template: %s", tmplErr.Error()))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
@@ -62,16 +99,17 @@ func (wh *WebHandlers) OtpRequestNewHandler(w http.ResponseWriter, r *http.Reque
 	// Note: valid_till is a generated column, so we do not insert it.
 	stmt, err := wh.db.Prepare("INSERT INTO otp_requests (user_id, otp_code, requested_at, delivery_method) VALUES (?, ?, ?, ?)")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(userID, otpCode, requestedAt, deliveryMethod)
+	res, err := stmt.Exec(userID, otpCode, requestedAt, deliveryMethod)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/otp_requests", http.StatusSeeOther)
+	wh.auditCreate(r, "otp_requests", res)
+	wh.redirectWithFlash(w, r, "/otp_requests", FlashSuccess, "flash.created", wh.T(r, "entity.otp_requests"))
 }
 
 func (wh *WebHandlers) OtpRequestEditHandler(w http.ResponseWriter, r *http.Request) {
@@ -82,13 +120,19 @@ func (wh *WebHandlers) OtpRequestEditHandler(w http.ResponseWriter, r *http.Requ
 		err := wh.db.QueryRow("SELECT otp_request_id, user_id, otp_code, requested_at, delivery_method, valid_till FROM otp_requests WHERE otp_request_id=?", id).
 			Scan(&otpReq.ID, &otpReq.UserID, &otpReq.OTPCode, &otpReq.RequestedAt, &otpReq.DeliveryMethod, &otpReq.ValidTill)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("otp_request_form", wh)
+		templateData, err := wh.otpRequestFormData(r, otpReq)
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "otp_request_form", templateData)
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("error executing template: %s", tmplErr.Error()))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
@@ -97,37 +141,41 @@ func (wh *WebHandlers) OtpRequestEditHandler(w http.ResponseWriter, r *http.Requ
 	otpCode := r.FormValue("otp_code")
 	requestedAt, _ := time.Parse("2006-01-02 15:04:05", r.FormValue("requested_at"))
 	deliveryMethod := r.FormValue("delivery_method")
+	before := wh.auditSnapshot("otp_requests", id)
 	stmt, err := wh.db.Prepare("UPDATE otp_requests SET user_id=?, otp_code=?, requested_at=?, delivery_method=? WHERE otp_request_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(userID, otpCode, requestedAt, deliveryMethod, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/otp_requests", http.StatusSeeOther)
+	wh.auditUpdate(r, "otp_requests", id, before)
+	wh.redirectWithFlash(w, r, "/otp_requests", FlashSuccess, "flash.updated", wh.T(r, "entity.otp_requests"))
 }
 
 func (wh *WebHandlers) OtpRequestDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	idStr := r.FormValue("id")
 	id, _ := strconv.ParseInt(idStr, 10, 64)
+	before := wh.auditSnapshot("otp_requests", id)
 	stmt, err := wh.db.Prepare("DELETE FROM otp_requests WHERE otp_request_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/otp_requests", err, "flash.delete_failed", wh.T(r, "entity.otp_requests"))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/otp_requests", err, "flash.delete_failed", wh.T(r, "entity.otp_requests"))
 		return
 	}
-	http.Redirect(w, r, "/otp_requests", http.StatusSeeOther)
+	wh.auditDelete(r, "otp_requests", id, before)
+	wh.redirectWithFlash(w, r, "/otp_requests", FlashSuccess, "flash.deleted", wh.T(r, "entity.otp_requests"))
 }
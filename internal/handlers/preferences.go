@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// The session values which keep the theme and locale chosen by the logged-in user, see userPreferences
+	prefsUserSessionKey     = "prefs_user_id"
+	prefsThemeSessionKey    = "prefs_theme"
+	prefsLocaleSessionKey   = "prefs_locale"
+	prefsLoadedAtSessionKey = "prefs_loaded_at"
+	// userPreferencesTTL - how long the preferences kept in the session are used before they are read again, which
+	// bounds how long a change made by an admin takes to reach a user who is logged in
+	userPreferencesTTL = 5 * time.Minute
+)
+
+// UserPreferences - the theme and locale chosen by a user, empty when they use those of the site
+type UserPreferences struct {
+	Theme  string
+	Locale string
+}
+
+// userPreferences - returns the preferences of the user logged in with r. They are kept in the session, so that the
+// users table is only read once every userPreferencesTTL rather than on every request. It reports whether they were
+// read from the users table, in which case the session needs saving to keep them, see saveUserPreferences.
+func (wh *WebHandlers) userPreferences(r *http.Request) (UserPreferences, bool) {
+	var prefs UserPreferences
+	session, err := wh.GetSession(r)
+	if err != nil {
+		wh.Log.Debugf("handlers.WebHandlers.userPreferences: error getting session: %s", err.Error())
+		return prefs, false
+	}
+	userID, _ := session.Values["user_id"].(int64)
+	if userID <= 0 {
+		return prefs, false
+	}
+	cachedFor, _ := session.Values[prefsUserSessionKey].(int64)
+	loadedAt, _ := session.Values[prefsLoadedAtSessionKey].(int64)
+	if cachedFor == userID && time.Since(time.Unix(loadedAt, 0)) < userPreferencesTTL {
+		prefs.Theme, _ = session.Values[prefsThemeSessionKey].(string)
+		prefs.Locale, _ = session.Values[prefsLocaleSessionKey].(string)
+		return prefs, false
+	}
+
+	var theme, locale sql.NullString
+	err = wh.db.QueryRow("SELECT theme, locale FROM users WHERE user_id = ? AND deleted_at IS NULL", userID).Scan(&theme, &locale)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		wh.Log.Errorf("handlers.WebHandlers.userPreferences: error reading the preferences of user %d: %s", userID, err.Error())
+		return prefs, false
+	}
+	prefs = UserPreferences{Theme: theme.String, Locale: locale.String}
+	setSessionPreferences(session.Values, userID, prefs)
+	return prefs, true
+}
+
+// setSessionPreferences - keeps prefs as the preferences of the user userID in the session values
+func setSessionPreferences(values map[interface{}]interface{}, userID int64, prefs UserPreferences) {
+	values[prefsUserSessionKey] = userID
+	values[prefsThemeSessionKey] = prefs.Theme
+	values[prefsLocaleSessionKey] = prefs.Locale
+	values[prefsLoadedAtSessionKey] = time.Now().Unix()
+}
+
+// saveUserPreferences - reads the preferences of the user logged in with r if the session does not hold them yet, and
+// saves the session so that the following requests find them
+func (wh *WebHandlers) saveUserPreferences(w http.ResponseWriter, r *http.Request) {
+	if _, loaded := wh.userPreferences(r); !loaded {
+		return
+	}
+	session, err := wh.GetSession(r)
+	if err != nil {
+		return
+	}
+	if err = session.Save(r, w); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.saveUserPreferences: error saving session: %s", err.Error())
+	}
+}
+
+// updateSessionPreferences - replaces the preferences kept in the session of r after the user userID changed them,
+// if they are the user logged in with r. Other sessions of the user pick the change up within userPreferencesTTL.
+func (wh *WebHandlers) updateSessionPreferences(w http.ResponseWriter, r *http.Request, userID int64, prefs UserPreferences) {
+	session, err := wh.GetSession(r)
+	if err != nil {
+		return
+	}
+	if current, _ := session.Values["user_id"].(int64); current != userID {
+		return
+	}
+	setSessionPreferences(session.Values, userID, prefs)
+	if err = session.Save(r, w); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.updateSessionPreferences: error saving session: %s", err.Error())
+	}
+}
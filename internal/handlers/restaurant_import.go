@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+)
+
+// restaurantImportMaxBytes - the largest file accepted by RestaurantImportHandler
+const restaurantImportMaxBytes = 10 << 20
+
+type RestaurantImportHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Format          string
+	DryRun          bool
+	// Data is the content of the previewed file, posted back when the preview is confirmed
+	Data   string
+	Result *db.ImportResult
+}
+
+// -----------------------------------------------------------------
+// Restaurant Import/Export Handlers
+
+// RestaurantImportHandler - shows the import form on GET. On POST it imports the restaurants in the uploaded CSV or
+// JSON file, or only previews what the import would do when dry_run is set.
+func (wh *WebHandlers) RestaurantImportHandler(w http.ResponseWriter, r *http.Request) {
+	templateData := RestaurantImportHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Errors:          []string{},
+		DryRun:          true,
+	}
+
+	if r.Method == http.MethodPost {
+		wh.restaurantImport(w, r, &templateData)
+	}
+
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "restaurant_import", templateData)
+	if tmplErr != nil {
+		wh.Log.Errorf("handlers.WebHandlers.RestaurantImportHandler: error executing template: restaurant_import: %s", tmplErr.Error())
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+func (wh *WebHandlers) restaurantImport(w http.ResponseWriter, r *http.Request, templateData *RestaurantImportHandlerTemplateData) {
+	r.Body = http.MaxBytesReader(w, r.Body, restaurantImportMaxBytes+(1<<20))
+	if err := r.ParseMultipartForm(restaurantImportMaxBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		templateData.Errors = append(templateData.Errors, fmt.Sprintf("error reading the upload: %s", err.Error()))
+		return
+	}
+	templateData.DryRun = r.FormValue("dry_run") == "on"
+
+	var (
+		data     []byte
+		fileName string
+	)
+	if file, header, err := r.FormFile("file"); err == nil {
+		defer file.Close()
+		fileName = header.Filename
+		data, err = io.ReadAll(io.LimitReader(file, restaurantImportMaxBytes+1))
+		if err != nil {
+			templateData.Errors = append(templateData.Errors, fmt.Sprintf("error reading %s: %s", fileName, err.Error()))
+			return
+		}
+		if len(data) > restaurantImportMaxBytes {
+			templateData.Errors = append(templateData.Errors, fmt.Sprintf("%s is larger than %d MB", fileName, restaurantImportMaxBytes>>20))
+			return
+		}
+	} else {
+		data = []byte(r.FormValue("data"))
+	}
+	if len(data) == 0 {
+		templateData.Errors = append(templateData.Errors, "choose a CSV or JSON file to import")
+		return
+	}
+
+	format := db.DetectImportFormat(r.FormValue("format"), fileName)
+	templateData.Format = format
+	rows, err := db.ParseRestaurants(bytes.NewReader(data), format)
+	if err != nil {
+		templateData.Errors = append(templateData.Errors, err.Error())
+		return
+	}
+
+	result, err := db.ImportRestaurants(wh.db, rows, templateData.DryRun, wh.auditActor(r))
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.RestaurantImportHandler: error importing restaurants: %s", err.Error())
+		templateData.Errors = append(templateData.Errors, wh.T(r, "error.import_failed"))
+		return
+	}
+	templateData.Result = &result
+	if templateData.DryRun {
+		templateData.Data = string(data)
+	}
+}
+
+// RestaurantExportHandler - downloads every restaurant as CSV or, with format=json, as JSON. The file can be imported
+// again through RestaurantImportHandler.
+func (wh *WebHandlers) RestaurantExportHandler(w http.ResponseWriter, r *http.Request) {
+	format := db.DetectImportFormat(r.URL.Query().Get("format"), "")
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case db.ImportFormatCSV:
+	case db.ImportFormatJSON:
+		contentType = "application/json"
+	default:
+		wh.Error(w, r, badRequest(nil, "error.export_format", format))
+		return
+	}
+
+	records, err := db.ListRestaurantRecords(wh.db)
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.RestaurantExportHandler: error listing restaurants: %s", err.Error())
+		wh.Error(w, r, internalError(err))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = db.WriteRestaurants(&buf, records, format); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.RestaurantExportHandler: error writing restaurants: %s", err.Error())
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"restaurants-%s.%s\"", time.Now().Format("20060102"), format))
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/spf13/viper"
+)
+
+// The orders the reviews of a restaurant can be listed in, see reviewSortOrders
+const (
+	reviewSortHelpful = "helpful"
+	reviewSortRecent  = "recent"
+	reviewSortHighest = "highest"
+	reviewSortLowest  = "lowest"
+)
+
+// reviewSorts - the review orders, in the order they are offered. The first one is the default.
+var reviewSorts = []string{reviewSortHelpful, reviewSortRecent, reviewSortHighest, reviewSortLowest}
+
+// reviewSortOrders - the ORDER BY clause of each review order, on the columns of restaurantReviews
+var reviewSortOrders = map[string]string{
+	reviewSortHelpful: "t.helpful - t.not_helpful DESC, t.helpful DESC, t.created_at DESC",
+	reviewSortRecent:  "t.created_at DESC",
+	reviewSortHighest: "t.overall_score DESC, t.created_at DESC",
+	reviewSortLowest:  "t.overall_score ASC, t.created_at DESC",
+}
+
+// maxReportDetails - the longest explanation users can add to a report
+const maxReportDetails = 500
+
+// defaultReviewReportThreshold - the number of users who must report a review before it is flagged when
+// review_report_threshold has not been set, so that no single user can take a review down
+const defaultReviewReportThreshold = 3
+
+// mentionTerms - the number of terms shown for each aspect in the summary of what reviews mention
+const mentionTerms = 5
+
+// RestaurantReview - an approved review as shown on the page of its restaurant
+type RestaurantReview struct {
+	Review
+	Helpful    int
+	NotHelpful int
+	// MyVote is the vote of the user viewing the page, invalid if they have not voted. MyReport is the reason they
+	// reported the review for, if they did.
+	MyVote   sql.NullBool
+	MyReport sql.NullString
+	// Own is set when the user viewing the page wrote the review, which they cannot vote on or report
+	Own bool
+}
+
+type RestaurantViewTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Restaurant      Restaurant
+	// Rating is the mean overall score of the reviews listed, invalid if there are none
+	Rating        sql.NullFloat64
+	Sort          string
+	Sorts         []string
+	ReportReasons []string
+	Reviews       []RestaurantReview
+	// Gallery holds the photos of the reviews listed, newest first
+	Gallery []ReviewPhoto
+	// Mentions summarizes what the reviews say about each aspect of the restaurant
+	Mentions []db.AspectSummary
+}
+
+// restaurantViewURL - returns the URL of the page of the restaurant restaurantID with its reviews in the order sort,
+// scrolled to the review reviewID if it is not 0
+func restaurantViewURL(restaurantID int64, sort string, reviewID int64) string {
+	u := fmt.Sprintf("/restaurants/view?id=%d", restaurantID)
+	if _, ok := reviewSortOrders[sort]; ok && sort != reviewSorts[0] {
+		u += "&sort=" + sort
+	}
+	if reviewID != 0 {
+		u += fmt.Sprintf("#review-%d", reviewID)
+	}
+	return u
+}
+
+// restaurantReviews - returns the approved, active reviews of the restaurant restaurantID in the order sort, along
+// with their photos and the votes and reports of the user viewerID on them
+func (wh *WebHandlers) restaurantReviews(restaurantID, viewerID int64, sort string) ([]RestaurantReview, error) {
+	// The votes are counted in a derived table so that the order can combine them
+	rows, err := wh.db.Query(`SELECT t.* FROM (
+		SELECT rv.review_id, rv.user_id, CONCAT('User #', rv.user_id), rv.overall_score, COALESCE(rv.review_text, ''), rv.created_at,
+			(SELECT COUNT(*) FROM review_votes AS v WHERE v.review_id=rv.review_id AND v.is_helpful) AS helpful,
+			(SELECT COUNT(*) FROM review_votes AS v WHERE v.review_id=rv.review_id AND NOT v.is_helpful) AS not_helpful,
+			(SELECT v.is_helpful FROM review_votes AS v WHERE v.review_id=rv.review_id AND v.user_id=?) AS my_vote,
+			(SELECT rp.reason_code FROM review_reports AS rp WHERE rp.review_id=rv.review_id AND rp.user_id=? AND rp.resolved_at IS NULL) AS my_report
+		FROM reviews AS rv JOIN users AS u ON rv.user_id=u.user_id
+		WHERE rv.restaurant_id=? AND rv.status=? AND u.deleted_at IS NULL AND `+db.ActiveReviewSQL+`
+	) AS t ORDER BY `+reviewSortOrders[sort]+`, t.review_id DESC`,
+		viewerID, viewerID, restaurantID, db.ReviewStatusApproved)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reviews of restaurant %d: %s", restaurantID, err.Error())
+	}
+	defer rows.Close()
+	var reviews []RestaurantReview
+	for rows.Next() {
+		rr := RestaurantReview{Review: Review{RestaurantID: restaurantID, Status: db.ReviewStatusApproved}}
+		err := rows.Scan(&rr.ID, &rr.UserID, &rr.UserName, &rr.OverallScore, &rr.ReviewText, &rr.CreatedAt, &rr.Helpful, &rr.NotHelpful, &rr.MyVote, &rr.MyReport)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning review: %s", err.Error())
+		}
+		rr.Own = rr.UserID == viewerID
+		reviews = append(reviews, rr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(reviews))
+	for i, rr := range reviews {
+		ids[i] = rr.ID
+	}
+	photos, err := wh.reviewPhotos(ids, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range reviews {
+		reviews[i].Photos = photos[reviews[i].ID]
+	}
+	return reviews, nil
+}
+
+// interactiveReview - returns the restaurant and the author of the review id, if it is approved and active, as only
+// those can be voted on and reported
+func (wh *WebHandlers) interactiveReview(id int64) (int64, int64, error) {
+	var restaurantID, userID int64
+	err := wh.db.QueryRow("SELECT rv.restaurant_id, rv.user_id FROM reviews AS rv WHERE rv.review_id=? AND rv.status=? AND "+db.ActiveReviewSQL, id, db.ReviewStatusApproved).
+		Scan(&restaurantID, &userID)
+	if err == sql.ErrNoRows {
+		return 0, 0, notFound(err, "")
+	}
+	if err != nil {
+		return 0, 0, internalError(err)
+	}
+	return restaurantID, userID, nil
+}
+
+// -----------------------------------------------------------------
+// Restaurant Page Handlers
+
+// RestaurantViewHandler - shows the restaurant ?id= with its approved reviews, in the order ?sort=
+func (wh *WebHandlers) RestaurantViewHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	sort := r.URL.Query().Get("sort")
+	if _, ok := reviewSortOrders[sort]; !ok {
+		sort = reviewSorts[0]
+	}
+	templateData := RestaurantViewTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Sort:            sort,
+		Sorts:           reviewSorts,
+		ReportReasons:   db.ReportReasons,
+	}
+	rct := &templateData.Restaurant
+	err := wh.db.QueryRow("SELECT restaurant_id, name, address, latitude, longitude, overall_rating, price_for_two, image_url, COALESCE(image_key, ''), discount_available, alcohol_available, portion_size_large FROM restaurants WHERE restaurant_id=? AND deleted_at IS NULL", id).
+		Scan(&rct.ID, &rct.Name, &rct.Address, &rct.Latitude, &rct.Longitude, &rct.OverallRating, &rct.PriceForTwo, &rct.ImageURL, &rct.ImageKey, &rct.DiscountAvailable, &rct.AlcoholAvailable, &rct.PortionSizeLarge)
+	if err == sql.ErrNoRows {
+		wh.Error(w, r, notFound(err, ""))
+		return
+	}
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if templateData.Reviews, err = wh.restaurantReviews(id, wh.sessionUserID(r), sort); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if n := len(templateData.Reviews); n > 0 {
+		var sum float64
+		for _, rr := range templateData.Reviews {
+			sum += rr.OverallScore
+		}
+		templateData.Rating = sql.NullFloat64{Float64: sum / float64(n), Valid: true}
+	}
+	templateData.Gallery = galleryPhotos(templateData.Reviews)
+	if templateData.Mentions, err = db.RestaurantMentions(wh.db, id, mentionTerms); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "restaurant_view", templateData)
+	if tmplErr != nil {
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+// ReviewVoteHandler - records whether the logged-in user found the review id helpful, as the form value helpful says.
+// Voting the same way again withdraws the vote.
+func (wh *WebHandlers) ReviewVoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	restaurantID, authorID, err := wh.interactiveReview(id)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	back := restaurantViewURL(restaurantID, r.FormValue("sort"), id)
+	userID := wh.sessionUserID(r)
+	if userID == authorID {
+		wh.redirectWithError(w, r, back, nil, "error.own_review")
+		return
+	}
+	if err := db.VoteOnReview(wh.db, id, userID, r.FormValue("helpful") == "1"); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// reviewReportThreshold - returns the number of users who must report a review before it is flagged for moderation
+func reviewReportThreshold() int {
+	if n := viper.GetInt("review_report_threshold"); n > 0 {
+		return n
+	}
+	return defaultReviewReportThreshold
+}
+
+// ReviewReportHandler - records the report of the review id by the logged-in user, for the reason code reason with
+// the details given. Reviews reported by review_report_threshold users are flagged for moderation.
+func (wh *WebHandlers) ReviewReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	restaurantID, authorID, err := wh.interactiveReview(id)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	back := restaurantViewURL(restaurantID, r.FormValue("sort"), id)
+	userID := wh.sessionUserID(r)
+	if userID == authorID {
+		wh.redirectWithError(w, r, back, nil, "error.own_review")
+		return
+	}
+	reason := r.FormValue("reason")
+	if !db.IsReportReason(reason) {
+		wh.redirectWithError(w, r, back, nil, "error.report_reason")
+		return
+	}
+	details := strings.TrimSpace(r.FormValue("details"))
+	if utf8.RuneCountInString(details) > maxReportDetails {
+		wh.redirectWithError(w, r, back, nil, "error.report_details", maxReportDetails)
+		return
+	}
+	before := wh.auditSnapshot("reviews", id)
+	flagged, err := db.ReportReview(wh.db, id, userID, reason, details, reviewReportThreshold())
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if flagged {
+		wh.auditUpdate(r, "reviews", id, before)
+		wh.rankRestaurant(restaurantID)
+		// The review is no longer listed, so there is nothing to scroll to
+		back = restaurantViewURL(restaurantID, r.FormValue("sort"), 0)
+	}
+	wh.redirectWithFlash(w, r, back, FlashSuccess, "flash.review_reported")
+}
@@ -5,83 +5,222 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/images"
 )
 
 type Restaurant struct {
-	ID                int64
-	Name              string
-	Address           string
-	Latitude          float64
-	Longitude         float64
-	OverallRating     float64
-	PriceForTwo       float64
-	ImageURL          string
+	ID        int64
+	Name      string
+	Address   string
+	Latitude  float64
+	Longitude float64
+	// OverallRating is the mean overall score of the approved, active reviews of the restaurant, see db.RankRestaurant
+	OverallRating float64
+	PriceForTwo   float64
+	ImageURL      string
+	// ImageKey is where an uploaded image is kept in the media storage. It is empty when ImageURL points elsewhere.
+	ImageKey          string
 	DiscountAvailable bool
 	AlcoholAvailable  bool
 	PortionSizeLarge  bool
+	// RankingScore is the Bayesian average of the reviews, see db.RankRestaurant, and RankedReviews the number of
+	// reviews it was computed from
+	RankingScore  float64
+	RankedReviews int
+}
+
+// ThumbnailURL - returns the URL of the smallest available version of the image of the restaurant
+func (rct Restaurant) ThumbnailURL() string {
+	if rct.ImageKey != "" {
+		return imageURL(rct.ImageKey, "thumb")
+	}
+	return rct.ImageURL
+}
+
+// CardURL - returns the URL of the version of the image of the restaurant sized for its page
+func (rct Restaurant) CardURL() string {
+	if rct.ImageKey != "" {
+		return imageURL(rct.ImageKey, "card")
+	}
+	return rct.ImageURL
+}
+
+// restaurantImageFromForm - returns the image_url and image_key to store for the restaurant form posted in r.
+// uploadedKey is the key of the image uploaded with the form, if any, which takes precedence over the image_url field.
+func restaurantImageFromForm(r *http.Request, uploadedKey string) (string, string) {
+	if uploadedKey != "" {
+		return imageURL(uploadedKey, "large"), uploadedKey
+	}
+	imgURL := r.FormValue("image_url")
+	if r.FormValue("remove_image") == "on" || imgURL == "" {
+		return "", ""
+	}
+	// keep the previously uploaded image unless its URL has been replaced by another one
+	imageKey := r.FormValue("image_key")
+	if imageKey != "" && imgURL != imageURL(imageKey, "large") {
+		imageKey = ""
+	}
+	return imgURL, imageKey
+}
+
+type RestaurantFormTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Restaurant
+}
+
+type RestaurantsHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Restaurants     []Restaurant
+	// Query is the text searched for in the names and addresses of the restaurants
+	Query string
+}
+
+// rankRestaurant - ranks the restaurant restaurantID again after its reviews changed, see db.RankRestaurant. Errors
+// are logged rather than returned as the change has been saved by then.
+func (wh *WebHandlers) rankRestaurant(restaurantID int64) {
+	if err := db.RankRestaurant(wh.db, restaurantID, db.LoadRanking(), time.Now()); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.rankRestaurant: %s", err.Error())
+	}
+}
+
+// rankUserRestaurants - ranks the restaurants the user userID reviewed again after the user was deleted or restored,
+// as only the reviews of users who have not been deleted count, see rankRestaurant
+func (wh *WebHandlers) rankUserRestaurants(userID int64) {
+	rows, err := wh.db.Query("SELECT DISTINCT restaurant_id FROM reviews WHERE user_id=? AND deleted_at IS NULL", userID)
+	if err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.rankUserRestaurants: error loading the restaurants user %d reviewed: %s", userID, err.Error())
+		return
+	}
+	var restaurantIDs []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			wh.Log.Errorf("handlers.WebHandlers.rankUserRestaurants: error scanning restaurant: %s", err.Error())
+			rows.Close()
+			return
+		}
+		restaurantIDs = append(restaurantIDs, id)
+	}
+	rows.Close()
+	for _, id := range restaurantIDs {
+		wh.rankRestaurant(id)
+	}
+}
+
+// rankReviewRestaurant - ranks the restaurant of the review reviewID again, see rankRestaurant
+func (wh *WebHandlers) rankReviewRestaurant(reviewID int64) {
+	var restaurantID int64
+	if err := wh.db.QueryRow("SELECT restaurant_id FROM reviews WHERE review_id=?", reviewID).Scan(&restaurantID); err != nil {
+		wh.Log.Errorf("handlers.WebHandlers.rankReviewRestaurant: error loading review %d: %s", reviewID, err.Error())
+		return
+	}
+	wh.rankRestaurant(restaurantID)
 }
 
 // -----------------------------------------------------------------
 // Restaurants Handlers
 
+// RestaurantsHandler - lists the restaurants whose name or address contains the query q, if any, best ranked first
 func (wh *WebHandlers) RestaurantsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := wh.db.Query("SELECT restaurant_id, name, address, latitude, longitude, overall_rating, price_for_two, image_url, discount_available, alcohol_available, portion_size_large FROM restaurants")
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	query := "SELECT restaurant_id, name, address, latitude, longitude, overall_rating, price_for_two, image_url, COALESCE(image_key, ''), discount_available, alcohol_available, portion_size_large, COALESCE(ranking_score, 0), ranked_reviews FROM restaurants WHERE deleted_at IS NULL"
+	var args []interface{}
+	if q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		query += " AND (name LIKE ? OR address LIKE ?)"
+		args = append(args, pattern, pattern)
+	}
+	rows, err := wh.db.Query(query+" ORDER BY ranking_score DESC, ranked_reviews DESC, name", args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer rows.Close()
 	var restaurants []Restaurant
 	for rows.Next() {
 		var rct Restaurant
-		err := rows.Scan(&rct.ID, &rct.Name, &rct.Address, &rct.Latitude, &rct.Longitude, &rct.OverallRating, &rct.PriceForTwo, &rct.ImageURL, &rct.DiscountAvailable, &rct.AlcoholAvailable, &rct.PortionSizeLarge)
+		err := rows.Scan(&rct.ID, &rct.Name, &rct.Address, &rct.Latitude, &rct.Longitude, &rct.OverallRating, &rct.PriceForTwo, &rct.ImageURL, &rct.ImageKey, &rct.DiscountAvailable, &rct.AlcoholAvailable, &rct.PortionSizeLarge, &rct.RankingScore, &rct.RankedReviews)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 		restaurants = append(restaurants, rct)
 	}
-	tmpl, tmplErr := wh.ExecuteTemplate("restaurants", restaurants)
+	templateData := RestaurantsHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Errors:          []string{},
+		Restaurants:     restaurants,
+		Query:           q,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "restaurants", templateData)
 	if tmplErr != nil {
 		slog.Error(fmt.Sprintf("Error executing template: restaurants.html: %s", tmplErr))
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
 
 func (wh *WebHandlers) RestaurantNewHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl, tmplErr := wh.ExecuteTemplate("restaurant_form", nil)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "restaurant_form", RestaurantFormTemplateData{
+			IsLoggedIn:      isLoggedIn(r),
+			IsLoggedInAdmin: isAdmin(r),
+			Errors:          []string{},
+		})
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("Error executing template: restaurant_form: %s", tmplErr))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	// POST
+	if err := wh.limitImageUpload(w, r, 1); err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	uploadedImageKey, err := wh.storeUploadedImage(r, "image", "restaurants", images.RestaurantSizes)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
 	name := r.FormValue("name")
 	address := r.FormValue("address")
 	lat, _ := strconv.ParseFloat(r.FormValue("latitude"), 64)
 	lng, _ := strconv.ParseFloat(r.FormValue("longitude"), 64)
-	overallRating, _ := strconv.ParseFloat(r.FormValue("overall_rating"), 64)
 	priceForTwo, _ := strconv.ParseFloat(r.FormValue("price_for_two"), 64)
-	imageURL := r.FormValue("image_url")
+	imgURL, imageKey := restaurantImageFromForm(r, uploadedImageKey)
 	discountAvailable := r.FormValue("discount_available") == "on"
 	alcoholAvailable := r.FormValue("alcohol_available") == "on"
 	portionSizeLarge := r.FormValue("portion_size_large") == "on"
-	stmt, err := wh.db.Prepare("INSERT INTO restaurants (name, address, latitude, longitude, overall_rating, price_for_two, image_url, discount_available, alcohol_available, portion_size_large) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := wh.db.Prepare("INSERT INTO restaurants (name, address, latitude, longitude, price_for_two, image_url, image_key, discount_available, alcohol_available, portion_size_large) VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?)")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(name, address, lat, lng, overallRating, priceForTwo, imageURL, discountAvailable, alcoholAvailable, portionSizeLarge)
+	res, err := stmt.Exec(name, address, lat, lng, priceForTwo, imgURL, imageKey, discountAvailable, alcoholAvailable, portionSizeLarge)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/restaurants", http.StatusSeeOther)
+	// Restaurants without reviews rank by the prior and have no overall rating, see db.RankRestaurant
+	if id, err := res.LastInsertId(); err == nil {
+		wh.rankRestaurant(id)
+	}
+	wh.auditCreate(r, "restaurants", res)
+	wh.redirectWithFlash(w, r, "/restaurants", FlashSuccess, "flash.created", wh.T(r, "entity.restaurants"))
 }
 
 func (wh *WebHandlers) RestaurantEditHandler(w http.ResponseWriter, r *http.Request) {
@@ -89,62 +228,81 @@ func (wh *WebHandlers) RestaurantEditHandler(w http.ResponseWriter, r *http.Requ
 	id, _ := strconv.ParseInt(idStr, 10, 64)
 	if r.Method == http.MethodGet {
 		var rct Restaurant
-		err := wh.db.QueryRow("SELECT restaurant_id, name, address, latitude, longitude, overall_rating, price_for_two, image_url, discount_available, alcohol_available, portion_size_large FROM restaurants WHERE restaurant_id=?", id).
-			Scan(&rct.ID, &rct.Name, &rct.Address, &rct.Latitude, &rct.Longitude, &rct.OverallRating, &rct.PriceForTwo, &rct.ImageURL, &rct.DiscountAvailable, &rct.AlcoholAvailable, &rct.PortionSizeLarge)
+		err := wh.db.QueryRow("SELECT restaurant_id, name, address, latitude, longitude, overall_rating, price_for_two, image_url, COALESCE(image_key, ''), discount_available, alcohol_available, portion_size_large FROM restaurants WHERE restaurant_id=?", id).
+			Scan(&rct.ID, &rct.Name, &rct.Address, &rct.Latitude, &rct.Longitude, &rct.OverallRating, &rct.PriceForTwo, &rct.ImageURL, &rct.ImageKey, &rct.DiscountAvailable, &rct.AlcoholAvailable, &rct.PortionSizeLarge)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("restaurant_form", rct)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "restaurant_form", RestaurantFormTemplateData{
+			IsLoggedIn:      isLoggedIn(r),
+			IsLoggedInAdmin: isAdmin(r),
+			Errors:          []string{},
+			Restaurant:      rct,
+		})
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("Error executing template: restaurant_form.%s", tmplErr))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	// POST update
+	if err := wh.limitImageUpload(w, r, 1); err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	uploadedImageKey, err := wh.storeUploadedImage(r, "image", "restaurants", images.RestaurantSizes)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
 	name := r.FormValue("name")
 	address := r.FormValue("address")
 	lat, _ := strconv.ParseFloat(r.FormValue("latitude"), 64)
 	lng, _ := strconv.ParseFloat(r.FormValue("longitude"), 64)
-	overallRating, _ := strconv.ParseFloat(r.FormValue("overall_rating"), 64)
 	priceForTwo, _ := strconv.ParseFloat(r.FormValue("price_for_two"), 64)
-	imageURL := r.FormValue("image_url")
+	imgURL, imageKey := restaurantImageFromForm(r, uploadedImageKey)
 	discountAvailable := r.FormValue("discount_available") == "on"
 	alcoholAvailable := r.FormValue("alcohol_available") == "on"
 	portionSizeLarge := r.FormValue("portion_size_large") == "on"
-	stmt, err := wh.db.Prepare("UPDATE restaurants SET name=?, address=?, latitude=?, longitude=?, overall_rating=?, price_for_two=?, image_url=?, discount_available=?, alcohol_available=?, portion_size_large=? WHERE restaurant_id=?")
+	before := wh.auditSnapshot("restaurants", id)
+	stmt, err := wh.db.Prepare("UPDATE restaurants SET name=?, address=?, latitude=?, longitude=?, price_for_two=?, image_url=?, image_key=NULLIF(?, ''), discount_available=?, alcohol_available=?, portion_size_large=? WHERE restaurant_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(name, address, lat, lng, overallRating, priceForTwo, imageURL, discountAvailable, alcoholAvailable, portionSizeLarge, id)
+	_, err = stmt.Exec(name, address, lat, lng, priceForTwo, imgURL, imageKey, discountAvailable, alcoholAvailable, portionSizeLarge, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/restaurants", http.StatusSeeOther)
+	wh.auditUpdate(r, "restaurants", id, before)
+	wh.redirectWithFlash(w, r, "/restaurants", FlashSuccess, "flash.updated", wh.T(r, "entity.restaurants"))
 }
 
 func (wh *WebHandlers) RestaurantDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	idStr := r.FormValue("id")
 	id, _ := strconv.ParseInt(idStr, 10, 64)
-	stmt, err := wh.db.Prepare("DELETE FROM restaurants WHERE restaurant_id=?")
+	before := wh.auditSnapshot("restaurants", id)
+	// Restaurants are only marked as deleted so that their reviews and metric scores survive
+	stmt, err := wh.db.Prepare("UPDATE restaurants SET deleted_at=CURRENT_TIMESTAMP WHERE restaurant_id=? AND deleted_at IS NULL")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/restaurants", err, "flash.delete_failed", wh.T(r, "entity.restaurants"))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/restaurants", err, "flash.delete_failed", wh.T(r, "entity.restaurants"))
 		return
 	}
-	http.Redirect(w, r, "/restaurants", http.StatusSeeOther)
+	wh.auditDelete(r, "restaurants", id, before)
+	wh.redirectWithFlash(w, r, "/restaurants", FlashSuccess, "flash.deleted", wh.T(r, "entity.restaurants"))
 }
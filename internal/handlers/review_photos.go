@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/images"
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultReviewMaxPhotos - the number of photos a review can have when review_max_photos has not been set
+	defaultReviewMaxPhotos = 6
+
+	// reviewPhotoPrefix - the prefix of the keys photos of reviews are stored under in the media storage
+	reviewPhotoPrefix = "reviews"
+)
+
+// ReviewPhoto - a photo attached to a review, with the URLs of its sizes
+type ReviewPhoto struct {
+	db.ReviewPhoto
+}
+
+// ThumbURL - returns the URL of the square thumbnail of the photo
+func (p ReviewPhoto) ThumbURL() string {
+	return imageURL(p.ImageKey, "thumb")
+}
+
+// LargeURL - returns the URL of the photo scaled to fit the screen
+func (p ReviewPhoto) LargeURL() string {
+	return imageURL(p.ImageKey, "large")
+}
+
+// reviewMaxPhotos - returns the number of photos a review can have
+func reviewMaxPhotos() int {
+	if n := viper.GetInt("review_max_photos"); n > 0 {
+		return n
+	}
+	return defaultReviewMaxPhotos
+}
+
+// reviewPhotos - returns the photos of the reviews reviewIDs keyed by review, see db.ReviewPhotos
+func (wh *WebHandlers) reviewPhotos(reviewIDs []int64, withRejected bool) (map[int64][]ReviewPhoto, error) {
+	stored, err := db.ReviewPhotos(wh.db, reviewIDs, withRejected)
+	if err != nil {
+		return nil, err
+	}
+	photos := make(map[int64][]ReviewPhoto, len(stored))
+	for id, ps := range stored {
+		for _, p := range ps {
+			photos[id] = append(photos[id], ReviewPhoto{ReviewPhoto: p})
+		}
+	}
+	return photos, nil
+}
+
+// galleryPhotos - returns the photos of reviews, newest first
+func galleryPhotos(reviews []RestaurantReview) []ReviewPhoto {
+	var gallery []ReviewPhoto
+	for _, rr := range reviews {
+		gallery = append(gallery, rr.Photos...)
+	}
+	sort.SliceStable(gallery, func(i, j int) bool {
+		return gallery[i].CreatedAt.After(gallery[j].CreatedAt)
+	})
+	return gallery
+}
+
+// uploadedReviewPhotos - stores the photos uploaded in the form field photos of r for the review reviewID, 0 for a new
+// review. It returns their keys along with the IDs of the photos the form field remove_photo asks to remove. The photos
+// the review keeps and the new ones may not exceed review_max_photos. Failures are *HTTPError.
+func (wh *WebHandlers) uploadedReviewPhotos(r *http.Request, reviewID int64) ([]string, []int64, error) {
+	var remove []int64
+	for _, v := range r.Form["remove_photo"] {
+		if photoID, err := strconv.ParseInt(v, 10, 64); err == nil {
+			remove = append(remove, photoID)
+		}
+	}
+	var kept int
+	if reviewID != 0 {
+		n, err := db.CountReviewPhotos(wh.db, reviewID)
+		if err != nil {
+			return nil, nil, internalError(err)
+		}
+		if kept = n - len(remove); kept < 0 {
+			kept = 0
+		}
+	}
+	max := reviewMaxPhotos()
+	if kept+len(uploadedFiles(r, "photos")) > max {
+		return nil, nil, badRequest(nil, "error.too_many_photos", max)
+	}
+	keys, err := wh.storeUploadedImages(r, "photos", reviewPhotoPrefix, images.ReviewSizes)
+	if err != nil {
+		wh.discardReviewPhotos(keys)
+		return nil, nil, err
+	}
+	return keys, remove, nil
+}
+
+// discardReviewPhotos - deletes the stored sizes of the photos uploaded under keys for a review which could not be
+// saved. Identical uploads share their keys, so the photos another review refers to are kept.
+func (wh *WebHandlers) discardReviewPhotos(keys []string) {
+	for _, key := range keys {
+		used, err := db.IsReviewPhotoKeyUsed(wh.db, key)
+		if err != nil {
+			wh.Log.Errorf("handlers.WebHandlers.discardReviewPhotos: error checking whether %s is used: %s", key, err.Error())
+			continue
+		}
+		if used {
+			continue
+		}
+		for _, size := range images.ReviewSizes {
+			if err := wh.media.Delete(key + "/" + size.Name + ".jpg"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				wh.Log.Errorf("handlers.WebHandlers.discardReviewPhotos: error deleting the %s image of %s: %s", size.Name, key, err.Error())
+			}
+		}
+	}
+}
+
+// saveReviewPhotos - removes the photos remove from the review reviewID and attaches the ones stored under keys, through
+// conn. It returns the number of photos attached, leaving out those the review already had.
+func (wh *WebHandlers) saveReviewPhotos(conn db.Queryer, r *http.Request, reviewID int64, keys []string, remove []int64) (int, error) {
+	for _, photoID := range remove {
+		before := wh.auditSnapshotIn(conn, "review_photos", photoID)
+		removed, err := db.RemoveReviewPhoto(conn, reviewID, photoID)
+		if err != nil {
+			return 0, fmt.Errorf("error removing photo %d of review %d: %s", photoID, reviewID, err.Error())
+		}
+		if removed {
+			wh.auditIn(conn, r, db.AuditActionDelete, "review_photos", photoID, before, nil)
+		}
+	}
+	var added int
+	for _, key := range keys {
+		photoID, err := db.AddReviewPhoto(conn, reviewID, key)
+		if err != nil {
+			return added, fmt.Errorf("error attaching photo %s to review %d: %s", key, reviewID, err.Error())
+		}
+		if photoID != 0 {
+			added++
+			wh.auditIn(conn, r, db.AuditActionCreate, "review_photos", photoID, nil, wh.auditSnapshotIn(conn, "review_photos", photoID))
+		}
+	}
+	return added, nil
+}
+
+// holdReviewPhotos - reports whether reviews with new photos wait for a moderator, as configured
+func holdReviewPhotos() bool {
+	return viper.GetBool("moderation_hold_photos")
+}
+
+// newPhotosReason - returns the reason a review to which n photos were added is held for moderation
+func newPhotosReason(n int) db.ModerationReason {
+	return db.NewModerationReason(db.ReasonNewPhotos, n)
+}
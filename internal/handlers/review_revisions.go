@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/textdiff"
+)
+
+// ReviewVersion - a version of a review, along with what changed since the version before it
+type ReviewVersion struct {
+	Number         int
+	RestaurantName string
+	OverallScore   float64
+	ReviewText     string
+	// WrittenAt is when the version was written and EditorName who wrote it, unknown for the first version
+	WrittenAt  time.Time
+	EditorName sql.NullString
+	// Diff turns the text of the version before into the text of this one, nil for the first version
+	Diff              []textdiff.Segment
+	RestaurantChanged bool
+	ScoreChanged      bool
+	Current           bool
+}
+
+type ReviewHistoryTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Review          Review
+	// Versions holds every version of the review, the current one first
+	Versions []ReviewVersion
+}
+
+// reviewVersions - returns the versions of rev, the current one first, from the revisions kept of it, oldest first
+func reviewVersions(rev Review, revisions []db.ReviewRevision) []ReviewVersion {
+	versions := make([]ReviewVersion, 0, len(revisions)+1)
+	for i, rr := range revisions {
+		v := ReviewVersion{RestaurantName: rr.RestaurantName, OverallScore: rr.OverallScore, ReviewText: rr.ReviewText, WrittenAt: rev.CreatedAt}
+		if i > 0 {
+			v.WrittenAt, v.EditorName = revisions[i-1].ReplacedAt, revisions[i-1].EditorName
+		}
+		versions = append(versions, v)
+	}
+	current := ReviewVersion{RestaurantName: rev.RestaurantName, OverallScore: rev.OverallScore, ReviewText: rev.ReviewText, WrittenAt: rev.CreatedAt, Current: true}
+	if n := len(revisions); n > 0 {
+		current.WrittenAt, current.EditorName = revisions[n-1].ReplacedAt, revisions[n-1].EditorName
+	}
+	versions = append(versions, current)
+
+	for i := range versions {
+		versions[i].Number = i + 1
+		if i == 0 {
+			continue
+		}
+		prev := versions[i-1]
+		versions[i].Diff = textdiff.Words(prev.ReviewText, versions[i].ReviewText)
+		versions[i].RestaurantChanged = prev.RestaurantName != versions[i].RestaurantName
+		versions[i].ScoreChanged = prev.OverallScore != versions[i].OverallScore
+	}
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions
+}
+
+// -----------------------------------------------------------------
+// Review History Handlers
+
+// ReviewHistoryHandler - shows every version of the review ?id=, with the changes each edit made
+func (wh *WebHandlers) ReviewHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	reviews, err := wh.listReviews("rv.review_id = ?", id)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if len(reviews) == 0 {
+		wh.Error(w, r, notFound(nil, ""))
+		return
+	}
+	revisions, err := db.ListReviewRevisions(wh.db, id)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	templateData := ReviewHistoryTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Review:          reviews[0],
+		Versions:        reviewVersions(reviews[0], revisions),
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "review_history", templateData)
+	if tmplErr != nil {
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/spf13/viper"
 )
 
 type Review struct {
@@ -13,61 +18,287 @@ type Review struct {
 	OverallScore float64
 	ReviewText   string
 	CreatedAt    time.Time
+	// Status is the moderation state of the review, see db.ReviewStatusApproved
+	Status           string
+	ModerationReason sql.NullString
+	ModeratedAt      sql.NullTime
+	// AnomalyScore is how suspicious the review looked when it was last checked, see moderation.Detector
+	AnomalyScore sql.NullFloat64
+	// SentimentScore is how positive the text of the review reads, from -1 to 1, see sentiment.Analyze
+	SentimentScore sql.NullFloat64
+	// SupersededBy is the newer review of the same user for the same restaurant which replaced the review
+	SupersededBy sql.NullInt64
+	// Revisions counts the earlier versions of the review, only set when listing reviews
+	Revisions int
+	// RestaurantName and UserName name the restaurant and the user the IDs refer to, ModeratorName the moderator who
+	// last decided on the review. They are only set when listing reviews.
+	RestaurantName string
+	UserName       string
+	ModeratorName  sql.NullString
+	// Photos holds the photos attached to the review, only set where they are shown
+	Photos []ReviewPhoto
 }
 
-// -----------------------------------------------------------------
-// Reviews Handlers
+// Reasons - returns the reasons the review was held, or the reason the moderator gave for their decision
+func (rev Review) Reasons() []db.ModerationReason {
+	return db.ParseModerationReasons(rev.ModerationReason.String)
+}
 
-func (wh *WebHandlers) ReviewsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := wh.db.Query("SELECT review_id, restaurant_id, user_id, overall_score, review_text, created_at FROM reviews")
+type ReviewsHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Reviews         []Review
+}
+
+type ReviewFormTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Review          Review
+	Restaurant      LookupField
+	User            LookupField
+	// Metrics holds an input per metric, scored with the widget of its display type
+	Metrics []MetricInput
+	// MaxPhotos is the number of photos the review can have, see Review.Photos for the ones it has
+	MaxPhotos int
+}
+
+// reviewFormData - returns the data of review_form.html for rev, with its foreign keys as lookup fields
+func (wh *WebHandlers) reviewFormData(r *http.Request, rev Review) (ReviewFormTemplateData, error) {
+	data := ReviewFormTemplateData{IsLoggedIn: isLoggedIn(r), IsLoggedInAdmin: isAdmin(r), Review: rev, MaxPhotos: reviewMaxPhotos()}
+	var err error
+	if rev.ID != 0 {
+		photos, err := wh.reviewPhotos([]int64{rev.ID}, true)
+		if err != nil {
+			return data, err
+		}
+		data.Review.Photos = photos[rev.ID]
+	}
+	if data.Restaurant, err = wh.lookupField("restaurant_id", "field.restaurant", "restaurants", true, rev.RestaurantID); err != nil {
+		return data, err
+	}
+	if data.User, err = wh.lookupField("user_id", "field.user", "users", true, rev.UserID); err != nil {
+		return data, err
+	}
+	data.Metrics, err = wh.reviewMetricInputs(rev.ID)
+	return data, err
+}
+
+// listReviews - returns the reviews which have not been deleted and match the condition where, which may be followed
+// by an ORDER BY clause, along with the names of their restaurant, user and moderator
+func (wh *WebHandlers) listReviews(where string, args ...interface{}) ([]Review, error) {
+	rows, err := wh.db.Query("SELECT rv.review_id, rv.restaurant_id, rs.name, rv.user_id, COALESCE(NULLIF(u.email, ''), NULLIF(u.mobile_number, ''), CONCAT('#', u.user_id)), rv.overall_score, rv.review_text, rv.status, rv.moderation_reason, rv.moderated_at, rv.anomaly_score, rv.sentiment_score, rv.superseded_by, (SELECT COUNT(*) FROM review_revisions AS rr WHERE rr.review_id=rv.review_id), COALESCE(NULLIF(mu.email, ''), NULLIF(mu.mobile_number, ''), CONCAT('#', mu.user_id)), rv.created_at FROM reviews AS rv JOIN restaurants AS rs ON rv.restaurant_id=rs.restaurant_id JOIN users AS u ON rv.user_id=u.user_id LEFT JOIN users AS mu ON rv.moderated_by=mu.user_id WHERE rv.deleted_at IS NULL AND rs.deleted_at IS NULL AND u.deleted_at IS NULL AND "+where, args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("error querying reviews: %s", err.Error())
 	}
 	defer rows.Close()
 	var reviews []Review
 	for rows.Next() {
 		var rev Review
-		err := rows.Scan(&rev.ID, &rev.RestaurantID, &rev.UserID, &rev.OverallScore, &rev.ReviewText, &rev.CreatedAt)
+		err := rows.Scan(&rev.ID, &rev.RestaurantID, &rev.RestaurantName, &rev.UserID, &rev.UserName, &rev.OverallScore, &rev.ReviewText, &rev.Status, &rev.ModerationReason, &rev.ModeratedAt, &rev.AnomalyScore, &rev.SentimentScore, &rev.SupersededBy, &rev.Revisions, &rev.ModeratorName, &rev.CreatedAt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return nil, fmt.Errorf("error scanning review: %s", err.Error())
 		}
 		reviews = append(reviews, rev)
 	}
-	tmpl, tmplErr := wh.ExecuteTemplate("reviews", reviews)
+	return reviews, rows.Err()
+}
+
+// checkReviewAgain - returns an error unless the user userID may review the restaurant restaurantID at now. Users may
+// only review a restaurant again once review_rereview_days have passed since their active review of it, which the new
+// review supersedes once it is approved, see supersedeEarlierReviews. The user and their reviews of the restaurant stay
+// locked until tx ends, so the new review must be inserted in tx.
+func (wh *WebHandlers) checkReviewAgain(tx *sql.Tx, restaurantID, userID int64, now time.Time) error {
+	earlierID, earlierAt, err := db.LockActiveReview(tx, restaurantID, userID, 0)
+	if err != nil {
+		return internalError(err)
+	}
+	if earlierID == 0 {
+		return nil
+	}
+	days := viper.GetInt("review_rereview_days")
+	if days <= 0 {
+		return conflict(nil, "error.review_exists", earlierID)
+	}
+	if again := earlierAt.AddDate(0, 0, days); now.Before(again) {
+		return conflict(nil, "error.review_too_soon", earlierID, again.Format(time.DateOnly))
+	}
+	return nil
+}
+
+// supersedeEarlierReviews - marks the active reviews the user of the approved review id wrote earlier for its
+// restaurant as replaced by it. Reviews held for moderation leave the earlier ones shown until they are approved.
+func (wh *WebHandlers) supersedeEarlierReviews(r *http.Request, id int64) error {
+	earlierIDs, err := db.EarlierActiveReviews(wh.db, id)
+	if err != nil {
+		return err
+	}
+	for _, earlierID := range earlierIDs {
+		before := wh.auditSnapshot("reviews", earlierID)
+		if err = db.SupersedeReview(wh.db, earlierID, id); err != nil {
+			return err
+		}
+		wh.auditUpdate(r, "reviews", earlierID, before)
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------
+// Reviews Handlers
+
+// ReviewsHandler - lists the reviews. Admins see reviews of every state, other users only the approved, active ones
+// which are shown on the restaurant pages. Whether the user is an admin is taken from the login state of r, as the
+// route is open to every user who is logged in.
+func (wh *WebHandlers) ReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	admin := isAdmin(r)
+	where, args := "rv.status = ? AND "+db.ActiveReviewSQL, []interface{}{db.ReviewStatusApproved}
+	if admin {
+		where, args = "TRUE", nil
+	}
+	reviews, err := wh.listReviews(where+" ORDER BY rv.review_id", args...)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	templateData := ReviewsHandlerTemplateData{
+		IsLoggedIn:      true,
+		IsLoggedInAdmin: admin,
+		Reviews:         reviews,
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "reviews", templateData)
 	if tmplErr != nil {
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
 
 func (wh *WebHandlers) ReviewNewHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		tmpl, tmplErr := wh.ExecuteTemplate("review_form", nil)
+		templateData, err := wh.reviewFormData(r, Review{})
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "review_form", templateData)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
+	if err := wh.limitImageUpload(w, r, reviewMaxPhotos()); err != nil {
+		wh.Error(w, r, err)
+		return
+	}
 	restaurantID, _ := strconv.ParseInt(r.FormValue("restaurant_id"), 10, 64)
 	userID, _ := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
 	overallScore, _ := strconv.ParseFloat(r.FormValue("overall_score"), 64)
 	reviewText := r.FormValue("review_text")
+	metricInputs, err := wh.reviewMetricInputs(0)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	metricScores, err := submittedMetricScores(r, metricInputs)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
 	now := time.Now()
-	stmt, err := wh.db.Prepare("INSERT INTO reviews (restaurant_id, user_id, overall_score, review_text, created_at) VALUES (?, ?, ?, ?, ?)")
+	tx, err := wh.db.Begin()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(restaurantID, userID, overallScore, reviewText, now)
+	defer func() { _ = tx.Rollback() }()
+	if err := wh.checkReviewAgain(tx, restaurantID, userID, now); err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	photoKeys, _, err := wh.uploadedReviewPhotos(r, 0)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, err)
 		return
 	}
-	http.Redirect(w, r, "/reviews", http.StatusSeeOther)
+	// The review is saved along with its photos and scores or not at all, in which case the photos are deleted again
+	saved := false
+	defer func() {
+		if !saved {
+			_ = tx.Rollback()
+			wh.discardReviewPhotos(photoKeys)
+		}
+	}()
+	status, reasons := wh.screenReview(reviewText)
+	if len(photoKeys) > 0 && holdReviewPhotos() {
+		reasons = append(reasons, newPhotosReason(len(photoKeys)))
+		if status == db.ReviewStatusApproved {
+			status = db.ReviewStatusPending
+		}
+	}
+	moderationReason := sql.NullString{String: db.FormatModerationReasons(reasons), Valid: len(reasons) > 0}
+	// Reviews which pass the screening stay pending until the detectors below have run, see db.ReleaseReview
+	insertStatus := status
+	if insertStatus == db.ReviewStatusApproved {
+		insertStatus = db.ReviewStatusPending
+	}
+	res, err := tx.Exec("INSERT INTO reviews (restaurant_id, user_id, overall_score, review_text, status, moderation_reason, created_at) VALUES (?, ?, ?, ?, ?, LEFT(?, 1000), ?)",
+		restaurantID, userID, overallScore, reviewText, insertStatus, moderationReason, now)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.auditIn(tx, r, db.AuditActionCreate, "reviews", id, nil, wh.auditSnapshotIn(tx, "reviews", id))
+	if _, err := wh.saveReviewPhotos(tx, r, id, photoKeys, nil); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if err := wh.saveReviewMetricScores(tx, r, id, metricInputs, metricScores); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if err := wh.updateOverallScore(tx, r, id); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	saved = true
+	if wh.detectAnomalies(r, id) {
+		status = db.ReviewStatusFlagged
+	}
+	if wh.analyzeSentiment(r, id) {
+		status = db.ReviewStatusFlagged
+	}
+	if status == db.ReviewStatusApproved {
+		before := wh.auditSnapshot("reviews", id)
+		if status, err = db.ReleaseReview(wh.db, id); err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		wh.auditUpdate(r, "reviews", id, before)
+	}
+	if status == db.ReviewStatusApproved {
+		if err := wh.supersedeEarlierReviews(r, id); err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+	}
+	wh.rankRestaurant(restaurantID)
+	if status != db.ReviewStatusApproved {
+		wh.redirectWithFlash(w, r, "/reviews", FlashSuccess, "flash.review_held", wh.T(r, "review_status."+status))
+		return
+	}
+	wh.redirectWithFlash(w, r, "/reviews", FlashSuccess, "flash.created", wh.T(r, "entity.reviews"))
 }
 
 func (wh *WebHandlers) ReviewEditHandler(w http.ResponseWriter, r *http.Request) {
@@ -78,51 +309,159 @@ func (wh *WebHandlers) ReviewEditHandler(w http.ResponseWriter, r *http.Request)
 		err := wh.db.QueryRow("SELECT review_id, restaurant_id, user_id, overall_score, review_text, created_at FROM reviews WHERE review_id=?", id).
 			Scan(&rev.ID, &rev.RestaurantID, &rev.UserID, &rev.OverallScore, &rev.ReviewText, &rev.CreatedAt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		templateData, err := wh.reviewFormData(r, rev)
+		if err != nil {
+			wh.Error(w, r, internalError(err))
 			return
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("review_form", rev)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "review_form", templateData)
 		if tmplErr != nil {
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
+	if err := wh.limitImageUpload(w, r, reviewMaxPhotos()); err != nil {
+		wh.Error(w, r, err)
+		return
+	}
 	restaurantID, _ := strconv.ParseInt(r.FormValue("restaurant_id"), 10, 64)
 	userID, _ := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
 	overallScore, _ := strconv.ParseFloat(r.FormValue("overall_score"), 64)
 	reviewText := r.FormValue("review_text")
-	stmt, err := wh.db.Prepare("UPDATE reviews SET restaurant_id=?, user_id=?, overall_score=?, review_text=? WHERE review_id=?")
+	metricInputs, err := wh.reviewMetricInputs(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(restaurantID, userID, overallScore, reviewText, id)
+	metricScores, err := submittedMetricScores(r, metricInputs)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	// The review stays locked until the edit is saved, so that concurrent edits and the revisions they keep follow
+	// each other
+	tx, err := wh.db.Begin()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	// A review which is still active cannot be moved to a user and restaurant which have another active review. A
+	// newer review held for moderation does not stop its user from editing the one it is about to supersede.
+	var (
+		supersededBy         sql.NullInt64
+		previousRestaurantID int64
+		previousUserID       int64
+	)
+	if err := tx.QueryRow("SELECT superseded_by, restaurant_id, user_id FROM reviews WHERE review_id=? FOR UPDATE", id).Scan(&supersededBy, &previousRestaurantID, &previousUserID); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if !supersededBy.Valid && (previousRestaurantID != restaurantID || previousUserID != userID) {
+		otherID, _, err := db.LockActiveReview(tx, restaurantID, userID, id)
+		if err != nil {
+			wh.Error(w, r, internalError(err))
+			return
+		}
+		if otherID != 0 {
+			wh.Error(w, r, conflict(nil, "error.review_exists", otherID))
+			return
+		}
+	}
+	photoKeys, removePhotos, err := wh.uploadedReviewPhotos(r, id)
+	if err != nil {
+		wh.Error(w, r, err)
+		return
+	}
+	saved := false
+	defer func() {
+		if !saved {
+			_ = tx.Rollback()
+			wh.discardReviewPhotos(photoKeys)
+		}
+	}()
+	if _, err := db.SaveReviewRevision(tx, id, wh.sessionUserID(r), restaurantID, userID, overallScore, reviewText); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	before := wh.auditSnapshotIn(tx, "reviews", id)
+	_, err = tx.Exec("UPDATE reviews SET restaurant_id=?, user_id=?, overall_score=?, review_text=? WHERE review_id=?", restaurantID, userID, overallScore, reviewText, id)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	addedPhotos, err := wh.saveReviewPhotos(tx, r, id, photoKeys, removePhotos)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	// Edited texts are checked again, so that a review cannot be approved first and filled with links later
+	reasons := wh.moderationRules().Check(reviewText)
+	if addedPhotos > 0 && holdReviewPhotos() {
+		reasons = append(reasons, newPhotosReason(addedPhotos))
+	}
+	flagged, err := db.FlagReview(tx, id, reasons)
+	if err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	wh.auditIn(tx, r, db.AuditActionUpdate, "reviews", id, before, wh.auditSnapshotIn(tx, "reviews", id))
+	if err := wh.saveReviewMetricScores(tx, r, id, metricInputs, metricScores); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if err := wh.updateOverallScore(tx, r, id); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		wh.Error(w, r, internalError(err))
+		return
+	}
+	saved = true
+	if wh.detectAnomalies(r, id) {
+		flagged = true
+	}
+	if wh.analyzeSentiment(r, id) {
+		flagged = true
+	}
+	wh.rankRestaurant(restaurantID)
+	if previousRestaurantID != restaurantID {
+		wh.rankRestaurant(previousRestaurantID)
+	}
+	if flagged {
+		wh.redirectWithFlash(w, r, "/reviews", FlashSuccess, "flash.review_held", wh.T(r, "review_status."+db.ReviewStatusFlagged))
 		return
 	}
-	http.Redirect(w, r, "/reviews", http.StatusSeeOther)
+	wh.redirectWithFlash(w, r, "/reviews", FlashSuccess, "flash.updated", wh.T(r, "entity.reviews"))
 }
 
 func (wh *WebHandlers) ReviewDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	idStr := r.FormValue("id")
 	id, _ := strconv.ParseInt(idStr, 10, 64)
-	stmt, err := wh.db.Prepare("DELETE FROM reviews WHERE review_id=?")
+	before := wh.auditSnapshot("reviews", id)
+	// Reviews are only marked as deleted so that their metric scores survive
+	stmt, err := wh.db.Prepare("UPDATE reviews SET deleted_at=CURRENT_TIMESTAMP WHERE review_id=? AND deleted_at IS NULL")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/reviews", err, "flash.delete_failed", wh.T(r, "entity.reviews"))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/reviews", err, "flash.delete_failed", wh.T(r, "entity.reviews"))
 		return
 	}
-	http.Redirect(w, r, "/reviews", http.StatusSeeOther)
+	wh.auditDelete(r, "reviews", id, before)
+	wh.rankReviewRestaurant(id)
+	wh.redirectWithFlash(w, r, "/reviews", FlashSuccess, "flash.deleted", wh.T(r, "entity.reviews"))
 }
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"database/sql"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/i18n"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultCurrencySymbol - the currency prices are shown in when currency_symbol has not been set
+	defaultCurrencySymbol = "₹"
+
+	// maxStars - the rating shown as a row of full stars
+	maxStars = 5
+)
+
+// TemplateFuncs - the functions available to the templates of every theme. Times are shown in the time zone of u, and
+// messages, numbers and dates are written for the default locale of locales until ExecuteTemplate replaces the
+// functions marked with * by those of the locale of the request, see localizedTemplateFuncs.
+//
+//	T key args...               * the message stored under key in the catalog of the locale, formatted with args
+//	Tn key n args...            * the plural form of the message for n, e.g. "1 day" or "3 days"
+//	locale                      * the locale the page is written for, e.g. "hi"
+//	localeURL locale            * the URL of the current page in another locale
+//	locales                     the locales with a catalog
+//	asset path                  * the fingerprinted URL of a file of the static directory of the theme, see AssetIndex
+//	flashes                     * the flash messages queued for the page, see AddFlash
+//	date t, datetime t          * t in the date or date and time format of the locale, empty for zero times
+//	formatTime layout t         * t formatted with the Go layout
+//	timeAgo t                   * how long ago t was, e.g. "3 days ago"
+//	duration d                  * d in words, e.g. "2 hours 5 minutes"
+//	currency v                  * v as a price, e.g. "₹1,250.00"
+//	number decimals v           * v with the digit grouping of the locale
+//	stars v                     * v out of 5 as a row of stars
+//	moderationReason reason     * why a review is held for moderation, see db.ModerationReason
+//	pluralize n singular plural "1 review" or "3 reviews"
+//	truncate n s                s cut down to n characters
+//	percent v                   the share v, from 0 to 1, as a percentage
+//	userTypeLabel key           a user type key such as __superadmin__ as "Superadmin"
+func TemplateFuncs(u *utils.Utils, locales *i18n.Bundle) template.FuncMap {
+	funcs := template.FuncMap{
+		"asset": func(p string) string {
+			return staticURLPrefix + p
+		},
+		"flashes": func() []Flash {
+			return nil
+		},
+		"locales":       locales.Locales,
+		"percent":       percent,
+		"pluralize":     pluralize,
+		"truncate":      truncate,
+		"userTypeLabel": userTypeLabel,
+	}
+	for name, fn := range localizedTemplateFuncs(u, locales.Localizer(locales.DefaultLocale()), nil) {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// localizedTemplateFuncs - the template functions which depend on the locale of l. r is the request the page is
+// rendered for, nil while parsing.
+func localizedTemplateFuncs(u *utils.Utils, l *i18n.Localizer, r *http.Request) template.FuncMap {
+	location := time.Local
+	if u != nil && u.TimeZone != nil {
+		location = u.TimeZone
+	}
+	formatTime := func(t interface{}, layout string) string {
+		tm, ok := templateTime(t)
+		if !ok {
+			return ""
+		}
+		return l.FormatTime(tm.In(location), layout)
+	}
+	return template.FuncMap{
+		"T":      l.T,
+		"Tn":     l.N,
+		"locale": l.Locale,
+		"localeURL": func(locale string) string {
+			if r == nil {
+				return "?" + localeQueryParam + "=" + url.QueryEscape(locale)
+			}
+			query := r.URL.Query()
+			query.Set(localeQueryParam, locale)
+			return r.URL.Path + "?" + query.Encode()
+		},
+		"date": func(t interface{}) string {
+			return formatTime(t, l.T("format.date"))
+		},
+		"datetime": func(t interface{}) string {
+			return formatTime(t, l.T("format.datetime"))
+		},
+		"formatTime": func(layout string, t interface{}) string {
+			return formatTime(t, layout)
+		},
+		"timeAgo": func(t interface{}) string {
+			tm, ok := templateTime(t)
+			if !ok {
+				return ""
+			}
+			return timeAgo(l, tm, time.Now())
+		},
+		"duration": func(d time.Duration) string {
+			return humanizeDuration(l, d)
+		},
+		"currency": func(v interface{}) string {
+			return formatCurrency(l, v)
+		},
+		"number": func(decimals int, v interface{}) string {
+			return l.Number(decimals, templateFloat(v))
+		},
+		"stars": func(v interface{}) template.HTML {
+			return renderStars(l, v)
+		},
+		"moderationReason": func(reason db.ModerationReason) string {
+			return moderationReasonText(l, reason)
+		},
+	}
+}
+
+// templateTime - returns the time held by t, which may be a time.Time, a *time.Time or an sql.NullTime. Zero and
+// NULL times are reported as missing.
+func templateTime(t interface{}) (time.Time, bool) {
+	var tm time.Time
+	switch v := t.(type) {
+	case time.Time:
+		tm = v
+	case *time.Time:
+		if v == nil {
+			return tm, false
+		}
+		tm = *v
+	case sql.NullTime:
+		if !v.Valid {
+			return tm, false
+		}
+		tm = v.Time
+	default:
+		return tm, false
+	}
+	return tm, !tm.IsZero()
+}
+
+// templateFloat - converts the numbers templates receive from the handlers to a float64
+func templateFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int8:
+		return float64(n)
+	case int16:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint:
+		return float64(n)
+	case uint8:
+		return float64(n)
+	case uint16:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case sql.NullFloat64:
+		return n.Float64
+	case sql.NullInt64:
+		return float64(n.Int64)
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f
+	}
+	return 0
+}
+
+// timeAgo - describes how long before now t was, or how long after for times in the future
+func timeAgo(l *i18n.Localizer, t, now time.Time) string {
+	d := now.Sub(t)
+	if d < 0 {
+		return l.T("time.in", humanizeDuration(l, -d))
+	}
+	if d < time.Minute {
+		return l.T("time.just_now")
+	}
+	return l.T("time.ago", humanizeDuration(l, d))
+}
+
+// humanizeDuration - describes d in words using its two largest units, e.g. "2 hours 5 minutes"
+func humanizeDuration(l *i18n.Localizer, d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	if d < time.Second {
+		return l.T("duration.less_than_second")
+	}
+	units := []struct {
+		size time.Duration
+		name string
+	}{
+		{365 * 24 * time.Hour, "year"},
+		{30 * 24 * time.Hour, "month"},
+		{7 * 24 * time.Hour, "week"},
+		{24 * time.Hour, "day"},
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+		{time.Second, "second"},
+	}
+	var parts []string
+	for _, unit := range units {
+		if d < unit.size {
+			if len(parts) > 0 {
+				// only adjacent units are combined, "1 day 3 seconds" is not helpful
+				break
+			}
+			continue
+		}
+		n := int64(d / unit.size)
+		d -= time.Duration(n) * unit.size
+		parts = append(parts, l.N("duration."+unit.name, int(n)))
+		if len(parts) == 2 {
+			break
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatCurrency - formats v as a price in the currency set in currency_symbol, with the digit grouping of l
+func formatCurrency(l *i18n.Localizer, v interface{}) string {
+	symbol := viper.GetString("currency_symbol")
+	if symbol == "" {
+		symbol = defaultCurrencySymbol
+	}
+	decimals := 2
+	if viper.IsSet("currency_decimals") {
+		decimals = viper.GetInt("currency_decimals")
+	}
+	s := l.Number(decimals, templateFloat(v))
+	if strings.HasPrefix(s, "-") {
+		return "-" + symbol + s[1:]
+	}
+	return symbol + s
+}
+
+// renderStars - renders the rating v, out of 5, as full, half and empty stars rounded to the nearest half
+func renderStars(l *i18n.Localizer, v interface{}) template.HTML {
+	rating := math.Max(0, math.Min(maxStars, templateFloat(v)))
+	halves := int(math.Round(rating * 2))
+	label := template.HTMLEscapeString(l.T("stars.label", strconv.FormatFloat(rating, 'f', -1, 64), maxStars))
+
+	var b strings.Builder
+	b.WriteString(`<span class="stars" role="img" title="` + label + `" aria-label="` + label + `">`)
+	for i := 0; i < maxStars; i++ {
+		switch {
+		case halves >= 2:
+			b.WriteString(`<span class="star star-full">★</span>`)
+			halves -= 2
+		case halves == 1:
+			b.WriteString(`<span class="star star-half">★</span>`)
+			halves--
+		default:
+			b.WriteString(`<span class="star star-empty">☆</span>`)
+		}
+	}
+	b.WriteString(`</span>`)
+	return template.HTML(b.String())
+}
+
+// moderationReasonText - returns reason in the words of the locale of l, from its message moderation_reason.<code>.
+// Durations, stored in seconds, are written out, and report reasons named. Reasons stored before they had codes, and
+// those whose code has no message, are shown as stored.
+func moderationReasonText(l *i18n.Localizer, reason db.ModerationReason) string {
+	if reason.Code == "" {
+		return reason.String()
+	}
+	key := "moderation_reason." + reason.Code
+	args := make([]interface{}, len(reason.Args))
+	for i, arg := range reason.Args {
+		args[i] = arg
+	}
+	seconds := func(i int) {
+		if i < len(args) {
+			if n, err := strconv.ParseInt(reason.Args[i], 10, 64); err == nil {
+				args[i] = humanizeDuration(l, time.Duration(n)*time.Second)
+			}
+		}
+	}
+	switch reason.Code {
+	case db.ReasonNewPhotos:
+		if n, err := strconv.Atoi(strings.Join(reason.Args, "")); err == nil {
+			return l.N(key, n)
+		}
+	case db.ReasonNewAccount:
+		seconds(0)
+	case db.ReasonBurst:
+		seconds(1)
+	case db.ReasonReported:
+		if len(args) > 0 {
+			args[0] = l.T("report_reason." + reason.Args[0])
+		}
+	}
+	if _, ok := l.Lookup(key); !ok {
+		return reason.String()
+	}
+	return l.T(key, args...)
+}
+
+// pluralize - returns n followed by singular when n is 1 and by plural otherwise
+func pluralize(n interface{}, singular, plural string) string {
+	f := templateFloat(n)
+	word := plural
+	if f == 1 {
+		word = singular
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64) + " " + word
+}
+
+// truncate - cuts s down to at most n characters, ending it with an ellipsis when anything was cut
+func truncate(n int, s string) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	cut := strings.TrimRightFunc(string(runes[:n-1]), unicode.IsSpace)
+	return cut + "…"
+}
+
+// percent - returns the share v, from 0 to 1, as a percentage
+func percent(v float64) float64 {
+	return v * 100
+}
+
+// userTypeLabel - turns a user type key such as __super_admin__ into a label such as "Super Admin"
+func userTypeLabel(userTypeValue string) string {
+	// 1. Strip leading and trailing underscores
+	trimmedValue := strings.Trim(userTypeValue, "_")
+
+	// 2. Split the string into words by underscore
+	words := strings.Split(trimmedValue, "_")
+
+	var capitalizedWords []string
+	for _, word := range words {
+		if len(word) > 0 { // Handle empty words if any (though unlikely after trim and split)
+			// Capitalize the first letter and keep the rest lowercase
+			runes := []rune(word)                // Convert string to rune slice to handle Unicode correctly
+			runes[0] = unicode.ToUpper(runes[0]) // Capitalize the first rune
+			capitalizedWords = append(capitalizedWords, string(runes))
+		}
+	}
+
+	// 3. Join the capitalized words with spaces (or you can adjust as needed)
+	return strings.Join(capitalizedWords, " ")
+}
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/i18n"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/viper"
+)
+
+// testLocalizer - returns the localizer of the embedded catalog of locale
+func testLocalizer(t *testing.T, locale string) *i18n.Localizer {
+	t.Helper()
+	b, err := i18n.NewBundle("", "en")
+	if err != nil {
+		t.Fatalf("error loading catalogs: %s", err)
+	}
+	return b.Localizer(locale)
+}
+
+// TestTemplateMessages - every message the templates ask for is in the catalog of every locale
+func TestTemplateMessages(t *testing.T) {
+	catalogs := make(map[string]map[string]string)
+	paths, err := filepath.Glob("../../pkg/i18n/locales/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no catalogs found: %v", err)
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		catalog := make(map[string]string)
+		if err = json.Unmarshal(data, &catalog); err != nil {
+			t.Fatalf("error parsing %s: %s", p, err)
+		}
+		catalogs[filepath.Base(p)] = catalog
+	}
+
+	key := regexp.MustCompile(`\b(T|Tn) "([^"]+)"`)
+	prefix := regexp.MustCompile(`\(print "([a-z_.]+\.)"`)
+	err = filepath.WalkDir("../../templates", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(p) != ".html" {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for name, catalog := range catalogs {
+			for _, m := range key.FindAllStringSubmatch(string(data), -1) {
+				want := m[2]
+				if m[1] == "Tn" {
+					want += ".other"
+				}
+				if _, ok := catalog[want]; !ok {
+					t.Errorf("%s: %s has no %s", p, name, want)
+				}
+			}
+			for _, m := range prefix.FindAllStringSubmatch(string(data), -1) {
+				found := false
+				for k := range catalog {
+					found = found || strings.HasPrefix(k, m[1])
+				}
+				if !found {
+					t.Errorf("%s: %s has no message starting with %s", p, name, m[1])
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeAgo(t *testing.T) {
+	l := testLocalizer(t, "en")
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		t    time.Time
+		want string
+	}{
+		{"same instant", now, "just now"},
+		{"under a minute", now.Add(-59 * time.Second), "just now"},
+		{"one minute", now.Add(-time.Minute), "1 minute ago"},
+		{"two units", now.Add(-27 * time.Hour), "1 day 3 hours ago"},
+		{"days", now.AddDate(0, 0, -3), "3 days ago"},
+		{"future", now.Add(2 * time.Hour), "in 2 hours"},
+		{"future under a second", now.Add(time.Millisecond), "in less than a second"},
+		{"other time zone", now.In(time.FixedZone("IST", 19800)).Add(-5 * time.Minute), "5 minutes ago"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := timeAgo(l, tt.t, now); got != tt.want {
+				t.Errorf("timeAgo() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		locale string
+		d      time.Duration
+		want   string
+	}{
+		{"en", 0, "less than a second"},
+		{"en", 999 * time.Millisecond, "less than a second"},
+		{"en", -90 * time.Second, "1 minute 30 seconds"},
+		{"en", time.Second, "1 second"},
+		{"en", time.Hour + 5*time.Second, "1 hour"},
+		{"en", 2*time.Hour + 5*time.Minute + 7*time.Second, "2 hours 5 minutes"},
+		{"en", 8 * 24 * time.Hour, "1 week 1 day"},
+		{"en", 400 * 24 * time.Hour, "1 year 1 month"},
+		{"hi", 2 * time.Hour, "2 घंटे"},
+		{"hi", time.Second, "1 सेकंड"},
+		{"hi", 24 * time.Hour, "1 दिन"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale+" "+tt.d.String(), func(t *testing.T) {
+			if got := humanizeDuration(testLocalizer(t, tt.locale), tt.d); got != tt.want {
+				t.Errorf("humanizeDuration(%s) = %q, want %q", tt.d, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatCurrency(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	tests := []struct {
+		name     string
+		locale   string
+		symbol   string
+		decimals interface{}
+		v        interface{}
+		want     string
+	}{
+		{"default symbol", "en", "", nil, 1250, "₹1,250.00"},
+		{"negative", "en", "", nil, -5.5, "-₹5.50"},
+		{"string", "en", "", nil, " 12 ", "₹12.00"},
+		{"not a number", "en", "", nil, nil, "₹0.00"},
+		{"null", "en", "", nil, sql.NullFloat64{}, "₹0.00"},
+		{"configured", "en", "$", 0, 1234.56, "$1,235"},
+		{"indian grouping", "hi", "", nil, 1234567, "₹12,34,567.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			viper.Set("currency_symbol", tt.symbol)
+			if tt.decimals != nil {
+				viper.Set("currency_decimals", tt.decimals)
+			}
+			if got := formatCurrency(testLocalizer(t, tt.locale), tt.v); got != tt.want {
+				t.Errorf("formatCurrency(%v) = %q, want %q", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		locale   string
+		decimals int
+		v        interface{}
+		want     string
+	}{
+		{"en", 2, 1234567.891, "1,234,567.89"},
+		{"en", 0, 999.5, "1,000"},
+		{"en", -1, 12.7, "13"},
+		{"en", 1, int64(-4200), "-4,200.0"},
+		{"en", 2, "3.14159", "3.14"},
+		{"hi", 2, 1234567.891, "12,34,567.89"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			number := localizedTemplateFuncs(nil, testLocalizer(t, tt.locale), nil)["number"].(func(int, interface{}) string)
+			if got := number(tt.decimals, tt.v); got != tt.want {
+				t.Errorf("number(%d, %v) = %q, want %q", tt.decimals, tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderStars(t *testing.T) {
+	tests := []struct {
+		v                 interface{}
+		full, half, empty int
+		label             string
+	}{
+		{5, 5, 0, 0, "5 out of 5"},
+		{3.5, 3, 1, 1, "3.5 out of 5"},
+		{4.74, 4, 1, 0, "4.74 out of 5"},
+		{4.76, 5, 0, 0, "4.76 out of 5"},
+		{0.2, 0, 0, 5, "0.2 out of 5"},
+		{0.25, 0, 1, 4, "0.25 out of 5"},
+		{-1, 0, 0, 5, "0 out of 5"},
+		{7, 5, 0, 0, "5 out of 5"},
+		{sql.NullFloat64{Float64: 2.5, Valid: true}, 2, 1, 2, "2.5 out of 5"},
+	}
+	l := testLocalizer(t, "en")
+	for _, tt := range tests {
+		got := string(renderStars(l, tt.v))
+		full := strings.Count(got, "star-full")
+		half := strings.Count(got, "star-half")
+		empty := strings.Count(got, "star-empty")
+		if full != tt.full || half != tt.half || empty != tt.empty {
+			t.Errorf("stars(%v) = %d full, %d half, %d empty, want %d, %d, %d", tt.v, full, half, empty, tt.full, tt.half, tt.empty)
+		}
+		if !strings.Contains(got, `aria-label="`+tt.label+`"`) {
+			t.Errorf("stars(%v) = %s, want the label %q", tt.v, got, tt.label)
+		}
+	}
+
+	if got := renderStars(testLocalizer(t, "hi"), 4); !strings.Contains(string(got), `aria-label="5 में से 4"`) {
+		t.Errorf("stars(4) in hi = %s, want the label of the hi catalog", got)
+	}
+}
+
+func TestModerationReasonText(t *testing.T) {
+	tests := []struct {
+		locale string
+		stored string
+		want   string
+	}{
+		{"en", db.NewModerationReason(db.ReasonBlockedWord, "scam").String(), `contains the blocked word "scam"`},
+		{"en", db.NewModerationReason(db.ReasonLink, "http://a.example/?x=1,2; y").String(), `contains the link "http://a.example/?x=1,2; y"`},
+		{"en", db.NewModerationReason(db.ReasonNewPhotos, 1).String(), "has a new photo"},
+		{"en", db.NewModerationReason(db.ReasonNewPhotos, 3).String(), "has 3 new photos"},
+		{"en", db.NewModerationReason(db.ReasonNewAccount, 7200).String(), "written 2 hours after the account was created"},
+		{"en", db.NewModerationReason(db.ReasonBurst, 12, 86400).String(), "one of 12 reviews of the restaurant within 1 day"},
+		{"en", db.NewModerationReason(db.ReasonReported, db.ReportReasonSpam).String(), "reported as Spam or advertising"},
+		{"en", db.NewModerationReason(db.ReasonModerator, "Too short").String(), "Too short"},
+		{"en", "has a new photo", "has a new photo"},
+		{"en", "unknown_code:a", "unknown_code:a"},
+		{"hi", db.NewModerationReason(db.ReasonDuplicate, "85", 12).String(), "पाठ समीक्षा #12 से 85% मिलता है"},
+		{"hi", db.NewModerationReason(db.ReasonNewAccount, 60).String(), "खाता बनने के 1 मिनट बाद लिखी गई"},
+	}
+	for _, tt := range tests {
+		reasons := db.ParseModerationReasons(tt.stored)
+		if len(reasons) != 1 {
+			t.Errorf("ParseModerationReasons(%q) = %v, want one reason", tt.stored, reasons)
+			continue
+		}
+		if got := moderationReasonText(testLocalizer(t, tt.locale), reasons[0]); got != tt.want {
+			t.Errorf("moderationReasonText(%q) in %s = %q, want %q", tt.stored, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	tests := []struct {
+		n    interface{}
+		want string
+	}{
+		{1, "1 review"},
+		{int64(1), "1 review"},
+		{0, "0 reviews"},
+		{2, "2 reviews"},
+		{1.5, "1.5 reviews"},
+		{-1, "-1 reviews"},
+		{"1", "1 review"},
+	}
+	for _, tt := range tests {
+		if got := pluralize(tt.n, "review", "reviews"); got != tt.want {
+			t.Errorf("pluralize(%v) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n    int
+		s    string
+		want string
+	}{
+		{10, "hello", "hello"},
+		{5, "hello", "hello"},
+		{6, "hello world", "hello…"},
+		{4, "ab cd", "ab…"},
+		{1, "hello", "…"},
+		{0, "hello", ""},
+		{-3, "hello", ""},
+		{3, "", ""},
+		{4, "नमस्ते दुनिया", "नमस…"},
+		{3, "🍕🍔🌮🍜", "🍕🍔…"},
+		{4, "🍕🍔🌮🍜", "🍕🍔🌮🍜"},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.n, tt.s)
+		if got != tt.want {
+			t.Errorf("truncate(%d, %q) = %q, want %q", tt.n, tt.s, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%d, %q) = %q, which is not valid UTF-8", tt.n, tt.s, got)
+		}
+		if tt.n > 0 && utf8.RuneCountInString(got) > tt.n {
+			t.Errorf("truncate(%d, %q) = %q, which is longer than %d characters", tt.n, tt.s, got, tt.n)
+		}
+	}
+}
+
+func TestUserTypeLabel(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"__superadmin__", "Superadmin"},
+		{"__super_admin__", "Super Admin"},
+		{"reviewer", "Reviewer"},
+		{"restaurant__owner", "Restaurant Owner"},
+		{"über_user", "Über User"},
+		{"___", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := userTypeLabel(tt.key); got != tt.want {
+			t.Errorf("userTypeLabel(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateTimeZone(t *testing.T) {
+	u := &utils.Utils{TimeZone: time.FixedZone("IST", 19800)}
+	funcs := localizedTemplateFuncs(u, testLocalizer(t, "en"), nil)
+	datetime := funcs["datetime"].(func(interface{}) string)
+	date := funcs["date"].(func(interface{}) string)
+	formatTime := funcs["formatTime"].(func(string, interface{}) string)
+
+	utc := time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"datetime", datetime(utc), "03 Jan 2024, 01:30"},
+		{"date crosses midnight", date(utc), "03 Jan 2024"},
+		{"pointer", datetime(&utc), "03 Jan 2024, 01:30"},
+		{"other zone", formatTime("15:04 MST", utc.In(time.FixedZone("EST", -5*3600))), "01:30 IST"},
+		{"null time", datetime(sql.NullTime{Time: utc}), ""},
+		{"valid null time", date(sql.NullTime{Time: utc, Valid: true}), "03 Jan 2024"},
+		{"zero time", datetime(time.Time{}), ""},
+		{"nil pointer", datetime((*time.Time)(nil)), ""},
+		{"not a time", datetime("2024-01-02"), ""},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	hi := localizedTemplateFuncs(u, testLocalizer(t, "hi"), nil)["date"].(func(interface{}) string)
+	if got, want := hi(utc), "3 जनवरी 2024"; got != want {
+		t.Errorf("date in hi = %q, want %q", got, want)
+	}
+}
+
+// TestTemplateFuncs - every function listed in the doc comment of TemplateFuncs is available to the templates
+func TestTemplateFuncs(t *testing.T) {
+	b, err := i18n.NewBundle("", "en")
+	if err != nil {
+		t.Fatalf("error loading catalogs: %s", err)
+	}
+	funcs := TemplateFuncs(utils.NewUtils(), b)
+	for _, name := range []string{"T", "Tn", "locale", "localeURL", "locales", "asset", "flashes", "date", "datetime",
+		"formatTime", "timeAgo", "duration", "currency", "number", "stars", "moderationReason", "pluralize", "truncate", "percent",
+		"userTypeLabel"} {
+		if _, ok := funcs[name]; !ok {
+			t.Errorf("TemplateFuncs() has no %s", name)
+		}
+	}
+	tmpl := template.Must(template.New("t").Funcs(funcs).Parse(`{{ pluralize 3 "day" "days" }}, {{ truncate 4 "abcdef" }}, {{ duration .D }}`))
+	var out strings.Builder
+	if err := tmpl.Execute(&out, map[string]time.Duration{"D": 90 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "3 days, abc…, 1 hour 30 minutes"; got != want {
+		t.Errorf("template = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// templateReloadDelay - how long ThemeRegistry.Watch waits for further changes before re-parsing, since editors tend
+// to write a file in several steps
+const templateReloadDelay = 200 * time.Millisecond
+
+// templatePartials - the files of the partials directory of a theme which are parsed along with every page
+var templatePartials = []string{"layout.html", "header.html", "footer.html", "lookup_select.html", "metric_input.html", "review_status.html"}
+
+// TemplateCache - the parsed pages of a theme, keyed by page name. Pages are parsed once, either when the cache is
+// created or when Reload is called, and are safe to execute concurrently.
+type TemplateCache struct {
+	mu    sync.RWMutex
+	fsys  fs.FS
+	theme string
+	funcs template.FuncMap
+	pages map[string]*template.Template
+}
+
+// NewTemplateCache - parses every page of the theme themeName whose partials and pages directories are found at the
+// root of fsys. It fails if any of them cannot be parsed so that broken templates are noticed at startup rather than
+// by the first visitor. funcs are made available to every template.
+func NewTemplateCache(fsys fs.FS, themeName string, funcs template.FuncMap) (*TemplateCache, error) {
+	tc := &TemplateCache{fsys: fsys, theme: themeName, funcs: funcs}
+	if err := tc.Reload(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Reload - parses every page of the theme again. The pages parsed before are kept if any of them fails.
+func (tc *TemplateCache) Reload() error {
+	pagePaths, err := fs.Glob(tc.fsys, path.Join("pages", "*.html"))
+	if err != nil {
+		return fmt.Errorf("error listing the pages of theme %s: %s", tc.theme, err.Error())
+	}
+	if len(pagePaths) == 0 {
+		return fmt.Errorf("theme %s has no pages", tc.theme)
+	}
+
+	partialPaths := make([]string, 0, len(templatePartials))
+	for _, partial := range templatePartials {
+		partialPaths = append(partialPaths, path.Join("partials", partial))
+	}
+
+	pages := make(map[string]*template.Template, len(pagePaths))
+	for _, pagePath := range pagePaths {
+		pageName := strings.TrimSuffix(path.Base(pagePath), ".html")
+		// layout.html is parsed first so that it is the template executed for the page
+		tmpl, parseErr := template.New(templatePartials[0]).Funcs(tc.funcs).ParseFS(tc.fsys, append(partialPaths, pagePath)...)
+		if parseErr != nil {
+			return fmt.Errorf("error parsing page %s of theme %s: %s", pageName, tc.theme, parseErr.Error())
+		}
+		pages[pageName] = tmpl
+	}
+
+	tc.mu.Lock()
+	tc.pages = pages
+	tc.mu.Unlock()
+	return nil
+}
+
+// Execute - renders page with data, replacing the template functions of the cache with funcs, which may be nil. The
+// parsed page is cloned for every call since html/template does not allow changing the functions of a template once
+// it has been executed.
+func (tc *TemplateCache) Execute(page string, data interface{}, funcs template.FuncMap) (bytes.Buffer, error) {
+	tc.mu.RLock()
+	parsed, ok := tc.pages[page]
+	tc.mu.RUnlock()
+	if !ok {
+		return bytes.Buffer{}, fmt.Errorf("theme %s has no page %s", tc.theme, page)
+	}
+
+	tmpl, err := parsed.Clone()
+	if err != nil {
+		return bytes.Buffer{}, fmt.Errorf("error cloning page %s of theme %s: %s", page, tc.theme, err.Error())
+	}
+	if funcs != nil {
+		tmpl.Funcs(funcs)
+	}
+
+	var output bytes.Buffer
+	if err = tmpl.Execute(&output, data); err != nil {
+		return bytes.Buffer{}, fmt.Errorf("error executing template: %s", err.Error())
+	}
+	return output, nil
+}
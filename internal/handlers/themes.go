@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/scalland/bitebuddy/pkg/log"
+	"github.com/scalland/bitebuddy/pkg/utils"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultThemeName - the embedded theme every other theme falls back to
+	DefaultThemeName = "default"
+
+	// themesEmbeddedDir - the directory of the embedded filesystem holding one sub-directory per theme
+	themesEmbeddedDir = "templates"
+)
+
+// themeManifestFiles - the file names a theme manifest is looked up under, in order
+var themeManifestFiles = []string{"theme.yml", "theme.yaml", "theme.json"}
+
+// ThemeManifest - the metadata a theme describes itself with in its theme.yml, theme.yaml or theme.json file
+type ThemeManifest struct {
+	Name        string `mapstructure:"name"`
+	Title       string `mapstructure:"title"`
+	Description string `mapstructure:"description"`
+	Version     string `mapstructure:"version"`
+	Author      string `mapstructure:"author"`
+	// Parent is the theme whose files are used for the ones this theme does not have. It defaults to
+	// DefaultThemeName, which is the only theme without a parent.
+	Parent string `mapstructure:"parent"`
+}
+
+// Theme - a theme ready to be rendered
+type Theme struct {
+	Manifest ThemeManifest
+	// Dir is the directory the theme was loaded from on disk. It is empty for themes only found embedded.
+	Dir string
+	// FS holds the partials, pages and static directories of the theme, with every file missing from the theme
+	// itself taken from its parents
+	FS        fs.FS
+	Templates *TemplateCache
+	// Assets are the files of the static directory of FS
+	Assets *AssetIndex
+}
+
+// ThemeRegistry - every available theme, keyed by name. Themes are read from the embedded filesystem and from an
+// external directory whose themes override the embedded ones file by file.
+type ThemeRegistry struct {
+	mu          sync.RWMutex
+	embedded    fs.FS
+	externalDir string
+	funcs       template.FuncMap
+	themes      map[string]*Theme
+}
+
+// NewThemeRegistry - loads the themes found in the templates directory of embedded and in externalDir, which may be
+// empty. funcs are made available to the templates of every theme. It fails if any theme cannot be parsed.
+func NewThemeRegistry(embedded fs.FS, externalDir string, funcs template.FuncMap) (*ThemeRegistry, error) {
+	tr := &ThemeRegistry{embedded: embedded, externalDir: externalDir, funcs: funcs}
+	if err := tr.Reload(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// Reload - loads every theme again. The themes loaded before are kept if any of them fails.
+func (tr *ThemeRegistry) Reload() error {
+	// layers holds the filesystems of each theme, the ones overriding the others first
+	layers := map[string][]fs.FS{}
+	dirs := map[string]string{}
+
+	entries, err := fs.ReadDir(tr.embedded, themesEmbeddedDir)
+	if err != nil {
+		return fmt.Errorf("error listing embedded themes: %s", err.Error())
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		sub, subErr := fs.Sub(tr.embedded, path.Join(themesEmbeddedDir, entry.Name()))
+		if subErr != nil {
+			return fmt.Errorf("error opening embedded theme %s: %s", entry.Name(), subErr.Error())
+		}
+		layers[entry.Name()] = append(layers[entry.Name()], sub)
+	}
+
+	if tr.externalDir != "" {
+		entries, err = os.ReadDir(tr.externalDir)
+		if err != nil {
+			return fmt.Errorf("error listing themes in %s: %s", tr.externalDir, err.Error())
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			dir := filepath.Join(tr.externalDir, entry.Name())
+			dirs[entry.Name()] = dir
+			layers[entry.Name()] = append([]fs.FS{os.DirFS(dir)}, layers[entry.Name()]...)
+		}
+	}
+
+	if _, ok := layers[DefaultThemeName]; !ok {
+		return fmt.Errorf("the %s theme is missing", DefaultThemeName)
+	}
+
+	manifests := map[string]ThemeManifest{}
+	for name, themeLayers := range layers {
+		manifest, manifestErr := readThemeManifest(overlayFS(themeLayers), name)
+		if manifestErr != nil {
+			return manifestErr
+		}
+		manifests[name] = manifest
+	}
+
+	themes := map[string]*Theme{}
+	var resolve func(name string, seen []string) (*Theme, error)
+	resolve = func(name string, seen []string) (*Theme, error) {
+		if t, ok := themes[name]; ok {
+			return t, nil
+		}
+		for _, s := range seen {
+			if s == name {
+				return nil, fmt.Errorf("themes %s inherit from each other", strings.Join(append(seen, name), " -> "))
+			}
+		}
+		manifest, ok := manifests[name]
+		if !ok {
+			return nil, fmt.Errorf("theme %s does not exist, but %s inherits from it", name, seen[len(seen)-1])
+		}
+		themeFS := append(overlayFS{}, layers[name]...)
+		if manifest.Parent != "" {
+			parent, parentErr := resolve(manifest.Parent, append(seen, name))
+			if parentErr != nil {
+				return nil, parentErr
+			}
+			themeFS = append(themeFS, parent.FS)
+		}
+		templates, tplErr := NewTemplateCache(themeFS, name, tr.funcs)
+		if tplErr != nil {
+			return nil, tplErr
+		}
+		staticFS, staticErr := fs.Sub(themeFS, "static")
+		if staticErr != nil {
+			return nil, fmt.Errorf("error opening the static files of theme %s: %s", name, staticErr.Error())
+		}
+		assets, assetsErr := NewAssetIndex(staticFS)
+		if assetsErr != nil {
+			return nil, fmt.Errorf("theme %s: %s", name, assetsErr.Error())
+		}
+		t := &Theme{Manifest: manifest, Dir: dirs[name], FS: themeFS, Templates: templates, Assets: assets}
+		themes[name] = t
+		return t, nil
+	}
+	for name := range manifests {
+		if _, err = resolve(name, nil); err != nil {
+			return err
+		}
+	}
+
+	tr.mu.Lock()
+	tr.themes = themes
+	tr.mu.Unlock()
+	return nil
+}
+
+// readThemeManifest - reads the manifest of the theme called name from fsys. Themes without a manifest get one with
+// their name only.
+func readThemeManifest(fsys fs.FS, name string) (ThemeManifest, error) {
+	manifest := ThemeManifest{}
+	for _, file := range themeManifestFiles {
+		data, err := fs.ReadFile(fsys, file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("error reading %s of theme %s: %s", file, name, err.Error())
+		}
+		v := viper.New()
+		v.SetConfigType(strings.TrimPrefix(path.Ext(file), "."))
+		if err = v.ReadConfig(bytes.NewReader(data)); err != nil {
+			return manifest, fmt.Errorf("error parsing %s of theme %s: %s", file, name, err.Error())
+		}
+		if err = v.Unmarshal(&manifest); err != nil {
+			return manifest, fmt.Errorf("error parsing %s of theme %s: %s", file, name, err.Error())
+		}
+		break
+	}
+	// the name is always the one of the directory, so that themes are referred to the same way everywhere
+	manifest.Name = name
+	if manifest.Title == "" {
+		manifest.Title = name
+	}
+	if name == DefaultThemeName {
+		manifest.Parent = ""
+	} else if manifest.Parent == "" {
+		manifest.Parent = DefaultThemeName
+	}
+	return manifest, nil
+}
+
+// Get - returns the theme called name
+func (tr *ThemeRegistry) Get(name string) (*Theme, bool) {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	t, ok := tr.themes[name]
+	return t, ok
+}
+
+// LookupFingerprinted - returns the static file of any theme whose current fingerprinted path is p. The fingerprint
+// names the content, so the file is the same whichever theme it is found in.
+func (tr *ThemeRegistry) LookupFingerprinted(p string) (*Asset, bool) {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	for _, t := range tr.themes {
+		if asset, ok := t.Assets.byFingerprint[p]; ok {
+			return asset, true
+		}
+	}
+	return nil, false
+}
+
+// List - returns the manifests of every theme, sorted by name
+func (tr *ThemeRegistry) List() []ThemeManifest {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	manifests := make([]ThemeManifest, 0, len(tr.themes))
+	for _, t := range tr.themes {
+		manifests = append(manifests, t.Manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Name < manifests[j].Name })
+	return manifests
+}
+
+// Watch - reloads every theme whenever a file below one of dirs changes. A reload which fails is logged and the
+// previously loaded themes are kept, so a half-edited template does not take the site down.
+func (tr *ThemeRegistry) Watch(u *utils.Utils, dirs []string, l *log.Logger) ([]*fsnotify.Watcher, error) {
+	var (
+		mu    sync.Mutex
+		timer *time.Timer
+	)
+	reload := func() {
+		if err := tr.Reload(); err != nil {
+			l.Errorf("handlers.ThemeRegistry.Watch: keeping the previous themes: %s", err.Error())
+			return
+		}
+		l.Infof("handlers.ThemeRegistry.Watch: reloaded themes")
+	}
+	onChange := func(event fsnotify.Event) {
+		if event.Op == fsnotify.Chmod {
+			return
+		}
+		l.Debugf("handlers.ThemeRegistry.Watch: %s", event.String())
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(templateReloadDelay, reload)
+	}
+
+	var watchers []*fsnotify.Watcher
+	for _, dir := range dirs {
+		watcher, err := u.WatchTree(dir, onChange)
+		if err != nil {
+			for _, w := range watchers {
+				_ = w.Close()
+			}
+			return nil, fmt.Errorf("error watching %s: %s", dir, err.Error())
+		}
+		watchers = append(watchers, watcher)
+	}
+	return watchers, nil
+}
+
+// themeContextKey - the key of the name of the theme of a request in its context, see LocaleMiddleware
+type themeContextKey struct{}
+
+// requestThemeName - returns the name of the theme r is rendered with, worked out once per request by
+// LocaleMiddleware, see userThemeName
+func (wh *WebHandlers) requestThemeName(r *http.Request) string {
+	if name, ok := r.Context().Value(themeContextKey{}).(string); ok {
+		return name
+	}
+	return wh.userThemeName(r)
+}
+
+// userThemeName - returns the name of the theme chosen by the user logged in with r, else the one of the site, see
+// siteThemeName
+func (wh *WebHandlers) userThemeName(r *http.Request) string {
+	if prefs, _ := wh.userPreferences(r); prefs.Theme != "" {
+		if _, ok := wh.themes.Get(prefs.Theme); ok {
+			return prefs.Theme
+		}
+	}
+	return wh.siteThemeName(r)
+}
+
+// siteThemeName - returns the name of the theme configured for the host name of r in theme_hosts, else the one set in
+// theme. It does not need the session of r.
+func (wh *WebHandlers) siteThemeName(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if name, ok := viper.GetStringMapString("theme_hosts")[strings.ToLower(host)]; ok {
+		if _, ok = wh.themes.Get(name); ok {
+			return name
+		}
+		wh.Log.Errorf("handlers.WebHandlers.siteThemeName: theme %s configured for host %s does not exist", name, host)
+	}
+
+	if name := viper.GetString("theme"); name != "" {
+		if _, ok := wh.themes.Get(name); ok {
+			return name
+		}
+	}
+	return wh.themeName
+}
+
+// theme - returns the theme r is rendered with, see requestThemeName
+func (wh *WebHandlers) theme(r *http.Request) *Theme {
+	return wh.themeNamed(wh.requestThemeName(r))
+}
+
+// themeNamed - returns the theme called name, the default theme if there is none
+func (wh *WebHandlers) themeNamed(name string) *Theme {
+	t, ok := wh.themes.Get(name)
+	if !ok {
+		t, _ = wh.themes.Get(DefaultThemeName)
+	}
+	return t
+}
+
+// formTheme - returns the theme chosen in the theme field of the form posted in r, or an empty string for unknown
+// themes and for the site theme
+func (wh *WebHandlers) formTheme(r *http.Request) string {
+	name := r.FormValue("theme")
+	if _, ok := wh.themes.Get(name); !ok {
+		return ""
+	}
+	return name
+}
+
+type ThemesHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Themes          []ThemeManifest
+	SiteTheme       string
+	CurrentTheme    string
+	ThemeHosts      map[string]string
+}
+
+// ThemesHandler - lists the available themes along with the metadata of their manifests
+func (wh *WebHandlers) ThemesHandler(w http.ResponseWriter, r *http.Request) {
+	templateData := ThemesHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Errors:          []string{},
+		Themes:          wh.themes.List(),
+		SiteTheme:       viper.GetString("theme"),
+		CurrentTheme:    wh.requestThemeName(r),
+		ThemeHosts:      viper.GetStringMapString("theme_hosts"),
+	}
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "themes", templateData)
+	if tmplErr != nil {
+		wh.Log.Errorf("handlers.WebHandlers.ThemesHandler: error executing template: themes: %s", tmplErr.Error())
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+// overlayFS - a read-only filesystem made of layers, the first one having a file providing it
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	for _, layer := range o {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir - lists the entries of the directory name found in any layer, so that fs.Glob sees every file
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	seen := map[string]bool{}
+	var (
+		entries []fs.DirEntry
+		found   bool
+	)
+	for _, layer := range o {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, entry := range layerEntries {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
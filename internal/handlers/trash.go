@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+)
+
+type TrashSection struct {
+	Entity string
+	Items  []db.TrashItem
+}
+
+type TrashHandlerTemplateData struct {
+	IsLoggedIn      bool
+	IsLoggedInAdmin bool
+	Errors          []string
+	Sections        []TrashSection
+}
+
+// -----------------------------------------------------------------
+// Trash Handlers
+
+// TrashHandler - lists the soft-deleted restaurants, users, reviews and metrics
+func (wh *WebHandlers) TrashHandler(w http.ResponseWriter, r *http.Request) {
+	templateData := TrashHandlerTemplateData{
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
+		Errors:          []string{},
+	}
+	for _, entity := range db.SoftDeleteEntities {
+		items, err := db.ListTrash(wh.db, entity)
+		if err != nil {
+			wh.Log.Errorf("handlers.WebHandlers.TrashHandler: error listing deleted %s: %s", entity.Table, err.Error())
+			templateData.Errors = append(templateData.Errors, wh.T(r, "error.trash_list", wh.T(r, "table."+entity.Table)))
+			continue
+		}
+		templateData.Sections = append(templateData.Sections, TrashSection{Entity: entity.Table, Items: items})
+	}
+
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "trash", templateData)
+	if tmplErr != nil {
+		wh.Log.Errorf("handlers.WebHandlers.TrashHandler: error executing template: trash: %s", tmplErr.Error())
+		wh.Error(w, r, internalError(tmplErr))
+		return
+	}
+	wh.WriteHTML(w, tmpl, http.StatusOK)
+}
+
+// TrashRestoreHandler - restores a soft-deleted record identified by the entity and id form values
+func (wh *WebHandlers) TrashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wh.Error(w, r, methodNotAllowed())
+		return
+	}
+	entity, ok := db.GetSoftDeleteEntity(r.FormValue("entity"))
+	if !ok {
+		wh.Error(w, r, badRequest(nil, "error.not_restorable", r.FormValue("entity")))
+		return
+	}
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	before := wh.auditSnapshot(entity.Table, id)
+	err := db.Restore(wh.db, entity, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		wh.redirectWithError(w, r, "/trash", nil, "error.not_in_trash", wh.T(r, "entity."+entity.Table), id)
+		return
+	}
+	if err != nil {
+		wh.redirectWithError(w, r, "/trash", fmt.Errorf("error restoring %s %d: %s", entity.Table, id, err.Error()), "flash.restore_failed", wh.T(r, "entity."+entity.Table))
+		return
+	}
+	wh.audit(r, db.AuditActionRestore, entity.Table, id, before, wh.auditSnapshot(entity.Table, id))
+	// Restored reviews, and those of restored users, count towards the ranking of their restaurant again
+	switch entity.Table {
+	case "reviews":
+		wh.rankReviewRestaurant(id)
+	case "restaurants":
+		wh.rankRestaurant(id)
+	case "users":
+		wh.rankUserRestaurants(id)
+	}
+	wh.redirectWithFlash(w, r, "/trash", FlashSuccess, "flash.restored", wh.T(r, "entity."+entity.Table))
+}
@@ -9,7 +9,6 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"unicode"
 )
 
 type UserTypes struct {
@@ -19,24 +18,7 @@ type UserTypes struct {
 }
 
 func (wh *WebHandlers) UserTypeNameToString(userTypeValue string) string {
-	// 1. Strip leading and trailing underscores
-	trimmedValue := strings.Trim(userTypeValue, "_")
-
-	// 2. Split the string into words by underscore
-	words := strings.Split(trimmedValue, "_")
-
-	var capitalizedWords []string
-	for _, word := range words {
-		if len(word) > 0 { // Handle empty words if any (though unlikely after trim and split)
-			// Capitalize the first letter and keep the rest lowercase
-			runes := []rune(word)                // Convert string to rune slice to handle Unicode correctly
-			runes[0] = unicode.ToUpper(runes[0]) // Capitalize the first rune
-			capitalizedWords = append(capitalizedWords, string(runes))
-		}
-	}
-
-	// 3. Join the capitalized words with spaces (or you can adjust as needed)
-	return strings.Join(capitalizedWords, " ")
+	return userTypeLabel(userTypeValue)
 }
 
 type UserTypesHandlerTemplateData struct {
@@ -51,21 +33,22 @@ func (wh *WebHandlers) UserTypesHandler(w http.ResponseWriter, r *http.Request)
 	userTypes, userTypesErr := wh.GetUserTypes()
 	if userTypesErr != nil {
 		wh.Log.Errorf("Error reconnecting to database: %s", userTypesErr.Error())
-		http.Error(w, userTypesErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(userTypesErr))
 		return
 	}
 
 	templateData := UserTypesHandlerTemplateData{
-		IsLoggedIn:      wh.isLoggedIn,
-		IsLoggedInAdmin: wh.isAdmin,
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
 		Errors:          nil,
 		UserTypes:       userTypes,
 	}
 
-	tmpl, tmplErr := wh.ExecuteTemplate("user_types", templateData)
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "user_types", templateData)
 	if tmplErr != nil {
 		slog.Error(fmt.Sprintf("Error executing template: users.html: %s", tmplErr.Error()))
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
@@ -119,15 +102,16 @@ func (wh *WebHandlers) UserTypesNewHandler(w http.ResponseWriter, r *http.Reques
 	}
 	if r.Method == http.MethodGet {
 		templateData := UserTypesNewHandlerTemplateData{
-			IsLoggedIn:      wh.isLoggedIn,
-			IsLoggedInAdmin: wh.isAdmin,
+			IsLoggedIn:      isLoggedIn(r),
+			IsLoggedInAdmin: isAdmin(r),
 			Errors:          nil,
 			UserTypes:       userTypes,
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("user_types_form", templateData)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "user_types_form", templateData)
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("wh.UserTypesNewHandler: Error executing template: users: %s", tmplErr.Error()))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
@@ -136,7 +120,7 @@ func (wh *WebHandlers) UserTypesNewHandler(w http.ResponseWriter, r *http.Reques
 	userTypeName := r.FormValue("usertypename")
 	stmt, err := wh.db.Prepare("INSERT INTO user_types (user_type_name) VALUES (?)")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 
@@ -147,12 +131,13 @@ func (wh *WebHandlers) UserTypesNewHandler(w http.ResponseWriter, r *http.Reques
 		}
 	}(stmt)
 
-	_, err = stmt.Exec(userTypeName)
+	res, err := stmt.Exec(userTypeName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/user_types", http.StatusSeeOther)
+	wh.auditCreate(r, "user_types", res)
+	wh.redirectWithFlash(w, r, "/user_types", FlashSuccess, "flash.created", wh.T(r, "entity.user_types"))
 }
 
 type UserTypesEditHandlerTemplateData UserTypesNewHandlerTemplateData
@@ -166,29 +151,31 @@ func (wh *WebHandlers) UserTypesEditHandler(w http.ResponseWriter, r *http.Reque
 		err := wh.db.QueryRow("SELECT user_type_id, user_type_name FROM user_types WHERE user_type_id = ?", id).
 			Scan(&u.UserTypeID, &u.UserTypeName)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
 
 		templateData := UserTypesNewHandlerTemplateData{
-			IsLoggedIn:      wh.isLoggedIn,
-			IsLoggedInAdmin: wh.isAdmin,
+			IsLoggedIn:      isLoggedIn(r),
+			IsLoggedInAdmin: isAdmin(r),
 			Errors:          nil,
 			UserTypes:       u,
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("user_types_form", templateData)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "user_types_form", templateData)
 		if tmplErr != nil {
 			wh.Log.Errorf(fmt.Sprintf("Error executing template: user_form.html: %s", tmplErr.Error()))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
 	// POST update
 	email := r.FormValue("usertypename")
+	before := wh.auditSnapshot("user_types", id)
 	stmt, err := wh.db.Prepare("UPDATE user_types SET user_type_name=? WHERE user_type_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer func(stmt *sql.Stmt) {
@@ -199,28 +186,30 @@ func (wh *WebHandlers) UserTypesEditHandler(w http.ResponseWriter, r *http.Reque
 	}(stmt)
 	_, err = stmt.Exec(email, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/user_types", http.StatusSeeOther)
+	wh.auditUpdate(r, "user_types", id, before)
+	wh.redirectWithFlash(w, r, "/user_types", FlashSuccess, "flash.updated", wh.T(r, "entity.user_types"))
 }
 
 func (wh *WebHandlers) UserTypesDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	deleteRestrictedUserTypes := viper.GetString("delete_restricted_user_types")
 	idStr := r.FormValue("id")
 
 	if strings.Contains(deleteRestrictedUserTypes, idStr) {
-		http.Error(w, fmt.Sprintf("User Type '%s' is configured as restricted from deletetion", idStr), http.StatusConflict)
+		wh.redirectWithError(w, r, "/user_types", nil, "error.user_type_restricted", idStr)
 		return
 	}
 	id, _ := strconv.ParseInt(idStr, 10, 64)
+	before := wh.auditSnapshot("user_types", id)
 	stmt, err := wh.db.Prepare("DELETE FROM user_types WHERE user_type_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/user_types", err, "flash.delete_failed", wh.T(r, "entity.user_types"))
 		return
 	}
 
@@ -233,8 +222,9 @@ func (wh *WebHandlers) UserTypesDeleteHandler(w http.ResponseWriter, r *http.Req
 
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/user_types", err, "flash.delete_failed", wh.T(r, "entity.user_types"))
 		return
 	}
-	http.Redirect(w, r, "/user_types", http.StatusSeeOther)
+	wh.auditDelete(r, "user_types", id, before)
+	wh.redirectWithFlash(w, r, "/user_types", FlashSuccess, "flash.deleted", wh.T(r, "entity.user_types"))
 }
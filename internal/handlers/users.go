@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/scalland/bitebuddy/pkg/i18n"
 )
 
 type User struct {
@@ -19,6 +21,10 @@ type User struct {
 	CreatedAt        time.Time
 	LastLogin        time.Time
 	LastAccessedFrom string
+	// Theme is the theme the user has chosen. Empty to use the one picked for the site.
+	Theme string
+	// Locale is the locale the user has chosen. Empty to negotiate it with their browser.
+	Locale string
 }
 
 type UsersHandlerTemplateData struct {
@@ -35,12 +41,12 @@ func (wh *WebHandlers) UsersHandler(w http.ResponseWriter, r *http.Request) {
 	reconnectErr := wh.ReconnectDB()
 	if reconnectErr != nil {
 		wh.Log.Errorf("handlers.WebHandlers.UsersHandler: error reconnecting to database: %s", reconnectErr.Error())
-		http.Error(w, reconnectErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(reconnectErr))
 		return
 	}
-	rows, err := wh.db.Query("SELECT user_id, email, mobile_number, u.user_type_id AS userTypeID, ut.user_type_name, is_active, created_at, last_login, last_accessed_from FROM users AS u LEFT JOIN user_types AS ut ON u.user_type_id=ut.user_type_id")
+	rows, err := wh.db.Query("SELECT user_id, email, mobile_number, u.user_type_id AS userTypeID, ut.user_type_name, is_active, created_at, last_login, last_accessed_from FROM users AS u LEFT JOIN user_types AS ut ON u.user_type_id=ut.user_type_id WHERE u.deleted_at IS NULL")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer func(rows *sql.Rows) {
@@ -55,24 +61,24 @@ func (wh *WebHandlers) UsersHandler(w http.ResponseWriter, r *http.Request) {
 		var u User
 		err := rows.Scan(&u.ID, &u.Email, &u.MobileNumber, &u.UserTypeID, &u.UserTypeName, &u.IsActive, &u.CreatedAt, &u.LastLogin, &u.LastAccessedFrom)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
-		u.UserTypeName = wh.UserTypeNameToString(u.UserTypeName)
 		users = append(users, u)
 	}
 
 	templateData := UsersHandlerTemplateData{
-		IsLoggedIn:      wh.isLoggedIn,
-		IsLoggedInAdmin: wh.isAdmin,
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
 		Errors:          []string{},
 		Users:           users,
 	}
 
-	tmpl, tmplErr := wh.ExecuteTemplate("users", templateData)
+	tmpl, tmplErr := wh.ExecuteTemplate(r, "users", templateData)
 	if tmplErr != nil {
 		slog.Error(fmt.Sprintf("Error executing template: users.html: %s", tmplErr.Error()))
-		http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(tmplErr))
+		return
 	}
 	wh.WriteHTML(w, tmpl, http.StatusOK)
 }
@@ -83,12 +89,14 @@ type UserEditData struct {
 	Errors          []string
 	U               User
 	UserTypesData   []UserTypes
+	Themes          []ThemeManifest
+	Locales         []i18n.Locale
 }
 
 func (wh *WebHandlers) UserNewHandler(w http.ResponseWriter, r *http.Request) {
 	templateData := UserEditData{
-		IsLoggedIn:      wh.isLoggedIn,
-		IsLoggedInAdmin: wh.isAdmin,
+		IsLoggedIn:      isLoggedIn(r),
+		IsLoggedInAdmin: isAdmin(r),
 		Errors:          []string{},
 		U: User{
 			ID:               0,
@@ -102,6 +110,8 @@ func (wh *WebHandlers) UserNewHandler(w http.ResponseWriter, r *http.Request) {
 			LastAccessedFrom: "",
 		},
 		UserTypesData: nil,
+		Themes:        wh.themes.List(),
+		Locales:       wh.locales.Locales(),
 	}
 	var err error
 	templateData.UserTypesData, err = wh.GetUserTypes()
@@ -111,10 +121,10 @@ func (wh *WebHandlers) UserNewHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodGet {
-		tmpl, tmplErr := wh.ExecuteTemplate("user_form", templateData)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "user_form", templateData)
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("handlers.WebHandlers.UserNewHandler: error executing template: users: %s", tmplErr.Error()))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
 			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
@@ -126,17 +136,19 @@ func (wh *WebHandlers) UserNewHandler(w http.ResponseWriter, r *http.Request) {
 	userTypeStr := r.FormValue("user_type")
 	templateData.U.UserTypeID = wh.u.Atoi(userTypeStr)
 	templateData.U.IsActive = r.FormValue("is_active") == "on"
+	templateData.U.Theme = wh.formTheme(r)
+	templateData.U.Locale = wh.formLocale(r)
 	lastAccessedFrom := "0.0.0.0"
 	now := time.Now()
-	stmt, err := wh.db.Prepare("INSERT INTO users(email, mobile_number, user_type_id, is_active, created_at, last_login, last_accessed_from) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := wh.db.Prepare("INSERT INTO users(email, mobile_number, user_type_id, is_active, created_at, last_login, last_accessed_from, theme, locale) VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''))")
 	if err != nil {
 		tErr := fmt.Sprintf("error creating user: %s", err.Error())
 		templateData.Errors = append(templateData.Errors, tErr)
 		wh.Log.Errorf("handlers.WebHandlers.UserNewHandler: %s", tErr)
-		tmpl, tmplErr := wh.ExecuteTemplate("user_form", templateData)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "user_form", templateData)
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("handlers.WebHandlers.UserNewHandler: error executing template: users: %s", tmplErr.Error()))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
 			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
@@ -148,21 +160,22 @@ func (wh *WebHandlers) UserNewHandler(w http.ResponseWriter, r *http.Request) {
 			wh.Log.Errorf("handlers.WebHandlers.UserNewHandler: error closing rows: %s", err.Error())
 		}
 	}(stmt)
-	_, err = stmt.Exec(templateData.U.Email, templateData.U.MobileNumber, templateData.U.UserTypeID, templateData.U.IsActive, now, now, lastAccessedFrom)
+	res, err := stmt.Exec(templateData.U.Email, templateData.U.MobileNumber, templateData.U.UserTypeID, templateData.U.IsActive, now, now, lastAccessedFrom, templateData.U.Theme, templateData.U.Locale)
 	if err != nil {
 		tErr := fmt.Sprintf("error creating user: %s", err.Error())
 		templateData.Errors = append(templateData.Errors, tErr)
 		wh.Log.Errorf("handlers.WebHandlers.UserNewHandler: %s", tErr)
-		tmpl, tmplErr := wh.ExecuteTemplate("user_form", templateData)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "user_form", templateData)
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("handlers.WebHandlers.UserNewHandler: error executing template: users: %s", tmplErr.Error()))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
 			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
 	}
-	http.Redirect(w, r, "/users", http.StatusSeeOther)
+	wh.auditCreate(r, "users", res)
+	wh.redirectWithFlash(w, r, "/users", FlashSuccess, "flash.created", wh.T(r, "entity.users"))
 }
 
 func (wh *WebHandlers) UserEditHandler(w http.ResponseWriter, r *http.Request) {
@@ -174,22 +187,28 @@ func (wh *WebHandlers) UserEditHandler(w http.ResponseWriter, r *http.Request) {
 			utdErr error
 			u      = ued.U
 		)
-		err := wh.db.QueryRow("SELECT user_id, email, mobile_number, u.user_type_id, ut.user_type_name, is_active, created_at, last_login, last_accessed_from FROM users AS u LEFT JOIN user_types AS ut ON u.user_type_id=ut.user_type_id WHERE user_id = ?", id).
-			Scan(&u.ID, &u.Email, &u.MobileNumber, &u.UserTypeID, &u.UserTypeName, &u.IsActive, &u.CreatedAt, &u.LastLogin, &u.LastAccessedFrom)
+		err := wh.db.QueryRow("SELECT user_id, email, mobile_number, u.user_type_id, ut.user_type_name, is_active, created_at, last_login, last_accessed_from, COALESCE(theme, ''), COALESCE(locale, '') FROM users AS u LEFT JOIN user_types AS ut ON u.user_type_id=ut.user_type_id WHERE user_id = ?", id).
+			Scan(&u.ID, &u.Email, &u.MobileNumber, &u.UserTypeID, &u.UserTypeName, &u.IsActive, &u.CreatedAt, &u.LastLogin, &u.LastAccessedFrom, &u.Theme, &u.Locale)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(err))
 			return
 		}
+		ued.IsLoggedIn = isLoggedIn(r)
+		ued.IsLoggedInAdmin = isAdmin(r)
 		ued.U = u
+		ued.Themes = wh.themes.List()
+		ued.Locales = wh.locales.Locales()
 		u.UserTypeName = wh.UserTypeNameToString(u.UserTypeName)
 		ued.UserTypesData, utdErr = wh.GetUserTypes()
 		if utdErr != nil {
-			http.Error(w, utdErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(utdErr))
+			return
 		}
-		tmpl, tmplErr := wh.ExecuteTemplate("user_form", ued)
+		tmpl, tmplErr := wh.ExecuteTemplate(r, "user_form", ued)
 		if tmplErr != nil {
 			slog.Error(fmt.Sprintf("Error executing template: user_form.html: %s", tmplErr.Error()))
-			http.Error(w, tmplErr.Error(), http.StatusInternalServerError)
+			wh.Error(w, r, internalError(tmplErr))
+			return
 		}
 		wh.WriteHTML(w, tmpl, http.StatusOK)
 		return
@@ -200,37 +219,46 @@ func (wh *WebHandlers) UserEditHandler(w http.ResponseWriter, r *http.Request) {
 	userTypeStr := r.FormValue("user_type")
 	userType, _ := strconv.Atoi(userTypeStr)
 	isActive := r.FormValue("is_active") == "on"
-	stmt, err := wh.db.Prepare("UPDATE users SET email=?, mobile_number=?, user_type_id=?, is_active=? WHERE user_id=?")
+	theme := wh.formTheme(r)
+	locale := wh.formLocale(r)
+	before := wh.auditSnapshot("users", id)
+	stmt, err := wh.db.Prepare("UPDATE users SET email=?, mobile_number=?, user_type_id=?, is_active=?, theme=NULLIF(?, ''), locale=NULLIF(?, '') WHERE user_id=?")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(email, mobile, userType, isActive, id)
+	_, err = stmt.Exec(email, mobile, userType, isActive, theme, locale, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.Error(w, r, internalError(err))
 		return
 	}
-	http.Redirect(w, r, "/users", http.StatusSeeOther)
+	wh.auditUpdate(r, "users", id, before)
+	wh.updateSessionPreferences(w, r, id, UserPreferences{Theme: theme, Locale: locale})
+	wh.redirectWithFlash(w, r, "/users", FlashSuccess, "flash.updated", wh.T(r, "entity.users"))
 }
 
 func (wh *WebHandlers) UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		wh.Error(w, r, methodNotAllowed())
 		return
 	}
 	idStr := r.FormValue("id")
 	id, _ := strconv.ParseInt(idStr, 10, 64)
-	stmt, err := wh.db.Prepare("DELETE FROM users WHERE user_id=?")
+	before := wh.auditSnapshot("users", id)
+	// Users are only marked as deleted so that their reviews survive and they can be restored from the trash
+	stmt, err := wh.db.Prepare("UPDATE users SET deleted_at=CURRENT_TIMESTAMP WHERE user_id=? AND deleted_at IS NULL")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/users", err, "flash.delete_failed", wh.T(r, "entity.users"))
 		return
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		wh.redirectWithError(w, r, "/users", err, "flash.delete_failed", wh.T(r, "entity.users"))
 		return
 	}
-	http.Redirect(w, r, "/users", http.StatusSeeOther)
+	wh.auditDelete(r, "users", id, before)
+	wh.rankUserRestaurants(id)
+	wh.redirectWithFlash(w, r, "/users", FlashSuccess, "flash.deleted", wh.T(r, "entity.users"))
 }
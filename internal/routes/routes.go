@@ -1,10 +1,8 @@
 package routes
 
 import (
-	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/scalland/bitebuddy/internal/handlers"
-	"io/fs"
 	"net/http"
 )
 
@@ -12,18 +10,15 @@ func SetupRoutes(wh *handlers.WebHandlers) *mux.Router {
 
 	router := mux.NewRouter()
 	router.Use(wh.LoggerMiddleware)
+	router.Use(wh.LocaleMiddleware)
+	router.Use(wh.LoginMiddleware)
+	router.Use(wh.FlashMiddleware)
 
-	staticFilesDirPath := fmt.Sprintf("templates/%s/static", wh.GetThemeName())
-	wh.Log.Infof("Serving static files from %s", staticFilesDirPath)
-	staticFilesDirFS, staticFilesDirFSErr := fs.Sub(wh.GetTemplateFS(), staticFilesDirPath)
-	if staticFilesDirFSErr != nil {
-		wh.Log.Errorf("Error getting a sub-directory as a filesystem from webDir: %s", staticFilesDirFSErr.Error())
-	}
+	// Serve static assets from the theme of each request, falling back to the files of its parent themes
+	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", wh.ServeThemeStatic()))
 
-	wh.Log.Infof("Serving static files from %s", staticFilesDirFS)
-	// Serve static assets from your embedded FS.
-	//router.PathPrefix("/static/*").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(staticFilesDirFS))))
-	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", wh.ServeStaticFilesWithContentType(staticFilesDirFS)))
+	// Uploaded images, served from the media storage
+	router.HandleFunc("/media/{key:.+}", wh.MediaHandler).Methods("GET", "HEAD")
 
 	// Public routes.
 	router.HandleFunc("/login", wh.LoginHandler).Methods("GET", "POST")
@@ -54,6 +49,9 @@ func SetupRoutes(wh *handlers.WebHandlers) *mux.Router {
 	router.Handle("/restaurants/new", wh.RequireAdmin(http.HandlerFunc(wh.RestaurantNewHandler))).Methods("GET", "POST")
 	router.Handle("/restaurants/edit", wh.RequireAdmin(http.HandlerFunc(wh.RestaurantEditHandler))).Methods("GET", "POST")
 	router.Handle("/restaurants/delete", wh.RequireAdmin(http.HandlerFunc(wh.RestaurantDeleteHandler))).Methods("POST")
+	router.Handle("/restaurants/import", wh.RequireAdmin(http.HandlerFunc(wh.RestaurantImportHandler))).Methods("GET", "POST")
+	router.Handle("/restaurants/export", wh.RequireAuth(http.HandlerFunc(wh.RestaurantExportHandler))).Methods("GET")
+	router.Handle("/restaurants/view", wh.RequireAuth(http.HandlerFunc(wh.RestaurantViewHandler))).Methods("GET")
 
 	// Metrics CRUD
 	router.Handle("/metrics", wh.RequireAuth(http.HandlerFunc(wh.MetricsHandler))).Methods("GET")
@@ -61,6 +59,8 @@ func SetupRoutes(wh *handlers.WebHandlers) *mux.Router {
 	router.Handle("/metrics/new", wh.RequireAdmin(http.HandlerFunc(wh.MetricNewHandler))).Methods("GET", "POST")
 	router.Handle("/metrics/edit", wh.RequireAdmin(http.HandlerFunc(wh.MetricEditHandler))).Methods("GET", "POST")
 	router.Handle("/metrics/delete", wh.RequireAdmin(http.HandlerFunc(wh.MetricDeleteHandler))).Methods("POST")
+	router.Handle("/metrics/move", wh.RequireAdmin(http.HandlerFunc(wh.MetricMoveHandler))).Methods("POST")
+	router.Handle("/metrics/bulk", wh.RequireAdmin(http.HandlerFunc(wh.MetricBulkHandler))).Methods("POST")
 
 	// Reviews CRUD
 	router.Handle("/reviews", wh.RequireAuth(http.HandlerFunc(wh.ReviewsHandler))).Methods("GET")
@@ -68,6 +68,15 @@ func SetupRoutes(wh *handlers.WebHandlers) *mux.Router {
 	router.Handle("/reviews/new", wh.RequireAdmin(http.HandlerFunc(wh.ReviewNewHandler))).Methods("GET", "POST")
 	router.Handle("/reviews/edit", wh.RequireAdmin(http.HandlerFunc(wh.ReviewEditHandler))).Methods("GET", "POST")
 	router.Handle("/reviews/delete", wh.RequireAdmin(http.HandlerFunc(wh.ReviewDeleteHandler))).Methods("POST")
+	router.Handle("/reviews/history", wh.RequireAdmin(http.HandlerFunc(wh.ReviewHistoryHandler))).Methods("GET")
+	// Votes and reports of customers on the reviews of others
+	router.Handle("/reviews/vote", wh.RequireAuth(http.HandlerFunc(wh.ReviewVoteHandler))).Methods("POST")
+	router.Handle("/reviews/report", wh.RequireAuth(http.HandlerFunc(wh.ReviewReportHandler))).Methods("POST")
+
+	// Moderation queue of held reviews
+	router.Handle("/moderation", wh.RequireAdmin(http.HandlerFunc(wh.ModerationHandler))).Methods("GET")
+	router.Handle("/moderation/decide", wh.RequireAdmin(http.HandlerFunc(wh.ReviewModerateHandler))).Methods("POST")
+	router.Handle("/moderation/photo", wh.RequireAdmin(http.HandlerFunc(wh.ReviewPhotoModerateHandler))).Methods("POST")
 
 	// Metric Reviews CRUD
 	router.Handle("/metric_reviews", wh.RequireAuth(http.HandlerFunc(wh.MetricReviewsHandler))).Methods("GET")
@@ -76,6 +85,20 @@ func SetupRoutes(wh *handlers.WebHandlers) *mux.Router {
 	router.Handle("/metric_reviews/edit", wh.RequireAdmin(http.HandlerFunc(wh.MetricReviewEditHandler))).Methods("GET", "POST")
 	router.Handle("/metric_reviews/delete", wh.RequireAdmin(http.HandlerFunc(wh.MetricReviewDeleteHandler))).Methods("POST")
 
+	// Display Types CRUD
+	router.Handle("/display_types", wh.RequireAuth(http.HandlerFunc(wh.DisplayTypesHandler))).Methods("GET")
+	// Admin-only routes
+	router.Handle("/display_types/new", wh.RequireAdmin(http.HandlerFunc(wh.DisplayTypeNewHandler))).Methods("GET", "POST")
+	router.Handle("/display_types/edit", wh.RequireAdmin(http.HandlerFunc(wh.DisplayTypeEditHandler))).Methods("GET", "POST")
+	router.Handle("/display_types/delete", wh.RequireAdmin(http.HandlerFunc(wh.DisplayTypeDeleteHandler))).Methods("POST")
+
+	// Metric Types CRUD
+	router.Handle("/metric_types", wh.RequireAuth(http.HandlerFunc(wh.MetricTypesHandler))).Methods("GET")
+	// Admin-only routes
+	router.Handle("/metric_types/new", wh.RequireAdmin(http.HandlerFunc(wh.MetricTypeNewHandler))).Methods("GET", "POST")
+	router.Handle("/metric_types/edit", wh.RequireAdmin(http.HandlerFunc(wh.MetricTypeEditHandler))).Methods("GET", "POST")
+	router.Handle("/metric_types/delete", wh.RequireAdmin(http.HandlerFunc(wh.MetricTypeDeleteHandler))).Methods("POST")
+
 	// Metric Reviews CRUD
 	router.Handle("/filter_types", wh.RequireAuth(http.HandlerFunc(wh.FilterTypesHandler))).Methods("GET")
 	// Admin-only routes
@@ -88,7 +111,7 @@ func SetupRoutes(wh *handlers.WebHandlers) *mux.Router {
 	// Admin-only routes
 	router.Handle("/filters/new", wh.RequireAdmin(http.HandlerFunc(wh.FilterNewHandler))).Methods("GET", "POST")
 	router.Handle("/filters/edit", wh.RequireAdmin(http.HandlerFunc(wh.FilterEditHandler))).Methods("GET", "POST")
-	router.Handle("/filters/delete", wh.RequireAdmin(http.HandlerFunc(wh.FiltersHandler))).Methods("POST")
+	router.Handle("/filters/delete", wh.RequireAdmin(http.HandlerFunc(wh.FilterDeleteHandler))).Methods("POST")
 
 	// Filters CRUD
 	router.Handle("/otp_requests", wh.RequireAuth(http.HandlerFunc(wh.OtpRequestsHandler))).Methods("GET")
@@ -97,12 +120,25 @@ func SetupRoutes(wh *handlers.WebHandlers) *mux.Router {
 	router.Handle("/otp_requests/edit", wh.RequireAdmin(http.HandlerFunc(wh.OtpRequestEditHandler))).Methods("GET", "POST")
 	router.Handle("/otp_requests/delete", wh.RequireAdmin(http.HandlerFunc(wh.OtpRequestDeleteHandler))).Methods("POST")
 
+	// Searching the tables foreign keys point to, for the lookup selects of forms
+	router.Handle("/lookup/{source}", wh.RequireAdmin(http.HandlerFunc(wh.LookupHandler))).Methods("GET")
+
+	// Audit log
+	router.Handle("/audit_log", wh.RequireAdmin(http.HandlerFunc(wh.AuditLogHandler))).Methods("GET")
+
+	// Trash of soft-deleted records
+	router.Handle("/trash", wh.RequireAdmin(http.HandlerFunc(wh.TrashHandler))).Methods("GET")
+	router.Handle("/trash/restore", wh.RequireAdmin(http.HandlerFunc(wh.TrashRestoreHandler))).Methods("POST")
+
+	// Installed themes
+	router.Handle("/themes", wh.RequireAdmin(http.HandlerFunc(wh.ThemesHandler))).Methods("GET")
+
 	// Logout handler
 	router.HandleFunc("/logout", wh.LogoutHandler).Methods("GET")
 
-	// ALWAYS KEEP THIS HANDLER AS LAST otherwise it would override others see. https://stackoverflow.com/a/56937571/6670698
-	// Re-define the default NotFound handler
-	router.NotFoundHandler = router.NewRoute().HandlerFunc(http.NotFound).GetHandler()
+	// Themed error pages for paths without a route and for methods a route does not accept
+	router.NotFoundHandler = http.HandlerFunc(wh.NotFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(wh.MethodNotAllowedHandler)
 
 	return router
 }
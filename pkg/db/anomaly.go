@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ReviewSignals - what is known about a review and its surroundings for telling whether it is genuine
+type ReviewSignals struct {
+	ReviewID     int64
+	RestaurantID int64
+	UserID       int64
+	OverallScore float64
+	ReviewText   string
+	Status       string
+	// Cleared is set for reviews a moderator approved
+	Cleared   bool
+	CreatedAt time.Time
+	// AccountAge is the time from the creation of the account of the user to the review
+	AccountAge time.Duration
+	// SharedIPReviewers counts the other users who reviewed the restaurant and were last seen at the IP address the
+	// user was last seen at
+	SharedIPReviewers int
+	// NearbyReviews counts the reviews of the restaurant written within the window before or after the review,
+	// including the review itself
+	NearbyReviews int
+	// RestaurantMean is the mean overall score of the other approved, active reviews of the restaurant, which
+	// RestaurantReviews counts
+	RestaurantMean    sql.NullFloat64
+	RestaurantReviews int
+}
+
+// ReviewText - the text of a review, for comparing texts
+type ReviewText struct {
+	ReviewID int64
+	Text     string
+}
+
+// LoadReviewSignals - gathers the signals of the review reviewID, counting the reviews written within window of it
+func LoadReviewSignals(conn Queryer, reviewID int64, window time.Duration) (ReviewSignals, error) {
+	s := ReviewSignals{ReviewID: reviewID}
+	var (
+		ip            string
+		userCreatedAt time.Time
+		windowSeconds = int64(window / time.Second)
+	)
+	err := conn.QueryRow(`SELECT rv.restaurant_id, rv.user_id, rv.overall_score, COALESCE(rv.review_text, ''), rv.status, rv.status=? AND rv.moderated_at IS NOT NULL, rv.created_at, u.created_at, COALESCE(u.last_accessed_from, '')
+		FROM reviews AS rv JOIN users AS u ON rv.user_id=u.user_id WHERE rv.review_id=?`, ReviewStatusApproved, reviewID).
+		Scan(&s.RestaurantID, &s.UserID, &s.OverallScore, &s.ReviewText, &s.Status, &s.Cleared, &s.CreatedAt, &userCreatedAt, &ip)
+	if err != nil {
+		return s, err
+	}
+	s.AccountAge = s.CreatedAt.Sub(userCreatedAt)
+
+	// Users who were never seen from a real address all share the placeholder ones
+	if ip != "" && ip != "0.0.0.0" && ip != "::" {
+		err = conn.QueryRow(`SELECT COUNT(DISTINCT rv.user_id) FROM reviews AS rv JOIN users AS u ON rv.user_id=u.user_id
+			WHERE rv.restaurant_id=? AND rv.user_id<>? AND rv.deleted_at IS NULL AND u.last_accessed_from=?`,
+			s.RestaurantID, s.UserID, ip).Scan(&s.SharedIPReviewers)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	err = conn.QueryRow(`SELECT COUNT(*) FROM reviews WHERE restaurant_id=? AND deleted_at IS NULL
+		AND created_at BETWEEN ? - INTERVAL ? SECOND AND ? + INTERVAL ? SECOND`,
+		s.RestaurantID, s.CreatedAt, windowSeconds, s.CreatedAt, windowSeconds).Scan(&s.NearbyReviews)
+	if err != nil {
+		return s, err
+	}
+
+	err = conn.QueryRow("SELECT AVG(rv.overall_score), COUNT(*) FROM reviews AS rv WHERE rv.restaurant_id=? AND rv.review_id<>? AND rv.status=? AND "+ActiveReviewSQL,
+		s.RestaurantID, reviewID, ReviewStatusApproved).Scan(&s.RestaurantMean, &s.RestaurantReviews)
+	return s, err
+}
+
+// RecentReviewTexts - returns the texts of up to limit of the latest reviews of users other than userID which have not
+// been deleted, newest first. Users repeating themselves, e.g. in a review superseding their earlier one, are left out.
+func RecentReviewTexts(conn Queryer, userID int64, limit int) ([]ReviewText, error) {
+	rows, err := conn.Query("SELECT review_id, review_text FROM reviews WHERE user_id<>? AND deleted_at IS NULL AND COALESCE(review_text, '')<>'' ORDER BY created_at DESC, review_id DESC LIMIT ?", userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var texts []ReviewText
+	for rows.Next() {
+		var rt ReviewText
+		if err = rows.Scan(&rt.ReviewID, &rt.Text); err != nil {
+			return nil, err
+		}
+		texts = append(texts, rt)
+	}
+	return texts, rows.Err()
+}
+
+// SetAnomalyScore - stores how suspicious the review reviewID looks, see moderation.Detector
+func SetAnomalyScore(conn Queryer, reviewID int64, score float64) error {
+	_, err := conn.Exec("UPDATE reviews SET anomaly_score=? WHERE review_id=?", score, reviewID)
+	return err
+}
+
+// ReviewIDsSince - returns the reviews of the states statuses written at or after since which have not been deleted,
+// oldest first
+func ReviewIDsSince(conn Queryer, since time.Time, statuses ...string) ([]int64, error) {
+	query := "SELECT review_id FROM reviews WHERE deleted_at IS NULL AND created_at >= ?"
+	args := []interface{}{since}
+	if len(statuses) > 0 {
+		query += " AND status IN (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+	rows, err := conn.Query(query+" ORDER BY created_at, review_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,260 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	// DefaultAuditLogLimit is the number of entries returned by QueryAuditLog when no limit has been set
+	DefaultAuditLogLimit = 50
+
+	auditRedactedValue = "[redacted]"
+)
+
+// auditRedactedColumns - columns whose values must never be written to the audit log. A change to them is still
+// recorded, but only as auditRedactedValue
+var auditRedactedColumns = map[string]bool{
+	"otp_code":     true,
+	"session_id":   true,
+	"session_data": true,
+}
+
+// AuditEntry - one row of the audit_log table
+type AuditEntry struct {
+	ID          int64
+	ActorUserID sql.NullInt64
+	Actor       string
+	Action      string
+	Entity      string
+	EntityID    sql.NullInt64
+	Before      map[string]interface{}
+	After       map[string]interface{}
+	IPAddress   string
+	CreatedAt   time.Time
+}
+
+// AuditChange - the value of a single column before and after a change
+type AuditChange struct {
+	Field  string
+	Before interface{}
+	After  interface{}
+}
+
+// Changes - returns the columns touched by the entry, sorted by name
+func (e AuditEntry) Changes() []AuditChange {
+	fields := map[string]bool{}
+	for k := range e.Before {
+		fields[k] = true
+	}
+	for k := range e.After {
+		fields[k] = true
+	}
+	var changes []AuditChange
+	for f := range fields {
+		changes = append(changes, AuditChange{Field: f, Before: e.Before[f], After: e.After[f]})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// AuditFilter - narrows down the entries returned by QueryAuditLog. Zero values are ignored.
+type AuditFilter struct {
+	Entity      string
+	EntityID    int64
+	Action      string
+	ActorUserID int64
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+	Offset      int
+}
+
+// Snapshot - reads the row of table identified by pkColumn = id and returns it as a map of column name to value.
+// NULL columns are returned as nil and every other column as its string representation. table and pkColumn are
+// interpolated into the query and must never come from user input. A missing row returns sql.ErrNoRows.
+func Snapshot(conn Queryer, table, pkColumn string, id int64) (map[string]interface{}, error) {
+	rows, err := conn.Query(fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", table, pkColumn), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		switch {
+		case values[i] == nil:
+			snapshot[column] = nil
+		case auditRedactedColumns[column]:
+			snapshot[column] = auditRedactedValue
+		default:
+			snapshot[column] = string(values[i])
+		}
+	}
+	return snapshot, nil
+}
+
+// DiffSnapshots - reduces two snapshots of the same row to the columns whose values differ. Either snapshot may be
+// nil, as is the case for creations and deletions, in which case the other one is returned as it is.
+func DiffSnapshots(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	if before == nil || after == nil {
+		return before, after
+	}
+	b := map[string]interface{}{}
+	a := map[string]interface{}{}
+	for k, v := range before {
+		if av, ok := after[k]; !ok || !reflect.DeepEqual(v, av) {
+			b[k] = v
+			if ok {
+				a[k] = av
+			}
+		}
+	}
+	for k, v := range after {
+		if _, ok := before[k]; !ok {
+			a[k] = v
+		}
+	}
+	return b, a
+}
+
+// WriteAuditEntry - stores e in the audit_log table. Only the columns which changed between e.Before and e.After
+// are kept.
+func WriteAuditEntry(conn Queryer, e AuditEntry) error {
+	before, after := DiffSnapshots(e.Before, e.After)
+	if e.Action == AuditActionUpdate && len(before) == 0 && len(after) == 0 {
+		// nothing has changed, there is nothing to record
+		return nil
+	}
+
+	beforeJSON, err := marshalAuditData(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalAuditData(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec("INSERT INTO audit_log (actor_user_id, actor, action, entity, entity_id, before_data, after_data, ip_address) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		e.ActorUserID, e.Actor, e.Action, e.Entity, e.EntityID, beforeJSON, afterJSON, e.IPAddress)
+	return err
+}
+
+func marshalAuditData(data map[string]interface{}) (sql.NullString, error) {
+	if data == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("error encoding audit data: %s", err.Error())
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// QueryAuditLog - returns the entries of the audit log matching f, newest first
+func QueryAuditLog(conn *sql.DB, f AuditFilter) ([]AuditEntry, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if f.Entity != "" {
+		where = append(where, "entity = ?")
+		args = append(args, f.Entity)
+	}
+	if f.EntityID > 0 {
+		where = append(where, "entity_id = ?")
+		args = append(args, f.EntityID)
+	}
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.ActorUserID > 0 {
+		where = append(where, "actor_user_id = ?")
+		args = append(args, f.ActorUserID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.Until)
+	}
+
+	q := "SELECT audit_log_id, actor_user_id, actor, action, entity, entity_id, before_data, after_data, ip_address, created_at FROM audit_log"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY audit_log_id DESC"
+
+	// A negative limit returns every matching entry, which is what exports need
+	if f.Limit == 0 {
+		f.Limit = DefaultAuditLogLimit
+	}
+	if f.Limit > 0 {
+		q += " LIMIT ? OFFSET ?"
+		args = append(args, f.Limit, f.Offset)
+	}
+
+	rows, err := conn.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var (
+			e                     AuditEntry
+			beforeJSON, afterJSON sql.NullString
+		)
+		err = rows.Scan(&e.ID, &e.ActorUserID, &e.Actor, &e.Action, &e.Entity, &e.EntityID, &beforeJSON, &afterJSON, &e.IPAddress, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if beforeJSON.Valid {
+			if err = json.Unmarshal([]byte(beforeJSON.String), &e.Before); err != nil {
+				return nil, fmt.Errorf("error decoding audit data of entry %d: %s", e.ID, err.Error())
+			}
+		}
+		if afterJSON.Valid {
+			if err = json.Unmarshal([]byte(afterJSON.String), &e.After); err != nil {
+				return nil, fmt.Errorf("error decoding audit data of entry %d: %s", e.ID, err.Error())
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,11 @@
+package db
+
+import "database/sql"
+
+// Queryer - the subset of methods shared by *sql.DB and *sql.Tx, so that helpers can run both inside and outside
+// of a transaction
+type Queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
@@ -0,0 +1,81 @@
+package db
+
+// The reasons users can report a review for, stored in review_reports.reason_code
+const (
+	ReportReasonSpam      = "spam"
+	ReportReasonOffensive = "offensive"
+	ReportReasonOffTopic  = "off_topic"
+	ReportReasonFake      = "fake"
+	ReportReasonConflict  = "conflict_of_interest"
+	ReportReasonOther     = "other"
+)
+
+// ReportReasons - every report reason, in the order they are offered
+var ReportReasons = []string{ReportReasonSpam, ReportReasonOffensive, ReportReasonOffTopic, ReportReasonFake, ReportReasonConflict, ReportReasonOther}
+
+// IsReportReason - reports whether s is a report reason
+func IsReportReason(s string) bool {
+	return containsString(ReportReasons, s)
+}
+
+// VoteOnReview - records whether the user userID found the review reviewID helpful. Voting the same way again
+// withdraws the vote. Users have one vote per review, a later vote the other way replaces the earlier one.
+func VoteOnReview(conn Queryer, reviewID, userID int64, helpful bool) error {
+	res, err := conn.Exec("DELETE FROM review_votes WHERE review_id=? AND user_id=? AND is_helpful=?", reviewID, userID, helpful)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = conn.Exec("INSERT INTO review_votes (review_id, user_id, is_helpful) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE is_helpful=VALUES(is_helpful), created_at=CURRENT_TIMESTAMP",
+		reviewID, userID, helpful)
+	return err
+}
+
+// ReportReview - records that the user userID reported the review reviewID for reasonCode, replacing their earlier
+// report of it. Once threshold different users have open reports of the review, it is flagged for moderation with
+// their reasons, each named once.
+// It reports whether the review was flagged.
+func ReportReview(conn Queryer, reviewID, userID int64, reasonCode, details string, threshold int) (bool, error) {
+	_, err := conn.Exec(`INSERT INTO review_reports (review_id, user_id, reason_code, details) VALUES (?, ?, ?, NULLIF(?, ''))
+		ON DUPLICATE KEY UPDATE reason_code=VALUES(reason_code), details=VALUES(details), created_at=CURRENT_TIMESTAMP, resolved_at=NULL`,
+		reviewID, userID, reasonCode, details)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := conn.Query("SELECT reason_code, COUNT(*) FROM review_reports WHERE review_id=? AND resolved_at IS NULL GROUP BY reason_code ORDER BY 2 DESC, reason_code", reviewID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	var (
+		reasons []ModerationReason
+		reports int
+	)
+	for rows.Next() {
+		var (
+			code string
+			n    int
+		)
+		if err = rows.Scan(&code, &n); err != nil {
+			return false, err
+		}
+		reports += n
+		reasons = append(reasons, NewModerationReason(ReasonReported, code))
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	if reports < threshold {
+		return false, nil
+	}
+	return FlagReview(conn, reviewID, reasons)
+}
+
+// ResolveReviewReports - closes the open reports of the review reviewID, once a moderator decided on it
+func ResolveReviewReports(conn Queryer, reviewID int64) error {
+	_, err := conn.Exec("UPDATE review_reports SET resolved_at=CURRENT_TIMESTAMP WHERE review_id=? AND resolved_at IS NULL", reviewID)
+	return err
+}
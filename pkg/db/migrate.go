@@ -6,6 +6,8 @@ import (
 	"github.com/scalland/bitebuddy/pkg/utils"
 	"github.com/spf13/viper"
 	"log"
+	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -48,13 +50,19 @@ var migrationQueries = []string{
 	// Display types master table.
 	`CREATE TABLE IF NOT EXISTS display_types (
 		display_type_id INT AUTO_INCREMENT PRIMARY KEY,
-		display_type_name VARCHAR(50) NOT NULL UNIQUE
+		display_type_name VARCHAR(50) NOT NULL UNIQUE,
+		widget VARCHAR(20) NOT NULL DEFAULT 'number'
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
 
 	// Metric types master table.
 	`CREATE TABLE IF NOT EXISTS metric_types (
 		metric_type_id INT AUTO_INCREMENT PRIMARY KEY,
-		metric_type_name VARCHAR(50) NOT NULL UNIQUE
+		metric_type_name VARCHAR(50) NOT NULL UNIQUE,
+		scale_min DECIMAL(6,2) NOT NULL DEFAULT 0,
+		scale_max DECIMAL(6,2) NOT NULL DEFAULT 5,
+		scale_step DECIMAL(6,2) NOT NULL DEFAULT 0,
+		scale_labels VARCHAR(1000) NULL DEFAULT NULL,
+		is_boolean BOOLEAN NOT NULL DEFAULT FALSE
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
 
 	// Metrics table.
@@ -65,6 +73,9 @@ var migrationQueries = []string{
 		is_sub_metric BOOLEAN DEFAULT FALSE,
 		display_type_id INT NOT NULL,
 		metric_type_id INT NOT NULL,
+		weight DECIMAL(6,2) NOT NULL DEFAULT 1,
+		sort_order INT NOT NULL DEFAULT 0,
+		is_enabled BOOLEAN NOT NULL DEFAULT TRUE,
 		FOREIGN KEY (parent_metric_id) REFERENCES metrics(metric_id) ON DELETE CASCADE,
 		FOREIGN KEY (display_type_id) REFERENCES display_types(display_type_id) ON DELETE CASCADE,
 		FOREIGN KEY (metric_type_id) REFERENCES metric_types(metric_type_id) ON DELETE CASCADE
@@ -87,17 +98,89 @@ var migrationQueries = []string{
 		user_id INT NOT NULL,
 		overall_score DECIMAL(3,2) NOT NULL,
 		review_text TEXT,
+		status VARCHAR(10) NOT NULL DEFAULT 'approved',
+		moderation_reason VARCHAR(1000) NULL DEFAULT NULL,
+		moderated_by INT NULL DEFAULT NULL,
+		moderated_at TIMESTAMP NULL DEFAULT NULL,
+		superseded_by INT NULL DEFAULT NULL,
+		anomaly_score DECIMAL(4,2) NULL DEFAULT NULL,
+		sentiment_score DECIMAL(4,3) NULL DEFAULT NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (restaurant_id) REFERENCES restaurants(restaurant_id) ON DELETE CASCADE,
 		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
 
+	// Earlier versions of edited reviews, see db.SaveReviewRevision
+	`CREATE TABLE IF NOT EXISTS review_revisions (
+		review_revision_id INT AUTO_INCREMENT PRIMARY KEY,
+		review_id INT NOT NULL,
+		restaurant_id INT NOT NULL,
+		user_id INT NOT NULL,
+		overall_score DECIMAL(3,2) NOT NULL,
+		review_text TEXT,
+		edited_by INT NULL DEFAULT NULL,
+		replaced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (review_id) REFERENCES reviews(review_id) ON DELETE CASCADE,
+		INDEX (review_id, replaced_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
+
+	// Helpful and not helpful votes on reviews, one per user, see db.VoteOnReview
+	`CREATE TABLE IF NOT EXISTS review_votes (
+		review_vote_id INT AUTO_INCREMENT PRIMARY KEY,
+		review_id INT NOT NULL,
+		user_id INT NOT NULL,
+		is_helpful BOOLEAN NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY (review_id, user_id),
+		FOREIGN KEY (review_id) REFERENCES reviews(review_id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
+
+	// Reports of reviews by users, see db.ReportReview
+	`CREATE TABLE IF NOT EXISTS review_reports (
+		review_report_id INT AUTO_INCREMENT PRIMARY KEY,
+		review_id INT NOT NULL,
+		user_id INT NOT NULL,
+		reason_code VARCHAR(30) NOT NULL,
+		details VARCHAR(500) NULL DEFAULT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		resolved_at TIMESTAMP NULL DEFAULT NULL,
+		UNIQUE KEY (review_id, user_id),
+		FOREIGN KEY (review_id) REFERENCES reviews(review_id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
+
+	// Photos attached to reviews, stored in the media storage below image_key, see db.AddReviewPhoto
+	`CREATE TABLE IF NOT EXISTS review_photos (
+		review_photo_id INT AUTO_INCREMENT PRIMARY KEY,
+		review_id INT NOT NULL,
+		image_key VARCHAR(255) NOT NULL,
+		position INT NOT NULL DEFAULT 0,
+		is_rejected BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP NULL DEFAULT NULL,
+		KEY (review_id, position),
+		FOREIGN KEY (review_id) REFERENCES reviews(review_id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
+
+	// Aspect terms used in the text of a review, see db.SaveReviewSentiment
+	`CREATE TABLE IF NOT EXISTS review_mentions (
+		review_id INT NOT NULL,
+		term VARCHAR(50) NOT NULL,
+		aspect VARCHAR(20) NOT NULL,
+		mentions INT NOT NULL DEFAULT 1,
+		sentiment_score DECIMAL(4,3) NOT NULL DEFAULT 0,
+		PRIMARY KEY (review_id, term),
+		KEY (aspect),
+		FOREIGN KEY (review_id) REFERENCES reviews(review_id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
+
 	// Metric Reviews table.
 	`CREATE TABLE IF NOT EXISTS metric_reviews (
 		metric_review_id INT AUTO_INCREMENT PRIMARY KEY,
 		review_id INT NOT NULL,
 		metric_id INT NOT NULL,
-		score DECIMAL(3,2) NOT NULL,
+		score DECIMAL(6,2) NOT NULL,
 		FOREIGN KEY (review_id) REFERENCES reviews(review_id) ON DELETE CASCADE,
 		FOREIGN KEY (metric_id) REFERENCES metrics(metric_id) ON DELETE CASCADE
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
@@ -127,6 +210,125 @@ var migrationQueries = []string{
 		session_id VARCHAR(1024) NOT NULL,
 		UNIQUE KEY(user_id,otp_code,session_id)
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
+
+	// Audit log of administrative changes. There is deliberately no foreign key on actor_user_id or entity_id so
+	// that the trail survives the deletion of the users and records it refers to.
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		audit_log_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		actor_user_id INT DEFAULT NULL,
+		actor VARCHAR(255) NOT NULL DEFAULT '',
+		action VARCHAR(20) NOT NULL,
+		entity VARCHAR(50) NOT NULL,
+		entity_id BIGINT DEFAULT NULL,
+		before_data JSON DEFAULT NULL,
+		after_data JSON DEFAULT NULL,
+		ip_address VARCHAR(45) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		KEY idx_audit_log_entity (entity, entity_id),
+		KEY idx_audit_log_actor (actor_user_id),
+		KEY idx_audit_log_created_at (created_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`,
+}
+
+// columnMigration - a column added to a table after the table was first created
+type columnMigration struct {
+	Table      string
+	Column     string
+	Definition string
+}
+
+// columnMigrations - CREATE TABLE IF NOT EXISTS leaves existing tables untouched, so columns added later are listed
+// here and only applied to tables which do not have them yet
+var columnMigrations = []columnMigration{
+	// Soft deletion
+	{Table: "restaurants", Column: "deleted_at", Definition: "TIMESTAMP NULL DEFAULT NULL"},
+	{Table: "users", Column: "deleted_at", Definition: "TIMESTAMP NULL DEFAULT NULL"},
+	{Table: "reviews", Column: "deleted_at", Definition: "TIMESTAMP NULL DEFAULT NULL"},
+	{Table: "metrics", Column: "deleted_at", Definition: "TIMESTAMP NULL DEFAULT NULL"},
+	// Uploaded restaurant images, see handlers.WebHandlers.storeUploadedImage
+	{Table: "restaurants", Column: "image_key", Definition: "VARCHAR(255) NULL DEFAULT NULL AFTER image_url"},
+	// Theme chosen by the user, see handlers.WebHandlers.requestThemeName
+	{Table: "users", Column: "theme", Definition: "VARCHAR(64) NULL DEFAULT NULL"},
+	// Locale chosen by the user, see handlers.WebHandlers.requestLocale
+	{Table: "users", Column: "locale", Definition: "VARCHAR(35) NULL DEFAULT NULL"},
+	// Whether the OTP could be sent, see db.OTPDeliverySent
+	{Table: "otp_requests", Column: "delivery_status", Definition: "VARCHAR(10) NOT NULL DEFAULT '' AFTER delivery_method"},
+	// Input control of the metrics of a display type, see handlers.DisplayWidgets
+	{Table: "display_types", Column: "widget", Definition: "VARCHAR(20) NOT NULL DEFAULT 'number'"},
+	// Scale of the scores of the metrics of a metric type, see db.MetricScale
+	{Table: "metric_types", Column: "scale_min", Definition: "DECIMAL(6,2) NOT NULL DEFAULT 0"},
+	{Table: "metric_types", Column: "scale_max", Definition: "DECIMAL(6,2) NOT NULL DEFAULT 5"},
+	{Table: "metric_types", Column: "scale_step", Definition: "DECIMAL(6,2) NOT NULL DEFAULT 0"},
+	{Table: "metric_types", Column: "scale_labels", Definition: "VARCHAR(1000) NULL DEFAULT NULL"},
+	{Table: "metric_types", Column: "is_boolean", Definition: "BOOLEAN NOT NULL DEFAULT FALSE"},
+	// Weight of a metric among its siblings, see db.MetricHierarchy
+	{Table: "metrics", Column: "weight", Definition: "DECIMAL(6,2) NOT NULL DEFAULT 1 AFTER metric_type_id"},
+	// Position of a metric among its siblings and whether it is offered in review forms, see handlers.MetricTreeNode
+	{Table: "metrics", Column: "sort_order", Definition: "INT NOT NULL DEFAULT 0 AFTER weight"},
+	{Table: "metrics", Column: "is_enabled", Definition: "BOOLEAN NOT NULL DEFAULT TRUE AFTER sort_order"},
+	// Moderation state of a review, see db.ReviewStatusApproved. Reviews written before moderation existed stay live.
+	{Table: "reviews", Column: "status", Definition: "VARCHAR(10) NOT NULL DEFAULT 'approved' AFTER review_text"},
+	{Table: "reviews", Column: "moderation_reason", Definition: "VARCHAR(1000) NULL DEFAULT NULL AFTER status"},
+	{Table: "reviews", Column: "moderated_by", Definition: "INT NULL DEFAULT NULL AFTER moderation_reason"},
+	{Table: "reviews", Column: "moderated_at", Definition: "TIMESTAMP NULL DEFAULT NULL AFTER moderated_by"},
+	// Newer review of the same user for the same restaurant, see db.ActiveReviewSQL
+	{Table: "reviews", Column: "superseded_by", Definition: "INT NULL DEFAULT NULL AFTER moderated_at"},
+	// How suspicious a review looks, see moderation.Detector
+	{Table: "reviews", Column: "anomaly_score", Definition: "DECIMAL(4,2) NULL DEFAULT NULL AFTER superseded_by"},
+	// Sentiment of the text of a review, see sentiment.Analyze
+	{Table: "reviews", Column: "sentiment_score", Definition: "DECIMAL(4,3) NULL DEFAULT NULL AFTER anomaly_score"},
+	// Bayesian ranking of restaurants and of their metrics, see db.RankRestaurant
+	{Table: "restaurants", Column: "ranking_score", Definition: "DECIMAL(5,3) NULL DEFAULT NULL"},
+	{Table: "restaurants", Column: "ranked_reviews", Definition: "INT NOT NULL DEFAULT 0"},
+	{Table: "restaurants", Column: "ranked_at", Definition: "TIMESTAMP NULL DEFAULT NULL"},
+	{Table: "restaurant_metrics", Column: "ranking_score", Definition: "DECIMAL(5,3) NULL DEFAULT NULL AFTER average_score"},
+	{Table: "restaurant_metrics", Column: "review_count", Definition: "INT NOT NULL DEFAULT 0 AFTER ranking_score"},
+}
+
+// columnTypeMigrations - columns whose type changed after their table was first created. Type is compared with
+// information_schema.COLUMNS.COLUMN_TYPE and Definition is only applied to columns of another type.
+var columnTypeMigrations = []struct {
+	columnMigration
+	Type string
+}{
+	// Percentages do not fit into DECIMAL(3,2)
+	{columnMigration: columnMigration{Table: "metric_reviews", Column: "score", Definition: "DECIMAL(6,2) NOT NULL"}, Type: "decimal(6,2)"},
+}
+
+// addColumnIfMissing - applies m unless the column already exists in the current database
+func addColumnIfMissing(db *sql.DB, m columnMigration) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", m.Table, m.Column).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error checking for column %s.%s: %s", m.Table, m.Column, err.Error())
+	}
+	if count > 0 {
+		return nil
+	}
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.Table, m.Column, m.Definition)
+	log.Printf("Executing migration query: %s", query)
+	if _, err = db.Exec(query); err != nil {
+		return fmt.Errorf("migration error: %s", err.Error())
+	}
+	return nil
+}
+
+// modifyColumnIfDifferent - applies m unless the column already has the type columnType
+func modifyColumnIfDifferent(db *sql.DB, m columnMigration, columnType string) error {
+	var current string
+	err := db.QueryRow("SELECT COLUMN_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", m.Table, m.Column).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("error checking the type of column %s.%s: %s", m.Table, m.Column, err.Error())
+	}
+	if strings.EqualFold(current, columnType) {
+		return nil
+	}
+	query := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", m.Table, m.Column, m.Definition)
+	log.Printf("Executing migration query: %s", query)
+	if _, err = db.Exec(query); err != nil {
+		return fmt.Errorf("migration error: %s", err.Error())
+	}
+	return nil
 }
 
 func MigrateDB(u *utils.Utils, createDB bool) error {
@@ -175,5 +377,24 @@ func MigrateDB(u *utils.Utils, createDB bool) error {
 			return fmt.Errorf("migration error: %s", err)
 		}
 	}
+
+	for _, m := range columnMigrations {
+		if err := addColumnIfMissing(db, m); err != nil {
+			return err
+		}
+	}
+	for _, m := range columnTypeMigrations {
+		if err := modifyColumnIfDifferent(db, m.columnMigration, m.Type); err != nil {
+			return err
+		}
+	}
+
+	// Restaurants created before they were ranked have no ranking score, and the overall rating of those created before
+	// it followed from their reviews was typed in, until one of their reviews changes
+	n, err := RankRestaurants(db, LoadRanking(), time.Now())
+	if err != nil {
+		return fmt.Errorf("migration error: %s", err.Error())
+	}
+	log.Printf("Ranked %d restaurants", n)
 	return nil
 }
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The states of a review in reviews.status. Only approved reviews are shown and count toward ratings. Reviews are
+// pending while they wait for a moderator, and flagged when they were held for a reason, e.g. a blocked word.
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+	ReviewStatusFlagged  = "flagged"
+)
+
+// ReviewStatuses - every review state, in the order they are offered
+var ReviewStatuses = []string{ReviewStatusPending, ReviewStatusFlagged, ReviewStatusApproved, ReviewStatusRejected}
+
+// ModerationReasonSeparator - separates the reasons in reviews.moderation_reason
+const ModerationReasonSeparator = "; "
+
+// The codes of the reasons a review is held for moderation, see ModerationReason
+const (
+	// ReasonBlockedWord - the text contains the blocked word of the argument
+	ReasonBlockedWord = "blocked_word"
+	// ReasonLink - the text contains the link of the argument
+	ReasonLink = "link"
+	// ReasonNewPhotos - the number of photos of the argument were added to the review
+	ReasonNewPhotos = "new_photos"
+	// ReasonNewAccount - the review was written the number of seconds of the argument after the account was created
+	ReasonNewAccount = "new_account"
+	// ReasonSharedIP - the number of other reviewers of the argument were last seen at the same IP address
+	ReasonSharedIP = "shared_ip"
+	// ReasonBurst - the review is one of the number of reviews of the first argument within the number of seconds of
+	// the second
+	ReasonBurst = "burst"
+	// ReasonScoreDeviation - the overall score of the first argument is the second argument off the mean of the
+	// restaurant, the third
+	ReasonScoreDeviation = "score_deviation"
+	// ReasonDuplicate - the text is the percentage of the first argument like the review of the second
+	ReasonDuplicate = "duplicate"
+	// ReasonReadsPositive, ReasonReadsNegative - the text, of the sentiment of the first argument, contradicts the
+	// overall score of the second
+	ReasonReadsPositive = "reads_positive"
+	ReasonReadsNegative = "reads_negative"
+	// ReasonReported - users reported the review for the report reason of the argument, see ReportReasons
+	ReasonReported = "reported"
+	// ReasonModerator - the moderator who decided on the review gave the reason of the argument
+	ReasonModerator = "moderator"
+)
+
+// moderationReasonCode - matches the reasons stored with a code, reasons stored before they had codes are sentences
+var moderationReasonCode = regexp.MustCompile(`^[a-z_]+(?::|$)`)
+
+// ModerationReason - a reason a review is held for moderation, or the one a moderator gave for their decision. Code
+// names the message of the catalogs it is shown with, moderation_reason.<Code>, and Args are the values the message
+// is formatted with. Reasons stored before they had codes have none, their text is the only argument.
+type ModerationReason struct {
+	Code string
+	Args []string
+}
+
+// NewModerationReason - returns the reason code with the arguments args, written out with fmt.Sprint
+func NewModerationReason(code string, args ...interface{}) ModerationReason {
+	reason := ModerationReason{Code: code}
+	for _, arg := range args {
+		reason.Args = append(reason.Args, fmt.Sprint(arg))
+	}
+	return reason
+}
+
+// String - returns the reason as it is stored in reviews.moderation_reason: its code, followed by a colon and its
+// arguments separated by commas if it has any. The arguments are escaped so that they hold neither.
+func (mr ModerationReason) String() string {
+	if mr.Code == "" {
+		return strings.Join(mr.Args, " ")
+	}
+	if len(mr.Args) == 0 {
+		return mr.Code
+	}
+	args := make([]string, len(mr.Args))
+	for i, arg := range mr.Args {
+		args[i] = url.QueryEscape(arg)
+	}
+	return mr.Code + ":" + strings.Join(args, ",")
+}
+
+// parseModerationReason - returns the reason s stored in reviews.moderation_reason, see ModerationReason.String
+func parseModerationReason(s string) ModerationReason {
+	if !moderationReasonCode.MatchString(s) {
+		return ModerationReason{Args: []string{s}}
+	}
+	code, args, found := strings.Cut(s, ":")
+	reason := ModerationReason{Code: code}
+	if !found {
+		return reason
+	}
+	for _, arg := range strings.Split(args, ",") {
+		// Arguments cut short at the end of the column are kept as they are
+		if unescaped, err := url.QueryUnescape(arg); err == nil {
+			arg = unescaped
+		}
+		reason.Args = append(reason.Args, arg)
+	}
+	return reason
+}
+
+// FormatModerationReasons - returns reasons as they are stored in reviews.moderation_reason, cut down to the 1000
+// characters of the column
+func FormatModerationReasons(reasons []ModerationReason) string {
+	encoded := make([]string, len(reasons))
+	for i, reason := range reasons {
+		encoded[i] = reason.String()
+	}
+	joined := strings.Join(encoded, ModerationReasonSeparator)
+	if runes := []rune(joined); len(runes) > 1000 {
+		joined = string(runes[:1000])
+	}
+	return joined
+}
+
+// IsReviewStatus - reports whether s is a review state
+func IsReviewStatus(s string) bool {
+	for _, status := range ReviewStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseModerationReasons - splits the reasons stored in reviews.moderation_reason
+func ParseModerationReasons(s string) []ModerationReason {
+	var reasons []ModerationReason
+	for _, reason := range strings.Split(s, ModerationReasonSeparator) {
+		if reason = strings.TrimSpace(reason); reason != "" {
+			reasons = append(reasons, parseModerationReason(reason))
+		}
+	}
+	return reasons
+}
+
+// FlagReview - flags the pending or approved review reviewID for reasons, adding them to the reasons it is already
+// waiting for moderation for, of which those for the same replace the earlier ones, see sameReason. Reviews a
+// moderator rejected stay rejected. It reports whether the review was flagged.
+func FlagReview(conn Queryer, reviewID int64, reasons []ModerationReason) (bool, error) {
+	if len(reasons) == 0 {
+		return false, nil
+	}
+	var (
+		status string
+		reason sql.NullString
+	)
+	err := conn.QueryRow("SELECT status, moderation_reason FROM reviews WHERE review_id=?", reviewID).Scan(&status, &reason)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if status == ReviewStatusRejected {
+		return false, nil
+	}
+	// Reasons a moderator gave for approving the review no longer apply
+	var merged []ModerationReason
+	if status != ReviewStatusApproved {
+		merged = ParseModerationReasons(reason.String)
+	}
+	for _, r := range reasons {
+		if i := indexReason(merged, r); i >= 0 {
+			merged[i] = r
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	_, err = conn.Exec("UPDATE reviews SET status=?, moderation_reason=? WHERE review_id=?", ReviewStatusFlagged, FormatModerationReasons(merged), reviewID)
+	return err == nil, err
+}
+
+// containsString - reports whether s is among list
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// indexReason - returns the index of the reason of list which holds a review for the same as reason, see sameReason,
+// or -1 if there is none
+func indexReason(list []ModerationReason, reason ModerationReason) int {
+	for i, l := range list {
+		if sameReason(l, reason) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameReason - reports whether the reasons a and b hold a review for the same, in which case the later one replaces
+// the earlier. Reasons naming what they are about, a word, a link or a report reason, are the same when they name the
+// same. The others measure something which changes as the review is checked again, e.g. the number of reviews of a
+// burst, and are the same when their codes are.
+func sameReason(a, b ModerationReason) bool {
+	if a.Code != b.Code {
+		return false
+	}
+	switch a.Code {
+	case "", ReasonBlockedWord, ReasonLink, ReasonReported, ReasonModerator:
+		return a.String() == b.String()
+	}
+	return true
+}
+
+// ModerateReview - sets the status of the review reviewID, as decided by the moderator moderatorID for reason, which
+// is stored as a ReasonModerator
+func ModerateReview(conn Queryer, reviewID int64, status, reason string, moderatorID int64) error {
+	stored := sql.NullString{String: FormatModerationReasons([]ModerationReason{NewModerationReason(ReasonModerator, reason)}), Valid: reason != ""}
+	_, err := conn.Exec("UPDATE reviews SET status=?, moderation_reason=?, moderated_by=NULLIF(?, 0), moderated_at=CURRENT_TIMESTAMP WHERE review_id=? AND deleted_at IS NULL",
+		status, stored, moderatorID, reviewID)
+	return err
+}
+
+// ReleaseReview - approves the review reviewID which was held as pending while it was checked, unless it was flagged
+// or moderated meanwhile. It returns the status the review ends up with.
+func ReleaseReview(conn Queryer, reviewID int64) (string, error) {
+	if _, err := conn.Exec("UPDATE reviews SET status=? WHERE review_id=? AND status=? AND moderated_at IS NULL", ReviewStatusApproved, reviewID, ReviewStatusPending); err != nil {
+		return "", err
+	}
+	var status string
+	err := conn.QueryRow("SELECT status FROM reviews WHERE review_id=?", reviewID).Scan(&status)
+	return status, err
+}
+
+// CountReviewsToModerate - counts the pending and flagged reviews which have not been deleted
+func CountReviewsToModerate(conn Queryer) (int64, error) {
+	var n int64
+	err := conn.QueryRow("SELECT COUNT(*) FROM reviews WHERE deleted_at IS NULL AND status IN (?, ?)", ReviewStatusPending, ReviewStatusFlagged).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ReviewPhoto - a photo attached to a review. The photo is shown along with the review unless a moderator rejected it.
+type ReviewPhoto struct {
+	ID       int64
+	ReviewID int64
+	// ImageKey is the key the sizes of the photo are stored under in the media storage, see images.ReviewSizes
+	ImageKey  string
+	Position  int
+	Rejected  bool
+	CreatedAt time.Time
+}
+
+// ReviewPhotos - returns the photos of the reviews reviewIDs which have not been removed, keyed by review, in the order
+// they were attached. Rejected photos are left out unless withRejected is set.
+func ReviewPhotos(conn Queryer, reviewIDs []int64, withRejected bool) (map[int64][]ReviewPhoto, error) {
+	photos := make(map[int64][]ReviewPhoto)
+	if len(reviewIDs) == 0 {
+		return photos, nil
+	}
+	args := make([]interface{}, len(reviewIDs))
+	for i, id := range reviewIDs {
+		args[i] = id
+	}
+	query := "SELECT review_photo_id, review_id, image_key, position, is_rejected, created_at FROM review_photos WHERE review_id IN (?" +
+		strings.Repeat(", ?", len(reviewIDs)-1) + ") AND deleted_at IS NULL"
+	if !withRejected {
+		query += " AND NOT is_rejected"
+	}
+	rows, err := conn.Query(query+" ORDER BY review_id, position, review_photo_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying review photos: %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p ReviewPhoto
+		if err := rows.Scan(&p.ID, &p.ReviewID, &p.ImageKey, &p.Position, &p.Rejected, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning review photo: %s", err.Error())
+		}
+		photos[p.ReviewID] = append(photos[p.ReviewID], p)
+	}
+	return photos, rows.Err()
+}
+
+// CountReviewPhotos - returns the number of photos attached to the review reviewID which have not been removed
+func CountReviewPhotos(conn Queryer, reviewID int64) (int, error) {
+	var n int
+	err := conn.QueryRow("SELECT COUNT(*) FROM review_photos WHERE review_id=? AND deleted_at IS NULL", reviewID).Scan(&n)
+	return n, err
+}
+
+// AddReviewPhoto - attaches the photo stored under imageKey to the review reviewID, after its other photos. It returns
+// the ID of the photo, 0 if the review already has it.
+func AddReviewPhoto(conn Queryer, reviewID int64, imageKey string) (int64, error) {
+	var exists bool
+	err := conn.QueryRow("SELECT EXISTS (SELECT 1 FROM review_photos WHERE review_id=? AND image_key=? AND deleted_at IS NULL)", reviewID, imageKey).Scan(&exists)
+	if err != nil || exists {
+		return 0, err
+	}
+	res, err := conn.Exec("INSERT INTO review_photos (review_id, image_key, position) SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM review_photos WHERE review_id=?",
+		reviewID, imageKey, reviewID)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// RemoveReviewPhoto - removes the photo photoID from the review reviewID. The stored images are kept, as other reviews
+// may share them. It reports whether the review had the photo.
+func RemoveReviewPhoto(conn Queryer, reviewID, photoID int64) (bool, error) {
+	res, err := conn.Exec("UPDATE review_photos SET deleted_at=CURRENT_TIMESTAMP WHERE review_photo_id=? AND review_id=? AND deleted_at IS NULL", photoID, reviewID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// IsReviewPhotoKeyUsed - reports whether any photo of a review, removed ones included, is stored under imageKey
+func IsReviewPhotoKeyUsed(conn Queryer, imageKey string) (bool, error) {
+	var used bool
+	err := conn.QueryRow("SELECT EXISTS (SELECT 1 FROM review_photos WHERE image_key=?)", imageKey).Scan(&used)
+	return used, err
+}
+
+// RejectReviewPhoto - hides the photo photoID, or shows it again if rejected is not set, as a moderator decided
+func RejectReviewPhoto(conn Queryer, photoID int64, rejected bool) error {
+	_, err := conn.Exec("UPDATE review_photos SET is_rejected=? WHERE review_photo_id=?", rejected, photoID)
+	return err
+}
+
+// IsReviewPhotoShown - reports whether the photo stored under imageKey is shown with a review, that is attached to an
+// approved, active review without having been removed or rejected
+func IsReviewPhotoShown(conn Queryer, imageKey string) (bool, error) {
+	var shown bool
+	err := conn.QueryRow(`SELECT EXISTS (SELECT 1 FROM review_photos AS rp JOIN reviews AS rv ON rp.review_id=rv.review_id
+		WHERE rp.image_key=? AND rp.deleted_at IS NULL AND NOT rp.is_rejected AND rv.status=? AND `+ActiveReviewSQL+`)`, imageKey, ReviewStatusApproved).Scan(&shown)
+	return shown, err
+}
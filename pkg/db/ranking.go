@@ -0,0 +1,117 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Ranking - how restaurants and their metrics are ranked. The ranking score is the Bayesian average of the review
+// scores: the mean after adding PriorWeight reviews scoring PriorMean, so that a few reviews cannot outrank many
+// slightly lower ones. With a HalfLife, reviews count half as much each time it passes.
+type Ranking struct {
+	PriorMean   float64
+	PriorWeight float64
+	HalfLife    time.Duration
+}
+
+// LoadRanking - returns the ranking of the configuration, falling back to the defaults of sample.yml for the settings
+// which are not set
+func LoadRanking() Ranking {
+	rk := Ranking{PriorMean: 3.5, PriorWeight: 5}
+	if viper.IsSet("ranking_prior_mean") {
+		rk.PriorMean = viper.GetFloat64("ranking_prior_mean")
+	}
+	if viper.IsSet("ranking_prior_weight") {
+		rk.PriorWeight = viper.GetFloat64("ranking_prior_weight")
+	}
+	if days := viper.GetFloat64("ranking_half_life_days"); days > 0 {
+		rk.HalfLife = time.Duration(days * float64(24*time.Hour))
+	}
+	if rk.PriorWeight < 0 {
+		rk.PriorWeight = 0
+	}
+	return rk
+}
+
+// scoreSQL - returns the SQL aggregate of the ranking score of the rows grouped, whose score and time of writing are
+// the expressions score and createdAt, along with its arguments. Groups without rows score PriorMean, or NULL when
+// PriorWeight is 0.
+func (rk Ranking) scoreSQL(score, createdAt string, now time.Time) (string, []interface{}) {
+	if rk.HalfLife <= 0 {
+		return fmt.Sprintf("(? * ? + COALESCE(SUM(%s), 0)) / NULLIF(? + COUNT(%[1]s), 0)", score),
+			[]interface{}{rk.PriorWeight, rk.PriorMean, rk.PriorWeight}
+	}
+	weight := fmt.Sprintf("POW(0.5, GREATEST(TIMESTAMPDIFF(SECOND, %s, ?), 0) / ?)", createdAt)
+	halfLife := rk.HalfLife.Seconds()
+	return fmt.Sprintf("(? * ? + COALESCE(SUM(%[1]s * %[2]s), 0)) / NULLIF(? + COALESCE(SUM(IF(%[2]s IS NULL, NULL, %[1]s)), 0), 0)", weight, score),
+		[]interface{}{rk.PriorWeight, rk.PriorMean, now, halfLife, rk.PriorWeight, now, halfLife}
+}
+
+// metricScoreSQL - the score of the metric_reviews row mr on the range from 0 to OverallScoreMax, so that the prior
+// applies to metrics of every scale
+var metricScoreSQL = fmt.Sprintf("(%s) * %g", NormalizedScoreSQL, OverallScoreMax)
+
+// RankRestaurant - computes the ranking score of the restaurant restaurantID from its approved, active reviews as of
+// now, and those of its metrics from their metric scores, and stores them with the restaurant and in
+// restaurant_metrics. The overall rating of the restaurant is set to the plain mean of the same reviews, 0 without any.
+// Reviews of deleted users do not count, as on the page of the restaurant.
+func RankRestaurant(conn Queryer, restaurantID int64, rk Ranking, now time.Time) error {
+	expr, args := rk.scoreSQL("rv.overall_score", "rv.created_at", now)
+	args = append(args, restaurantID, ReviewStatusApproved)
+	_, err := conn.Exec(`UPDATE restaurants AS rs JOIN (
+			SELECT `+expr+` AS score, COUNT(rv.review_id) AS reviews, AVG(rv.overall_score) AS rating
+			FROM reviews AS rv JOIN users AS u ON rv.user_id=u.user_id
+			WHERE rv.restaurant_id=? AND rv.status=? AND u.deleted_at IS NULL AND `+ActiveReviewSQL+`
+		) AS t SET rs.ranking_score=t.score, rs.ranked_reviews=t.reviews, rs.overall_rating=COALESCE(ROUND(t.rating, 2), 0), rs.ranked_at=?
+		WHERE rs.restaurant_id=?`, append(args, now, restaurantID)...)
+	if err != nil {
+		return fmt.Errorf("error ranking restaurant %d: %s", restaurantID, err.Error())
+	}
+
+	if _, err = conn.Exec("DELETE FROM restaurant_metrics WHERE restaurant_id=?", restaurantID); err != nil {
+		return fmt.Errorf("error clearing metrics of restaurant %d: %s", restaurantID, err.Error())
+	}
+	expr, exprArgs := rk.scoreSQL(metricScoreSQL, "rv.created_at", now)
+	args = append([]interface{}{restaurantID}, exprArgs...)
+	args = append(args, restaurantID, ReviewStatusApproved)
+	_, err = conn.Exec(`INSERT INTO restaurant_metrics (restaurant_id, metric_id, average_score, ranking_score, review_count)
+		SELECT ?, mr.metric_id, AVG(`+metricScoreSQL+`), `+expr+`, COUNT(*)
+		FROM metric_reviews AS mr JOIN reviews AS rv ON mr.review_id=rv.review_id JOIN users AS u ON rv.user_id=u.user_id
+		JOIN metrics AS m ON mr.metric_id=m.metric_id JOIN metric_types AS mt ON m.metric_type_id=mt.metric_type_id
+		WHERE rv.restaurant_id=? AND rv.status=? AND u.deleted_at IS NULL AND m.deleted_at IS NULL AND mt.scale_max > mt.scale_min AND `+ActiveReviewSQL+`
+		GROUP BY mr.metric_id`, args...)
+	if err != nil {
+		return fmt.Errorf("error ranking metrics of restaurant %d: %s", restaurantID, err.Error())
+	}
+	return nil
+}
+
+// RankRestaurants - ranks every restaurant which has not been deleted as of now, see RankRestaurant. It returns the
+// number of restaurants ranked.
+func RankRestaurants(conn Queryer, rk Ranking, now time.Time) (int, error) {
+	rows, err := conn.Query("SELECT restaurant_id FROM restaurants WHERE deleted_at IS NULL ORDER BY restaurant_id")
+	if err != nil {
+		return 0, fmt.Errorf("error querying restaurants: %s", err.Error())
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning restaurant: %s", err.Error())
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := RankRestaurant(conn, id, rk, now); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
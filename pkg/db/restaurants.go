@@ -0,0 +1,410 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ImportFormatCSV  = "csv"
+	ImportFormatJSON = "json"
+
+	ImportActionCreate = "create"
+	ImportActionUpdate = "update"
+	ImportActionError  = "error"
+)
+
+// RestaurantColumns - the columns read and written by restaurant imports and exports, in export order. Exports also
+// write the restaurant_id and the overall_rating, which imports ignore as the rating follows from the reviews.
+var RestaurantColumns = []string{"name", "address", "latitude", "longitude", "price_for_two", "image_url", "discount_available", "alcohol_available", "portion_size_large"}
+
+// RestaurantRecord - a restaurant as it appears in an import or export file
+type RestaurantRecord struct {
+	ID        int64   `json:"restaurant_id,omitempty"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// OverallRating is only exported, see RestaurantColumns
+	OverallRating     float64 `json:"overall_rating"`
+	PriceForTwo       float64 `json:"price_for_two"`
+	ImageURL          string  `json:"image_url"`
+	DiscountAvailable bool    `json:"discount_available"`
+	AlcoholAvailable  bool    `json:"alcohol_available"`
+	PortionSizeLarge  bool    `json:"portion_size_large"`
+}
+
+// Validate - returns the reasons why r cannot be stored, if any
+func (r RestaurantRecord) Validate() []string {
+	var errs []string
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, "name is required")
+	} else if len(r.Name) > 255 {
+		errs = append(errs, "name is longer than 255 characters")
+	}
+	if strings.TrimSpace(r.Address) == "" {
+		errs = append(errs, "address is required")
+	}
+	if r.Latitude < -90 || r.Latitude > 90 {
+		errs = append(errs, "latitude must be between -90 and 90")
+	}
+	if r.Longitude < -180 || r.Longitude > 180 {
+		errs = append(errs, "longitude must be between -180 and 180")
+	}
+	if r.PriceForTwo < 0 {
+		errs = append(errs, "price_for_two cannot be negative")
+	}
+	if len(r.ImageURL) > 255 {
+		errs = append(errs, "image_url is longer than 255 characters")
+	}
+	return errs
+}
+
+// ImportRow - a single row of an import file along with what the import did, or would do, with it
+type ImportRow struct {
+	// Line is the line of a CSV file or the 1-based index of a JSON array element
+	Line   int
+	Record RestaurantRecord
+	// Columns holds the columns of RestaurantColumns which the row gives a value. Updates leave the others as they
+	// are.
+	Columns map[string]bool
+	Action  string
+	// ID is the restaurant which was created or updated
+	ID     int64
+	Errors []string
+}
+
+// ImportResult - the outcome of ImportRestaurants
+type ImportResult struct {
+	Rows    []ImportRow
+	Created int
+	Updated int
+	Failed  int
+	DryRun  bool
+}
+
+// DetectImportFormat - returns format if it has been set, otherwise guesses it from the extension of fileName
+func DetectImportFormat(format, fileName string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	if strings.EqualFold(filepath.Ext(fileName), ".json") {
+		return ImportFormatJSON
+	}
+	return ImportFormatCSV
+}
+
+// ParseRestaurants - reads restaurants from r in the given format. Errors in individual rows are reported on the
+// rows; only an unreadable file returns an error.
+func ParseRestaurants(r io.Reader, format string) ([]ImportRow, error) {
+	switch format {
+	case ImportFormatCSV:
+		return ParseRestaurantsCSV(r)
+	case ImportFormatJSON:
+		return ParseRestaurantsJSON(r)
+	}
+	return nil, fmt.Errorf("unknown import format %q, use csv or json", format)
+}
+
+// ParseRestaurantsCSV - reads restaurants from a CSV file whose first line names the columns. Column names are those
+// in RestaurantColumns; unknown columns, such as restaurant_id and overall_rating in an export, are ignored.
+func ParseRestaurantsCSV(r io.Reader) ([]ImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %s", err.Error())
+	}
+	index := map[string]int{}
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))] = i
+	}
+	for _, required := range []string{"name", "address"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("the header has no %s column", required)
+		}
+	}
+
+	var rows []ImportRow
+	for {
+		record, readErr := cr.Read()
+		if readErr == io.EOF {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		row := ImportRow{Line: line, Columns: map[string]bool{}}
+		if readErr != nil {
+			row.Errors = append(row.Errors, readErr.Error())
+			rows = append(rows, row)
+			continue
+		}
+		get := func(column string) string {
+			if i, ok := index[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		for _, column := range RestaurantColumns {
+			if get(column) != "" {
+				row.Columns[column] = true
+			}
+		}
+		rec := &row.Record
+		rec.Name = get("name")
+		rec.Address = get("address")
+		rec.ImageURL = get("image_url")
+		row.Errors = append(row.Errors, parseCSVFloat(get("latitude"), "latitude", &rec.Latitude)...)
+		row.Errors = append(row.Errors, parseCSVFloat(get("longitude"), "longitude", &rec.Longitude)...)
+		row.Errors = append(row.Errors, parseCSVFloat(get("price_for_two"), "price_for_two", &rec.PriceForTwo)...)
+		row.Errors = append(row.Errors, parseCSVBool(get("discount_available"), "discount_available", &rec.DiscountAvailable)...)
+		row.Errors = append(row.Errors, parseCSVBool(get("alcohol_available"), "alcohol_available", &rec.AlcoholAvailable)...)
+		row.Errors = append(row.Errors, parseCSVBool(get("portion_size_large"), "portion_size_large", &rec.PortionSizeLarge)...)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseCSVFloat(value, column string, dest *float64) []string {
+	if value == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %q is not a number", column, value)}
+	}
+	*dest = f
+	return nil
+}
+
+func parseCSVBool(value, column string, dest *bool) []string {
+	switch strings.ToLower(value) {
+	case "", "0", "false", "no", "n", "off":
+		*dest = false
+	case "1", "true", "yes", "y", "on":
+		*dest = true
+	default:
+		return []string{fmt.Sprintf("%s: %q is not a yes/no value", column, value)}
+	}
+	return nil
+}
+
+// ParseRestaurantsJSON - reads restaurants from a JSON array of objects with the keys in RestaurantColumns
+func ParseRestaurantsJSON(r io.Reader) ([]ImportRow, error) {
+	var elements []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&elements); err != nil {
+		return nil, fmt.Errorf("the file is not a JSON array: %s", err.Error())
+	}
+	rows := make([]ImportRow, 0, len(elements))
+	for i, element := range elements {
+		row := ImportRow{Line: i + 1, Columns: map[string]bool{}}
+		dec := json.NewDecoder(bytes.NewReader(element))
+		if err := dec.Decode(&row.Record); err != nil {
+			row.Errors = append(row.Errors, err.Error())
+		}
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(element, &fields) == nil {
+			for _, column := range RestaurantColumns {
+				if v, ok := fields[column]; ok && string(v) != "null" {
+					row.Columns[column] = true
+				}
+			}
+		}
+		row.Record.ID = 0
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ImportRestaurants - validates rows and creates or updates a restaurant for each valid one, matching existing
+// restaurants by name and address. Updates only change the columns a row gives. Rows are imported in a single
+// transaction which is rolled back when dryRun is set, so that a dry run reports exactly what a real import would do.
+// Created restaurants are ranked, which sets their overall rating, see RankRestaurant. Every change is written to the
+// audit log as made by actor.
+func ImportRestaurants(conn *sql.DB, rows []ImportRow, dryRun bool, actor AuditEntry) (ImportResult, error) {
+	result := ImportResult{DryRun: dryRun}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if dryRun || err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Created restaurants have no reviews yet, so they rank by the prior, see Ranking
+	rk, now := LoadRanking(), time.Now()
+	seen := map[string]int{}
+	for _, row := range rows {
+		row.Errors = append(row.Errors, row.Record.Validate()...)
+		key := strings.ToLower(strings.TrimSpace(row.Record.Name)) + "\x00" + strings.ToLower(strings.TrimSpace(row.Record.Address))
+		if line, ok := seen[key]; ok && len(row.Errors) == 0 {
+			row.Errors = append(row.Errors, fmt.Sprintf("same name and address as line %d", line))
+		}
+		if len(row.Errors) > 0 {
+			row.Action = ImportActionError
+			result.Failed++
+			result.Rows = append(result.Rows, row)
+			continue
+		}
+		seen[key] = row.Line
+
+		if err = upsertRestaurant(tx, &row, actor); err != nil {
+			return result, fmt.Errorf("error importing line %d: %s", row.Line, err.Error())
+		}
+		if row.Action == ImportActionCreate {
+			if err = RankRestaurant(tx, row.ID, rk, now); err != nil {
+				return result, fmt.Errorf("error importing line %d: %s", row.Line, err.Error())
+			}
+			if dryRun {
+				// the ID belongs to a row which is about to be rolled back
+				row.ID = 0
+			}
+			result.Created++
+		} else {
+			result.Updated++
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	if !dryRun {
+		err = tx.Commit()
+	}
+	return result, err
+}
+
+func upsertRestaurant(tx *sql.Tx, row *ImportRow, actor AuditEntry) error {
+	rec := row.Record
+	err := tx.QueryRow("SELECT restaurant_id FROM restaurants WHERE name = ? AND address = ? AND deleted_at IS NULL ORDER BY restaurant_id LIMIT 1", rec.Name, rec.Address).Scan(&row.ID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		res, insErr := tx.Exec("INSERT INTO restaurants (name, address, latitude, longitude, price_for_two, image_url, discount_available, alcohol_available, portion_size_large) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			rec.Name, rec.Address, rec.Latitude, rec.Longitude, rec.PriceForTwo, rec.ImageURL, rec.DiscountAvailable, rec.AlcoholAvailable, rec.PortionSizeLarge)
+		if insErr != nil {
+			return insErr
+		}
+		if row.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		row.Action = ImportActionCreate
+		actor.Action = AuditActionCreate
+	case err != nil:
+		return err
+	default:
+		if actor.Before, err = Snapshot(tx, "restaurants", "restaurant_id", row.ID); err != nil {
+			return err
+		}
+		// Only the columns the file gives are changed
+		var (
+			sets []string
+			args []interface{}
+		)
+		set := func(column string, value interface{}) {
+			if row.Columns[column] {
+				sets = append(sets, column+"=?")
+				args = append(args, value)
+			}
+		}
+		set("latitude", rec.Latitude)
+		set("longitude", rec.Longitude)
+		set("price_for_two", rec.PriceForTwo)
+		if row.Columns["image_url"] {
+			// an uploaded image is dropped when the file points its image_url elsewhere. MySQL assigns from left to
+			// right, so image_key has to be compared against image_url before the latter is changed
+			sets = append(sets, "image_key=IF(image_url <=> ?, image_key, NULL)", "image_url=?")
+			args = append(args, rec.ImageURL, rec.ImageURL)
+		}
+		set("discount_available", rec.DiscountAvailable)
+		set("alcohol_available", rec.AlcoholAvailable)
+		set("portion_size_large", rec.PortionSizeLarge)
+		if len(sets) > 0 {
+			_, err = tx.Exec("UPDATE restaurants SET "+strings.Join(sets, ", ")+" WHERE restaurant_id=?", append(args, row.ID)...)
+			if err != nil {
+				return err
+			}
+		}
+		row.Action = ImportActionUpdate
+		actor.Action = AuditActionUpdate
+	}
+
+	actor.Entity = "restaurants"
+	actor.EntityID = sql.NullInt64{Int64: row.ID, Valid: true}
+	if actor.After, err = Snapshot(tx, "restaurants", "restaurant_id", row.ID); err != nil {
+		return err
+	}
+	return WriteAuditEntry(tx, actor)
+}
+
+// ListRestaurantRecords - returns every restaurant which has not been deleted, for exports
+func ListRestaurantRecords(conn Queryer) ([]RestaurantRecord, error) {
+	rows, err := conn.Query("SELECT restaurant_id, name, address, latitude, longitude, overall_rating, COALESCE(price_for_two, 0), COALESCE(image_url, ''), discount_available, alcohol_available, portion_size_large FROM restaurants WHERE deleted_at IS NULL ORDER BY restaurant_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []RestaurantRecord
+	for rows.Next() {
+		var rec RestaurantRecord
+		err = rows.Scan(&rec.ID, &rec.Name, &rec.Address, &rec.Latitude, &rec.Longitude, &rec.OverallRating, &rec.PriceForTwo, &rec.ImageURL, &rec.DiscountAvailable, &rec.AlcoholAvailable, &rec.PortionSizeLarge)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// WriteRestaurants - writes records to w in the given format. The output can be imported again as it is.
+func WriteRestaurants(w io.Writer, records []RestaurantRecord, format string) error {
+	switch format {
+	case ImportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(append(append([]string{"restaurant_id"}, RestaurantColumns...), "overall_rating")); err != nil {
+			return err
+		}
+		for _, rec := range records {
+			err := cw.Write([]string{
+				strconv.FormatInt(rec.ID, 10),
+				rec.Name,
+				rec.Address,
+				strconv.FormatFloat(rec.Latitude, 'f', -1, 64),
+				strconv.FormatFloat(rec.Longitude, 'f', -1, 64),
+				strconv.FormatFloat(rec.PriceForTwo, 'f', -1, 64),
+				rec.ImageURL,
+				strconv.FormatBool(rec.DiscountAvailable),
+				strconv.FormatBool(rec.AlcoholAvailable),
+				strconv.FormatBool(rec.PortionSizeLarge),
+				strconv.FormatFloat(rec.OverallRating, 'f', -1, 64),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case ImportFormatJSON:
+		if records == nil {
+			records = []RestaurantRecord{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	return fmt.Errorf("unknown export format %q, use csv or json", format)
+}
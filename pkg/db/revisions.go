@@ -0,0 +1,122 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ActiveReviewSQL - the condition reviews AS rv must meet to be the active review of its user for its restaurant.
+// Reviews stop being active once they are deleted, rejected or superseded by a newer review.
+const ActiveReviewSQL = "rv.deleted_at IS NULL AND rv.superseded_by IS NULL AND rv.status <> '" + ReviewStatusRejected + "'"
+
+// ReviewRevision - a version of a review which was replaced by an edit
+type ReviewRevision struct {
+	ID           int64
+	ReviewID     int64
+	RestaurantID int64
+	UserID       int64
+	OverallScore float64
+	ReviewText   string
+	// EditedBy is the user whose edit replaced the version, invalid if it is unknown
+	EditedBy   sql.NullInt64
+	ReplacedAt time.Time
+	// RestaurantName and EditorName name the restaurant and the editor the IDs refer to
+	RestaurantName string
+	EditorName     sql.NullString
+}
+
+// ActiveReview - returns the ID and the creation time of the active review of the user userID for the restaurant
+// restaurantID, leaving out the review excludeID. The ID is 0 if there is none.
+func ActiveReview(conn Queryer, restaurantID, userID, excludeID int64) (int64, time.Time, error) {
+	return activeReview(conn, restaurantID, userID, excludeID, "")
+}
+
+// LockActiveReview - returns the same as ActiveReview, locking the user userID and their reviews of the restaurant
+// restaurantID until the transaction tx ends. Concurrent requests of the user thus wait for each other rather than
+// both finding that they may review the restaurant.
+func LockActiveReview(tx *sql.Tx, restaurantID, userID, excludeID int64) (int64, time.Time, error) {
+	var locked int64
+	err := tx.QueryRow("SELECT user_id FROM users WHERE user_id=? FOR UPDATE", userID).Scan(&locked)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, time.Time{}, err
+	}
+	return activeReview(tx, restaurantID, userID, excludeID, " FOR UPDATE")
+}
+
+func activeReview(conn Queryer, restaurantID, userID, excludeID int64, lock string) (int64, time.Time, error) {
+	var (
+		id        int64
+		createdAt time.Time
+	)
+	err := conn.QueryRow("SELECT rv.review_id, rv.created_at FROM reviews AS rv WHERE rv.restaurant_id=? AND rv.user_id=? AND rv.review_id<>? AND "+ActiveReviewSQL+" ORDER BY rv.created_at DESC, rv.review_id DESC LIMIT 1"+lock,
+		restaurantID, userID, excludeID).Scan(&id, &createdAt)
+	if err == sql.ErrNoRows {
+		return 0, createdAt, nil
+	}
+	return id, createdAt, err
+}
+
+// EarlierActiveReviews - returns the IDs of the active reviews the user of the review reviewID wrote for its
+// restaurant before it, which it supersedes once it is approved. There are none if the review is not active itself.
+func EarlierActiveReviews(conn Queryer, reviewID int64) ([]int64, error) {
+	rows, err := conn.Query(`SELECT rv.review_id FROM reviews AS nw JOIN reviews AS rv ON rv.restaurant_id=nw.restaurant_id AND rv.user_id=nw.user_id
+		AND (rv.created_at < nw.created_at OR (rv.created_at = nw.created_at AND rv.review_id < nw.review_id))
+		WHERE nw.review_id=? AND nw.deleted_at IS NULL AND nw.superseded_by IS NULL AND nw.status <> ? AND `+ActiveReviewSQL+` ORDER BY rv.review_id`,
+		reviewID, ReviewStatusRejected)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SupersedeReview - marks the review reviewID as replaced by the newer review newID of the same user
+func SupersedeReview(conn Queryer, reviewID, newID int64) error {
+	_, err := conn.Exec("UPDATE reviews SET superseded_by=? WHERE review_id=?", newID, reviewID)
+	return err
+}
+
+// SaveReviewRevision - keeps the current version of the review reviewID before the user editorID replaces it with the
+// given values. Nothing is kept if none of them differ from the current ones. It reports whether a revision was saved.
+func SaveReviewRevision(conn Queryer, reviewID, editorID, restaurantID, userID int64, overallScore float64, reviewText string) (bool, error) {
+	res, err := conn.Exec(`INSERT INTO review_revisions (review_id, restaurant_id, user_id, overall_score, review_text, edited_by, replaced_at)
+		SELECT review_id, restaurant_id, user_id, overall_score, review_text, NULLIF(?, 0), CURRENT_TIMESTAMP FROM reviews
+		WHERE review_id=? AND NOT (restaurant_id=? AND user_id=? AND overall_score=CAST(? AS DECIMAL(3,2)) AND COALESCE(review_text, '')=?)`,
+		editorID, reviewID, restaurantID, userID, overallScore, reviewText)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListReviewRevisions - returns the earlier versions of the review reviewID, oldest first
+func ListReviewRevisions(conn Queryer, reviewID int64) ([]ReviewRevision, error) {
+	rows, err := conn.Query(`SELECT rr.review_revision_id, rr.review_id, rr.restaurant_id, rr.user_id, rr.overall_score, COALESCE(rr.review_text, ''), rr.edited_by, rr.replaced_at,
+		COALESCE(rs.name, CONCAT('#', rr.restaurant_id)), COALESCE(NULLIF(u.email, ''), NULLIF(u.mobile_number, ''), CONCAT('#', u.user_id))
+		FROM review_revisions AS rr LEFT JOIN restaurants AS rs ON rr.restaurant_id=rs.restaurant_id LEFT JOIN users AS u ON rr.edited_by=u.user_id
+		WHERE rr.review_id=? ORDER BY rr.replaced_at, rr.review_revision_id`, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []ReviewRevision
+	for rows.Next() {
+		var rr ReviewRevision
+		if err = rows.Scan(&rr.ID, &rr.ReviewID, &rr.RestaurantID, &rr.UserID, &rr.OverallScore, &rr.ReviewText, &rr.EditedBy, &rr.ReplacedAt, &rr.RestaurantName, &rr.EditorName); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rr)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ScaleLabelSeparator - separates the labels of a scale in metric_types.scale_labels
+const ScaleLabelSeparator = "|"
+
+// maxScaleValues - scales with more steps than this are not offered value by value, e.g. as stars
+const maxScaleValues = 20
+
+// NormalizedScoreSQL - the score of the metric_reviews row mr on the 0 to 1 range of the scale of the metric_types row
+// mt, for aggregating scores of metrics of different types. Scores of empty scales normalize to NULL.
+const NormalizedScoreSQL = "LEAST(GREATEST((mr.score - mt.scale_min) / NULLIF(mt.scale_max - mt.scale_min, 0), 0), 1)"
+
+// The ways a score can be off its scale, returned by MetricScale.Validate
+var (
+	ErrScoreOutOfRange = errors.New("score out of range")
+	ErrScoreOffStep    = errors.New("score between steps")
+	ErrScoreNotBoolean = errors.New("score neither yes nor no")
+)
+
+// MetricScale - the values the scores of the metrics of a metric type can take
+type MetricScale struct {
+	Min, Max float64
+	// Step is the difference between successive values, 0 for any value from Min to Max
+	Step float64
+	// Labels name the values from Min upwards, one per step
+	Labels []string
+	// Boolean scales only take Min, for no, and Max, for yes
+	Boolean bool
+}
+
+// ParseScaleLabels - splits the labels stored in metric_types.scale_labels
+func ParseScaleLabels(s string) []string {
+	var labels []string
+	for _, l := range strings.Split(s, ScaleLabelSeparator) {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// LabelsString - returns the labels of s the way they are stored in metric_types.scale_labels
+func (s MetricScale) LabelsString() string {
+	return strings.Join(s.Labels, ScaleLabelSeparator)
+}
+
+// Check - returns an error if s cannot hold any score
+func (s MetricScale) Check() error {
+	if s.Max <= s.Min {
+		return fmt.Errorf("the maximum %g is not above the minimum %g", s.Max, s.Min)
+	}
+	if s.Step < 0 {
+		return fmt.Errorf("the step %g is negative", s.Step)
+	}
+	return nil
+}
+
+// Validate - returns ErrScoreOutOfRange, ErrScoreOffStep or ErrScoreNotBoolean if score is not a value of s
+func (s MetricScale) Validate(score float64) error {
+	if s.Boolean {
+		if score != s.Min && score != s.Max {
+			return ErrScoreNotBoolean
+		}
+		return nil
+	}
+	if score < s.Min || score > s.Max {
+		return ErrScoreOutOfRange
+	}
+	if s.Step > 0 {
+		steps := (score - s.Min) / s.Step
+		if math.Abs(steps-math.Round(steps)) > 1e-6 {
+			return ErrScoreOffStep
+		}
+	}
+	return nil
+}
+
+// Normalize - returns score on the range from 0, for Min, to 1, for Max. Scores off the scale are clamped to it.
+func (s MetricScale) Normalize(score float64) float64 {
+	if s.Max <= s.Min {
+		return 0
+	}
+	return math.Min(math.Max((score-s.Min)/(s.Max-s.Min), 0), 1)
+}
+
+// Values - returns every value of s from Min upwards, or nil if s takes any value or too many of them to list
+func (s MetricScale) Values() []float64 {
+	if s.Boolean {
+		return []float64{s.Min, s.Max}
+	}
+	if s.Step <= 0 || (s.Max-s.Min)/s.Step > maxScaleValues {
+		return nil
+	}
+	var values []float64
+	for i := 0; ; i++ {
+		v := s.Min + float64(i)*s.Step
+		if v > s.Max+1e-9 {
+			break
+		}
+		values = append(values, math.Round(v*100)/100)
+	}
+	return values
+}
+
+// Label - returns the label of the value v of s, or an empty string if it has none
+func (s MetricScale) Label(v float64) string {
+	var i int
+	switch {
+	case s.Boolean && v == s.Max:
+		i = 1
+	case s.Boolean:
+		i = 0
+	case s.Step > 0:
+		i = int(math.Round((v - s.Min) / s.Step))
+	default:
+		return ""
+	}
+	if i < 0 || i >= len(s.Labels) {
+		return ""
+	}
+	return s.Labels[i]
+}
+
+// MetricScaleOf - returns the scale of the metric type of the metric metricID
+func MetricScaleOf(conn Queryer, metricID int64) (MetricScale, error) {
+	var (
+		s      MetricScale
+		labels string
+	)
+	err := conn.QueryRow("SELECT mt.scale_min, mt.scale_max, mt.scale_step, COALESCE(mt.scale_labels, ''), mt.is_boolean FROM metrics AS m JOIN metric_types AS mt ON m.metric_type_id=mt.metric_type_id WHERE m.metric_id=?", metricID).
+		Scan(&s.Min, &s.Max, &s.Step, &labels, &s.Boolean)
+	s.Labels = ParseScaleLabels(labels)
+	return s, err
+}
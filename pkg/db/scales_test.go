@@ -0,0 +1,114 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMetricScaleValidate(t *testing.T) {
+	stars := MetricScale{Min: 1, Max: 5, Step: 1}
+	halves := MetricScale{Min: 0, Max: 10, Step: 0.5}
+	percent := MetricScale{Min: 0, Max: 100}
+	yesNo := MetricScale{Min: 0, Max: 1, Boolean: true}
+	tests := []struct {
+		name  string
+		scale MetricScale
+		score float64
+		want  error
+	}{
+		{"minimum", stars, 1, nil},
+		{"maximum", stars, 5, nil},
+		{"below the minimum", stars, 0, ErrScoreOutOfRange},
+		{"above the maximum", stars, 5.5, ErrScoreOutOfRange},
+		{"between steps", stars, 3.5, ErrScoreOffStep},
+		{"half step", halves, 7.5, nil},
+		{"off a half step", halves, 7.25, ErrScoreOffStep},
+		{"step with rounding error", MetricScale{Min: 0, Max: 1, Step: 0.1}, 0.3, nil},
+		{"any value", percent, 42.42, nil},
+		{"any value out of range", percent, -0.01, ErrScoreOutOfRange},
+		{"no", yesNo, 0, nil},
+		{"yes", yesNo, 1, nil},
+		{"neither yes nor no", yesNo, 0.5, ErrScoreNotBoolean},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scale.Validate(tt.score); !errors.Is(got, tt.want) {
+				t.Errorf("Validate(%v) = %v, want %v", tt.score, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricScaleNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		scale MetricScale
+		score float64
+		want  float64
+	}{
+		{"minimum", MetricScale{Min: 1, Max: 5}, 1, 0},
+		{"maximum", MetricScale{Min: 1, Max: 5}, 5, 1},
+		{"middle", MetricScale{Min: 1, Max: 5}, 3, 0.5},
+		{"negative minimum", MetricScale{Min: -2, Max: 2}, 1, 0.75},
+		{"clamped below", MetricScale{Min: 1, Max: 5}, -3, 0},
+		{"clamped above", MetricScale{Min: 1, Max: 5}, 9, 1},
+		{"empty scale", MetricScale{Min: 3, Max: 3}, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scale.Normalize(tt.score); got != tt.want {
+				t.Errorf("Normalize(%v) = %v, want %v", tt.score, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricScaleValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		scale MetricScale
+		want  []float64
+	}{
+		{"stars", MetricScale{Min: 1, Max: 5, Step: 1}, []float64{1, 2, 3, 4, 5}},
+		{"tenths", MetricScale{Min: 0, Max: 0.5, Step: 0.1}, []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5}},
+		{"step past the maximum", MetricScale{Min: 0, Max: 5, Step: 2}, []float64{0, 2, 4}},
+		{"boolean", MetricScale{Min: 0, Max: 1, Boolean: true}, []float64{0, 1}},
+		{"any value", MetricScale{Min: 0, Max: 10}, nil},
+		{"as many as can be listed", MetricScale{Min: 0, Max: 20, Step: 1}, []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}},
+		{"too many to list", MetricScale{Min: 0, Max: 21, Step: 1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scale.Values(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Values() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricScaleLabel(t *testing.T) {
+	stars := MetricScale{Min: 1, Max: 5, Step: 1, Labels: []string{"Poor", "Fair", "Good", "Very good", "Excellent"}}
+	tests := []struct {
+		name  string
+		scale MetricScale
+		v     float64
+		want  string
+	}{
+		{"first", stars, 1, "Poor"},
+		{"last", stars, 5, "Excellent"},
+		{"rounded to the nearest step", stars, 2.9, "Good"},
+		{"below the scale", stars, 0, ""},
+		{"past the labels", MetricScale{Min: 1, Max: 5, Step: 1, Labels: []string{"Poor", "Fair"}}, 4, ""},
+		{"no", MetricScale{Min: 0, Max: 1, Boolean: true, Labels: []string{"No", "Yes"}}, 0, "No"},
+		{"yes", MetricScale{Min: 0, Max: 1, Boolean: true, Labels: []string{"No", "Yes"}}, 1, "Yes"},
+		{"any value", MetricScale{Min: 0, Max: 10, Labels: []string{"Low"}}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scale.Label(tt.v); got != tt.want {
+				t.Errorf("Label(%v) = %q, want %q", tt.v, got, tt.want)
+			}
+		})
+	}
+}
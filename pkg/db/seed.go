@@ -21,6 +21,19 @@ var seedDBQueries = []string{
 	   (2,"admin@example.com","+919999341745",1,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,'0.0.0.0'),
 	   (3,"user@example.com","+919844629772",1,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,'0.0.0.0')
 	ON DUPLICATE KEY UPDATE user_id=user_id;`,
+
+	// Display types, the input control of each is picked by its widget
+	`INSERT INTO display_types (display_type_name,widget)
+	VALUES("Stars","stars"),("Slider","slider"),("Yes/No","yes_no"),("Percentage","percentage")
+	ON DUPLICATE KEY UPDATE display_type_id=display_type_id;`,
+
+	// Metric types master table, with the scale of each, see MetricScale
+	`INSERT INTO metric_types (metric_type_name,scale_min,scale_max,scale_step,scale_labels,is_boolean)
+	VALUES
+	   ("rating",1,5,1,"Poor|Fair|Good|Very good|Excellent",false),
+	   ("boolean",0,1,1,"No|Yes",true),
+	   ("numeric",0,100,0,NULL,false)
+	ON DUPLICATE KEY UPDATE metric_type_id=metric_type_id;`,
 }
 
 func SeedDB(u *utils.Utils) error {
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ReviewOpinion - the text of a review along with the overall score it should agree with
+type ReviewOpinion struct {
+	ReviewID     int64
+	Text         string
+	OverallScore float64
+	// Cleared is set for reviews a moderator approved
+	Cleared bool
+}
+
+// ReviewMention - an aspect term used in the text of a review, stored in review_mentions
+type ReviewMention struct {
+	Term     string
+	Aspect   string
+	Mentions int
+	// Score is the sentiment of the sentences the term was used in, from -1 to 1
+	Score float64
+}
+
+// TermSummary - how often the reviews of a restaurant use a term and what they think of it
+type TermSummary struct {
+	Term    string
+	Reviews int
+	Score   float64
+}
+
+// AspectSummary - how many reviews of a restaurant talk about an aspect and what they think of it, along with the
+// terms they use most
+type AspectSummary struct {
+	Aspect  string
+	Reviews int
+	// Score is the mean sentiment of the reviews about the aspect, from -1 to 1
+	Score float64
+	Terms []TermSummary
+}
+
+// LoadReviewOpinion - returns the text and the overall score of the review reviewID
+func LoadReviewOpinion(conn Queryer, reviewID int64) (ReviewOpinion, error) {
+	o := ReviewOpinion{ReviewID: reviewID}
+	err := conn.QueryRow("SELECT COALESCE(review_text, ''), overall_score, status=? AND moderated_at IS NOT NULL FROM reviews WHERE review_id=?", ReviewStatusApproved, reviewID).
+		Scan(&o.Text, &o.OverallScore, &o.Cleared)
+	return o, err
+}
+
+// SaveReviewSentiment - stores the sentiment score of the review reviewID along with the aspect terms its text uses,
+// replacing those found before
+func SaveReviewSentiment(conn Queryer, reviewID int64, score float64, mentions []ReviewMention) error {
+	if _, err := conn.Exec("UPDATE reviews SET sentiment_score=? WHERE review_id=?", score, reviewID); err != nil {
+		return err
+	}
+	if _, err := conn.Exec("DELETE FROM review_mentions WHERE review_id=?", reviewID); err != nil {
+		return err
+	}
+	for _, m := range mentions {
+		_, err := conn.Exec("INSERT INTO review_mentions (review_id, term, aspect, mentions, sentiment_score) VALUES (?, LEFT(?, 50), ?, ?, ?)",
+			reviewID, m.Term, m.Aspect, m.Mentions, m.Score)
+		if err != nil {
+			return fmt.Errorf("error storing mention of %q: %s", m.Term, err.Error())
+		}
+	}
+	return nil
+}
+
+// RestaurantMentions - summarizes what the approved, active reviews of the restaurant restaurantID say about each
+// aspect, most talked about first, with up to terms of the terms used for each
+func RestaurantMentions(conn Queryer, restaurantID int64, terms int) ([]AspectSummary, error) {
+	// Reviews using several terms of an aspect count once towards it, with the mean of their scores
+	rows, err := conn.Query(`SELECT t.aspect, COUNT(*), AVG(t.score) FROM (
+			SELECT m.review_id, m.aspect, SUM(m.sentiment_score * m.mentions) / SUM(m.mentions) AS score
+			FROM review_mentions AS m JOIN reviews AS rv ON m.review_id=rv.review_id
+			WHERE rv.restaurant_id=? AND rv.status=? AND `+ActiveReviewSQL+`
+			GROUP BY m.review_id, m.aspect
+		) AS t GROUP BY t.aspect`, restaurantID, ReviewStatusApproved)
+	if err != nil {
+		return nil, fmt.Errorf("error querying aspects: %s", err.Error())
+	}
+	defer rows.Close()
+	var summaries []AspectSummary
+	index := make(map[string]int)
+	for rows.Next() {
+		var s AspectSummary
+		if err := rows.Scan(&s.Aspect, &s.Reviews, &s.Score); err != nil {
+			return nil, fmt.Errorf("error scanning aspect: %s", err.Error())
+		}
+		index[s.Aspect] = len(summaries)
+		summaries = append(summaries, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = conn.Query(`SELECT m.aspect, m.term, COUNT(*), AVG(m.sentiment_score)
+		FROM review_mentions AS m JOIN reviews AS rv ON m.review_id=rv.review_id
+		WHERE rv.restaurant_id=? AND rv.status=? AND `+ActiveReviewSQL+`
+		GROUP BY m.aspect, m.term ORDER BY COUNT(*) DESC, m.term`, restaurantID, ReviewStatusApproved)
+	if err != nil {
+		return nil, fmt.Errorf("error querying terms: %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			aspect string
+			t      TermSummary
+		)
+		if err := rows.Scan(&aspect, &t.Term, &t.Reviews, &t.Score); err != nil {
+			return nil, fmt.Errorf("error scanning term: %s", err.Error())
+		}
+		if i, ok := index[aspect]; ok && len(summaries[i].Terms) < terms {
+			summaries[i].Terms = append(summaries[i].Terms, t)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Reviews > summaries[j].Reviews
+	})
+	return summaries, nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"time"
+)
+
+const (
+	// OTPDeliverySent and OTPDeliveryFailed are the values of otp_requests.delivery_status once sending the OTP has
+	// been attempted. Requests made before the column existed have an empty status.
+	OTPDeliverySent   = "sent"
+	OTPDeliveryFailed = "failed"
+)
+
+// Totals - the number of live rows of the main tables
+type Totals struct {
+	Restaurants int64
+	Users       int64
+	Reviews     int64
+}
+
+// CountTotals - counts the restaurants, users and reviews which have not been deleted
+func CountTotals(conn Queryer) (Totals, error) {
+	var t Totals
+	err := conn.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM restaurants WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM users WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM reviews WHERE deleted_at IS NULL)`).Scan(&t.Restaurants, &t.Users, &t.Reviews)
+	return t, err
+}
+
+// CountReviewsSince - counts the reviews written at or after since which have not been deleted
+func CountReviewsSince(conn Queryer, since time.Time) (int64, error) {
+	var n int64
+	err := conn.QueryRow("SELECT COUNT(*) FROM reviews WHERE deleted_at IS NULL AND created_at >= ?", since).Scan(&n)
+	return n, err
+}
+
+// RestaurantRating - the average overall score of the reviews of a restaurant, along with its ranking score
+type RestaurantRating struct {
+	RestaurantID int64
+	Name         string
+	Rating       float64
+	// Score is the Bayesian average of the overall scores, see Ranking
+	Score   float64
+	Reviews int
+}
+
+// RateRestaurants - returns up to limit restaurants with at least minReviews approved reviews written since since,
+// best ranked first by rk as of now, or worst ranked first when ascending is set. Reviews superseded by a newer one of
+// the same user and reviews of deleted users do not count.
+func RateRestaurants(conn Queryer, since time.Time, minReviews, limit int, ascending bool, rk Ranking, now time.Time) ([]RestaurantRating, error) {
+	order := "DESC"
+	if ascending {
+		order = "ASC"
+	}
+	expr, args := rk.scoreSQL("rv.overall_score", "rv.created_at", now)
+	args = append(args, ReviewStatusApproved, since, minReviews, limit)
+	rows, err := conn.Query(`SELECT rs.restaurant_id, rs.name, AVG(rv.overall_score), COALESCE(`+expr+`, AVG(rv.overall_score)), COUNT(*)
+		FROM reviews AS rv JOIN restaurants AS rs ON rv.restaurant_id=rs.restaurant_id JOIN users AS u ON rv.user_id=u.user_id
+		WHERE rv.deleted_at IS NULL AND rs.deleted_at IS NULL AND u.deleted_at IS NULL AND rv.status = ? AND rv.superseded_by IS NULL AND rv.created_at >= ?
+		GROUP BY rs.restaurant_id, rs.name
+		HAVING COUNT(*) >= ?
+		ORDER BY 4 `+order+`, 5 DESC, rs.name
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ratings []RestaurantRating
+	for rows.Next() {
+		var rr RestaurantRating
+		if err = rows.Scan(&rr.RestaurantID, &rr.Name, &rr.Rating, &rr.Score, &rr.Reviews); err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, rr)
+	}
+	return ratings, rows.Err()
+}
+
+// Reviewer - a user and the number of reviews they wrote
+type Reviewer struct {
+	UserID  int64
+	Name    string
+	Reviews int
+}
+
+// ActiveReviewers - returns up to limit users who wrote the most reviews since since
+func ActiveReviewers(conn Queryer, since time.Time, limit int) ([]Reviewer, error) {
+	rows, err := conn.Query(`SELECT u.user_id, COALESCE(NULLIF(u.email, ''), NULLIF(u.mobile_number, ''), CONCAT('#', u.user_id)), COUNT(*)
+		FROM reviews AS rv JOIN users AS u ON rv.user_id=u.user_id
+		WHERE rv.deleted_at IS NULL AND u.deleted_at IS NULL AND rv.created_at >= ?
+		GROUP BY u.user_id, u.email, u.mobile_number
+		ORDER BY 3 DESC, u.user_id
+		LIMIT ?`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviewers []Reviewer
+	for rows.Next() {
+		var rv Reviewer
+		if err = rows.Scan(&rv.UserID, &rv.Name, &rv.Reviews); err != nil {
+			return nil, err
+		}
+		reviewers = append(reviewers, rv)
+	}
+	return reviewers, rows.Err()
+}
+
+// DayCount - numbers counted over a day. Failed is only used for OTP requests.
+type DayCount struct {
+	Day    time.Time
+	Count  int64
+	Failed int64
+}
+
+// ReviewsPerDay - counts the reviews written on each day since since. Days without reviews are left out.
+func ReviewsPerDay(conn Queryer, since time.Time) ([]DayCount, error) {
+	return queryDayCounts(conn, `SELECT DATE(created_at), COUNT(*), 0 FROM reviews
+		WHERE deleted_at IS NULL AND created_at >= ? GROUP BY 1 ORDER BY 1`, since)
+}
+
+// OTPRequestsPerDay - counts the OTPs requested on each day since since, and how many of them could not be sent.
+// Days without requests are left out.
+func OTPRequestsPerDay(conn Queryer, since time.Time) ([]DayCount, error) {
+	return queryDayCounts(conn, `SELECT DATE(requested_at), COUNT(*), COALESCE(SUM(delivery_status = ?), 0) FROM otp_requests
+		WHERE requested_at >= ? GROUP BY 1 ORDER BY 1`, OTPDeliveryFailed, since)
+}
+
+// queryDayCounts - runs query, which selects a date and two counts, and returns its rows
+func queryDayCounts(conn Queryer, query string, args ...interface{}) ([]DayCount, error) {
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []DayCount
+	for rows.Next() {
+		var dc DayCount
+		if err = rows.Scan(&dc.Day, &dc.Count, &dc.Failed); err != nil {
+			return nil, err
+		}
+		counts = append(counts, dc)
+	}
+	return counts, rows.Err()
+}
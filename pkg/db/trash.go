@@ -0,0 +1,140 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	AuditActionRestore = "restore"
+	AuditActionPurge   = "purge"
+
+	// DefaultSoftDeleteRetentionDays is how long soft-deleted records are kept when nothing has been configured
+	DefaultSoftDeleteRetentionDays = 30
+)
+
+// SoftDeleteEntity - a table whose rows are marked as deleted through its deleted_at column instead of being removed
+type SoftDeleteEntity struct {
+	Table    string
+	PKColumn string
+	// LabelExpr is the SQL expression used to describe a row in the trash
+	LabelExpr string
+	// PurgeGuard, if set, is an SQL condition rows have to meet to be purged, keeping back the ones whose purge would
+	// cascade to rows which are still in use
+	PurgeGuard string
+}
+
+// SoftDeleteEntities - the soft-deletable tables, in the order in which they are purged. Reviews go before
+// restaurants and users so that purging the latter does not have to cascade through rows which are purged anyway.
+var SoftDeleteEntities = []SoftDeleteEntity{
+	{Table: "reviews", PKColumn: "review_id", LabelExpr: "LEFT(COALESCE(review_text, ''), 80)"},
+	// Purging a metric cascades to its sub-metrics, so metrics whose sub-metrics are still in use are kept
+	{Table: "metrics", PKColumn: "metric_id", LabelExpr: "metric_name", PurgeGuard: "NOT EXISTS (SELECT 1 FROM metrics AS sub WHERE sub.parent_metric_id = metrics.metric_id AND sub.deleted_at IS NULL)"},
+	{Table: "restaurants", PKColumn: "restaurant_id", LabelExpr: "name"},
+	{Table: "users", PKColumn: "user_id", LabelExpr: "COALESCE(email, mobile_number, '')"},
+}
+
+// GetSoftDeleteEntity - returns the soft-deletable entity backed by table
+func GetSoftDeleteEntity(table string) (SoftDeleteEntity, bool) {
+	for _, e := range SoftDeleteEntities {
+		if e.Table == table {
+			return e, true
+		}
+	}
+	return SoftDeleteEntity{}, false
+}
+
+// TrashItem - a soft-deleted row
+type TrashItem struct {
+	Entity    string
+	ID        int64
+	Label     string
+	DeletedAt time.Time
+}
+
+// ListTrash - returns the soft-deleted rows of entity, most recently deleted first
+func ListTrash(conn *sql.DB, entity SoftDeleteEntity) ([]TrashItem, error) {
+	rows, err := conn.Query(fmt.Sprintf("SELECT %s, %s, deleted_at FROM %s WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC", entity.PKColumn, entity.LabelExpr, entity.Table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []TrashItem
+	for rows.Next() {
+		item := TrashItem{Entity: entity.Table}
+		var label sql.NullString
+		if err = rows.Scan(&item.ID, &label, &item.DeletedAt); err != nil {
+			return nil, err
+		}
+		item.Label = label.String
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Restore - clears the deletion mark of the row of entity identified by id. It returns sql.ErrNoRows when there is
+// no such deleted row.
+func Restore(conn *sql.DB, entity SoftDeleteEntity, id int64) error {
+	res, err := conn.Exec(fmt.Sprintf("UPDATE %s SET deleted_at = NULL WHERE %s = ? AND deleted_at IS NOT NULL", entity.Table, entity.PKColumn), id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Purge - permanently deletes every row which was soft-deleted before olderThan, recording each one in the audit log.
+// Dependent rows are removed by the ON DELETE CASCADE foreign keys. It returns the number of purged rows per table.
+func Purge(conn *sql.DB, olderThan time.Time) (map[string]int, error) {
+	purged := map[string]int{}
+	for _, entity := range SoftDeleteEntities {
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE deleted_at IS NOT NULL AND deleted_at < ?", entity.PKColumn, entity.Table)
+		if entity.PurgeGuard != "" {
+			query += " AND " + entity.PurgeGuard
+		}
+		rows, err := conn.Query(query, olderThan)
+		if err != nil {
+			return purged, err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return purged, err
+			}
+			ids = append(ids, id)
+		}
+		_ = rows.Close()
+
+		for _, id := range ids {
+			before, snapErr := Snapshot(conn, entity.Table, entity.PKColumn, id)
+			if snapErr != nil {
+				return purged, snapErr
+			}
+			if _, err = conn.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND deleted_at IS NOT NULL", entity.Table, entity.PKColumn), id); err != nil {
+				return purged, err
+			}
+			purged[entity.Table]++
+			err = WriteAuditEntry(conn, AuditEntry{
+				Actor:    "purge",
+				Action:   AuditActionPurge,
+				Entity:   entity.Table,
+				EntityID: sql.NullInt64{Int64: id, Valid: true},
+				Before:   before,
+			})
+			if err != nil {
+				return purged, err
+			}
+		}
+	}
+	return purged, nil
+}
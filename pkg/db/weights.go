@@ -0,0 +1,215 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// OverallScoreMax - the highest overall score of a review. Overall scores derived from metric scores are the weighted
+// normalized score of the top-level metrics on the range from 0 to OverallScoreMax.
+const OverallScoreMax = 5.0
+
+// ErrMetricCycle - setting the parent would make a metric its own ancestor
+var ErrMetricCycle = errors.New("metric would become its own ancestor")
+
+// WeightedMetric - a metric of the hierarchy scores are combined along
+type WeightedMetric struct {
+	ID       int64
+	ParentID sql.NullInt64
+	Name     string
+	// Weight is the share of the metric among its siblings relative to theirs. Metrics of weight 0 are left out.
+	Weight float64
+	Scale  MetricScale
+}
+
+// MetricHierarchy - metrics which have not been deleted, by ID, along with the children of each. Metrics whose parent
+// has been deleted are treated as top-level metrics.
+type MetricHierarchy struct {
+	Metrics  map[int64]WeightedMetric
+	Children map[int64][]int64
+	// Roots are the top-level metrics, in their sort order
+	Roots []int64
+}
+
+// LoadMetricHierarchy - loads the metrics which have not been deleted, with the weights and scales of each
+func LoadMetricHierarchy(conn Queryer) (MetricHierarchy, error) {
+	h := MetricHierarchy{Metrics: make(map[int64]WeightedMetric), Children: make(map[int64][]int64)}
+	rows, err := conn.Query("SELECT m.metric_id, m.parent_metric_id, m.metric_name, m.weight, mt.scale_min, mt.scale_max, mt.scale_step, COALESCE(mt.scale_labels, ''), mt.is_boolean FROM metrics AS m JOIN metric_types AS mt ON m.metric_type_id=mt.metric_type_id WHERE m.deleted_at IS NULL ORDER BY m.sort_order, m.metric_id")
+	if err != nil {
+		return h, err
+	}
+	defer rows.Close()
+	var order []int64
+	for rows.Next() {
+		var (
+			m      WeightedMetric
+			labels string
+		)
+		if err := rows.Scan(&m.ID, &m.ParentID, &m.Name, &m.Weight, &m.Scale.Min, &m.Scale.Max, &m.Scale.Step, &labels, &m.Scale.Boolean); err != nil {
+			return h, err
+		}
+		m.Scale.Labels = ParseScaleLabels(labels)
+		h.Metrics[m.ID] = m
+		order = append(order, m.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return h, err
+	}
+	for _, id := range order {
+		m := h.Metrics[id]
+		if _, ok := h.Metrics[m.ParentID.Int64]; m.ParentID.Valid && ok && m.ParentID.Int64 != id {
+			h.Children[m.ParentID.Int64] = append(h.Children[m.ParentID.Int64], id)
+			continue
+		}
+		h.Roots = append(h.Roots, id)
+	}
+	return h, nil
+}
+
+// weightedMean - returns the mean of the values of ids weighted by their weights, skipping the ones value has none for
+func (h MetricHierarchy) weightedMean(ids []int64, value func(id int64) (float64, bool)) (float64, bool) {
+	var sum, weights float64
+	for _, id := range ids {
+		w := h.Metrics[id].Weight
+		if w <= 0 {
+			continue
+		}
+		if v, ok := value(id); ok {
+			sum += v * w
+			weights += w
+		}
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return sum / weights, true
+}
+
+// Scores - returns the normalized score of every metric which is scored, either directly in scores, which holds the
+// raw scores of a review by metric ID, or through its sub-metrics. A metric with scored sub-metrics takes the weighted
+// mean of theirs, any other the normalized score it was given. The second value is the weighted mean of the scores of
+// the top-level metrics on the 0 to 1 range, invalid if none of them is scored.
+func (h MetricHierarchy) Scores(scores map[int64]float64) (map[int64]float64, sql.NullFloat64) {
+	derived := make(map[int64]float64)
+	visiting := make(map[int64]bool)
+	var score func(id int64) (float64, bool)
+	score = func(id int64) (float64, bool) {
+		if v, ok := derived[id]; ok {
+			return v, true
+		}
+		// The hierarchy is kept free of cycles when parents are set, this only guards against older data
+		if visiting[id] {
+			return 0, false
+		}
+		visiting[id] = true
+		v, ok := h.weightedMean(h.Children[id], score)
+		if !ok {
+			var raw float64
+			if raw, ok = scores[id]; ok {
+				v = h.Metrics[id].Scale.Normalize(raw)
+			}
+		}
+		if ok {
+			derived[id] = v
+		}
+		return v, ok
+	}
+	for id := range h.Metrics {
+		score(id)
+	}
+	overall, ok := h.weightedMean(h.Roots, func(id int64) (float64, bool) {
+		v, ok := derived[id]
+		return v, ok
+	})
+	return derived, sql.NullFloat64{Float64: overall, Valid: ok}
+}
+
+// Shares - returns the share of every metric in the overall score, when all metrics are scored, and the share of every
+// metric among its siblings, both from 0 to 1
+func (h MetricHierarchy) Shares() (overall map[int64]float64, ofParent map[int64]float64) {
+	overall = make(map[int64]float64)
+	ofParent = make(map[int64]float64)
+	// Metrics caught in a cycle are no root's descendants, so the recursion ends
+	var share func(ids []int64, parentShare float64)
+	share = func(ids []int64, parentShare float64) {
+		var weights float64
+		for _, id := range ids {
+			if w := h.Metrics[id].Weight; w > 0 {
+				weights += w
+			}
+		}
+		for _, id := range ids {
+			if w := h.Metrics[id].Weight; w > 0 {
+				ofParent[id] = w / weights
+			}
+			overall[id] = parentShare * ofParent[id]
+			share(h.Children[id], overall[id])
+		}
+	}
+	share(h.Roots, 1)
+	return overall, ofParent
+}
+
+// CheckMetricParent - returns ErrMetricCycle if making parentID the parent of the metric metricID would make the
+// metric its own ancestor. metricID is 0 for metrics which have not been created yet.
+func CheckMetricParent(conn Queryer, metricID, parentID int64) error {
+	if metricID == 0 {
+		return nil
+	}
+	seen := make(map[int64]bool)
+	for id := parentID; id != 0; {
+		if id == metricID {
+			return ErrMetricCycle
+		}
+		if seen[id] {
+			// An older cycle above the parent, which does not involve the metric
+			return nil
+		}
+		seen[id] = true
+		var parent sql.NullInt64
+		err := conn.QueryRow("SELECT parent_metric_id FROM metrics WHERE metric_id=?", id).Scan(&parent)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching parent of metric %d: %s", id, err.Error())
+		}
+		id = parent.Int64
+	}
+	return nil
+}
+
+// UpdateOverallScore - sets the overall score of the review reviewID to the weighted score of its metric scores, if
+// any of its top-level metrics is scored. Reviews without such scores keep the overall score they were given. The
+// overall score set is returned, invalid if it was kept.
+func UpdateOverallScore(conn Queryer, h MetricHierarchy, reviewID int64) (sql.NullFloat64, error) {
+	rows, err := conn.Query("SELECT metric_id, score FROM metric_reviews WHERE review_id=? ORDER BY metric_review_id", reviewID)
+	if err != nil {
+		return sql.NullFloat64{}, err
+	}
+	defer rows.Close()
+	scores := make(map[int64]float64)
+	for rows.Next() {
+		var (
+			metricID int64
+			score    float64
+		)
+		if err := rows.Scan(&metricID, &score); err != nil {
+			return sql.NullFloat64{}, err
+		}
+		if _, ok := scores[metricID]; !ok {
+			scores[metricID] = score
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return sql.NullFloat64{}, err
+	}
+	_, overall := h.Scores(scores)
+	if !overall.Valid {
+		return overall, nil
+	}
+	overall.Float64 *= OverallScoreMax
+	_, err = conn.Exec("UPDATE reviews SET overall_score=? WHERE review_id=?", overall.Float64, reviewID)
+	return overall, err
+}
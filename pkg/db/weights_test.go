@@ -0,0 +1,99 @@
+package db
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+)
+
+// testHierarchy - returns the metrics food, of weight 2, with the sub-metrics taste and portion, of weights 1 and 3,
+// service, of weight 1, and ambience, of weight 0, all scored from 1 to 5
+func testHierarchy() MetricHierarchy {
+	scale := MetricScale{Min: 1, Max: 5, Step: 1}
+	child := func(parentID int64) sql.NullInt64 { return sql.NullInt64{Int64: parentID, Valid: true} }
+	return MetricHierarchy{
+		Metrics: map[int64]WeightedMetric{
+			1: {ID: 1, Name: "food", Weight: 2, Scale: scale},
+			2: {ID: 2, Name: "service", Weight: 1, Scale: scale},
+			3: {ID: 3, ParentID: child(1), Name: "taste", Weight: 1, Scale: scale},
+			4: {ID: 4, ParentID: child(1), Name: "portion", Weight: 3, Scale: scale},
+			5: {ID: 5, Name: "ambience", Weight: 0, Scale: scale},
+		},
+		Children: map[int64][]int64{1: {3, 4}},
+		Roots:    []int64{1, 2, 5},
+	}
+}
+
+// near - reports whether a and b are equal but for rounding
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestMetricHierarchyScores(t *testing.T) {
+	h := testHierarchy()
+	tests := []struct {
+		name    string
+		scores  map[int64]float64
+		derived map[int64]float64
+		overall sql.NullFloat64
+	}{
+		{"nothing scored", map[int64]float64{}, map[int64]float64{}, sql.NullFloat64{}},
+		{"one top-level metric", map[int64]float64{2: 5}, map[int64]float64{2: 1}, sql.NullFloat64{Float64: 1, Valid: true}},
+		{"metric scored directly", map[int64]float64{1: 3}, map[int64]float64{1: 0.5}, sql.NullFloat64{Float64: 0.5, Valid: true}},
+		{"weighted sub-metrics", map[int64]float64{3: 5, 4: 1}, map[int64]float64{1: 0.25, 3: 1, 4: 0}, sql.NullFloat64{Float64: 0.25, Valid: true}},
+		{"sub-metrics win over the parent score", map[int64]float64{1: 5, 3: 5, 4: 1}, map[int64]float64{1: 0.25, 3: 1, 4: 0}, sql.NullFloat64{Float64: 0.25, Valid: true}},
+		{"weighted top-level metrics", map[int64]float64{3: 5, 2: 1}, map[int64]float64{1: 1, 2: 0, 3: 1}, sql.NullFloat64{Float64: 2.0 / 3, Valid: true}},
+		{"metric of weight 0", map[int64]float64{5: 5}, map[int64]float64{5: 1}, sql.NullFloat64{}},
+		{"metric of weight 0 left out", map[int64]float64{2: 3, 5: 5}, map[int64]float64{2: 0.5, 5: 1}, sql.NullFloat64{Float64: 0.5, Valid: true}},
+		{"unknown metric", map[int64]float64{9: 5}, map[int64]float64{}, sql.NullFloat64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			derived, overall := h.Scores(tt.scores)
+			if len(derived) != len(tt.derived) {
+				t.Errorf("Scores() derived %v, want %v", derived, tt.derived)
+			}
+			for id, want := range tt.derived {
+				if got, ok := derived[id]; !ok || !near(got, want) {
+					t.Errorf("Scores() derived %v for metric %d, want %v", got, id, want)
+				}
+			}
+			if overall.Valid != tt.overall.Valid || !near(overall.Float64, tt.overall.Float64) {
+				t.Errorf("Scores() overall = %v, want %v", overall, tt.overall)
+			}
+		})
+	}
+}
+
+func TestMetricHierarchyShares(t *testing.T) {
+	h := testHierarchy()
+	// Metrics caught in a cycle have no share
+	h.Metrics[6] = WeightedMetric{ID: 6, ParentID: sql.NullInt64{Int64: 7, Valid: true}, Name: "cycle", Weight: 1}
+	h.Metrics[7] = WeightedMetric{ID: 7, ParentID: sql.NullInt64{Int64: 6, Valid: true}, Name: "cycle", Weight: 1}
+	h.Children[6] = []int64{7}
+	h.Children[7] = []int64{6}
+	overall, ofParent := h.Shares()
+	tests := []struct {
+		name     string
+		id       int64
+		overall  float64
+		ofParent float64
+	}{
+		{"food", 1, 2.0 / 3, 2.0 / 3},
+		{"service", 2, 1.0 / 3, 1.0 / 3},
+		{"taste", 3, 1.0 / 6, 0.25},
+		{"portion", 4, 0.5, 0.75},
+		{"ambience", 5, 0, 0},
+		{"cycle", 6, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !near(overall[tt.id], tt.overall) {
+				t.Errorf("Shares() overall = %v, want %v", overall[tt.id], tt.overall)
+			}
+			if !near(ofParent[tt.id], tt.ofParent) {
+				t.Errorf("Shares() of parent = %v, want %v", ofParent[tt.id], tt.ofParent)
+			}
+		})
+	}
+}
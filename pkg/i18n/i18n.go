@@ -0,0 +1,196 @@
+// Package i18n - message catalogs per locale, the negotiation of the locale a request is answered in and the
+// formatting of messages, numbers and dates for a locale.
+//
+// A catalog is a JSON object mapping message keys to fmt format strings, one file per locale named after its BCP 47
+// tag, e.g. en.json or hi.json. The catalogs built into the binary can be overridden key by key, and new locales
+// added, by the files of an external directory.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// DefaultLocale - the locale used when neither the request nor the configuration asks for another one
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var embeddedLocales embed.FS
+
+// Catalog - the messages of a locale, keyed by message key
+type Catalog map[string]string
+
+// Locale - a locale with a catalog, along with its name in that locale, e.g. "हिन्दी" for hi
+type Locale struct {
+	Tag  string
+	Name string
+}
+
+// Bundle - the catalogs of every available locale. It is safe for concurrent use.
+type Bundle struct {
+	mu            sync.RWMutex
+	dir           string
+	defaultLocale string
+	tags          []language.Tag
+	catalogs      map[string]Catalog
+	matcher       language.Matcher
+}
+
+// NewBundle - loads the built-in catalogs and those found in dir, which may be empty. defaultLocale is the locale
+// requests fall back to and must have a catalog.
+func NewBundle(dir, defaultLocale string) (*Bundle, error) {
+	if defaultLocale == "" {
+		defaultLocale = DefaultLocale
+	}
+	b := &Bundle{dir: dir, defaultLocale: defaultLocale}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload - reads the catalogs again. The catalogs read before are kept if any of them fails.
+func (b *Bundle) Reload() error {
+	catalogs := make(map[string]Catalog)
+	if err := loadCatalogs(embeddedLocales, "locales", catalogs); err != nil {
+		return err
+	}
+	if b.dir != "" {
+		if err := loadCatalogs(os.DirFS(b.dir), ".", catalogs); err != nil {
+			return err
+		}
+	}
+
+	defaultTag, err := language.Parse(b.defaultLocale)
+	if err != nil {
+		return fmt.Errorf("invalid default locale %s: %s", b.defaultLocale, err.Error())
+	}
+	if _, ok := catalogs[defaultTag.String()]; !ok {
+		return fmt.Errorf("there is no catalog for the default locale %s", defaultTag.String())
+	}
+
+	// the matcher falls back to the first tag, so the default locale goes first
+	tags := []language.Tag{defaultTag}
+	names := make([]string, 0, len(catalogs))
+	for name := range catalogs {
+		if name != defaultTag.String() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tags = append(tags, language.MustParse(name))
+	}
+
+	b.mu.Lock()
+	b.catalogs = catalogs
+	b.tags = tags
+	b.matcher = language.NewMatcher(tags)
+	b.mu.Unlock()
+	return nil
+}
+
+// loadCatalogs - merges the catalogs found in the directory dir of fsys into catalogs, key by key
+func loadCatalogs(fsys fs.FS, dir string, catalogs map[string]Catalog) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("error listing catalogs: %s", err.Error())
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".json")
+		tag, parseErr := language.Parse(name)
+		if parseErr != nil {
+			return fmt.Errorf("catalog %s is not named after a locale: %s", file, parseErr.Error())
+		}
+
+		data, readErr := fs.ReadFile(fsys, file)
+		if readErr != nil {
+			return fmt.Errorf("error reading catalog %s: %s", file, readErr.Error())
+		}
+		var messages Catalog
+		if jsonErr := json.Unmarshal(data, &messages); jsonErr != nil {
+			return fmt.Errorf("error parsing catalog %s: %s", file, jsonErr.Error())
+		}
+
+		catalog, ok := catalogs[tag.String()]
+		if !ok {
+			catalog = make(Catalog, len(messages))
+			catalogs[tag.String()] = catalog
+		}
+		for key, message := range messages {
+			catalog[key] = message
+		}
+	}
+	return nil
+}
+
+// DefaultLocale - returns the locale requests fall back to
+func (b *Bundle) DefaultLocale() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.tags[0].String()
+}
+
+// Locales - returns the locales with a catalog, the default one first
+func (b *Bundle) Locales() []Locale {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	locales := make([]Locale, 0, len(b.tags))
+	for _, tag := range b.tags {
+		locales = append(locales, Locale{Tag: tag.String(), Name: display.Self.Name(tag)})
+	}
+	return locales
+}
+
+// Match - returns the available locale closest to locale, e.g. hi for hi-IN, and false when none is close enough
+func (b *Bundle) Match(locale string) (string, bool) {
+	tag, err := language.Parse(strings.TrimSpace(locale))
+	if err != nil {
+		return "", false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, index, confidence := b.matcher.Match(tag)
+	if confidence == language.No {
+		return "", false
+	}
+	return b.tags[index].String(), true
+}
+
+// Negotiate - returns the available locale which suits the Accept-Language header acceptLanguage best, or the
+// default locale when none of them does
+func (b *Bundle) Negotiate(acceptLanguage string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	preferred, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(preferred) == 0 {
+		return b.tags[0].String()
+	}
+	_, index, confidence := b.matcher.Match(preferred...)
+	if confidence == language.No {
+		return b.tags[0].String()
+	}
+	return b.tags[index].String()
+}
+
+// Localizer - returns the Localizer of locale, or of the default locale when locale has no catalog
+func (b *Bundle) Localizer(locale string) *Localizer {
+	if matched, ok := b.Match(locale); ok {
+		locale = matched
+	} else {
+		locale = b.DefaultLocale()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return newLocalizer(language.MustParse(locale), b.catalogs[locale], b.catalogs[b.tags[0].String()])
+}
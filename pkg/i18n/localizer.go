@@ -0,0 +1,123 @@
+package i18n
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// Localizer - formats messages, numbers and dates for one locale. Messages missing from the catalog of the locale
+// are taken from the catalog of the default locale, and keys missing from both are returned as they are.
+type Localizer struct {
+	tag      language.Tag
+	catalog  Catalog
+	fallback Catalog
+	printer  *message.Printer
+}
+
+func newLocalizer(tag language.Tag, catalog, fallback Catalog) *Localizer {
+	return &Localizer{tag: tag, catalog: catalog, fallback: fallback, printer: message.NewPrinter(tag)}
+}
+
+// Locale - returns the BCP 47 tag of the locale, e.g. "hi"
+func (l *Localizer) Locale() string {
+	return l.tag.String()
+}
+
+// Lookup - returns the message stored under key, and false when no catalog has it
+func (l *Localizer) Lookup(key string) (string, bool) {
+	if msg, ok := l.catalog[key]; ok {
+		return msg, true
+	}
+	msg, ok := l.fallback[key]
+	return msg, ok
+}
+
+// T - returns the message stored under key, formatted with args. Numbers in args are formatted the way the locale
+// writes them.
+func (l *Localizer) T(key string, args ...interface{}) string {
+	msg, ok := l.Lookup(key)
+	if !ok {
+		msg = key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return l.printer.Sprintf(msg, args...)
+}
+
+// N - returns the plural form for n of the message stored under key, formatted with n followed by args. The forms
+// are stored as key.zero, key.one, key.two, key.few, key.many and key.other as the plural rules of the locale
+// require, with key.other used for any form which is missing.
+func (l *Localizer) N(key string, n int, args ...interface{}) string {
+	form := pluralForms[plural.Cardinal.MatchPlural(l.tag, abs(n), 0, 0, 0, 0)]
+	msgKey := key + "." + form
+	if _, ok := l.Lookup(msgKey); !ok {
+		msgKey = key + ".other"
+	}
+	return l.T(msgKey, append([]interface{}{n}, args...)...)
+}
+
+var pluralForms = map[plural.Form]string{
+	plural.Zero:  "zero",
+	plural.One:   "one",
+	plural.Two:   "two",
+	plural.Few:   "few",
+	plural.Many:  "many",
+	plural.Other: "other",
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Number - formats v with the given number of decimals and the digit grouping of the locale, e.g. "1,234.50" in en
+// and "12,34,567.00" in hi
+func (l *Localizer) Number(decimals int, v float64) string {
+	if decimals < 0 {
+		decimals = 0
+	}
+	return l.printer.Sprint(number.Decimal(v, number.MinFractionDigits(decimals), number.MaxFractionDigits(decimals)))
+}
+
+// FormatTime - formats t with the Go layout, writing the names of months and weekdays in the language of the locale.
+// The names are stored as month.1 to month.12, month.short.1 to month.short.12, weekday.0 (Sunday) to weekday.6 and
+// weekday.short.0 to weekday.short.6.
+func (l *Localizer) FormatTime(t time.Time, layout string) string {
+	// the names are swapped for placeholders which no layout element produces, then replaced once formatted. Long
+	// names go first since "January" contains "Jan".
+	type name struct {
+		element, placeholder, key string
+	}
+	names := []name{
+		{"January", "\x00M\x00", fmt.Sprintf("month.%d", t.Month())},
+		{"Jan", "\x00m\x00", fmt.Sprintf("month.short.%d", t.Month())},
+		{"Monday", "\x00W\x00", fmt.Sprintf("weekday.%d", t.Weekday())},
+		{"Mon", "\x00w\x00", fmt.Sprintf("weekday.short.%d", t.Weekday())},
+	}
+	var replacements []string
+	for _, n := range names {
+		if !strings.Contains(layout, n.element) {
+			continue
+		}
+		translated, ok := l.Lookup(n.key)
+		if !ok {
+			continue
+		}
+		layout = strings.ReplaceAll(layout, n.element, n.placeholder)
+		replacements = append(replacements, n.placeholder, translated)
+	}
+	formatted := t.Format(layout)
+	if len(replacements) > 0 {
+		formatted = strings.NewReplacer(replacements...).Replace(formatted)
+	}
+	return formatted
+}
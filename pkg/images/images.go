@@ -0,0 +1,159 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+const (
+	// MaxPixels is the largest number of pixels accepted in an uploaded image. It guards against small files which
+	// decode into huge images.
+	MaxPixels = 40_000_000
+
+	// JPEGQuality is the quality of every rendered image
+	JPEGQuality = 85
+)
+
+// SupportedContentTypes - the sniffed content types which can be decoded
+var SupportedContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+var (
+	ErrUnsupportedType = errors.New("only JPEG, PNG and GIF images are supported")
+	ErrTooLarge        = fmt.Errorf("the image has more than %d megapixels", MaxPixels/1_000_000)
+)
+
+// Size - a standard size an uploaded image is rendered in
+type Size struct {
+	Name   string
+	Width  int
+	Height int
+	// Crop fills Width x Height exactly by cutting off the edges. Otherwise the image is scaled down to fit inside
+	// Width x Height, keeping its aspect ratio.
+	Crop bool
+}
+
+// RestaurantSizes - the sizes restaurant images are stored in
+var RestaurantSizes = []Size{
+	{Name: "thumb", Width: 160, Height: 160, Crop: true},
+	{Name: "card", Width: 480, Height: 320, Crop: true},
+	{Name: "large", Width: 1280, Height: 1280},
+}
+
+// ReviewSizes - the sizes photos attached to reviews are stored in
+var ReviewSizes = []Size{
+	{Name: "thumb", Width: 160, Height: 160, Crop: true},
+	{Name: "large", Width: 1280, Height: 1280},
+}
+
+// Render - decodes data and returns it re-encoded as a JPEG in each of sizes, keyed by the size name. Images are
+// never scaled up. Since only the pixels are re-encoded, metadata such as EXIF is dropped, so photos are turned
+// upright as their EXIF orientation says first.
+func Render(data []byte, sizes []Size) (map[string][]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s image: %s", format, err.Error())
+	}
+	flat := flatten(src)
+	if format == "jpeg" {
+		flat = orient(flat, jpegOrientation(data))
+	}
+
+	rendered := make(map[string][]byte, len(sizes))
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		if err = jpeg.Encode(&buf, scale(flat, size), &jpeg.Options{Quality: JPEGQuality}); err != nil {
+			return nil, fmt.Errorf("error encoding %s image: %s", size.Name, err.Error())
+		}
+		rendered[size.Name] = buf.Bytes()
+	}
+	return rendered, nil
+}
+
+// flatten - draws src over a white background, as JPEG has no transparency
+func flatten(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+	return dst
+}
+
+// scale - returns the part of src selected by size, scaled down to size
+func scale(src *image.RGBA, size Size) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	crop := src.Bounds()
+	w, h := size.Width, size.Height
+
+	if size.Crop {
+		// cut the edges off along the axis which is too long for the target aspect ratio
+		if sw*h > sh*w {
+			cw := sh * w / h
+			crop = image.Rect((sw-cw)/2, 0, (sw-cw)/2+cw, sh)
+		} else {
+			ch := sw * h / w
+			crop = image.Rect(0, (sh-ch)/2, sw, (sh-ch)/2+ch)
+		}
+		if crop.Dx() < w {
+			w, h = crop.Dx(), crop.Dy()
+		}
+	} else {
+		if sw <= w && sh <= h {
+			w, h = sw, sh
+		} else if sw*h > sh*w {
+			h = max(1, sh*w/sw)
+		} else {
+			w = max(1, sw*h/sh)
+		}
+	}
+	return boxResize(src, crop, w, h)
+}
+
+// boxResize - scales the rect r of src down to w x h, averaging the source pixels covered by each target pixel
+func boxResize(src *image.RGBA, r image.Rectangle, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	rw, rh := r.Dx(), r.Dy()
+	for y := 0; y < h; y++ {
+		y0 := r.Min.Y + y*rh/h
+		y1 := max(y0+1, r.Min.Y+(y+1)*rh/h)
+		for x := 0; x < w; x++ {
+			x0 := r.Min.X + x*rw/w
+			x1 := max(x0+1, r.Min.X+(x+1)*rw/w)
+			var red, green, blue, alpha, n uint32
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					red += uint32(src.Pix[i])
+					green += uint32(src.Pix[i+1])
+					blue += uint32(src.Pix[i+2])
+					alpha += uint32(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(red / n)
+			dst.Pix[j+1] = uint8(green / n)
+			dst.Pix[j+2] = uint8(blue / n)
+			dst.Pix[j+3] = uint8(alpha / n)
+		}
+	}
+	return dst
+}
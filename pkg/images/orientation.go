@@ -0,0 +1,121 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+const (
+	// exifOrientationTag - the EXIF tag holding how the camera was held, from 1 to 8 as in the TIFF specification
+	exifOrientationTag = 0x0112
+	// exifTypeShort - the TIFF type of 16-bit unsigned values
+	exifTypeShort = 3
+)
+
+// jpegOrientation - returns the EXIF orientation of the JPEG image data, 1 if it has none. Cameras store the pixels
+// as the sensor saw them and record with this tag how they have to be turned to be shown upright.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// start of scan, the metadata segments all come before it
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation - returns the orientation tag of the first image file directory of the TIFF structure tiff, 1 if
+// it has none
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) != exifOrientationTag {
+			continue
+		}
+		if order.Uint16(tiff[entry+2:entry+4]) != exifTypeShort {
+			return 1
+		}
+		if o := int(order.Uint16(tiff[entry+8 : entry+10])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 1
+	}
+	return 1
+}
+
+// orient - returns src turned upright as the EXIF orientation says, see jpegOrientation
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	// orientations 5 to 8 turn the image by a quarter
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirror
+				sx, sy = w-1-x, y
+			case 3: // turn halfway
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip
+				sx, sy = x, h-1-y
+			case 5: // mirror along the diagonal from the top left
+				sx, sy = y, x
+			case 6: // turn a quarter clockwise
+				sx, sy = y, h-1-x
+			case 7: // mirror along the diagonal from the top right
+				sx, sy = w-1-y, h-1-x
+			case 8: // turn a quarter counterclockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,96 @@
+package log
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+
+	DefaultLogFileMaxSizeMB  = 100
+	DefaultLogFileMaxBackups = 5
+)
+
+// Config - describes how a Logger should write its output. It is usually built from the application configuration
+// using ConfigFromViper() and can be re-applied to a running Logger with Logger.Configure()
+type Config struct {
+	// Level is one of trace, debug, info, notice, warning, error or emergency
+	Level string
+	// Format is either "json" or "text"
+	Format string
+	// Time includes the timestamp of the record in the output when set to true
+	Time bool
+	// Source includes the file and line number of the caller in the output when set to true
+	Source bool
+	// File is the path of the log file. Logs are written to stdout when it is empty
+	File string
+	// MaxSizeMB is the size in megabytes after which File is rotated
+	MaxSizeMB int
+	// MaxBackups is the number of rotated files which are kept besides File
+	MaxBackups int
+}
+
+// DefaultConfig - returns the configuration used when nothing has been configured
+func DefaultConfig() Config {
+	return Config{
+		Level:      "info",
+		Format:     FormatJSON,
+		Time:       true,
+		Source:     false,
+		File:       "",
+		MaxSizeMB:  DefaultLogFileMaxSizeMB,
+		MaxBackups: DefaultLogFileMaxBackups,
+	}
+}
+
+// ConfigFromViper - reads the log_* keys from the application configuration. Keys which have not been set fall back
+// to the values returned by DefaultConfig()
+func ConfigFromViper() Config {
+	cfg := DefaultConfig()
+	if viper.IsSet("log_level") {
+		cfg.Level = viper.GetString("log_level")
+	}
+	if viper.IsSet("log_format") {
+		cfg.Format = viper.GetString("log_format")
+	}
+	if viper.IsSet("log_time") {
+		cfg.Time = viper.GetBool("log_time")
+	}
+	if viper.IsSet("log_source") {
+		cfg.Source = viper.GetBool("log_source")
+	}
+	cfg.File = viper.GetString("log_file")
+	if viper.IsSet("log_file_max_size_mb") {
+		cfg.MaxSizeMB = viper.GetInt("log_file_max_size_mb")
+	}
+	if viper.IsSet("log_file_max_backups") {
+		cfg.MaxBackups = viper.GetInt("log_file_max_backups")
+	}
+	return cfg
+}
+
+// ParseLevel - converts the name of a level (case-insensitive) into its slog.Level
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "notice":
+		return LevelNotice, nil
+	case "warn", "warning":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	case "emergency":
+		return LevelEmergency, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
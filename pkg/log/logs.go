@@ -1,9 +1,16 @@
 package log
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -18,11 +25,6 @@ const (
 
 // CustomLogLevel - For details, see https://pkg.go.dev/log/slog#example-HandlerOptions-CustomLevels
 func CustomLogLevel(groups []string, a slog.Attr) slog.Attr {
-	// Remove time from the output for predictable test output.
-	if a.Key == slog.TimeKey {
-		return slog.Attr{}
-	}
-
 	// Customize the name of the level key and the output string, including
 	// custom level values.
 	if a.Key == slog.LevelKey {
@@ -57,8 +59,108 @@ func CustomLogLevel(groups []string, a slog.Attr) slog.Attr {
 	return a
 }
 
+// ReplaceAttr - returns a slog.HandlerOptions.ReplaceAttr function which renames levels using CustomLogLevel and
+// drops the timestamp of every record when includeTime is false
+func ReplaceAttr(includeTime bool) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if !includeTime && len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return CustomLogLevel(groups, a)
+	}
+}
+
+// swapState - the handler shared by a swapHandler and every handler derived from it. Records are handled under the
+// read lock, so that replacing the handler under the write lock waits for the records being written to the old one.
+type swapState struct {
+	mu  sync.RWMutex
+	h   slog.Handler
+	gen uint64
+}
+
+// derivedHandler - the handler of a swapHandler as derived from the shared handler of generation gen
+type derivedHandler struct {
+	gen uint64
+	h   slog.Handler
+}
+
+// swapHandler - a slog.Handler which delegates to another handler that can be replaced at runtime. This is what
+// allows a Logger to be reconfigured without handing out a new *slog.Logger to everyone who holds the old one.
+// Handlers returned by WithAttrs and WithGroup follow the replacements as well, applying their attributes and groups
+// to the current handler.
+type swapHandler struct {
+	state *swapState
+	// ops turn the shared handler into this one, in the order WithAttrs and WithGroup were called
+	ops   []func(slog.Handler) slog.Handler
+	cache atomic.Pointer[derivedHandler]
+}
+
+func newSwapHandler(h slog.Handler) *swapHandler {
+	return &swapHandler{state: &swapState{h: h}}
+}
+
+// set - replaces the shared handler, waiting for the records being handled by the previous one
+func (s *swapHandler) set(h slog.Handler) {
+	s.state.mu.Lock()
+	s.state.h = h
+	s.state.gen++
+	s.state.mu.Unlock()
+}
+
+// handler - returns the handler to delegate to, the caller holding the read lock of the state
+func (s *swapHandler) handler() slog.Handler {
+	if len(s.ops) == 0 {
+		return s.state.h
+	}
+	if d := s.cache.Load(); d != nil && d.gen == s.state.gen {
+		return d.h
+	}
+	h := s.state.h
+	for _, op := range s.ops {
+		h = op(h)
+	}
+	s.cache.Store(&derivedHandler{gen: s.state.gen, h: h})
+	return h
+}
+
+func (s *swapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	return s.handler().Enabled(ctx, level)
+}
+
+func (s *swapHandler) Handle(ctx context.Context, r slog.Record) error {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	return s.handler().Handle(ctx, r)
+}
+
+// with - returns a swapHandler sharing the state of s which applies op after the operations of s
+func (s *swapHandler) with(op func(slog.Handler) slog.Handler) *swapHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(s.ops), len(s.ops)+1)
+	copy(ops, s.ops)
+	return &swapHandler{state: s.state, ops: append(ops, op)}
+}
+
+func (s *swapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return s
+	}
+	return s.with(func(h slog.Handler) slog.Handler { return h.WithAttrs(attrs) })
+}
+
+func (s *swapHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return s
+	}
+	return s.with(func(h slog.Handler) slog.Handler { return h.WithGroup(name) })
+}
+
 type Logger struct {
-	l *slog.Logger
+	l   *slog.Logger
+	h   *swapHandler
+	mu  sync.Mutex
+	out io.Closer
 }
 
 func (l *Logger) NewJSONLogger(opts *slog.HandlerOptions) *slog.Logger {
@@ -67,51 +169,152 @@ func (l *Logger) NewJSONLogger(opts *slog.HandlerOptions) *slog.Logger {
 }
 
 func New(opts *slog.HandlerOptions) *Logger {
+	h := newSwapHandler(slog.NewJSONHandler(os.Stdout, opts))
 	return &Logger{
-		l: slog.New(slog.NewJSONHandler(os.Stdout, opts)),
+		l: slog.New(h),
+		h: h,
+	}
+}
+
+// NewWithConfig - creates a Logger which writes its output as described by cfg
+func NewWithConfig(cfg Config) (*Logger, error) {
+	l := New(nil)
+	if err := l.Configure(cfg); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+// Configure - replaces the level, format and destination of the Logger with the ones described by cfg. Log records
+// written concurrently are not lost; they go either to the old or to the new destination. On error the Logger keeps
+// its previous configuration.
+func (l *Logger) Configure(cfg Config) error {
+	level, levelErr := ParseLevel(cfg.Level)
+	if levelErr != nil {
+		return levelErr
+	}
+
+	var (
+		w      io.Writer = os.Stdout
+		closer io.Closer
+	)
+	if cfg.File != "" {
+		rf, rfErr := NewRotatingFile(cfg.File, cfg.MaxSizeMB, cfg.MaxBackups)
+		if rfErr != nil {
+			return rfErr
+		}
+		w = rf
+		closer = rf
+	}
+
+	opts := &slog.HandlerOptions{
+		AddSource:   cfg.Source,
+		Level:       level,
+		ReplaceAttr: ReplaceAttr(cfg.Time),
+	}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case "", FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		if closer != nil {
+			_ = closer.Close()
+		}
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	l.mu.Lock()
+	previous := l.out
+	l.out = closer
+	// set returns once the records being written to the previous destination are done, so it can be closed
+	l.h.set(h)
+	l.mu.Unlock()
+
+	if previous != nil {
+		return previous.Close()
 	}
+	return nil
+}
+
+// Slog - returns the *slog.Logger backing this Logger. It follows every later call to Configure, so it is suitable
+// for slog.SetDefault()
+func (l *Logger) Slog() *slog.Logger {
+	return l.l
+}
+
+// Close - closes the log file if the Logger is writing to one, once the records being written to it are done
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out == nil {
+		return nil
+	}
+	l.h.state.mu.Lock()
+	err := l.out.Close()
+	l.h.state.mu.Unlock()
+	l.out = nil
+	return err
+}
+
+// log - writes msg at the given level, recording the caller of the exported logging method as the source
+func (l *Logger) log(level slog.Level, msg string) {
+	ctx := context.Background()
+	if !l.l.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // skip runtime.Callers, Logger.log and the exported method
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	_ = l.l.Handler().Handle(ctx, r)
+}
+
+func (l *Logger) Tracef(format string, v ...interface{}) {
+	l.log(LevelTrace, fmt.Sprintf(format, v...))
 }
 
 func (l *Logger) Debugf(format string, v ...interface{}) {
-	l.l.Debug(fmt.Sprintf(format, v...))
+	l.log(LevelDebug, fmt.Sprintf(format, v...))
 }
 
 func (l *Logger) Info(v ...interface{}) {
-	l.l.Info(fmt.Sprint(v...))
+	l.log(LevelInfo, fmt.Sprint(v...))
 }
 
 func (l *Logger) Infof(format string, v ...interface{}) {
-	l.l.Info(fmt.Sprintf(format, v...))
+	l.log(LevelInfo, fmt.Sprintf(format, v...))
 }
 
 func (l *Logger) Warn(v ...interface{}) {
-	l.l.Warn(fmt.Sprint(v...))
+	l.log(LevelWarning, fmt.Sprint(v...))
 }
 
 func (l *Logger) Warnf(format string, v ...interface{}) {
-	l.l.Warn(fmt.Sprintf(format, v...))
+	l.log(LevelWarning, fmt.Sprintf(format, v...))
 }
 
 func (l *Logger) Error(v ...interface{}) {
-	l.l.Error(fmt.Sprint(v...))
+	l.log(LevelError, fmt.Sprint(v...))
 }
 
 func (l *Logger) Errorf(format string, v ...interface{}) {
-	l.l.Error(fmt.Sprintf(format, v...))
+	l.log(LevelError, fmt.Sprintf(format, v...))
 }
 
 func (l *Logger) Fatal(v ...interface{}) {
-	l.l.Error(fmt.Sprint(v...))
+	l.log(LevelError, fmt.Sprint(v...))
 	os.Exit(1)
 }
 
 func (l *Logger) Fatalf(format string, v ...interface{}) {
-	l.l.Error(fmt.Sprintf(format, v...))
+	l.log(LevelError, fmt.Sprintf(format, v...))
 	os.Exit(1)
 }
 
 func (l *Logger) Panic(v ...interface{}) {
-	l.l.Error(fmt.Sprint(v...))
+	l.log(LevelError, fmt.Sprint(v...))
 }
 
 //func (l *Logger) Panicf(format string, v ...interface{})   {}
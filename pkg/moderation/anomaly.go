@@ -0,0 +1,231 @@
+package moderation
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/spf13/viper"
+)
+
+// The weights the signals add to the anomaly score of a review when they fire. A review is flagged once its score
+// reaches AnomalyConfig.FlagScore, which at the default of 1 takes shared addresses or copied text on their own, and
+// any two of the weaker signals together.
+const (
+	weightNewAccount = 0.5
+	weightSharedIP   = 1
+	weightBurst      = 0.5
+	weightDeviation  = 0.5
+	weightDuplicate  = 1
+)
+
+const (
+	// duplicateCandidates is the number of recent reviews the text of a review is compared with
+	duplicateCandidates = 500
+	// minDuplicateWords is the number of words a text needs to be compared, shorter ones are alike too often
+	minDuplicateWords = 6
+	// shingleSize is the number of successive words compared as one
+	shingleSize = 3
+)
+
+// AnomalyConfig - the thresholds of the signals of Detector. Signals with a threshold of 0 are off.
+type AnomalyConfig struct {
+	// FlagScore is the anomaly score from which a review is flagged
+	FlagScore float64
+	// NewAccountAge is the age of the account of the user below which their review counts as coming from a new account
+	NewAccountAge time.Duration
+	// SharedIPReviewers is the number of other reviewers of the restaurant seen at the address of the user which
+	// counts as suspicious
+	SharedIPReviewers int
+	// Window and BurstReviews tell a burst, of BurstReviews or more reviews of the restaurant within Window either side
+	Window       time.Duration
+	BurstReviews int
+	// ScoreDeviation is the distance of the overall score from the mean of the restaurant which counts as suspicious,
+	// once the restaurant has MinRestaurantReviews other reviews
+	ScoreDeviation       float64
+	MinRestaurantReviews int
+	// DuplicateSimilarity is the share of the text, from 0 to 1, another review may have in common with the review
+	DuplicateSimilarity float64
+}
+
+// LoadAnomalyConfig - returns the thresholds of the configuration, falling back to the defaults of sample.yml for
+// those which are not set
+func LoadAnomalyConfig() AnomalyConfig {
+	floatOr := func(key string, def float64) float64 {
+		if viper.IsSet(key) {
+			return viper.GetFloat64(key)
+		}
+		return def
+	}
+	intOr := func(key string, def int) int {
+		if viper.IsSet(key) {
+			return viper.GetInt(key)
+		}
+		return def
+	}
+	return AnomalyConfig{
+		FlagScore:            floatOr("anomaly_flag_score", 1),
+		NewAccountAge:        time.Duration(floatOr("anomaly_new_account_days", 3) * float64(24*time.Hour)),
+		SharedIPReviewers:    intOr("anomaly_shared_ip_reviewers", 2),
+		Window:               time.Duration(floatOr("anomaly_window_hours", 24) * float64(time.Hour)),
+		BurstReviews:         intOr("anomaly_burst_reviews", 10),
+		ScoreDeviation:       floatOr("anomaly_score_deviation", 2.5),
+		MinRestaurantReviews: intOr("anomaly_min_restaurant_reviews", 5),
+		DuplicateSimilarity:  floatOr("anomaly_duplicate_similarity", 0.8),
+	}
+}
+
+// Assessment - how suspicious a review looks and why
+type Assessment struct {
+	Score   float64
+	Reasons []db.ModerationReason
+	// Cleared is set for reviews a moderator approved, which are not flagged again for the same signals
+	Cleared bool
+	// Flagged is set when the review was flagged for the reasons
+	Flagged bool
+}
+
+// Detector - scores reviews by the signals of abuse, e.g. bursts of reviews pumping a restaurant
+type Detector struct {
+	Config AnomalyConfig
+}
+
+// NewDetector - returns a detector with the thresholds of config
+func NewDetector(config AnomalyConfig) *Detector {
+	return &Detector{Config: config}
+}
+
+// Assess - scores the review reviewID by its signals
+func (d *Detector) Assess(conn db.Queryer, reviewID int64) (Assessment, error) {
+	var a Assessment
+	cfg := d.Config
+	s, err := db.LoadReviewSignals(conn, reviewID, cfg.Window)
+	if err != nil {
+		return a, fmt.Errorf("error loading signals of review %d: %s", reviewID, err.Error())
+	}
+	a.Cleared = s.Cleared
+	fire := func(weight float64, code string, args ...interface{}) {
+		a.Score += weight
+		a.Reasons = append(a.Reasons, db.NewModerationReason(code, args...))
+	}
+
+	// Reviews entered for users whose accounts were created later say nothing about the account
+	if cfg.NewAccountAge > 0 && s.AccountAge >= 0 && s.AccountAge < cfg.NewAccountAge {
+		fire(weightNewAccount, db.ReasonNewAccount, roundSeconds(s.AccountAge))
+	}
+	if cfg.SharedIPReviewers > 0 && s.SharedIPReviewers >= cfg.SharedIPReviewers {
+		fire(weightSharedIP, db.ReasonSharedIP, s.SharedIPReviewers)
+	}
+	if cfg.BurstReviews > 0 && s.NearbyReviews >= cfg.BurstReviews {
+		fire(weightBurst, db.ReasonBurst, s.NearbyReviews, roundSeconds(cfg.Window))
+	}
+	if cfg.ScoreDeviation > 0 && s.RestaurantMean.Valid && s.RestaurantReviews >= cfg.MinRestaurantReviews {
+		if dev := math.Abs(s.OverallScore - s.RestaurantMean.Float64); dev >= cfg.ScoreDeviation {
+			fire(weightDeviation, db.ReasonScoreDeviation, fmt.Sprintf("%.2f", s.OverallScore), fmt.Sprintf("%.2f", dev), fmt.Sprintf("%.2f", s.RestaurantMean.Float64))
+		}
+	}
+	if cfg.DuplicateSimilarity > 0 {
+		others, err := db.RecentReviewTexts(conn, s.UserID, duplicateCandidates)
+		if err != nil {
+			return a, fmt.Errorf("error loading review texts: %s", err.Error())
+		}
+		if id, similarity := mostSimilar(s.ReviewText, others); similarity >= cfg.DuplicateSimilarity {
+			fire(weightDuplicate, db.ReasonDuplicate, fmt.Sprintf("%.0f", similarity*100), id)
+		}
+	}
+	a.Score = math.Round(a.Score*100) / 100
+	return a, nil
+}
+
+// Review - assesses the review reviewID, stores its anomaly score and flags it for moderation if the score is high
+// enough and no moderator has approved it yet
+func (d *Detector) Review(conn db.Queryer, reviewID int64) (Assessment, error) {
+	a, err := d.Assess(conn, reviewID)
+	if err != nil {
+		return a, err
+	}
+	if err = db.SetAnomalyScore(conn, reviewID, a.Score); err != nil {
+		return a, fmt.Errorf("error storing anomaly score of review %d: %s", reviewID, err.Error())
+	}
+	if d.Config.FlagScore > 0 && a.Score >= d.Config.FlagScore && !a.Cleared {
+		if a.Flagged, err = db.FlagReview(conn, reviewID, a.Reasons); err != nil {
+			return a, fmt.Errorf("error flagging review %d: %s", reviewID, err.Error())
+		}
+	}
+	return a, nil
+}
+
+// mostSimilar - returns the review among others whose text is most like text, and how alike they are
+func mostSimilar(text string, others []db.ReviewText) (int64, float64) {
+	w := words(text)
+	if len(w) < minDuplicateWords {
+		return 0, 0
+	}
+	set := shingles(w)
+	var (
+		bestID int64
+		best   float64
+	)
+	for _, other := range others {
+		ow := words(other.Text)
+		if len(ow) < minDuplicateWords {
+			continue
+		}
+		if sim := jaccard(set, shingles(ow)); sim > best {
+			bestID, best = other.ReviewID, sim
+		}
+	}
+	return bestID, best
+}
+
+// words - returns the lower-cased words of s
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+	})
+}
+
+// shingles - returns the runs of shingleSize successive words of w, or w itself if it is shorter
+func shingles(w []string) map[string]bool {
+	set := make(map[string]bool)
+	if len(w) < shingleSize {
+		for _, word := range w {
+			set[word] = true
+		}
+		return set
+	}
+	for i := 0; i+shingleSize <= len(w); i++ {
+		set[strings.Join(w[i:i+shingleSize], " ")] = true
+	}
+	return set
+}
+
+// jaccard - returns the size of the intersection of a and b over the size of their union
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	var common int
+	for s := range a {
+		if b[s] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// roundSeconds - returns d in seconds, rounded to whole minutes, hours or days depending on its length
+func roundSeconds(d time.Duration) int64 {
+	switch {
+	case d < time.Hour:
+		d = d.Round(time.Minute)
+	case d < 48*time.Hour:
+		d = d.Round(time.Hour)
+	default:
+		d = d.Round(24 * time.Hour)
+	}
+	return int64(d / time.Second)
+}
@@ -0,0 +1,83 @@
+package moderation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+)
+
+func TestShingles(t *testing.T) {
+	tests := []struct {
+		name string
+		w    []string
+		want map[string]bool
+	}{
+		{"no words", nil, map[string]bool{}},
+		{"shorter than a shingle", []string{"great", "food"}, map[string]bool{"great": true, "food": true}},
+		{"one shingle", []string{"the", "food", "was"}, map[string]bool{"the food was": true}},
+		{"overlapping shingles", []string{"the", "food", "was", "great"}, map[string]bool{"the food was": true, "food was great": true}},
+		{"repeated shingles", []string{"very", "very", "very", "very"}, map[string]bool{"very very very": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shingles(tt.w); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shingles(%v) = %v, want %v", tt.w, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	set := func(s ...string) map[string]bool {
+		m := make(map[string]bool)
+		for _, v := range s {
+			m[v] = true
+		}
+		return m
+	}
+	tests := []struct {
+		name string
+		a, b map[string]bool
+		want float64
+	}{
+		{"both empty", set(), set(), 0},
+		{"one empty", set("a"), set(), 0},
+		{"identical", set("a", "b"), set("a", "b"), 1},
+		{"disjoint", set("a", "b"), set("c", "d"), 0},
+		{"half shared", set("a", "b", "c"), set("b", "c", "d"), 0.5},
+		{"subset", set("a"), set("a", "b", "c", "d"), 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jaccard(tt.a, tt.b); got != tt.want {
+				t.Errorf("jaccard(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMostSimilar(t *testing.T) {
+	others := []db.ReviewText{
+		{ReviewID: 1, Text: "The butter chicken"},
+		{ReviewID: 2, Text: "Service was slow but the staff were friendly enough"},
+		{ReviewID: 3, Text: "THE BUTTER CHICKEN WAS RICH, AND THE NAAN WAS FRESH!"},
+	}
+	tests := []struct {
+		name   string
+		text   string
+		wantID int64
+		want   float64
+	}{
+		{"copy in other case and punctuation", "The butter chicken was rich and the naan was fresh", 3, 1},
+		{"too short to compare", "The butter chicken", 0, 0},
+		{"nothing alike", "Parking is hard to find on weekend evenings here", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id, got := mostSimilar(tt.text, others); id != tt.wantID || got != tt.want {
+				t.Errorf("mostSimilar(%q) = %d, %v, want %d, %v", tt.text, id, got, tt.wantID, tt.want)
+			}
+		})
+	}
+}
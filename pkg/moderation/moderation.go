@@ -0,0 +1,87 @@
+package moderation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+)
+
+// maxMatchLength - matched text longer than this is cut short in the reasons returned by Rules.Check
+const maxMatchLength = 60
+
+// Rules - the checks new and edited review texts go through. Reviews which fail any of them are held for moderation.
+type Rules struct {
+	blocked []blockedWord
+	links   []*regexp.Regexp
+}
+
+type blockedWord struct {
+	word string
+	re   *regexp.Regexp
+}
+
+// NewRules - returns the rules for blocklist, the words and phrases reviews must not contain, and linkPatterns, the
+// regular expressions links in reviews are recognised by. Both are matched regardless of case, words only as whole
+// words. Invalid link patterns are left out of the rules and reported in the error, the other rules are still usable.
+func NewRules(blocklist, linkPatterns []string) (*Rules, error) {
+	rules := &Rules{}
+	for _, word := range blocklist {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		// \b only knows ASCII letters, which would let words of other scripts match inside longer words. Marks count as
+		// letters since many scripts write vowels with them
+		re := regexp.MustCompile(`(?i)(?:^|[^\pL\pM\pN])(` + regexp.QuoteMeta(word) + `)(?:[^\pL\pM\pN]|$)`)
+		rules.blocked = append(rules.blocked, blockedWord{word: word, re: re})
+	}
+	var errs []error
+	for _, pattern := range linkPatterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid link pattern %q: %s", pattern, err.Error()))
+			continue
+		}
+		rules.links = append(rules.links, re)
+	}
+	return rules, errors.Join(errs...)
+}
+
+// Check - returns why text should be held for moderation, one reason per word or link found, or nil if it passes
+func (rules *Rules) Check(text string) []db.ModerationReason {
+	if rules == nil {
+		return nil
+	}
+	var reasons []db.ModerationReason
+	for _, b := range rules.blocked {
+		if b.re.MatchString(text) {
+			reasons = append(reasons, db.NewModerationReason(db.ReasonBlockedWord, b.word))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, re := range rules.links {
+		for _, match := range re.FindAllString(text, -1) {
+			match = shorten(match)
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+			reasons = append(reasons, db.NewModerationReason(db.ReasonLink, match))
+		}
+	}
+	return reasons
+}
+
+// shorten - cuts s down to maxMatchLength runes
+func shorten(s string) string {
+	if runes := []rune(s); len(runes) > maxMatchLength {
+		return string(runes[:maxMatchLength]) + "…"
+	}
+	return s
+}
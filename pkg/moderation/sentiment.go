@@ -0,0 +1,85 @@
+package moderation
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/scalland/bitebuddy/pkg/db"
+	"github.com/scalland/bitebuddy/pkg/sentiment"
+	"github.com/spf13/viper"
+)
+
+// SentimentConfig - when the sentiment of a review contradicts its overall score. Both are put on the range from -1
+// to 1 and compared.
+type SentimentConfig struct {
+	// Contradiction is the distance between the sentiment and the overall score from which a review is flagged, 0
+	// turns the check off
+	Contradiction float64
+	// MinOpinions is the number of opinion words a text needs before its sentiment is trusted
+	MinOpinions int
+}
+
+// LoadSentimentConfig - returns the thresholds of the configuration, falling back to the defaults of sample.yml for
+// those which are not set
+func LoadSentimentConfig() SentimentConfig {
+	cfg := SentimentConfig{Contradiction: 1.2, MinOpinions: 2}
+	if viper.IsSet("sentiment_contradiction") {
+		cfg.Contradiction = viper.GetFloat64("sentiment_contradiction")
+	}
+	if viper.IsSet("sentiment_min_opinions") {
+		cfg.MinOpinions = viper.GetInt("sentiment_min_opinions")
+	}
+	return cfg
+}
+
+// SentimentResult - the sentiment of a review and whether it was flagged for contradicting the overall score
+type SentimentResult struct {
+	sentiment.Analysis
+	// Reason tells how the text and the overall score disagree, its Code is empty if they do not
+	Reason  db.ModerationReason
+	Flagged bool
+}
+
+// AnalyzeReview - scores the sentiment of the text of the review reviewID and stores it along with the aspect terms
+// used. A review whose text reads the opposite of its overall score is flagged for moderation, unless a moderator has
+// approved it.
+func AnalyzeReview(conn db.Queryer, reviewID int64, cfg SentimentConfig) (SentimentResult, error) {
+	var res SentimentResult
+	o, err := db.LoadReviewOpinion(conn, reviewID)
+	if err != nil {
+		return res, fmt.Errorf("error loading review %d: %s", reviewID, err.Error())
+	}
+	res.Analysis = sentiment.Analyze(o.Text)
+	mentions := make([]db.ReviewMention, len(res.Mentions))
+	for i, m := range res.Mentions {
+		mentions[i] = db.ReviewMention{Term: m.Term, Aspect: m.Aspect, Mentions: m.Count, Score: m.Score}
+	}
+	if err = db.SaveReviewSentiment(conn, reviewID, res.Score, mentions); err != nil {
+		return res, fmt.Errorf("error storing sentiment of review %d: %s", reviewID, err.Error())
+	}
+
+	res.Reason = contradiction(res.Analysis, o.OverallScore, cfg)
+	if res.Reason.Code != "" && !o.Cleared {
+		if res.Flagged, err = db.FlagReview(conn, reviewID, []db.ModerationReason{res.Reason}); err != nil {
+			return res, fmt.Errorf("error flagging review %d: %s", reviewID, err.Error())
+		}
+	}
+	return res, nil
+}
+
+// contradiction - returns how the sentiment of a and the overall score disagree, a reason without code if they do
+// not. Only opposite leanings count, a lukewarm text with a top score is no contradiction.
+func contradiction(a sentiment.Analysis, overallScore float64, cfg SentimentConfig) db.ModerationReason {
+	if cfg.Contradiction <= 0 || a.Opinions < cfg.MinOpinions {
+		return db.ModerationReason{}
+	}
+	rating := overallScore/db.OverallScoreMax*2 - 1
+	if a.Score*rating >= 0 || math.Abs(a.Score-rating) < cfg.Contradiction {
+		return db.ModerationReason{}
+	}
+	code := db.ReasonReadsPositive
+	if a.Score < 0 {
+		code = db.ReasonReadsNegative
+	}
+	return db.NewModerationReason(code, fmt.Sprintf("%+.2f", a.Score), fmt.Sprintf("%.2f", overallScore))
+}
@@ -0,0 +1,95 @@
+package sentiment
+
+// lexicon - the opinion words and their valence, from -3 for the most negative to 3 for the most positive. Words are
+// lower-cased, romanized and Devanagari Hindi words common in reviews are included.
+var lexicon = map[string]float64{
+	// positive
+	"amazing": 3, "awesome": 3, "best": 3, "brilliant": 3, "delicious": 3, "excellent": 3, "exceptional": 3,
+	"fantastic": 3, "heavenly": 3, "incredible": 3, "loved": 3, "outstanding": 3, "perfect": 3, "perfectly": 3,
+	"phenomenal": 3, "superb": 3, "wonderful": 3, "yummy": 3, "mouthwatering": 3, "divine": 3,
+	"authentic": 2, "beautiful": 2, "cozy": 2, "cosy": 2, "clean": 2, "courteous": 2, "crispy": 2, "enjoyed": 2,
+	"flavorful": 2, "flavourful": 2, "fresh": 2, "friendly": 2, "generous": 2, "great": 2, "helpful": 2,
+	"impressive": 2, "juicy": 2, "love": 2, "lovely": 2, "nice": 2, "pleasant": 2, "polite": 2, "prompt": 2,
+	"recommend": 2, "recommended": 2, "relaxing": 2, "tasty": 2, "tender": 2, "welcoming": 2, "attentive": 2,
+	"worth": 2, "charming": 2, "spotless": 2, "happy": 2, "glad": 2, "favourite": 2, "favorite": 2,
+	"affordable": 1, "cheap": 1, "comfortable": 1, "decent": 1, "efficient": 1, "fast": 1, "fine": 1, "good": 1,
+	"hot": 1, "like": 1, "liked": 1, "quick": 1, "reasonable": 1, "satisfying": 1, "quiet": 1, "spacious": 1,
+	"well": 1, "okay": 0.5, "ok": 0.5, "fair": 0.5,
+	"accha": 2, "achha": 2, "acha": 2, "badhiya": 3, "badiya": 3, "swadisht": 3, "mast": 2, "zabardast": 3,
+	"अच्छा": 2, "अच्छी": 2, "अच्छे": 2, "बढ़िया": 3, "स्वादिष्ट": 3, "शानदार": 3, "बेहतरीन": 3, "मज़ेदार": 2, "सस्ता": 1,
+
+	// negative
+	"awful": -3, "disgusting": -3, "horrible": -3, "inedible": -3, "terrible": -3, "worst": -3, "pathetic": -3,
+	"rude": -3, "filthy": -3, "rotten": -3, "poisoning": -3, "nightmare": -3, "hated": -3, "ripoff": -3,
+	"bad": -2, "bland": -2, "burnt": -2, "cold": -1, "dirty": -2, "disappointed": -2, "disappointing": -2,
+	"greasy": -2, "hate": -2, "mediocre": -2, "noisy": -2, "overcooked": -2, "overpriced": -2, "poor": -2,
+	"raw": -1, "slow": -2, "soggy": -2, "stale": -2, "undercooked": -2, "unfriendly": -2, "unhygienic": -3,
+	"waste": -2, "wasted": -2, "avoid": -2, "ignored": -2, "careless": -2, "cramped": -2, "smelly": -2,
+	"expensive": -1, "costly": -1, "average": -1, "boring": -1, "crowded": -1, "dry": -1, "late": -1, "oily": -1,
+	"salty": -1, "small": -1, "tiny": -1, "uncomfortable": -1, "meh": -1, "lacking": -1, "sad": -1, "wait": -0.5,
+	"bekar": -2, "bekaar": -2, "bakwas": -3, "ganda": -2, "mehenga": -1, "mehnga": -1,
+	"बेकार": -2, "बकवास": -3, "गंदा": -2, "गंदी": -2, "खराब": -2, "ख़राब": -2, "महंगा": -1, "महँगा": -1,
+}
+
+// negators - words which turn the valence of the opinion words shortly after them around. Contractions like "didn't"
+// are split at the apostrophe, leaving "didn".
+var negators = map[string]bool{
+	"not": true, "no": true, "never": true, "nothing": true, "nobody": true, "none": true, "neither": true,
+	"nor": true, "hardly": true, "barely": true, "without": true, "cannot": true, "isn": true, "wasn": true,
+	"aren": true, "weren": true, "don": true, "doesn": true, "didn": true, "won": true, "wouldn": true,
+	"couldn": true, "shouldn": true, "haven": true, "hasn": true, "hadn": true, "ain": true,
+	"nahi": true, "nahin": true, "nai": true, "नहीं": true, "ना": true,
+}
+
+// intensifiers - words which scale the valence of the opinion word right after them
+var intensifiers = map[string]float64{
+	"very": 1.3, "really": 1.3, "extremely": 1.5, "super": 1.3, "so": 1.2, "too": 1.2, "absolutely": 1.5,
+	"totally": 1.3, "incredibly": 1.5, "highly": 1.3, "truly": 1.3, "quite": 1.1, "bahut": 1.3, "बहुत": 1.3,
+	"slightly": 0.6, "somewhat": 0.7, "bit": 0.7, "little": 0.7, "kinda": 0.7, "fairly": 0.8,
+}
+
+// contrasts - words after which the rest of a sentence outweighs what came before, as in "the food was good but cold"
+var contrasts = map[string]bool{
+	"but": true, "however": true, "although": true, "though": true, "yet": true, "lekin": true,
+	"लेकिन": true,
+}
+
+// aspectTerms - the words and short phrases which tell that a sentence is about an aspect of the restaurant
+var aspectTerms = map[string]string{
+	// food
+	"food": AspectFood, "dish": AspectFood, "dishes": AspectFood, "meal": AspectFood, "meals": AspectFood,
+	"taste": AspectFood, "tasted": AspectFood, "flavour": AspectFood, "flavor": AspectFood, "menu": AspectFood,
+	"portion": AspectFood, "portions": AspectFood, "starter": AspectFood, "starters": AspectFood,
+	"dessert": AspectFood, "desserts": AspectFood, "curry": AspectFood, "biryani": AspectFood, "dosa": AspectFood,
+	"idli": AspectFood, "naan": AspectFood, "roti": AspectFood, "paneer": AspectFood, "chicken": AspectFood,
+	"mutton": AspectFood, "fish": AspectFood, "rice": AspectFood, "dal": AspectFood, "thali": AspectFood,
+	"pizza": AspectFood, "burger": AspectFood, "pasta": AspectFood, "noodles": AspectFood, "soup": AspectFood,
+	"salad": AspectFood, "bread": AspectFood, "coffee": AspectFood, "tea": AspectFood, "chai": AspectFood,
+	"drinks": AspectFood, "cocktails": AspectFood, "beer": AspectFood, "wine": AspectFood, "breakfast": AspectFood,
+	"lunch": AspectFood, "dinner": AspectFood, "buffet": AspectFood, "spicy": AspectFood, "sweet": AspectFood,
+	"khana": AspectFood, "खाना": AspectFood, "स्वाद": AspectFood,
+
+	// service
+	"service": AspectService, "staff": AspectService, "waiter": AspectService, "waiters": AspectService,
+	"waitress": AspectService, "server": AspectService, "manager": AspectService, "owner": AspectService,
+	"chef": AspectService, "host": AspectService, "delivery": AspectService, "order": AspectService,
+	"served": AspectService, "serving": AspectService, "waiting": AspectService, "reservation": AspectService,
+	"booking": AspectService, "hospitality": AspectService, "wait time": AspectService, "सेवा": AspectService,
+	"स्टाफ": AspectService,
+
+	// ambience
+	"ambience": AspectAmbience, "ambiance": AspectAmbience, "atmosphere": AspectAmbience, "decor": AspectAmbience,
+	"interior": AspectAmbience, "interiors": AspectAmbience, "music": AspectAmbience, "vibe": AspectAmbience,
+	"place": AspectAmbience, "seating": AspectAmbience, "seats": AspectAmbience, "view": AspectAmbience,
+	"lighting": AspectAmbience, "crowd": AspectAmbience, "parking": AspectAmbience, "washroom": AspectAmbience,
+	"restroom": AspectAmbience, "hygiene": AspectAmbience, "cleanliness": AspectAmbience, "noise": AspectAmbience,
+	"outdoor": AspectAmbience, "rooftop": AspectAmbience, "माहौल": AspectAmbience, "जगह": AspectAmbience,
+
+	// price
+	"price": AspectPrice, "prices": AspectPrice, "pricing": AspectPrice, "priced": AspectPrice, "cost": AspectPrice,
+	"bill": AspectPrice, "value": AspectPrice, "money": AspectPrice, "budget": AspectPrice, "rates": AspectPrice,
+	"discount": AspectPrice, "offer": AspectPrice, "offers": AspectPrice, "charges": AspectPrice,
+	"value for money": AspectPrice, "overpriced": AspectPrice, "expensive": AspectPrice, "cheap": AspectPrice,
+	"affordable": AspectPrice, "costly": AspectPrice, "daam": AspectPrice, "दाम": AspectPrice, "कीमत": AspectPrice,
+	"महंगा": AspectPrice, "सस्ता": AspectPrice,
+}
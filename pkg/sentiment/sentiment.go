@@ -0,0 +1,224 @@
+package sentiment
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The aspects of a restaurant reviews talk about
+const (
+	AspectFood     = "food"
+	AspectService  = "service"
+	AspectAmbience = "ambience"
+	AspectPrice    = "price"
+)
+
+// Aspects - every aspect, in the order they are shown
+var Aspects = []string{AspectFood, AspectService, AspectAmbience, AspectPrice}
+
+const (
+	// normalization turns the summed valence of a text into a score between -1 and 1. The higher it is, the more
+	// opinion words it takes to reach the ends of the range.
+	normalization = 15
+	// negationReach is the number of words before an opinion word which are searched for a negator
+	negationReach = 3
+	// negationFactor scales the valence of negated opinion words, as "not good" is weaker than "bad"
+	negationFactor = -0.75
+	// The weights of the parts of a sentence before and after a contrast, see contrasts
+	beforeContrast = 0.5
+	afterContrast  = 1.5
+	// maxPhraseWords is the number of words of the longest aspect term
+	maxPhraseWords = 3
+)
+
+// Mention - an aspect term used in a text, e.g. "biryani" for food, along with the sentiment of the sentences it was
+// used in
+type Mention struct {
+	Aspect string
+	Term   string
+	Count  int
+	// Score is the mean sentiment of the sentences the term was used in, from -1 to 1
+	Score float64
+}
+
+// Analysis - the sentiment of a text and the aspects it talks about
+type Analysis struct {
+	// Score is the sentiment of the text, from -1 for the most negative to 1 for the most positive
+	Score float64
+	// Opinions counts the opinion words found, which tells how much evidence Score rests on
+	Opinions int
+	// Mentions holds the aspect terms used, most used first
+	Mentions []Mention
+}
+
+// Analyze - scores the sentiment of text by its opinion words, turned around by negators and scaled by intensifiers,
+// and finds the aspect terms it uses. Terms take the sentiment of their clause, or of their sentence if the clause
+// holds no opinion. It runs on word lists kept in the code, without calling any service.
+func Analyze(text string) Analysis {
+	var (
+		a     Analysis
+		total float64
+		terms = make(map[string]*mentionSum)
+		order []string
+	)
+	for _, sentence := range sentences(text) {
+		var before, after float64
+		contrasted := false
+		for _, c := range sentence {
+			a.Opinions += c.opinions
+			if c.afterContrast {
+				contrasted = true
+				after += c.valence
+			} else {
+				before += c.valence
+			}
+		}
+		valence := before
+		if contrasted {
+			valence = before*beforeContrast + after*afterContrast
+		}
+		total += valence
+
+		for _, c := range sentence {
+			score := normalize(valence)
+			if c.opinions > 0 {
+				score = normalize(c.valence)
+			}
+			for _, term := range findTerms(c.words) {
+				m, ok := terms[term]
+				if !ok {
+					m = &mentionSum{Mention: Mention{Aspect: aspectTerms[term], Term: term}}
+					terms[term] = m
+					order = append(order, term)
+				}
+				m.Count++
+				m.sum += score
+			}
+		}
+	}
+	a.Score = round(normalize(total))
+	for _, term := range order {
+		m := terms[term]
+		m.Score = round(m.sum / float64(m.Count))
+		a.Mentions = append(a.Mentions, m.Mention)
+	}
+	sort.SliceStable(a.Mentions, func(i, j int) bool {
+		return a.Mentions[i].Count > a.Mentions[j].Count
+	})
+	return a
+}
+
+// mentionSum - a mention along with the sum of the scores of its clauses
+type mentionSum struct {
+	Mention
+	sum float64
+}
+
+// clause - a run of words of a sentence up to a comma or a contrast, along with the summed valence of its opinion
+// words and how many there are
+type clause struct {
+	words    []string
+	valence  float64
+	opinions int
+	// afterContrast is set for the clauses following a contrast in their sentence
+	afterContrast bool
+}
+
+// sentences - returns the clauses of each sentence of text, lower-cased
+func sentences(text string) [][]clause {
+	var out [][]clause
+	split := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return r == '.' || r == '!' || r == '?' || r == ';' || r == '\n' || r == '।'
+	})
+	for _, s := range split {
+		var (
+			sentence   []clause
+			contrasted bool
+		)
+		for _, part := range strings.Split(s, ",") {
+			var current []string
+			flush := func() {
+				if len(current) > 0 {
+					sentence = append(sentence, newClause(current, contrasted))
+				}
+				current = nil
+			}
+			for _, w := range words(part) {
+				if contrasts[w] {
+					flush()
+					contrasted = true
+					continue
+				}
+				current = append(current, w)
+			}
+			flush()
+		}
+		if len(sentence) > 0 {
+			out = append(out, sentence)
+		}
+	}
+	return out
+}
+
+// words - returns the words of s
+func words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+	})
+}
+
+// newClause - returns the clause of words, scoring its opinion words
+func newClause(words []string, afterContrast bool) clause {
+	c := clause{words: words, afterContrast: afterContrast}
+	for i, w := range words {
+		v, ok := lexicon[w]
+		if !ok {
+			continue
+		}
+		c.opinions++
+		if i > 0 {
+			if f, ok := intensifiers[words[i-1]]; ok {
+				v *= f
+			}
+		}
+		for j := i - 1; j >= 0 && j >= i-negationReach; j-- {
+			if negators[words[j]] {
+				v *= negationFactor
+				break
+			}
+		}
+		c.valence += v
+	}
+	return c
+}
+
+// findTerms - returns the aspect terms used in sentence, preferring the longest phrase at each word
+func findTerms(sentence []string) []string {
+	var found []string
+	for i := 0; i < len(sentence); i++ {
+		for n := min(maxPhraseWords, len(sentence)-i); n >= 1; n-- {
+			phrase := strings.Join(sentence[i:i+n], " ")
+			if _, ok := aspectTerms[phrase]; ok {
+				found = append(found, phrase)
+				i += n - 1
+				break
+			}
+		}
+	}
+	return found
+}
+
+// normalize - maps the summed valence v onto the range from -1 to 1
+func normalize(v float64) float64 {
+	if v == 0 {
+		return 0
+	}
+	return v / math.Sqrt(v*v+normalization)
+}
+
+// round - rounds f to 3 decimals, as stored
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
@@ -0,0 +1,129 @@
+package sentiment
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLexicon - the word lists only hold lower-cased words, valences within range and terms of known aspects
+func TestLexicon(t *testing.T) {
+	for w, v := range lexicon {
+		if w != strings.ToLower(w) || len(words(w)) != 1 {
+			t.Errorf("lexicon: %q is not a lower-cased word", w)
+		}
+		if v < -3 || v > 3 || v == 0 {
+			t.Errorf("lexicon: %q has the valence %v, want one from -3 to 3 other than 0", w, v)
+		}
+		if negators[w] || contrasts[w] {
+			t.Errorf("lexicon: %q is a negator or a contrast too", w)
+		}
+		if _, ok := intensifiers[w]; ok {
+			t.Errorf("lexicon: %q is an intensifier too", w)
+		}
+	}
+	for w := range negators {
+		if len(words(w)) != 1 {
+			t.Errorf("negators: %q is not a word", w)
+		}
+	}
+	for w, f := range intensifiers {
+		if len(words(w)) != 1 || f <= 0 {
+			t.Errorf("intensifiers: %q scales by %v, want a word scaling by more than 0", w, f)
+		}
+	}
+	for term, aspect := range aspectTerms {
+		if n := len(words(term)); n == 0 || n > maxPhraseWords || strings.Join(words(term), " ") != term {
+			t.Errorf("aspectTerms: %q is not a phrase of up to %d words", term, maxPhraseWords)
+		}
+		known := false
+		for _, a := range Aspects {
+			known = known || a == aspect
+		}
+		if !known {
+			t.Errorf("aspectTerms: %q is about the unknown aspect %q", term, aspect)
+		}
+	}
+}
+
+func TestNewClause(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		valence  float64
+		opinions int
+	}{
+		{"no opinion", "the food arrived", 0, 0},
+		{"opinion word", "good", 1, 1},
+		{"intensified", "very good", 1.3, 1},
+		{"weakened", "slightly cold", -0.6, 1},
+		{"negated", "not good", -0.75, 1},
+		{"negated and intensified", "not very good", -0.975, 1},
+		{"negator within reach", "not the best at all", -2.25, 1},
+		{"negator out of reach", "no one said it was bad", -2, 1},
+		{"contraction", "didn t like it", -0.75, 1},
+		{"several opinions", "fresh and tasty", 4, 2},
+		{"hindi", "बहुत बढ़िया", 3.9, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClause(words(tt.text), false)
+			if math.Abs(c.valence-tt.valence) > 1e-9 || c.opinions != tt.opinions {
+				t.Errorf("newClause(%q) = %v from %d opinions, want %v from %d", tt.text, c.valence, c.opinions, tt.valence, tt.opinions)
+			}
+		})
+	}
+}
+
+func TestFindTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"the weather was nice", nil},
+		{"the food and the service", []string{"food", "service"}},
+		{"food food", []string{"food", "food"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := findTerms(words(tt.text)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findTerms(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		score    float64
+		opinions int
+		mentions []Mention
+	}{
+		{"empty", "", 0, 0, nil},
+		{"positive", "The food was delicious.", 0.612, 1, []Mention{{Aspect: AspectFood, Term: "food", Count: 1, Score: 0.612}}},
+		{"negated", "The food was not good.", -0.19, 1, []Mention{{Aspect: AspectFood, Term: "food", Count: 1, Score: -0.19}}},
+		{"contrast outweighs", "The food was good but cold", -0.25, 2, []Mention{{Aspect: AspectFood, Term: "food", Count: 1, Score: 0.25}}},
+		{"aspects of their clauses", "The food was delicious but the service was slow.", -0.361, 2, []Mention{
+			{Aspect: AspectFood, Term: "food", Count: 1, Score: 0.612},
+			{Aspect: AspectService, Term: "service", Count: 1, Score: -0.459},
+		}},
+		{"most used first", "Service was fine. The food was great! Loved the food.", 0.84, 3, []Mention{
+			{Aspect: AspectFood, Term: "food", Count: 2, Score: 0.536},
+			{Aspect: AspectService, Term: "service", Count: 1, Score: 0.25},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Analyze(tt.text)
+			if a.Score != tt.score || a.Opinions != tt.opinions {
+				t.Errorf("Analyze(%q) scored %v from %d opinions, want %v from %d", tt.text, a.Score, a.Opinions, tt.score, tt.opinions)
+			}
+			if !reflect.DeepEqual(a.Mentions, tt.mentions) {
+				t.Errorf("Analyze(%q) mentions %+v, want %+v", tt.text, a.Mentions, tt.mentions)
+			}
+		})
+	}
+}
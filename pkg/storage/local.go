@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	DriverLocal = "local"
+
+	// DefaultLocalPath is the directory used by the local driver when media_local_path has not been set
+	DefaultLocalPath = "storage/media"
+)
+
+func init() {
+	Register(DriverLocal, func() (Storage, error) {
+		root := viper.GetString("media_local_path")
+		if root == "" {
+			root = DefaultLocalPath
+		}
+		return NewLocal(root)
+	})
+}
+
+// Local - stores objects as files below a directory of the local filesystem
+type Local struct {
+	root string
+}
+
+// NewLocal - returns a Local storage keeping its files below root, which is created if needed
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating storage directory %s: %s", root, err.Error())
+	}
+	return &Local{root: root}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put - writes r to key. The file is written under a temporary name first so that readers never see it half-written.
+func (l *Local) Put(key string, r io.Reader) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Open - opens the file stored under key
+func (l *Local) Open(key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, ObjectInfo{}, err
+	}
+	if fi.IsDir() {
+		_ = f.Close()
+		return nil, ObjectInfo{}, os.ErrNotExist
+	}
+	return f, ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Delete - removes the file stored under key
+func (l *Local) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidKey - returned for keys which are empty, absolute or try to escape the storage with ".."
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// ObjectInfo - what is known about a stored object without reading it
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage - where uploaded files are kept. Keys are slash-separated relative paths such as
+// "restaurants/0a1b2c/thumb.jpg". Open and Delete return an error matching fs.ErrNotExist for missing keys.
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Open(key string) (io.ReadSeekCloser, ObjectInfo, error)
+	Delete(key string) error
+}
+
+// Factory - creates a Storage from the current configuration
+type Factory func() (Storage, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register - makes a storage driver available to New under name. It is meant to be called from the init function of
+// the file implementing the driver.
+func Register(name string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = f
+}
+
+// Drivers - returns the names of the registered storage drivers, sorted
+func Drivers() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New - creates a Storage using the driver registered under name
+func New(name string) (Storage, error) {
+	factoriesMu.RLock()
+	f, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %q, available drivers are: %s", name, strings.Join(Drivers(), ", "))
+	}
+	return f()
+}
+
+// CleanKey - returns key in its canonical form, or ErrInvalidKey if it cannot be used
+func CleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
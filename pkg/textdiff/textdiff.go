@@ -0,0 +1,111 @@
+package textdiff
+
+import (
+	"regexp"
+)
+
+// maxCells - texts whose word counts multiply to more than this are not compared word by word, which takes time and
+// memory in proportion to the product. Their diff replaces the whole text.
+const maxCells = 4_000_000
+
+// tokenPattern - splits a text into words and the whitespace between them, which both take part in the diff so that
+// changed line breaks show
+var tokenPattern = regexp.MustCompile(`\s+|\S+`)
+
+// Op - what happened to a segment of text
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Segment - a run of text which was kept, inserted or deleted
+type Segment struct {
+	Op   Op
+	Text string
+}
+
+// IsInsert - reports whether the segment was inserted
+func (s Segment) IsInsert() bool {
+	return s.Op == Insert
+}
+
+// IsDelete - reports whether the segment was deleted
+func (s Segment) IsDelete() bool {
+	return s.Op == Delete
+}
+
+// Words - returns the segments which turn a into b, word by word. Deletions come before the insertions which replace
+// them.
+func Words(a, b string) []Segment {
+	at, bt := tokenPattern.FindAllString(a, -1), tokenPattern.FindAllString(b, -1)
+	if len(at)*len(bt) > maxCells {
+		var segments []Segment
+		segments = appendSegment(segments, Delete, a)
+		return appendSegment(segments, Insert, b)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of at[i:] and bt[j:]
+	lcs := make([][]int, len(at)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bt)+1)
+	}
+	for i := len(at) - 1; i >= 0; i-- {
+		for j := len(bt) - 1; j >= 0; j-- {
+			if at[i] == bt[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var segments []Segment
+	i, j := 0, 0
+	for i < len(at) && j < len(bt) {
+		switch {
+		case at[i] == bt[j]:
+			segments = appendSegment(segments, Equal, at[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			segments = appendSegment(segments, Delete, at[i])
+			i++
+		default:
+			segments = appendSegment(segments, Insert, bt[j])
+			j++
+		}
+	}
+	for ; i < len(at); i++ {
+		segments = appendSegment(segments, Delete, at[i])
+	}
+	for ; j < len(bt); j++ {
+		segments = appendSegment(segments, Insert, bt[j])
+	}
+	return segments
+}
+
+// appendSegment - appends text to segments, joining it with the last segment if that has the same op. Deletions
+// which directly follow insertions are moved before them.
+func appendSegment(segments []Segment, op Op, text string) []Segment {
+	if text == "" {
+		return segments
+	}
+	n := len(segments)
+	if n > 0 && segments[n-1].Op == op {
+		segments[n-1].Text += text
+		return segments
+	}
+	if op == Delete && n > 0 && segments[n-1].Op == Insert {
+		if n > 1 && segments[n-2].Op == Delete {
+			segments[n-2].Text += text
+			return segments
+		}
+		ins := segments[n-1]
+		segments[n-1] = Segment{Op: Delete, Text: text}
+		return append(segments, ins)
+	}
+	return append(segments, Segment{Op: op, Text: text})
+}
@@ -40,7 +40,7 @@ func (u *Utils) ViperReadConfig(env, appName, configFile string) {
 
 	cwd, cwdErr := os.Getwd()
 	if cwdErr != nil {
-		slog.Error("bitebuddy.main.readConfig: error getting current directory. %s", cwdErr.Error())
+		slog.Error(fmt.Sprintf("bitebuddy.main.readConfig: error getting current directory. %s", cwdErr.Error()))
 		execPath, execPathErr := os.Executable()
 		if execPathErr != nil {
 			log.Fatalf("bitebuddy.main.readConfig: error getting path to the executable of the current process: %s\n", execPathErr.Error())
@@ -74,6 +74,7 @@ func (u *Utils) ViperReadConfig(env, appName, configFile string) {
 				slog.Error(fmt.Sprintf("bitebuddy.main.readConfig: error reloading viper configuration file at %s: %s", configFile, err.Error()))
 			} else {
 				slog.Debug(fmt.Sprintf("bitebuddy.main.readConfig: successfully reloaded configuration file at: %s", configFile))
+				u.runConfigChangeHooks()
 			}
 		})
 
@@ -84,3 +85,22 @@ func (u *Utils) ViperReadConfig(env, appName, configFile string) {
 		slog.Info(fmt.Sprintf("bitebuddy.main.readConfig: configuration file %s does not exist", configFile))
 	}
 }
+
+// OnConfigChange - registers fn to be called every time the configuration file has been changed and re-read
+// successfully. Hooks run in the order in which they were registered.
+func (u *Utils) OnConfigChange(fn func()) {
+	u.configHooksMu.Lock()
+	defer u.configHooksMu.Unlock()
+	u.configHooks = append(u.configHooks, fn)
+}
+
+func (u *Utils) runConfigChangeHooks() {
+	u.configHooksMu.Lock()
+	hooks := make([]func(), len(u.configHooks))
+	copy(hooks, u.configHooks)
+	u.configHooksMu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
@@ -2,10 +2,11 @@ package utils
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-resty/resty/v2"
-	"log"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -87,52 +88,70 @@ func (u *Utils) AppendToFile(path string, data string) (int, error) {
 	return bytesWritten, err
 }
 
-func (u *Utils) Watch(fileName string) {
+// Watch - watches fileName, which may be a file or a directory, and calls onChange with every event reported for it
+// until the returned watcher is closed. Directories are not watched recursively, use WatchTree for that.
+func (u *Utils) Watch(fileName string, onChange func(fsnotify.Event)) (*fsnotify.Watcher, error) {
 	// Create a new file watcher
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer func() {
-		watcherErr := watcher.Close()
-		if watcherErr != nil {
-			slog.Error(fmt.Sprintf("Error closing watcher: %s", watcherErr.Error()))
-		}
-	}()
-
-	// Set the file to watch
-	filename := fileName
 
 	// Add the file to the watcher
-	err = watcher.Add(filename)
+	err = watcher.Add(fileName)
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	go u.watchLoop(watcher, onChange, false)
+	return watcher, nil
+}
+
+// WatchTree - watches the directory root and every directory below it, including the ones created later on, and
+// calls onChange with every event reported for them until the returned watcher is closed
+func (u *Utils) WatchTree(root string, onChange func(fsnotify.Event)) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	// Create a channel to receive events
-	events := watcher.Events
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	go u.watchLoop(watcher, onChange, true)
+	return watcher, nil
+}
 
-	// Start a loop to watch for events
+func (u *Utils) watchLoop(watcher *fsnotify.Watcher, onChange func(fsnotify.Event), recursive bool) {
 	for {
 		select {
-		case event := <-events:
-			// Check if the event was a modification
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				// Reload the file
-				file, err := os.Open(filename)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				// Print the contents of the file
-				slog.Info(fmt.Sprintf("Reloading file: %s", filename))
-				fileCloseErr := file.Close()
-				if fileCloseErr != nil {
-					slog.Error(fmt.Sprintf("utils.Watch: error closing file handle for %s. %s", filename, fileCloseErr.Error()))
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if recursive && event.Op&fsnotify.Create == fsnotify.Create && u.IsDirectory(event.Name) {
+				if err := watcher.Add(event.Name); err != nil {
+					slog.Error(fmt.Sprintf("utils.Watch: error watching new directory %s: %s", event.Name, err.Error()))
 				}
 			}
-		case err := <-watcher.Errors:
-			slog.Error(fmt.Sprintf("error: %s", err.Error()))
+			onChange(event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error(fmt.Sprintf("utils.Watch: error: %s", err.Error()))
 		}
 	}
 }
@@ -148,28 +167,32 @@ func (u *Utils) GetFileContentType(pathToFileName string) (string, error) {
 		return "application/octet-stream", err
 	}
 
-	// to sniff the content type only the first
-	// 512 bytes are used.
-
-	buf := make([]byte, 512)
-
-	bytesRead, err := file.Read(buf)
-
-	if err != nil {
-		return "application/octet-stream", err
-	}
+	contentType, err := u.GetContentType(file)
 
-	slog.Info(fmt.Sprintf("Read %d bytes from %s. Closing the file now", bytesRead, pathToFileName))
+	slog.Debug(fmt.Sprintf("Sniffed %s as %s. Closing the file now", pathToFileName, contentType))
 
 	fileCloseErr := file.Close()
 	if fileCloseErr != nil {
 		slog.Error(fmt.Sprintf("Utils.GetFileContentType()::Error closing handle for file %s. %s", pathToFileName, fileCloseErr.Error()))
 	}
 
-	// the function that actually does the trick
-	contentType := http.DetectContentType(buf)
+	return contentType, err
+}
+
+// GetContentType - sniffs the content type of the data read from r the same way as GetFileContentType does for files.
+// At most the first 512 bytes are consumed from r.
+func (u *Utils) GetContentType(r io.Reader) (string, error) {
+	// to sniff the content type only the first
+	// 512 bytes are used.
+	buf := make([]byte, 512)
 
-	return contentType, nil
+	bytesRead, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "application/octet-stream", err
+	}
+
+	// the function that actually does the trick
+	return http.DetectContentType(buf[:bytesRead]), nil
 }
 
 func (u *Utils) CreateFileWithDataAtURL(sourceFileURL string, targetFilePath string) (*resty.Response, error) {
@@ -6,12 +6,15 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type Utils struct {
-	debug    bool
-	TimeZone *time.Location
+	debug         bool
+	TimeZone      *time.Location
+	configHooksMu sync.Mutex
+	configHooks   []func()
 }
 
 var ut *Utils
@@ -47,7 +50,7 @@ func (u *Utils) SetDebug() bool {
 		}
 	}
 
-	slog.Debug("utils.SetDebug: utils.Debug is %t", u.debug)
+	slog.Debug(fmt.Sprintf("utils.SetDebug: utils.Debug is %t", u.debug))
 
 	//if u.debug {
 	//	u.Log.SetLevel(log.DebugLevel)
@@ -57,7 +60,7 @@ func (u *Utils) SetDebug() bool {
 
 func (u *Utils) SetTimeZone(tz string) {
 	var tzErr error
-	slog.Debug("utils.SetTimeZone: trying to load timezone %s...", tz)
+	slog.Debug(fmt.Sprintf("utils.SetTimeZone: trying to load timezone %s...", tz))
 	u.TimeZone, tzErr = time.LoadLocation(tz)
 	if tzErr != nil {
 		slog.Error(fmt.Sprintf("utils.SetTimeZone: error loading time zone: %s: %s", tz, tzErr.Error()))
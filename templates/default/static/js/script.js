@@ -2,4 +2,55 @@
 $(document).ready(function(){
     console.log("Dashboard loaded");
     // Add additional JS for cool effects and interactivity as needed.
+
+    // Lookup selects, see the lookup_select.html partial. Selects holding every row of their table are filtered in
+    // place, the others ask /lookup/{source} for the rows matching what has been typed.
+    $(".lookup-search").each(function(){
+        var $search = $(this);
+        var $select = $("#" + $search.data("lookup-for"));
+        var url = $search.data("lookup-url");
+        var timer = null;
+
+        $search.on("input", function(){
+            var term = $.trim($search.val());
+            if (!url) {
+                var needle = term.toLowerCase();
+                $select.find("option").each(function(){
+                    var $option = $(this);
+                    if ($option.val() === "" || $option.is(":selected")) {
+                        return;
+                    }
+                    $option.prop("hidden", needle !== "" && $option.text().toLowerCase().indexOf(needle) === -1);
+                });
+                return;
+            }
+
+            clearTimeout(timer);
+            timer = setTimeout(function(){
+                $.getJSON(url, {q: term}).done(function(data){
+                    var selected = $select.val();
+                    $select.find("option").each(function(){
+                        var $option = $(this);
+                        if ($option.val() !== "" && $option.val() !== selected) {
+                            $option.remove();
+                        }
+                    });
+                    $.each(data.results || [], function(_, result){
+                        if (String(result.id) === selected) {
+                            return;
+                        }
+                        $("<option>").val(result.id).text(result.label).appendTo($select);
+                    });
+                });
+            }, 250);
+        });
+    });
+
+    // Expand all and collapse all of the metric tree, see metrics.html
+    $("[data-metric-tree]").on("click", function(){
+        var action = $(this).data("metric-tree");
+        $(".metric-tree .collapse").each(function(){
+            bootstrap.Collapse.getOrCreateInstance(this, {toggle: false})[action]();
+        });
+    });
 });